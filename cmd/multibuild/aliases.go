@@ -0,0 +1,76 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// A name used for a GOOS or GOARCH in output names, e.g. x86_64 for amd64.
+type nameAlias struct {
+	name  string
+	alias string
+}
+
+// The aliases of an alias directive, e.g. //go:multibuild:alias=amd64=x86_64,darwin=macOS
+type nameAliases []nameAlias
+
+func (this nameAliases) String() string {
+	return strings.Join(mapSlice(this, func(a nameAlias) string { return a.name + "=" + a.alias }), ",")
+}
+
+// Returns what 'name' is called in output names.
+func (this nameAliases) of(name string) string {
+	for _, a := range this {
+		if a.name == name {
+			return a.alias
+		}
+	}
+	return name
+}
+
+// Validates an alias directive, e.g. amd64=x86_64,darwin=macOS.
+func validateAliases(s string) (nameAliases, error) {
+	var aliases nameAliases
+	for _, part := range strings.Split(s, ",") {
+		name, alias, ok := strings.Cut(part, "=")
+		if !ok || name == "" || alias == "" {
+			return nil, fmt.Errorf("expected NAME=ALIAS, got %q", part)
+		}
+		if strings.ContainsFunc(name, func(c rune) bool { return !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9') }) {
+			return nil, fmt.Errorf("%q is not a GOOS or GOARCH", name)
+		}
+		for _, c := range alias {
+			if c == '/' {
+				return nil, fmt.Errorf("unexpected character %q in the alias of %s: aliases name files, not directories", c, name)
+			}
+			if why := unsafeOutputChar(c, runtime.GOOS); why != "" {
+				return nil, fmt.Errorf("unexpected character %q in the alias of %s: %s", c, name, why)
+			}
+		}
+		if slices.ContainsFunc(aliases, func(a nameAlias) bool { return a.name == name }) {
+			return nil, fmt.Errorf("%s is aliased more than once", name)
+		}
+		aliases = append(aliases, nameAlias{name: name, alias: alias})
+	}
+	return aliases, nil
+}
+
+// Checks that each alias is of a GOOS or GOARCH of one of 'targets', so that a typo
+// doesn't go unnoticed.
+func (this nameAliases) validatePlatforms(targets []target) error {
+	for _, a := range this {
+		if !slices.ContainsFunc(targets, func(t target) bool {
+			goos, goarch := t.osArch()
+			return goos == a.name || goarch == a.name
+		}) {
+			return fmt.Errorf("alias=%s=%s: %s is not a GOOS or GOARCH go supports", a.name, a.alias, a.name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestValidateAliases(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    nameAliases
+		wantErr bool
+	}{
+		{"amd64=x86_64", nameAliases{{"amd64", "x86_64"}}, false},
+		{"amd64=x86_64,darwin=macOS", nameAliases{{"amd64", "x86_64"}, {"darwin", "macOS"}}, false},
+		{"amd64", nil, true},
+		{"amd64=", nil, true},
+		{"=x86_64", nil, true},
+		{"AMD64=x86_64", nil, true},
+		{"amd64=x86/64", nil, true},
+		{"amd64=x86*", nil, true},
+		{"amd64=x86_64,amd64=x64", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := validateAliases(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateAliases(%q): err = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("validateAliases(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+		if err == nil && got.String() != tt.input {
+			t.Errorf("validateAliases(%q).String() = %q", tt.input, got.String())
+		}
+	}
+}
+
+func TestAliasesValidatePlatforms(t *testing.T) {
+	targets := []target{"linux/amd64", "darwin/arm64", "windows/amd64"}
+	if err := (nameAliases{{"amd64", "x86_64"}, {"darwin", "macOS"}}).validatePlatforms(targets); err != nil {
+		t.Errorf("validatePlatforms() = %v", err)
+	}
+	if err := (nameAliases{{"darwn", "macOS"}}).validatePlatforms(targets); err == nil {
+		t.Errorf("validatePlatforms() of a typo succeeded")
+	}
+}
+
+func TestAliasedOutputPaths(t *testing.T) {
+	aliases := nameAliases{{"amd64", "x86_64"}, {"darwin", "macOS"}, {"windows", "Windows"}, {"arm", "armhf"}}
+	tests := []struct {
+		template outputTemplate
+		target   target
+		want     string
+	}{
+		{"${TARGET}-${GOOS}-${GOARCH}", "darwin/amd64", "app-macOS-x86_64"},
+		{"${TARGET}-${GOOS}-${GOARCH}", "linux/arm64", "app-linux-arm64"},
+		{"${TARGET}-${GOOS}-${GOARCH}", "windows/amd64", "app-Windows-x86_64.exe"},
+		{"${TARGET}-${GOOS}-${GOARCH}", "linux/arm/v7", "app-linux-armhfv7"},
+		{`{{.TARGET}}-{{.GOOS | lower}}-{{.GOARCH}}`, "darwin/arm64", "app-macos-arm64"},
+	}
+	for _, tt := range tests {
+		opts := options{Output: tt.template, Aliases: aliases}
+		if _, got := opts.outputPaths("app", tt.target); got != tt.want {
+			t.Errorf("outputPaths(%s) with %s = %q, want %q", tt.target, tt.template, got, tt.want)
+		}
+	}
+}
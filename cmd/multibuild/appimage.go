@@ -0,0 +1,98 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// The GOARCH values AppImages can be made for, and their names for appimagetool.
+var appImageArches = map[string]string{
+	"amd64": "x86_64",
+	"arm64": "aarch64",
+	"386":   "i686",
+	"arm":   "armhf",
+}
+
+// Returns the desktop entry for an AppImage of 'name'.
+func desktopEntry(name string, info packageInfo) string {
+	categories := "Utility"
+	if info.Categories != "" {
+		categories = info.Categories
+	}
+	var b strings.Builder
+	fmt.Fprintln(&b, "[Desktop Entry]")
+	fmt.Fprintln(&b, "Type=Application")
+	fmt.Fprintf(&b, "Name=%s\n", name)
+	fmt.Fprintf(&b, "Exec=%s\n", name)
+	fmt.Fprintf(&b, "Icon=%s\n", name)
+	fmt.Fprintf(&b, "Categories=%s;\n", strings.ReplaceAll(categories, ",", ";"))
+	fmt.Fprintln(&b, "Terminal=true") // Go binaries are usually command line tools
+	return b.String()
+}
+
+// Writes the icon for an AppImage to 'path': a copy of 'icon', or a blank one if there isn't one,
+// as appimagetool insists on having an icon.
+func writeAppImageIcon(path, icon string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if icon == "" {
+		err = png.Encode(f, image.NewNRGBA(image.Rect(0, 0, 16, 16)))
+	} else {
+		err = copyRaw(f, icon)
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Writes an AppImage at 'arPath' wrapping 'outBin', using appimagetool.
+func writeAppImage(ctx context.Context, t target, arPath, outBin string, info packageInfo, log io.Writer) error {
+	_, goarch := t.osArch()
+	arch, ok := appImageArches[goarch]
+	if !ok {
+		return fmt.Errorf("appimage: %s is not supported", t)
+	}
+
+	// The AppDir: the binary as usr/bin/NAME, run by AppRun, and a desktop entry and icon.
+	dir, err := stageBinary(outBin)
+	if err != nil {
+		return fmt.Errorf("failed to stage %s: %s", outBin, err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Base(outBin)
+	bin := filepath.Join(dir, "usr", "bin")
+	if err := os.MkdirAll(bin, 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(filepath.Join(dir, name), filepath.Join(bin, name)); err != nil {
+		return err
+	}
+	if err := os.Symlink(filepath.Join("usr", "bin", name), filepath.Join(dir, "AppRun")); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".desktop"), []byte(desktopEntry(name, info)), 0644); err != nil {
+		return err
+	}
+	if err := writeAppImageIcon(filepath.Join(dir, name+".png"), info.Icon); err != nil {
+		return fmt.Errorf("failed to write icon: %s", err)
+	}
+
+	cmd := exec.CommandContext(ctx, "appimagetool", "--no-appstream", dir, arPath)
+	cmd.Env = append(os.Environ(), "ARCH="+arch)
+	return runPackager(t, log, cmd)
+}
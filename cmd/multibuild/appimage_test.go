@@ -0,0 +1,75 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestAppImageAppliesTo(t *testing.T) {
+	for tgt, want := range map[target]bool{
+		"linux/amd64":   true,
+		"linux/arm64":   true,
+		"linux/riscv64": false,
+		"darwin/arm64":  false,
+	} {
+		if got := format(formatAppImage).appliesTo(tgt); got != want {
+			t.Errorf("%s: got %v, want %v", tgt, got, want)
+		}
+	}
+}
+
+func TestDesktopEntry(t *testing.T) {
+	got := desktopEntry("app", packageInfo{Categories: "Development,Utility"})
+	for _, want := range []string{"Name=app\n", "Exec=app\n", "Icon=app\n", "Categories=Development;Utility;\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+	if got := desktopEntry("app", packageInfo{}); !strings.Contains(got, "Categories=Utility;\n") {
+		t.Errorf("expected default categories in:\n%s", got)
+	}
+}
+
+func TestWriteAppImage(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake appimagetool is a shell script")
+	}
+
+	// A fake appimagetool, which lists the AppDir it was given as the AppImage.
+	bin := t.TempDir()
+	fake := "#!/bin/sh\ncd \"$2\" && { echo \"ARCH=$ARCH\"; find . | sort; ls -l AppRun; } > \"$3\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "appimagetool"), []byte(fake), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+	outBin := filepath.Join(dir, "app-linux-arm64")
+	if err := os.WriteFile(outBin, []byte("binary"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	arPath, _ := filepath.Abs(outBin + ".AppImage")
+	var log bytes.Buffer
+	if err := writeAppImage(context.Background(), "linux/arm64", arPath, outBin, packageInfo{}, &log); err != nil {
+		t.Fatalf("writeAppImage: %s\n%s", err, log.String())
+	}
+	data, err := os.ReadFile(arPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(data)
+	for _, want := range []string{"ARCH=aarch64", "./app-linux-arm64.desktop", "./app-linux-arm64.png", "./usr/bin/app-linux-arm64", "AppRun -> usr/bin/app-linux-arm64"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"runtime"
+)
+
+// Copies the raw binary at 'outBin' into 'w', checking nothing went missing.
+func copyRaw(w io.Writer, outBin string) error {
+	st, err := os.Stat(outBin)
+	if err != nil {
+		return fmt.Errorf("failed to stat raw %s: %s", outBin, err)
+	}
+	bin, err := os.Open(outBin)
+	if err != nil {
+		return fmt.Errorf("failed to open raw %s: %s", outBin, err)
+	}
+	defer bin.Close()
+	sz, err := io.Copy(w, bin)
+	if err != nil {
+		return fmt.Errorf("failed to copy %s: %s", outBin, err)
+	}
+	if sz != st.Size() {
+		return fmt.Errorf("size mismatch in copy of %s: (%d vs %d)", outBin, sz, st.Size())
+	}
+	return nil
+}
+
+// A generated file to include in archives, alongside the binary.
+type archiveFile struct {
+	name string
+	mode int64
+	data []byte
+}
+
+// Compression is CPU bound, so all archives being written at once share this many workers.
+var compressors = make(chan struct{}, runtime.GOMAXPROCS(0))
+
+// How much input each compression worker takes at a time.
+const deflateChunkSize = 1 << 20
+
+// A deflate compressor which splits its input into chunks, and compresses them on the shared
+// workers, as pigz does. Each chunk but the last ends with a sync flush, so the chunks join
+// up into one ordinary deflate stream, at the cost of a little compression across the joins.
+type deflater struct {
+	level int
+	buf   []byte
+	crc   uint32
+	size  int64
+	order chan chan deflated // chunks being compressed, in the order they are written out
+	done  chan error
+}
+
+// A compressed chunk.
+type deflated struct {
+	data []byte
+	err  error
+}
+
+// Returns a deflater writing to 'w', at flate 'level'.
+func newDeflater(w io.Writer, level int) *deflater {
+	this := &deflater{level: level, order: make(chan chan deflated, cap(compressors)), done: make(chan error, 1)}
+	go func() {
+		var err error
+		for c := range this.order {
+			d := <-c
+			if err == nil {
+				err = d.err
+			}
+			if err == nil {
+				_, err = w.Write(d.data)
+			}
+		}
+		this.done <- err
+	}()
+	return this
+}
+
+func (this *deflater) Write(p []byte) (int, error) {
+	this.crc = crc32.Update(this.crc, crc32.IEEETable, p)
+	this.size += int64(len(p))
+	for rest := p; len(rest) > 0; {
+		n := min(len(rest), deflateChunkSize-len(this.buf))
+		this.buf = append(this.buf, rest[:n]...)
+		rest = rest[n:]
+		if len(this.buf) == deflateChunkSize {
+			this.compress(false)
+		}
+	}
+	return len(p), nil
+}
+
+// Compresses the buffered input on a worker. 'last' finishes the stream.
+func (this *deflater) compress(last bool) {
+	chunk := this.buf
+	this.buf = make([]byte, 0, deflateChunkSize)
+	c := make(chan deflated, 1)
+	this.order <- c
+	compressors <- struct{}{}
+	go func() {
+		defer func() { <-compressors }()
+		var out bytes.Buffer
+		fw, err := flate.NewWriter(&out, this.level)
+		if err == nil {
+			_, err = fw.Write(chunk)
+		}
+		if err == nil && last {
+			err = fw.Close()
+		} else if err == nil {
+			err = fw.Flush()
+		}
+		c <- deflated{out.Bytes(), err}
+	}()
+}
+
+// Finishes the stream, and waits for it to be written out.
+func (this *deflater) Close() error {
+	this.compress(true)
+	close(this.order)
+	return <-this.done
+}
+
+// A gzip writer which compresses with a deflater.
+type gzipWriter struct {
+	w io.Writer
+	*deflater
+}
+
+// Returns a gzip writer to 'w', at flate 'level'. The header is written right away.
+func newGzipWriter(w io.Writer, level int) (*gzipWriter, error) {
+	// Magic, deflate, no flags, no mtime (for reproducible output), level, unknown OS.
+	var xfl byte
+	switch level {
+	case flate.BestCompression:
+		xfl = 2
+	case flate.BestSpeed:
+		xfl = 4
+	}
+	if _, err := w.Write([]byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, xfl, 255}); err != nil {
+		return nil, err
+	}
+	return &gzipWriter{w: w, deflater: newDeflater(w, level)}, nil
+}
+
+func (this *gzipWriter) Close() error {
+	if err := this.deflater.Close(); err != nil {
+		return err
+	}
+	trailer := binary.LittleEndian.AppendUint32(nil, this.crc)
+	trailer = binary.LittleEndian.AppendUint32(trailer, uint32(this.size))
+	_, err := this.w.Write(trailer)
+	return err
+}
+
+// An archive being written, which files are streamed into one by one.
+type archiver interface {
+	// Adds a file called 'name', of 'size' bytes, which are then written to the returned writer.
+	add(name string, mode int64, size int64) (io.Writer, error)
+	// Finishes the archive.
+	close() error
+}
+
+type zipArchiver struct {
+	zw *zip.Writer
+}
+
+// Returns a function creating archivers writing zip archives, compressed at 'level'.
+func newZipArchiver(level compressionLevel) func(io.Writer) (archiver, error) {
+	return func(w io.Writer) (archiver, error) {
+		zw := zip.NewWriter(w)
+		zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) { return newDeflater(w, level.deflate()), nil })
+		return zipArchiver{zw}, nil
+	}
+}
+
+func (this zipArchiver) add(name string, mode int64, size int64) (io.Writer, error) {
+	hdr := &zip.FileHeader{Name: name, Method: zip.Deflate}
+	hdr.SetMode(os.FileMode(mode))
+	return this.zw.CreateHeader(hdr)
+}
+
+func (this zipArchiver) close() error {
+	return this.zw.Close()
+}
+
+// An archiver writing a tar archive, compressed by 'c'.
+type tarArchiver struct {
+	c  io.WriteCloser
+	tw *tar.Writer
+}
+
+// Returns an archiver writing a tar archive to 'c', which is closed with it.
+func newTarArchiver(c io.WriteCloser) tarArchiver {
+	return tarArchiver{c, tar.NewWriter(c)}
+}
+
+// Returns a function creating archivers writing tar.gz archives, compressed at 'level'.
+func newTgzArchiver(level compressionLevel) func(io.Writer) (archiver, error) {
+	return func(w io.Writer) (archiver, error) {
+		gz, err := newGzipWriter(w, level.deflate())
+		if err != nil {
+			return nil, err
+		}
+		return newTarArchiver(gz), nil
+	}
+}
+
+func (this tarArchiver) add(name string, mode int64, size int64) (io.Writer, error) {
+	if err := this.tw.WriteHeader(&tar.Header{Name: name, Mode: mode, Size: size}); err != nil {
+		return nil, err
+	}
+	return this.tw, nil
+}
+
+func (this tarArchiver) close() error {
+	if err := this.tw.Close(); err != nil {
+		this.c.Close()
+		return err
+	}
+	return this.c.Close()
+}
+
+// A binary to include in archives, read from 'path'.
+type archiveBinary struct {
+	name string
+	mode int64
+	path string
+}
+
+// Writes an archive at 'arPath' with 'newArchiver', containing the binary at 'binPath' as 'outBin' with 'mode', and 'extra'.
+func writeArchive(arPath string, newArchiver func(io.Writer) (archiver, error), outBin, binPath string, mode int64, extra []archiveFile) error {
+	return writeArchiveOf(arPath, newArchiver, []archiveBinary{{name: outBin, mode: mode, path: binPath}}, extra)
+}
+
+// Writes an archive at 'arPath' with 'newArchiver', containing 'bins', and 'extra'.
+func writeArchiveOf(arPath string, newArchiver func(io.Writer) (archiver, error), bins []archiveBinary, extra []archiveFile) error {
+	f, err := os.Create(arPath)
+	if err != nil {
+		return fmt.Errorf("failed to create archive %s: %s", arPath, err)
+	}
+	defer f.Close()
+
+	// The archive is hashed as it is written, rather than read back later.
+	h := sha256.New()
+	ar, err := newArchiver(io.MultiWriter(f, h))
+	if err != nil {
+		return fmt.Errorf("failed to create archive %s: %s", arPath, err)
+	}
+	for _, b := range bins {
+		st, err := os.Stat(b.path)
+		if err != nil {
+			ar.close()
+			return fmt.Errorf("failed to stat raw %s: %s", b.path, err)
+		}
+		w, err := ar.add(b.name, b.mode, st.Size())
+		if err != nil {
+			ar.close()
+			return fmt.Errorf("failed to create header %s: %s", arPath, err)
+		}
+		if err := copyRaw(w, b.path); err != nil {
+			ar.close()
+			return err
+		}
+	}
+	for _, e := range extra {
+		w, err := ar.add(e.name, e.mode, int64(len(e.data)))
+		if err != nil {
+			ar.close()
+			return fmt.Errorf("failed to create header %s: %s", arPath, err)
+		}
+		if _, err := w.Write(e.data); err != nil {
+			ar.close()
+			return fmt.Errorf("failed to write %s: %s", e.name, err)
+		}
+	}
+	if err := ar.close(); err != nil {
+		return fmt.Errorf("failed to finish archive %s: %s", arPath, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	recordArtifactDigest(arPath, h.Sum(nil))
+	return nil
+}
+
+// Writes the binary at 'binPath', compressed with 'newCompressor', at 'path'.
+func writeCompressed(path string, newCompressor func(io.Writer) (io.WriteCloser, error), binPath string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", path, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	c, err := newCompressor(io.MultiWriter(f, h))
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", path, err)
+	}
+	if err := copyRaw(c, binPath); err != nil {
+		c.Close()
+		return err
+	}
+	if err := c.Close(); err != nil {
+		return fmt.Errorf("failed to finish %s: %s", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	recordArtifactDigest(path, h.Sum(nil))
+	return nil
+}
+
+// Writes a zip archive at 'arPath' containing the binary at 'binPath' as 'outBin' with 'mode', and 'extra'.
+func writeZip(arPath, outBin, binPath string, mode int64, level compressionLevel, extra []archiveFile) error {
+	return writeArchive(arPath, newZipArchiver(level), outBin, binPath, mode, extra)
+}
+
+// Writes a tar.gz archive at 'arPath' containing the binary at 'binPath' as 'outBin' with 'mode', and 'extra'.
+func writeTgz(arPath, outBin, binPath string, mode int64, level compressionLevel, extra []archiveFile) error {
+	return writeArchive(arPath, newTgzArchiver(level), outBin, binPath, mode, extra)
+}
+
+// Writes the binary at 'binPath', gzipped at 'level', at 'path'.
+func writeGz(path, binPath string, level compressionLevel) error {
+	return writeCompressed(path, func(w io.Writer) (io.WriteCloser, error) { return newGzipWriter(w, level.deflate()) }, binPath)
+}
@@ -0,0 +1,159 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"math/rand/v2"
+	"os"
+	"testing"
+)
+
+// Returns 'n' bytes which compress somewhat, like a binary.
+func testData(n int) []byte {
+	r := rand.New(rand.NewPCG(1, 2))
+	data := make([]byte, n)
+	for i := range data {
+		if i%3 == 0 {
+			data[i] = byte(r.IntN(256))
+		} else {
+			data[i] = byte(i / 1000)
+		}
+	}
+	return data
+}
+
+func TestDeflater(t *testing.T) {
+	for _, n := range []int{0, 10, deflateChunkSize, 3*deflateChunkSize + 12345} {
+		data := testData(n)
+		var out bytes.Buffer
+		d := newDeflater(&out, flate.DefaultCompression)
+		// Uneven writes, so chunks don't line up with them.
+		for rest := data; len(rest) > 0; {
+			k := min(len(rest), 100000)
+			d.Write(rest[:k])
+			rest = rest[k:]
+		}
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(flate.NewReader(&out))
+		if err != nil {
+			t.Fatalf("%d bytes: %s", n, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%d bytes: got %d bytes back, which differ", n, len(got))
+		}
+	}
+}
+
+func TestGzipWriter(t *testing.T) {
+	data := testData(2*deflateChunkSize + 7)
+	var out bytes.Buffer
+	gw, err := newGzipWriter(&out, flate.DefaultCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw.Write(data)
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The reader checks the CRC and size in the trailer.
+	gr, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("got %d bytes back, which differ", len(got))
+	}
+}
+
+func TestWriteZipBinary(t *testing.T) {
+	t.Chdir(t.TempDir())
+	data := testData(deflateChunkSize + 1)
+	if err := os.WriteFile("build", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeZip("app.zip", "app", "build", 0755, compressionDefault, nil); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.OpenReader("app.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	if len(zr.File) != 1 || zr.File[0].Name != "app" || zr.File[0].Mode() != 0755 {
+		t.Fatalf("unexpected entries: %+v", zr.File)
+	}
+	rc, err := zr.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if got, err := io.ReadAll(rc); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("got %d bytes back (%v), which differ", len(got), err)
+	}
+}
+
+func TestWriteGz(t *testing.T) {
+	t.Chdir(t.TempDir())
+	data := testData(deflateChunkSize + 1)
+	if err := os.WriteFile("app", data, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeGz("app.gz", "app", compressionBest); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open("app.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(gr); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("got %d bytes back (%v), which differ", len(got), err)
+	}
+}
+
+func TestCompressionLevels(t *testing.T) {
+	data := testData(deflateChunkSize)
+	sizes := make(map[compressionLevel]int)
+	for _, level := range []compressionLevel{compressionFast, compressionDefault, compressionBest} {
+		var out bytes.Buffer
+		gw, err := newGzipWriter(&out, level.deflate())
+		if err != nil {
+			t.Fatal(err)
+		}
+		gw.Write(data)
+		if err := gw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		sizes[level] = out.Len()
+		gr, err := gzip.NewReader(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := io.ReadAll(gr); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s: got %d bytes back (%v), which differ", level, len(got), err)
+		}
+	}
+	if sizes[compressionBest] > sizes[compressionDefault] || sizes[compressionDefault] >= sizes[compressionFast] {
+		t.Errorf("unexpected sizes: %v", sizes)
+	}
+	if compressionLevel("").deflate() != compressionDefault.deflate() || compressionLevel("").zstd() != compressionDefault.zstd() {
+		t.Errorf("no compression setting is not the default")
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"runtime"
+	"strings"
+)
+
+// The placeholders of archive-entry templates. ${NAME} is the name of the output, without
+// its directories or extension, e.g. app-linux-amd64.
+var archiveEntryPlaceholders = map[string]struct{}{
+	"NAME":      {},
+	"TARGET":    {},
+	"GOOS":      {},
+	"GOARCH":    {},
+	"GOVARIANT": {},
+}
+
+// Validates an archive-entry template, the path of the binary in archives, e.g.
+// ${NAME} to leave out the directories of the output, or ${NAME}/${TARGET} to put it in a directory.
+func validateArchiveEntry(s string) (outputTemplate, error) {
+	template, err := validateTemplateWith(s, runtime.GOOS, nil)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range template.placeholders() {
+		if _, ok := archiveEntryPlaceholders[name]; !ok {
+			return "", fmt.Errorf("unexpected placeholder %s, expected one of NAME, TARGET, GOOS, GOARCH or GOVARIANT", name)
+		}
+	}
+	if strings.HasPrefix(s, "/") {
+		return "", fmt.Errorf("archive entries are relative, so %q can't start with /", s)
+	}
+	for _, part := range strings.Split(s, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", fmt.Errorf("%q has an empty, . or .. path element", s)
+		}
+	}
+	return template, nil
+}
+
+// Returns the path of the binary of 't' in archives: by default, the path of the binary
+// (see outputPaths), or that given by the archive-entry template, with the extension of the binary.
+func (this options) archiveEntry(name string, t target) string {
+	out, outBin := this.outputPaths(name, t)
+	if this.ArchiveEntry == "" {
+		return outBin
+	}
+	goos, goarch := t.osArch()
+	variant := t.variant()
+	goos, goarch = this.Aliases.of(goos), this.Aliases.of(goarch)
+	if !this.ArchiveEntry.has("GOVARIANT") {
+		goarch += variant
+	}
+	entry := this.ArchiveEntry.expand(map[string]string{"NAME": path.Base(out), "TARGET": name, "GOOS": goos, "GOARCH": goarch, "GOVARIANT": variant}, "$")
+	return entry + this.extensionFor(t)
+}
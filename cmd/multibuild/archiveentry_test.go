@@ -0,0 +1,61 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestValidateArchiveEntry(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr bool
+	}{
+		{"myapp", false},
+		{"${NAME}", false},
+		{"${NAME}/${TARGET}", false},
+		{"release/${TARGET}-${GOOS}-${GOARCH}${GOVARIANT}", false},
+		{"{{.NAME}}/{{.TARGET | upper}}", false},
+
+		{"", true},
+		{"/usr/bin/${TARGET}", true},
+		{"../${TARGET}", true},
+		{"dir//${TARGET}", true},
+		{"${NAME}/", true},
+		{"${VERSION}/${TARGET}", true},
+		{"${TARGET}*", true},
+	}
+	for _, tt := range tests {
+		_, err := validateArchiveEntry(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateArchiveEntry(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+		}
+	}
+}
+
+func TestArchiveEntry(t *testing.T) {
+	output := outputTemplate("bin/${TARGET}-${GOOS}-${GOARCH}")
+	tests := []struct {
+		entry  outputTemplate
+		target target
+		want   string
+	}{
+		{"", "linux/amd64", "bin/app-linux-amd64"},
+		{"${NAME}", "windows/amd64", "app-windows-amd64.exe"},
+		{"myapp", "linux/arm/v7", "myapp"},
+		{"${NAME}/${TARGET}", "linux/arm/v7", "app-linux-armv7/app"},
+		{"${TARGET}-${GOARCH}${GOVARIANT}/${TARGET}", "linux/arm/v7", "app-armv7/app"},
+	}
+	for _, tt := range tests {
+		opts := options{Output: output, ArchiveEntry: tt.entry}
+		if got := opts.archiveEntry("app", tt.target); got != tt.want {
+			t.Errorf("archiveEntry(%q, %s) = %q, want %q", tt.entry, tt.target, got, tt.want)
+		}
+	}
+
+	// Aliases apply as they do to output names.
+	opts := options{Output: output, ArchiveEntry: "${TARGET}-${GOARCH}", Aliases: nameAliases{{name: "amd64", alias: "x86_64"}}}
+	if got := opts.archiveEntry("app", "linux/amd64"); got != "app-x86_64" {
+		t.Errorf("archiveEntry with aliases = %q", got)
+	}
+}
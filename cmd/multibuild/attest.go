@@ -0,0 +1,212 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// See https://github.com/in-toto/attestation/tree/main/spec
+const (
+	intotoStatementType = "https://in-toto.io/Statement/v1"
+	intotoLinkPredicate = "https://in-toto.io/attestation/link/v0.3"
+)
+
+// An in-toto ResourceDescriptor: something consumed or produced by a build.
+type intotoResource struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest"`
+}
+
+type intotoLink struct {
+	Name        string            `json:"name"`
+	Command     []string          `json:"command"`
+	Materials   []intotoResource  `json:"materials"`
+	Environment map[string]string `json:"environment,omitempty"`
+}
+
+// An in-toto Statement, with the link predicate.
+type intotoStatement struct {
+	Type          string           `json:"_type"`
+	Subject       []intotoResource `json:"subject"`
+	PredicateType string           `json:"predicateType"`
+	Predicate     intotoLink       `json:"predicate"`
+}
+
+// The parts of 'go list -json' output used to find sources, and materials.
+type listedPackage struct {
+	ImportPath      string
+	Name            string
+	Dir             string
+	Standard        bool
+	CompiledGoFiles []string
+	GoFiles         []string
+	CgoFiles        []string
+	CFiles          []string
+	CXXFiles        []string
+	HFiles          []string
+	SFiles          []string
+	SysoFiles       []string
+	EmbedFiles      []string
+	Module          *listedModule
+}
+
+type listedModule struct {
+	Path    string
+	Version string
+	Main    bool
+	Dir     string
+	GoMod   string
+	Sum     string
+	Replace *listedModule
+}
+
+// Returns the inputs of building 'packagePath' for 'goos'/'goarch' with 'env'.
+// Source files of the main module, and of modules without a go.sum hash (those replaced by
+// a local directory, or vendored), are listed individually, relative to the main module, and
+// other modules by their go.sum hash.
+func attestMaterials(ctx context.Context, env []string, packagePath string, t target) ([]intotoResource, error) {
+	cmd := exec.CommandContext(ctx, "go", "list", "-deps", "-json", packagePath)
+	cmd.Env = targetEnv(env, t)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list: %w", err)
+	}
+
+	var materials []intotoResource
+	var root string
+	var paths []string // local files
+	seen := make(map[string]bool)
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for dec.More() {
+		var p listedPackage
+		if err := dec.Decode(&p); err != nil {
+			return nil, fmt.Errorf("go list: %w", err)
+		}
+		if p.Standard || p.Module == nil {
+			continue
+		}
+		m := p.Module
+		// A module replaced by a local directory keeps its own version, but has no go.sum hash.
+		local := m.Replace != nil && m.Replace.Version == ""
+		if m.Replace != nil {
+			m = m.Replace
+		}
+		if m.Main || local || m.Version == "" || m.Sum == "" {
+			if m.Main && root == "" {
+				root = m.Dir
+				paths = append(paths, filepath.Join(m.Dir, "go.mod"))
+				if _, err := os.Stat(filepath.Join(m.Dir, "go.sum")); err == nil {
+					paths = append(paths, filepath.Join(m.Dir, "go.sum"))
+				}
+			} else if local {
+				paths = append(paths, filepath.Join(m.Dir, "go.mod"))
+			}
+			for _, name := range slices.Concat(p.GoFiles, p.CgoFiles, p.CFiles, p.CXXFiles, p.HFiles, p.SFiles, p.SysoFiles, p.EmbedFiles) {
+				paths = append(paths, filepath.Join(p.Dir, name))
+			}
+			continue
+		}
+		key := m.Path + "@" + m.Version
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		materials = append(materials, intotoResource{
+			URI:    "pkg:golang/" + key,
+			Digest: map[string]string{"dirHash": m.Sum},
+		})
+	}
+
+	// Vendored modules are listed by their files, and modules.txt says which versions they are.
+	if root != "" {
+		if _, err := os.Stat(filepath.Join(root, "vendor", "modules.txt")); err == nil {
+			paths = append(paths, filepath.Join(root, "vendor", "modules.txt"))
+		}
+	}
+
+	var files []intotoResource
+	for _, path := range paths {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		_, sum, err := artifactInfo(path)
+		if err != nil {
+			return nil, err
+		}
+		name := path
+		if rel, err := filepath.Rel(root, path); err == nil && root != "" {
+			name = rel
+		}
+		files = append(files, intotoResource{Name: filepath.ToSlash(name), Digest: map[string]string{"sha256": sum}})
+	}
+	slices.SortFunc(files, func(a, b intotoResource) int { return strings.Compare(a.Name, b.Name) })
+	slices.SortFunc(materials, func(a, b intotoResource) int { return strings.Compare(a.URI, b.URI) })
+	return append(files, materials...), nil
+}
+
+// Builds the in-toto statement for 'r', which was built by 'command' from 'materials'.
+func buildAttestation(r targetResult, command []string, materials []intotoResource) (intotoStatement, error) {
+	goos, goarch := r.target.osArch()
+	st := intotoStatement{
+		Type:          intotoStatementType,
+		PredicateType: intotoLinkPredicate,
+		Predicate: intotoLink{
+			Name:        "build " + string(r.target),
+			Command:     command,
+			Materials:   materials,
+			Environment: map[string]string{"GOOS": goos, "GOARCH": goarch},
+		},
+	}
+	for _, a := range r.artifacts {
+		_, sum, err := artifactInfo(a)
+		if err != nil {
+			return intotoStatement{}, err
+		}
+		st.Subject = append(st.Subject, intotoResource{Name: filepath.ToSlash(a), Digest: map[string]string{"sha256": sum}})
+	}
+	return st, nil
+}
+
+// Writes an in-toto statement for 'r' to 'path'.
+func writeAttestation(path string, r targetResult, command []string, materials []intotoResource) error {
+	st, err := buildAttestation(r, command, materials)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Writes an attestation for 'r', built from 'packagePath' into 'outBin' with 'goBuildArgs',
+// next to its other artifacts.
+func attestTarget(ctx context.Context, env []string, packagePath string, r *targetResult, out, outBin string, goBuildArgs []string) {
+	path := out + ".intoto.json"
+	materials, err := attestMaterials(ctx, env, packagePath, r.target)
+	if err == nil {
+		err = writeAttestation(path, *r, slices.Concat([]string{"go", "build", "-o", outBin}, goBuildArgs), materials)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: attestation: %s\n", r.target, err)
+		os.Remove(path)
+		r.err, r.code = fmt.Errorf("attestation: %w", err), exitArchive
+		return
+	}
+	r.artifacts = append(r.artifacts, path)
+}
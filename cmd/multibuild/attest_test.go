@@ -0,0 +1,110 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestAttestMaterials(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"go.mod":          "module example.com/app\n\ngo 1.24\n",
+		"main.go":         "package main\nimport _ \"example.com/app/lib\"\nfunc main() {}\n",
+		"lib/lib.go":      "package lib\n",
+		"lib/lib_test.go": "package lib\n",
+		"lib/windows.go":  "//go:build windows\npackage lib\n",
+	})
+	t.Chdir(dir)
+
+	for _, tt := range []struct {
+		goos string
+		want []string
+	}{
+		{"linux", []string{"go.mod", "lib/lib.go", "main.go"}},
+		{"windows", []string{"go.mod", "lib/lib.go", "lib/windows.go", "main.go"}},
+	} {
+		materials, err := attestMaterials(context.Background(), os.Environ(), ".", target(tt.goos+"/amd64"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := mapSlice(materials, func(r intotoResource) string { return r.Name })
+		if !slices.Equal(names, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.goos, names, tt.want)
+		}
+		for _, m := range materials {
+			if len(m.Digest["sha256"]) != 64 {
+				t.Errorf("%s: %s: bad digest %v", tt.goos, m.Name, m.Digest)
+			}
+		}
+	}
+}
+
+func TestAttestMaterialsLocalReplace(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"app/go.mod":  "module example.com/app\n\ngo 1.24\n\nrequire example.com/dep v1.0.0\n\nreplace example.com/dep => ../dep\n",
+		"app/main.go": "package main\nimport _ \"example.com/dep\"\nfunc main() {}\n",
+		"dep/go.mod":  "module example.com/dep\n\ngo 1.24\n",
+		"dep/dep.go":  "package dep\n",
+	})
+	t.Chdir(filepath.Join(dir, "app"))
+
+	materials, err := attestMaterials(context.Background(), os.Environ(), ".", "linux/amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := mapSlice(materials, func(r intotoResource) string { return r.Name })
+	if want := []string{"../dep/dep.go", "../dep/go.mod", "go.mod", "main.go"}; !slices.Equal(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+}
+
+func TestAttestMaterialsVendored(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"go.mod":                        "module example.com/app\n\ngo 1.24\n\nrequire example.com/dep v1.0.0\n",
+		"main.go":                       "package main\nimport _ \"example.com/dep\"\nfunc main() {}\n",
+		"vendor/modules.txt":            "# example.com/dep v1.0.0\n## explicit; go 1.24\nexample.com/dep\n",
+		"vendor/example.com/dep/dep.go": "package dep\n",
+	})
+	t.Chdir(dir)
+
+	// Vendored modules have no go.sum hash, so they must be listed by their files.
+	materials, err := attestMaterials(context.Background(), append(os.Environ(), "GOFLAGS=-mod=vendor"), ".", "linux/amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := mapSlice(materials, func(r intotoResource) string { return r.Name })
+	if want := []string{"go.mod", "main.go", "vendor/example.com/dep/dep.go", "vendor/modules.txt"}; !slices.Equal(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+}
+
+func TestBuildAttestation(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "app")
+	if err := os.WriteFile(bin, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := targetResult{target: "linux/arm64", artifacts: []string{bin}}
+	st, err := buildAttestation(r, []string{"go", "build"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Type != intotoStatementType || st.PredicateType != intotoLinkPredicate {
+		t.Errorf("unexpected types: %s, %s", st.Type, st.PredicateType)
+	}
+	if len(st.Subject) != 1 || st.Subject[0].Digest["sha256"] != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("unexpected subject: %v", st.Subject)
+	}
+	if st.Predicate.Environment["GOOS"] != "linux" || st.Predicate.Environment["GOARCH"] != "arm64" {
+		t.Errorf("unexpected environment: %v", st.Predicate.Environment)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// Bundles are single archives of every target, with each target's binary under a directory
+// of its own, e.g. format=bundle.tar.gz writes app-all-all.tar.gz containing
+// linux-amd64/app-linux-amd64, windows-amd64/app-windows-amd64.exe, and so on.
+
+// Returns whether the format is a bundle of every target, rather than something for each.
+func (this format) bundles() bool {
+	return strings.HasPrefix(string(this), "bundle.")
+}
+
+// Returns the path of the bundle in 'f': the output template, with "all" for ${GOOS} and
+// ${GOARCH}. 'name' is the value of ${TARGET}.
+func (this options) bundlePath(name string, f format) string {
+	return this.Output.expand(map[string]string{"TARGET": name, "GOOS": "all", "GOARCH": "all", "GOVARIANT": ""}, "$") + f.extension()
+}
+
+// Returns the directory of 't' in bundles, e.g. linux-arm-v7.
+func bundleDir(t target) string {
+	return strings.ReplaceAll(string(t), "/", "-")
+}
+
+// Returns the path of the binary of 't' in bundles: that in archives (see archiveEntry) under the
+// directory of 't', though without the directories of the binary, if that is its output path.
+func (this options) bundleEntry(name string, t target) string {
+	entry := this.archiveEntry(name, t)
+	if this.ArchiveEntry == "" {
+		entry = path.Base(entry)
+	}
+	return bundleDir(t) + "/" + entry
+}
+
+// Writes a bundle in 'f' at 'arPath', containing 'bins', and 'extra'.
+func writeBundle(ctx context.Context, f format, arPath string, bins []archiveBinary, extra []archiveFile, level compressionLevel) error {
+	var newArchiver func(io.Writer) (archiver, error)
+	switch f {
+	case formatBundleZip:
+		newArchiver = newZipArchiver(level)
+	case formatBundleTgz:
+		newArchiver = newTgzArchiver(level)
+	case formatBundleTarZst:
+		newArchiver = newTarZstArchiver(ctx, level)
+	default:
+		return fmt.Errorf("%s is not a bundle", f)
+	}
+	return writeArchiveOf(arPath, newArchiver, bins, extra)
+}
+
+// Writes a bundle in each bundle format of 'opts', of the binaries of 'results', built at
+// 'binPaths', returning their paths. 'name' is the value of ${TARGET}.
+func writeBundles(ctx context.Context, opts options, name string, results []targetResult, binPaths []string) ([]string, error) {
+	var bins []archiveBinary
+	var extra []archiveFile
+	for i, r := range results {
+		entry := opts.bundleEntry(name, r.target)
+		bins = append(bins, archiveBinary{name: entry, mode: binaryMode(r.target), path: binPaths[i]})
+		e, err := archiveExtras(r.target, entry, opts)
+		if err != nil {
+			return nil, err
+		}
+		extra = append(extra, e...)
+	}
+	var paths []string
+	for _, f := range opts.Format {
+		if !f.bundles() {
+			continue
+		}
+		arPath := opts.bundlePath(name, f)
+		if err := writeBundle(ctx, f, arPath, bins, extra, opts.Compression); err != nil {
+			os.Remove(arPath) // don't leave a broken bundle lying around
+			return paths, err
+		}
+		fmt.Fprintf(os.Stderr, "multibuild: wrote %s\n", arPath)
+		paths = append(paths, arPath)
+	}
+	return paths, nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestBundlePaths(t *testing.T) {
+	opts := options{Output: "dist/${TARGET}-${GOOS}-${GOARCH}"}
+	if got := opts.bundlePath("app", formatBundleTgz); got != "dist/app-all-all.tar.gz" {
+		t.Errorf("bundlePath() = %q", got)
+	}
+
+	tests := []struct {
+		entry  outputTemplate
+		target target
+		want   string
+	}{
+		{"", "linux/amd64", "linux-amd64/app-linux-amd64"},
+		{"", "windows/amd64", "windows-amd64/app-windows-amd64.exe"},
+		{"", "linux/arm/v7", "linux-arm-v7/app-linux-armv7"},
+		{"${TARGET}", "windows/amd64", "windows-amd64/app.exe"},
+		{"${NAME}/${TARGET}", "linux/amd64", "linux-amd64/app-linux-amd64/app"},
+	}
+	for _, tt := range tests {
+		opts.ArchiveEntry = tt.entry
+		if got := opts.bundleEntry("app", tt.target); got != tt.want {
+			t.Errorf("bundleEntry(%q, %s) = %q, want %q", tt.entry, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestWriteBundles(t *testing.T) {
+	t.Chdir(t.TempDir())
+	for _, name := range []string{"a", "b"} {
+		if err := os.WriteFile(name, []byte(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := options{Output: "${TARGET}-${GOOS}-${GOARCH}", Format: []format{formatRaw, formatBundleZip}, Package: packageInfo{Service: "app"}}
+	results := []targetResult{{target: "linux/amd64"}, {target: "js/wasm"}}
+	if _, err := writeBundles(context.Background(), opts, "app", results, []string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.OpenReader("app-all-all.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name+" "+f.Mode().String())
+	}
+	want := []string{"linux-amd64/app-linux-amd64 -rwxr-xr-x", "js-wasm/app-js-wasm.wasm -rw-r--r--", "linux-amd64/app-linux-amd64.service -rw-r--r--"}
+	if !slices.Equal(names, want) {
+		t.Errorf("got entries %q, want %q", names, want)
+	}
+}
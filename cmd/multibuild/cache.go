@@ -0,0 +1,128 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Files modified more recently than this aren't cached, as a change within the resolution
+// of the filesystem's timestamps might otherwise go unnoticed (as with the go build cache).
+const cacheModTimeCutoff = 2 * time.Second
+
+// Returns the directory multibuild caches things in, or "" if caching is off.
+// Like GOCACHE, $MULTIBUILD_CACHE picks another directory, or turns caching off if it is "off".
+func cacheDir() string {
+	if dir := os.Getenv("MULTIBUILD_CACHE"); dir == "off" {
+		return ""
+	} else if dir != "" {
+		return dir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "multibuild")
+}
+
+// Validates 's', the value of gocache= or gocacheprog=.
+func validateGoCache(s string) (string, error) {
+	if s == "" {
+		return "", fmt.Errorf("empty value")
+	}
+	return s, nil
+}
+
+// Returns the variables pointing child builds at the build cache set by gocache= and
+// gocacheprog= in 'opts', e.g. one shared by the jobs of a CI fleet, if either is set.
+func goCacheEnviron(opts options) ([]string, error) {
+	var env []string
+	if opts.GoCache != "" {
+		// go insists on an absolute path.
+		dir, err := filepath.Abs(opts.GoCache)
+		if err != nil {
+			return nil, fmt.Errorf("gocache=%s: %w", opts.GoCache, err)
+		}
+		env = append(env, "GOCACHE="+dir)
+	}
+	if opts.GoCacheProg != "" {
+		env = append(env, "GOCACHEPROG="+opts.GoCacheProg)
+	}
+	return env, nil
+}
+
+// Returns the path the directives of the source file at 'path' are cached at, or "" if they
+// shouldn't be. Hashing the content would mean reading the whole file, which is all that
+// scanning it does, so files are identified by their path, size and modification time instead.
+func directiveCachePath(dir, path string, st os.FileInfo) string {
+	if dir == "" || time.Since(st.ModTime()) < cacheModTimeCutoff {
+		return ""
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+	key := sha256.Sum256(fmt.Appendf(nil, "directives v1\n%s\n%d\n%d\n", abs, st.Size(), st.ModTime().UnixNano()))
+	return filepath.Join(dir, "directives", hex.EncodeToString(key[:]))
+}
+
+// Returns the directives in the source file at 'path', from the cache if the file hasn't
+// changed since it was last scanned.
+func cachedDirectives(path string) ([]directiveLine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open: %s: %w", path, err)
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat: %s: %w", path, err)
+	}
+
+	cached := directiveCachePath(cacheDir(), path, st)
+	if cached != "" {
+		var lines []directiveLine
+		if data, err := os.ReadFile(cached); err == nil && json.Unmarshal(data, &lines) == nil {
+			return lines, nil
+		}
+	}
+	lines, err := findDirectives(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if cached != "" {
+		// The cache is only an optimisation, so failing to write it doesn't matter.
+		if data, err := json.Marshal(lines); err == nil && os.MkdirAll(filepath.Dir(cached), 0755) == nil {
+			writeFileAtomic(cached, data)
+		}
+	}
+	return lines, nil
+}
+
+// Writes 'data' to 'path' through a temporary file, so that concurrent readers
+// never see it half written.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,92 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCachedDirectives(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("MULTIBUILD_CACHE", filepath.Join(dir, "cache"))
+	path := filepath.Join(dir, "main.go")
+	write := func(src string, mtime time.Time) {
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func() []directiveLine {
+		lines, err := cachedDirectives(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lines
+	}
+	cachePath := func() string {
+		st, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return directiveCachePath(cacheDir(), path, st)
+	}
+
+	old := time.Now().Add(-time.Hour)
+	write("package main\n\n//go:multibuild:include=linux/*\n", old)
+	want := []directiveLine{{3, "//go:multibuild:include=linux/*"}}
+	if got := get(); !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// The second time around, the cache is used.
+	if err := os.WriteFile(cachePath(), []byte(`[{"line":1,"text":"//go:multibuild:include=cached"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(); len(got) != 1 || got[0].Text != "//go:multibuild:include=cached" {
+		t.Errorf("cache was not used: %v", got)
+	}
+
+	// Changes are noticed.
+	write("package main\n//go:multibuild:include=darwin/*\n", old.Add(time.Second))
+	if got := get(); len(got) != 1 || got[0].Text != "//go:multibuild:include=darwin/*" {
+		t.Errorf("change was not noticed: %v", got)
+	}
+
+	// Recently modified files aren't cached, and neither is anything if it is turned off.
+	write("package main\n", time.Now())
+	if cachePath() != "" {
+		t.Errorf("recently modified file would be cached")
+	}
+	write("package main\n", old)
+	t.Setenv("MULTIBUILD_CACHE", "off")
+	if cachePath() != "" {
+		t.Errorf("file would be cached with MULTIBUILD_CACHE=off")
+	}
+}
+
+func TestGoCacheEnviron(t *testing.T) {
+	t.Chdir(t.TempDir())
+	wd, _ := os.Getwd()
+
+	env, err := goCacheEnviron(options{})
+	if err != nil || len(env) != 0 {
+		t.Errorf("got %v, %v, want nothing", env, err)
+	}
+
+	// Relative directories are relative to where multibuild runs, as go needs them absolute.
+	env, err = goCacheEnviron(options{GoCache: "cache", GoCacheProg: "cacheprog -remote https://cache.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"GOCACHE=" + filepath.Join(wd, "cache"), "GOCACHEPROG=cacheprog -remote https://cache.example"}
+	if !slices.Equal(env, want) {
+		t.Errorf("got %v, want %v", env, want)
+	}
+}
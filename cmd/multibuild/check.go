@@ -0,0 +1,230 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// A problem which would stop a target from building.
+type checkError struct {
+	// Where the problem is, e.g. main.go:3:2, or empty if it isn't in a file.
+	Pos string `json:"pos,omitempty"`
+
+	Message string `json:"message"`
+}
+
+func (this checkError) String() string {
+	if this.Pos == "" {
+		return this.Message
+	}
+	return this.Pos + ": " + this.Message
+}
+
+// The result of type checking a target.
+type checkResult struct {
+	Target target       `json:"target"`
+	Errors []checkError `json:"errors"`
+}
+
+// Adapts a function to types.Importer.
+type checkImporter func(path string) (*types.Package, error)
+
+func (this checkImporter) Import(path string) (*types.Package, error) {
+	return this(path)
+}
+
+// Source files, parsed once and shared between targets, as most of them (especially
+// in the standard library) are the same for every target.
+type parsedFiles struct {
+	fset *token.FileSet
+
+	mu    sync.Mutex
+	files map[string]parsedFile
+}
+
+type parsedFile struct {
+	file *ast.File
+	err  error
+}
+
+func newParsedFiles() *parsedFiles {
+	return &parsedFiles{fset: token.NewFileSet(), files: make(map[string]parsedFile)}
+}
+
+// Returns the parsed file at 'path'. The result must not be modified.
+func (this *parsedFiles) parse(path string) (*ast.File, error) {
+	this.mu.Lock()
+	f, ok := this.files[path]
+	this.mu.Unlock()
+	if !ok {
+		f.file, f.err = parser.ParseFile(this.fset, path, nil, parser.SkipObjectResolution)
+		this.mu.Lock()
+		this.files[path] = f
+		this.mu.Unlock()
+	}
+	return f.file, f.err
+}
+
+// Type checks 'patterns' as they would be built for 't', in this process rather than with the
+// compiler, which is much faster, though it can't find problems only the linker would.
+// The standard library is trusted to be correct, so only its declarations are checked.
+func typeCheck(ctx context.Context, env []string, parsed *parsedFiles, patterns []string, t target) (checkResult, error) {
+	_, goarch := t.osArch()
+	result := checkResult{Target: t, Errors: []checkError{}}
+
+	cmd := exec.CommandContext(ctx, "go", append([]string{"list", "-e", "-deps", "-json=ImportPath,Dir,Standard,GoFiles,CgoFiles,ImportMap,Error"}, patterns...)...)
+	cmd.Env = targetEnv(env, t)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return result, fmt.Errorf("go list: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	sizes := types.SizesFor("gc", goarch)
+	checked := make(map[string]*types.Package)
+	pos := func(p token.Position) string {
+		if !p.IsValid() {
+			return ""
+		}
+		if wd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(wd, p.Filename); err == nil && !strings.HasPrefix(rel, "..") {
+				p.Filename = rel
+			}
+		}
+		return p.String()
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for dec.More() {
+		// Dependencies are listed before the packages which import them.
+		var p struct {
+			listedPackage
+			ImportMap map[string]string
+			Error     *struct{ Pos, Err string }
+		}
+		if err := dec.Decode(&p); err != nil {
+			return result, fmt.Errorf("go list: %w", err)
+		}
+		if p.Error != nil {
+			result.Errors = append(result.Errors, checkError{Pos: p.Error.Pos, Message: p.Error.Err})
+			continue
+		}
+
+		var files []*ast.File
+		for _, name := range append(p.GoFiles, p.CgoFiles...) {
+			f, err := parsed.parse(filepath.Join(p.Dir, name))
+			if list, ok := err.(scanner.ErrorList); ok && !p.Standard {
+				for _, e := range list {
+					result.Errors = append(result.Errors, checkError{Pos: pos(e.Pos), Message: e.Msg})
+				}
+			} else if err != nil && !p.Standard {
+				result.Errors = append(result.Errors, checkError{Message: err.Error()})
+			}
+			if f != nil {
+				files = append(files, f)
+			}
+		}
+		conf := types.Config{
+			Importer: checkImporter(func(path string) (*types.Package, error) {
+				if path == "unsafe" {
+					return types.Unsafe, nil
+				}
+				if mapped, ok := p.ImportMap[path]; ok {
+					path = mapped
+				}
+				if pkg, ok := checked[path]; ok {
+					return pkg, nil
+				}
+				return nil, fmt.Errorf("package %s not found", path)
+			}),
+			Sizes:            sizes,
+			FakeImportC:      true,
+			IgnoreFuncBodies: p.Standard,
+			Error: func(err error) {
+				var terr types.Error
+				if p.Standard || !errors.As(err, &terr) {
+					return
+				}
+				result.Errors = append(result.Errors, checkError{Pos: pos(terr.Fset.Position(terr.Pos)), Message: terr.Msg})
+			},
+		}
+		pkg, _ := conf.Check(p.ImportPath, parsed.fset, files, nil)
+		checked[p.ImportPath] = pkg
+	}
+	return result, nil
+}
+
+// Type checks 'patterns' for each of 'targets', a few at a time, returning the results in the
+// same order. Each is checked with 'env' and the variables set for it in 'opts'.
+func typeCheckTargets(ctx context.Context, env []string, opts options, patterns []string, targets []target) ([]checkResult, error) {
+	results := make([]checkResult, len(targets))
+	errs := make([]error, len(targets))
+	parsed := newParsedFiles()
+	slots := make(chan struct{}, runtime.NumCPU())
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = typeCheck(ctx, append(slices.Clone(env), opts.environFor(t)...), parsed, patterns, t)
+			<-slots
+		}()
+	}
+	wg.Wait()
+	return results, errors.Join(errs...)
+}
+
+// Implements --multibuild-check: type checks every target, reporting what would stop any of
+// them building, and exits.
+func checkAndExit(args cliArgs, opts options, targets []target) {
+	env, cleanupEnv, err := buildEnviron(args.sandbox, opts)
+	if err != nil {
+		fatal("multibuild: failed to set up the build environment: %s", err)
+	}
+	patterns := args.sources
+	if len(patterns) == 0 {
+		patterns = []string{args.packagePath}
+	}
+	results, err := typeCheckTargets(context.Background(), env, opts, patterns, targets)
+	cleanupEnv()
+	if err != nil {
+		fatal("multibuild: %s", err)
+	}
+
+	failed := 0
+	for _, r := range results {
+		for _, e := range r.Errors {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", r.Target, e)
+		}
+		if len(r.Errors) > 0 {
+			failed++
+		} else if args.verbose {
+			fmt.Fprintf(os.Stderr, "%s: ok\n", r.Target)
+		}
+	}
+	if failed > 0 {
+		fatalCode(exitBuild, "multibuild: %d of %d targets failed to type check", failed, len(results))
+	}
+	os.Exit(0)
+}
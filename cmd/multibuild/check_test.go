@@ -0,0 +1,48 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTypeCheckTargets(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":         "module example.com/app\n\ngo 1.24\n",
+		"main.go":        "package main\n\nimport \"fmt\"\n\nfunc main() { fmt.Println(name()) }\n",
+		"name_linux.go":  "package main\n\nfunc name() string { return \"linux\" }\n",
+		"name_darwin.go": "package main\n\nfunc name() int { return 1 }\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Chdir(dir)
+	targets := []target{"linux/amd64", "darwin/arm64", "windows/amd64"}
+	results, err := typeCheckTargets(context.Background(), os.Environ(), options{}, []string{"."}, targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(targets) {
+		t.Fatalf("got %d results, want %d", len(results), len(targets))
+	}
+	if r := results[0]; r.Target != "linux/amd64" || len(r.Errors) != 0 {
+		t.Errorf("linux: got %+v", r)
+	}
+	// fmt.Println takes anything, so an int is fine: it's only missing on windows.
+	if r := results[1]; r.Target != "darwin/arm64" || len(r.Errors) != 0 {
+		t.Errorf("darwin: got %+v", r)
+	}
+	if r := results[2]; r.Target != "windows/amd64" || len(r.Errors) != 1 || !strings.Contains(r.Errors[0].String(), "main.go:5:") || !strings.Contains(r.Errors[0].Message, "undefined: name") {
+		t.Errorf("windows: got %+v", r)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The algorithm of the checksums file and sidecars. Without a checksum-algorithm setting, it is
+// "", which is the same as sha256. The manifest, attestations and summaries always use SHA-256.
+type checksumAlgorithm string
+
+const (
+	checksumSHA256  checksumAlgorithm = "sha256"
+	checksumSHA512  checksumAlgorithm = "sha512"
+	checksumBLAKE2b checksumAlgorithm = "blake2b"
+)
+
+// Validates that 's' is a checksum algorithm.
+func validateChecksumAlgorithm(s string) (checksumAlgorithm, error) {
+	switch a := checksumAlgorithm(s); a {
+	case checksumSHA256, checksumSHA512, checksumBLAKE2b:
+		return a, nil
+	}
+	return "", fmt.Errorf("%q is not one of %s, %s, %s", s, checksumSHA256, checksumSHA512, checksumBLAKE2b)
+}
+
+// Returns whether this is SHA-256, the digests of which are already worked out for the manifest.
+func (this checksumAlgorithm) isSHA256() bool {
+	return this == "" || this == checksumSHA256
+}
+
+func (this checksumAlgorithm) newHash() hash.Hash {
+	switch this {
+	case checksumSHA512:
+		return sha512.New()
+	case checksumBLAKE2b:
+		return newBlake2b()
+	}
+	return sha256.New()
+}
+
+// Returns the extension of sidecars, e.g. .sha256 for app.zip.sha256.
+func (this checksumAlgorithm) extension() string {
+	if this.isSHA256() {
+		return "." + string(checksumSHA256)
+	}
+	return "." + string(this)
+}
+
+// Returns the checksum of the artifact at 'path', as hex.
+func (this checksumAlgorithm) sum(path string) (string, error) {
+	if this.isSHA256() {
+		_, sum, err := artifactInfo(path)
+		return sum, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := this.newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Validates that 's' is a checksum-sidecars setting, a boolean, returning it as "true" or "false".
+func validateChecksumSidecars(s string) (string, error) {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return "", fmt.Errorf("%q is not true or false", s)
+	}
+	return strconv.FormatBool(b), nil
+}
+
+// Writes a sidecar next to the artifact at 'path', e.g. app.zip.sha256, with its checksum 'sum',
+// in the format sha256sum (or sha512sum, or b2sum) writes, so that it can be checked from the
+// directory it is in. Returns the path of the sidecar.
+func writeSidecar(path, sum string, algorithm checksumAlgorithm) (string, error) {
+	sidecar := path + algorithm.extension()
+	if err := os.WriteFile(sidecar, []byte(sum+"  "+filepath.Base(path)+"\n"), 0644); err != nil {
+		os.Remove(sidecar)
+		return "", err
+	}
+	return sidecar, nil
+}
+
+// Writes a sidecar of each of 'paths', e.g. bundles, returning the paths of the sidecars.
+func writeSidecars(paths []string, algorithm checksumAlgorithm) ([]string, error) {
+	var sidecars []string
+	for _, path := range paths {
+		sum, err := algorithm.sum(path)
+		if err != nil {
+			return sidecars, fmt.Errorf("failed to checksum %s: %w", path, err)
+		}
+		sidecar, err := writeSidecar(path, sum, algorithm)
+		if err != nil {
+			return sidecars, err
+		}
+		sidecars = append(sidecars, sidecar)
+	}
+	return sidecars, nil
+}
+
+// Validates a checksums setting: the path of the file to write the checksums of the run's
+// binaries and archives to, e.g. SHA256SUMS or dist/SHA256SUMS.
+func validateChecksums(s string) (string, error) {
+	if s == "" {
+		return "", fmt.Errorf("empty path")
+	}
+	if strings.HasSuffix(s, "/") {
+		return "", fmt.Errorf("%q is a directory, expected a file, e.g. SHA256SUMS", s)
+	}
+	for _, c := range s {
+		if why := unsafeOutputChar(c, runtime.GOOS); why != "" {
+			return "", fmt.Errorf("unexpected character %q in %s: %s", c, s, why)
+		}
+	}
+	return s, nil
+}
+
+// Returns the contents of a checksums file at 'path', for the artifacts with 'sums' (by path), in
+// the format sha256sum (or sha512sum, or b2sum) writes, and checks with -c. Paths are relative to that of the
+// checksums file, so that the check works from there, wherever the files are copied to.
+func formatChecksums(path string, sums map[string]string) (string, error) {
+	dir := filepath.Dir(path)
+	var b strings.Builder
+	for _, artifact := range slices.Sorted(maps.Keys(sums)) {
+		rel, err := filepath.Rel(dir, artifact)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s  %s\n", sums[artifact], filepath.ToSlash(rel))
+	}
+	return b.String(), nil
+}
+
+// Writes the checksums file at 'path', covering the artifacts of the targets in 'results' which
+// were kept (see applyPartialPolicy), and 'extra' artifacts of the run, e.g. bundles, with 'algorithm'.
+func writeChecksums(path string, algorithm checksumAlgorithm, results []targetResult, extra []string) error {
+	sums := make(map[string]string)
+	for _, r := range results {
+		if r.err != nil || r.discarded {
+			continue
+		}
+		for a, sum := range r.sums {
+			sums[a] = sum
+		}
+	}
+	for _, a := range extra {
+		sum, err := algorithm.sum(a)
+		if err != nil {
+			return fmt.Errorf("failed to checksum %s: %w", a, err)
+		}
+		sums[a] = sum
+	}
+	data, err := formatChecksums(path, sums)
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, []byte(data), 0644)
+}
@@ -0,0 +1,124 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateChecksums(t *testing.T) {
+	for _, s := range []string{"SHA256SUMS", "dist/SHA256SUMS", "../checksums.txt"} {
+		if _, err := validateChecksums(s); err != nil {
+			t.Errorf("validateChecksums(%q): unexpected error: %v", s, err)
+		}
+	}
+	for _, s := range []string{"", "dist/", "SUMS*", "a\tb"} {
+		if _, err := validateChecksums(s); err == nil {
+			t.Errorf("validateChecksums(%q): expected error", s)
+		}
+	}
+}
+
+func TestWriteChecksums(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("dist", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("dist/app-all-all.zip", []byte("bundle"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	results := []targetResult{
+		{target: "linux/amd64", sums: map[string]string{"dist/app-linux-amd64": "aa", "dist/app-linux-amd64.zip": "bb"}},
+		{target: "windows/amd64", sums: map[string]string{"dist/app-windows-amd64.exe": "cc"}, discarded: true},
+		{target: "darwin/arm64", err: os.ErrNotExist},
+	}
+	if err := writeChecksums("dist/SHA256SUMS", "", results, []string{"dist/app-all-all.zip"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile("dist/SHA256SUMS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "1e6ed65d77d6364eeaed5a745ba5c4985ae2b700dd85d7cf7f027bdf294a33fc  app-all-all.zip\n" +
+		"aa  app-linux-amd64\n" +
+		"bb  app-linux-amd64.zip\n"
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestChecksumAlgorithms(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app")
+	if err := os.WriteFile(path, []byte("abc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for algorithm, want := range map[checksumAlgorithm]string{
+		"":              "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		checksumSHA256:  "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		checksumSHA512:  "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f",
+		checksumBLAKE2b: "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923",
+	} {
+		if got, err := algorithm.sum(path); err != nil || got != want {
+			t.Errorf("%q: got %s, %v, want %s", algorithm, got, err, want)
+		}
+	}
+	if _, err := validateChecksumAlgorithm("md5"); err == nil {
+		t.Error("validateChecksumAlgorithm(md5): expected error")
+	}
+}
+
+func TestBlake2b(t *testing.T) {
+	// From b2sum, around the block size, where the last block is compressed differently.
+	for n, want := range map[int]string{
+		0:   "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce",
+		128: "fc6c71f688f43ea7d60817478808f3cac753e61571865c95adbc2d9122c943a76b92c2cb1047ef3fe7bf6e436ec1d0a99a9e5b216780bf7fed9d7ca91d3a8f3b",
+		129: "55e6e0eb418149a8af92fd9ddc99254781b2f522a131b4f4d984404b71a00e1167b8124d5dcddd4c6977b299392335d6edd303da6d344d74bbef2d38101b232b",
+		256: "0eee13d0c73a2710c5015a8b4be0a16120bb88f826b662951ffe4b3b81441cfdce1f712c58e237dba72a0dad7f9c86b9745ea0b4b3b850ff3a260fb7df9d3e81",
+	} {
+		data := bytes.Repeat([]byte{'a'}, n)
+		h := newBlake2b()
+		h.Write(data[:n/2])
+		h.Write(data[n/2:])
+		if got := hex.EncodeToString(h.Sum(nil)); got != want {
+			t.Errorf("%d bytes: got %s, want %s", n, got, want)
+		}
+	}
+}
+
+func TestChecksumSidecars(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "app-linux-amd64")
+	if err := os.WriteFile(bin, []byte("abc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	r := targetResult{target: "linux/amd64", artifacts: []string{bin}}
+	checksumTarget(&r, options{ChecksumAlgorithm: checksumSHA512, ChecksumSidecars: "true"})
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	sidecar := bin + ".sha512"
+	if !slices.Equal(r.artifacts, []string{bin, sidecar}) {
+		t.Errorf("got artifacts %v", r.artifacts)
+	}
+	// The manifest's checksums are SHA-256 whatever the algorithm, and don't cover sidecars.
+	if len(r.checksums) != 1 || r.checksums[bin] != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("got checksums %v", r.checksums)
+	}
+	got, err := os.ReadFile(sidecar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := r.sums[bin] + "  app-linux-amd64\n"
+	if !strings.HasPrefix(want, "ddaf35a1") || string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
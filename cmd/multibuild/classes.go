@@ -0,0 +1,242 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// How expensive a target is to build, so that e.g. a few slow cgo or wasm builds
+// don't hold up the many cheap ones.
+type resourceClass string
+
+const (
+	classHeavy resourceClass = "heavy"
+	classLight resourceClass = "light"
+)
+
+// The resource classes, in the order they are matched.
+var resourceClasses = []resourceClass{classHeavy, classLight}
+
+// Validates that 's' is a resource class.
+func validateClass(s string) (resourceClass, error) {
+	if c := resourceClass(s); slices.Contains(resourceClasses, c) {
+		return c, nil
+	}
+	return "", fmt.Errorf("%q is not a resource class, expected one of: heavy, light", s)
+}
+
+// Puts the targets matching 'filter' in 'class'.
+// e.g. //go:multibuild:class.heavy=js/wasm,windows/*
+type classTag struct {
+	filter filter
+	class  resourceClass
+}
+
+// How the targets of a resource class are built.
+// e.g. //go:multibuild:class.heavy.parallel=1
+type classSettings struct {
+	// How many targets of the class to build at once, within parallel=. Zero if not set.
+	parallel int
+
+	// Limits for each build, in place of memory-limit= and cpu-limit=.
+	limits buildLimits
+}
+
+// The keys of class.CLASS. settings, see classSettings.
+var classKeys = []string{"parallel", "memory-limit", "cpu-limit"}
+
+// Sets 'key', which must be in classKeys, from 'value'.
+func (this *classSettings) set(key, value string) error {
+	var err error
+	switch key {
+	case "parallel":
+		var n int
+		if n, err = strconv.Atoi(value); err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		} else if n < 1 {
+			return fmt.Errorf("must be at least 1, got %d", n)
+		}
+		this.parallel = n
+	case "memory-limit":
+		this.limits.memory, err = validateMemoryLimit(value)
+	case "cpu-limit":
+		this.limits.cpus, err = validateCPULimit(value)
+	default:
+		panic("unknown class key " + key)
+	}
+	return err
+}
+
+// Returns the value of 'key', which must be in classKeys, as it is written in a directive,
+// or "" if it isn't set.
+func (this classSettings) get(key string) string {
+	switch key {
+	case "parallel":
+		if this.parallel != 0 {
+			return strconv.Itoa(this.parallel)
+		}
+	case "memory-limit":
+		if this.limits.memory != 0 {
+			return formatMemoryLimit(this.limits.memory)
+		}
+	case "cpu-limit":
+		if this.limits.cpus != 0 {
+			return formatCPULimit(this.limits.cpus)
+		}
+	default:
+		panic("unknown class key " + key)
+	}
+	return ""
+}
+
+// Sets 'key' from 'from', if it is set there.
+func (this *classSettings) copy(from classSettings, key string) {
+	if v := from.get(key); v != "" {
+		this.set(key, v)
+	}
+}
+
+// The settings of each resource class.
+type classesSettings struct {
+	Heavy classSettings
+	Light classSettings
+}
+
+// Returns the settings of 'class'.
+func (this *classesSettings) of(class resourceClass) *classSettings {
+	switch class {
+	case classHeavy:
+		return &this.Heavy
+	case classLight:
+		return &this.Light
+	}
+	panic("unknown resource class " + class)
+}
+
+// Returns the resource class of 't', or "" if it isn't in one.
+// A target matching more than one class is in the first of resourceClasses.
+func (this options) classOf(t target) resourceClass {
+	for _, class := range resourceClasses {
+		if slices.ContainsFunc(this.Classes, func(tag classTag) bool { return tag.class == class && tag.filter.matches(t) }) {
+			return class
+		}
+	}
+	return ""
+}
+
+// Returns the filters which put targets in 'class'.
+func (this options) classFilters(class resourceClass) []filter {
+	var filters []filter
+	for _, tag := range this.Classes {
+		if tag.class == class {
+			filters = append(filters, tag.filter)
+		}
+	}
+	return filters
+}
+
+// Returns how many targets of 'class' may build at once, or 0 if only parallel= limits them.
+// Unless set otherwise, heavy targets build one at a time.
+func (this options) classParallel(class resourceClass) int {
+	if class == "" {
+		return 0
+	}
+	if n := this.ClassSettings.of(class).parallel; n != 0 {
+		return n
+	}
+	if class == classHeavy {
+		return 1
+	}
+	return 0
+}
+
+// Returns the limits for building 't': those of its class, or else memory-limit= and cpu-limit=.
+func (this options) limitsFor(t target) buildLimits {
+	limits := this.Limits
+	if class := this.classOf(t); class != "" {
+		settings := this.ClassSettings.of(class)
+		if settings.limits.memory != 0 {
+			limits.memory = settings.limits.memory
+		}
+		if settings.limits.cpus != 0 {
+			limits.cpus = settings.limits.cpus
+		}
+	}
+	return limits
+}
+
+// Returns every set of limits builds may run under.
+func (this options) allLimits() []buildLimits {
+	limits := []buildLimits{this.Limits}
+	for _, class := range resourceClasses {
+		limits = append(limits, this.ClassSettings.of(class).limits)
+	}
+	return limits
+}
+
+// Decides which target to start building next, keeping each resource class to its share.
+// A target which must wait for its class doesn't hold up later targets in other classes.
+type classScheduler struct {
+	classOf func(target) resourceClass
+	limit   map[resourceClass]int
+
+	mu      sync.Mutex
+	running map[resourceClass]int
+	changed chan struct{}
+}
+
+// Returns a scheduler for the classes in 'opts'.
+func newClassScheduler(opts options) *classScheduler {
+	limit := make(map[resourceClass]int)
+	for _, class := range resourceClasses {
+		limit[class] = opts.classParallel(class)
+	}
+	return &classScheduler{
+		classOf: opts.classOf,
+		limit:   limit,
+		running: make(map[resourceClass]int),
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// Returns the index in 'pending' of the first target whose class has room, which is then
+// counted as running, waiting for room if there is none. Returns -1 if 'ctx' is done first.
+func (this *classScheduler) next(ctx context.Context, pending []target) int {
+	for {
+		this.mu.Lock()
+		idx := slices.IndexFunc(pending, func(t target) bool {
+			class := this.classOf(t)
+			return this.limit[class] == 0 || this.running[class] < this.limit[class]
+		})
+		if idx >= 0 {
+			this.running[this.classOf(pending[idx])]++
+		}
+		this.mu.Unlock()
+		if idx >= 0 {
+			return idx
+		}
+		select {
+		case <-this.changed:
+		case <-ctx.Done():
+			return -1
+		}
+	}
+}
+
+// Records that a build of 't' has finished.
+func (this *classScheduler) done(t target) {
+	this.mu.Lock()
+	this.running[this.classOf(t)]--
+	this.mu.Unlock()
+	select {
+	case this.changed <- struct{}{}:
+	default:
+	}
+}
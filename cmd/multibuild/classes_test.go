@@ -0,0 +1,78 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestClassOf(t *testing.T) {
+	opts := options{
+		Classes: []classTag{{"linux/*", classLight}, {"js/wasm", classHeavy}, {"linux/s390x", classHeavy}},
+		Limits:  buildLimits{memory: 1 << 30, cpus: 2},
+	}
+	opts.ClassSettings.Heavy.limits.memory = 4 << 30
+
+	for tgt, want := range map[target]resourceClass{
+		"linux/amd64":   classLight,
+		"linux/s390x":   classHeavy,
+		"js/wasm":       classHeavy,
+		"windows/amd64": "",
+	} {
+		if got := opts.classOf(tgt); got != want {
+			t.Errorf("%s: got class %q, want %q", tgt, got, want)
+		}
+	}
+
+	if got, want := opts.limitsFor("js/wasm"), (buildLimits{memory: 4 << 30, cpus: 2}); got != want {
+		t.Errorf("heavy limits: got %+v, want %+v", got, want)
+	}
+	if got, want := opts.limitsFor("linux/amd64"), opts.Limits; got != want {
+		t.Errorf("light limits: got %+v, want %+v", got, want)
+	}
+
+	if got := opts.classParallel(classHeavy); got != 1 {
+		t.Errorf("heavy parallel: got %d, want 1 by default", got)
+	}
+	if got := opts.classParallel(classLight); got != 0 {
+		t.Errorf("light parallel: got %d, want no limit by default", got)
+	}
+}
+
+func TestClassScheduler(t *testing.T) {
+	opts := options{Classes: []classTag{{"js/wasm", classHeavy}, {"windows/*", classHeavy}}}
+	s := newClassScheduler(opts)
+	ctx := context.Background()
+
+	pending := []target{"js/wasm", "windows/amd64", "linux/amd64"}
+	if got := s.next(ctx, pending); got != 0 {
+		t.Fatalf("first: got %d, want 0", got)
+	}
+	pending = pending[1:]
+
+	// The heavy class is full, so the light target behind it goes first.
+	if got := s.next(ctx, pending); got != 1 {
+		t.Fatalf("second: got %d, want 1", got)
+	}
+	pending = pending[:1]
+
+	// Now only a heavy target is left, which waits until the first is done.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		s.done("js/wasm")
+	}()
+	if got := s.next(ctx, pending); got != 0 {
+		t.Fatalf("third: got %d, want 0", got)
+	}
+
+	// Waiting gives up when the context is done.
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if got := s.next(ctx, []target{"windows/arm64"}); got != -1 {
+		t.Errorf("got %d after the context was done, want -1", got)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"compress/flate"
+	"fmt"
+)
+
+// How hard archives and compressed binaries are compressed, trading time against size.
+// Without a compression setting, it is "", which is the same as default.
+type compressionLevel string
+
+const (
+	compressionFast    compressionLevel = "fast"
+	compressionDefault compressionLevel = "default"
+	compressionBest    compressionLevel = "best"
+)
+
+// Validates that 's' is a compression level.
+func validateCompression(s string) (compressionLevel, error) {
+	switch l := compressionLevel(s); l {
+	case compressionFast, compressionDefault, compressionBest:
+		return l, nil
+	}
+	return "", fmt.Errorf("%q is not one of %s, %s, %s", s, compressionFast, compressionDefault, compressionBest)
+}
+
+// Returns the level to deflate at, for zip, tar.gz and gz.
+func (this compressionLevel) deflate() int {
+	switch this {
+	case compressionFast:
+		return flate.BestSpeed
+	case compressionBest:
+		return flate.BestCompression
+	}
+	return flate.DefaultCompression
+}
+
+// Returns the zstd option for the level, for tar.zst and zst. Level 9 compresses go binaries a
+// little better than gzip -9 does, in a fraction of the time, while 19 approaches xz, at several
+// times the cost.
+func (this compressionLevel) zstd() string {
+	switch this {
+	case compressionFast:
+		return "-3"
+	case compressionBest:
+		return "-19"
+	}
+	return "-9"
+}
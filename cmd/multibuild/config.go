@@ -0,0 +1,779 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Configuration can come from a number of places. Each of them produces an
+// 'options' (a layer), and the layers are merged by resolveConfig in order of
+// increasing precedence. These are the sources, lowest precedence first.
+type configSource int
+
+const (
+	// Built in defaults, e.g. include=*/*
+	sourceDefault configSource = iota
+
+	// //go:multibuild: directives in the package's source files.
+	sourceDirective
+
+	// MULTIBUILD_* environment variables.
+	sourceEnvironment
+
+	// --multibuild-* command line arguments.
+	sourceCommandLine
+
+	// Rules multibuild always applies, regardless of configuration.
+	// These are applied after everything else is merged.
+	sourceImplicit
+)
+
+func (this configSource) String() string {
+	switch this {
+	case sourceDefault:
+		return "default"
+	case sourceDirective:
+		return "directive"
+	case sourceEnvironment:
+		return "environment"
+	case sourceCommandLine:
+		return "command line"
+	case sourceImplicit:
+		return "implicit"
+	}
+	return fmt.Sprintf("configSource(%d)", int(this))
+}
+
+// Describes where a setting came from.
+type origin struct {
+	source configSource
+
+	// Where in the source the setting was found, e.g. main.go:3.
+	// For implicit settings, this is the reason the rule exists instead.
+	// May be empty if that doesn't make sense for the source.
+	location string
+}
+
+func (this origin) String() string {
+	switch {
+	case this.location == "":
+		return this.source.String()
+	case this.source == sourceImplicit:
+		return fmt.Sprintf("%s (%s)", this.source, this.location)
+	case this.source == sourceEnvironment || this.source == sourceCommandLine:
+		return fmt.Sprintf("%s: %s", this.source, this.location)
+	}
+	return fmt.Sprintf("%s at %s", this.source, this.location)
+}
+
+// Returns the key used to track the origin of a setting.
+// Single valued settings (output, format, parallel, partial, stamp) are tracked by name,
+// and list settings are tracked per value, e.g. include=linux/*.
+func settingKey(name string, value ...string) string {
+	if len(value) == 0 {
+		return name
+	}
+	return name + "=" + strings.Join(value, ",")
+}
+
+// Records that 'key' was set by 'o'.
+func (this *options) setOrigin(key string, o origin) {
+	if this.origins == nil {
+		this.origins = make(map[string][]origin)
+	}
+	this.origins[key] = append(this.origins[key], o)
+}
+
+// Returns where 'key' was set, if known.
+func (this options) originOf(key string) []origin {
+	return this.origins[key]
+}
+
+// Describes 'origins' for a message, e.g. "directive at main.go:3, command line: --multibuild-parallel=2".
+func describeOrigins(origins []origin) string {
+	if len(origins) == 0 {
+		return "unknown"
+	}
+	return strings.Join(mapSlice(origins, func(o origin) string { return o.String() }), ", ")
+}
+
+// Copies the origins of the list setting 'name' for 'values' from 'from'.
+func (this *options) copyOrigins(from options, name string, values []filter) {
+	seen := make(map[filter]bool)
+	for _, v := range values {
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		for _, o := range from.originOf(settingKey(name, string(v))) {
+			this.setOrigin(settingKey(name, string(v)), o)
+		}
+	}
+}
+
+// Returns the options used when nothing else is configured.
+func defaultOptions() options {
+	var opts options
+	opts.Include = []filter{"*/*"}
+	opts.Format = []format{formatRaw}
+	opts.Output = "${TARGET}-${GOOS}-${GOARCH}"
+	opts.Parallel = 4 // limit max parallel builds to save sanity...
+	opts.Partial = partialKeep
+	opts.KeepGoing = "true"
+
+	o := origin{source: sourceDefault}
+	opts.setOrigin(settingKey("include", "*/*"), o)
+	opts.setOrigin(settingKey("format"), o)
+	opts.setOrigin(settingKey("output"), o)
+	opts.setOrigin(settingKey("parallel"), o)
+	opts.setOrigin(settingKey("partial"), o)
+	opts.setOrigin(settingKey("keep-going"), o)
+	return opts
+}
+
+// Returns the options set by MULTIBUILD_* environment variables.
+// 'lookup' is normally os.LookupEnv.
+func envOptions(lookup func(string) (string, bool)) (options, error) {
+	var opts options
+
+	// Returns the value of 'name', if it is set to something.
+	get := func(name string) (string, origin, bool) {
+		v, ok := lookup(name)
+		return v, origin{source: sourceEnvironment, location: name}, ok && v != ""
+	}
+	filters := func(name string) ([]filter, origin, error) {
+		v, o, ok := get(name)
+		if !ok {
+			return nil, o, nil
+		}
+		parsed, err := validateFilterString(v)
+		if err != nil {
+			return nil, o, fmt.Errorf("%s=%s is invalid: %s", name, v, err)
+		}
+		return parsed, o, nil
+	}
+
+	if v, o, ok := get("MULTIBUILD_OUTPUT"); ok {
+		parsed, err := validateTemplate(v)
+		if err != nil {
+			return options{}, fmt.Errorf("MULTIBUILD_OUTPUT=%s is invalid: %s", v, err)
+		}
+		opts.Output = parsed
+		opts.setOrigin(settingKey("output"), o)
+	}
+	if v, o, ok := get("MULTIBUILD_FORMAT"); ok {
+		parsed, err := validateFormatString(v)
+		if err != nil {
+			return options{}, fmt.Errorf("MULTIBUILD_FORMAT=%s is invalid: %s", v, err)
+		}
+		opts.Format = parsed
+		opts.setOrigin(settingKey("format"), o)
+	}
+	if v, o, ok := get("MULTIBUILD_PARALLEL"); ok {
+		parsed, err := validateParallel(v)
+		if err != nil {
+			return options{}, fmt.Errorf("MULTIBUILD_PARALLEL=%s is invalid: %s", v, err)
+		}
+		opts.Parallel = parsed
+		opts.setOrigin(settingKey("parallel"), o)
+	}
+	if v, o, ok := get("MULTIBUILD_PARTIAL"); ok {
+		parsed, err := validatePartial(v)
+		if err != nil {
+			return options{}, fmt.Errorf("MULTIBUILD_PARTIAL=%s is invalid: %s", v, err)
+		}
+		opts.Partial = parsed
+		opts.setOrigin(settingKey("partial"), o)
+	}
+	if v, o, ok := get("MULTIBUILD_COMPRESSION"); ok {
+		parsed, err := validateCompression(v)
+		if err != nil {
+			return options{}, fmt.Errorf("MULTIBUILD_COMPRESSION=%s is invalid: %s", v, err)
+		}
+		opts.Compression = parsed
+		opts.setOrigin(settingKey("compression"), o)
+	}
+	if v, o, ok := get("MULTIBUILD_KEEP_GOING"); ok {
+		parsed, err := validateKeepGoing(v)
+		if err != nil {
+			return options{}, fmt.Errorf("MULTIBUILD_KEEP_GOING=%s is invalid: %s", v, err)
+		}
+		opts.KeepGoing = parsed
+		opts.setOrigin(settingKey("keep-going"), o)
+	}
+	if v, o, ok := get("MULTIBUILD_GOCACHE"); ok {
+		parsed, err := validateGoCache(v)
+		if err != nil {
+			return options{}, fmt.Errorf("MULTIBUILD_GOCACHE=%s is invalid: %s", v, err)
+		}
+		opts.GoCache = parsed
+		opts.setOrigin(settingKey("gocache"), o)
+	}
+	if v, o, ok := get("MULTIBUILD_GOCACHEPROG"); ok {
+		parsed, err := validateGoCache(v)
+		if err != nil {
+			return options{}, fmt.Errorf("MULTIBUILD_GOCACHEPROG=%s is invalid: %s", v, err)
+		}
+		opts.GoCacheProg = parsed
+		opts.setOrigin(settingKey("gocacheprog"), o)
+	}
+
+	var err error
+	var o origin
+	if opts.Include, o, err = filters("MULTIBUILD_INCLUDE"); err != nil {
+		return options{}, err
+	}
+	for _, f := range opts.Include {
+		opts.setOrigin(settingKey("include", string(f)), o)
+	}
+	if opts.Exclude, o, err = filters("MULTIBUILD_EXCLUDE"); err != nil {
+		return options{}, err
+	}
+	for _, f := range opts.Exclude {
+		opts.setOrigin(settingKey("exclude", string(f)), o)
+	}
+	if opts.Priority, o, err = filters("MULTIBUILD_PRIORITY"); err != nil {
+		return options{}, err
+	}
+	for _, f := range opts.Priority {
+		opts.setOrigin(settingKey("priority", string(f)), o)
+	}
+
+	return opts, nil
+}
+
+// Builds the effective configuration for a package from all sources: defaults, .multibuild
+// files in the directories above it (see inheritedConfig), directives found in 'sources',
+// the environment, and 'cli'.
+// All filters are checked against 'platforms', the targets Go can build.
+func loadConfig(sources []string, cli options, platforms []target) (options, error) {
+	inherited, err := inheritedConfig(sources)
+	if err != nil {
+		return options{}, err
+	}
+	// Some inherited settings decide how the sources are scanned.
+	var scope options
+	for _, layer := range inherited {
+		scope.IgnoreFiles = append(scope.IgnoreFiles, layer.IgnoreFiles...)
+		if layer.DirectiveFile != "" {
+			scope.DirectiveFile = layer.DirectiveFile
+			delete(scope.origins, settingKey("directive-file"))
+			for _, o := range layer.originOf(settingKey("directive-file")) {
+				scope.setOrigin(settingKey("directive-file"), o)
+			}
+		}
+	}
+	directives, err := scanDirectives(sources, scope)
+	if err != nil {
+		return options{}, err
+	}
+	env, err := envOptions(os.LookupEnv)
+	if err != nil {
+		return options{}, fmt.Errorf("environment: %w", err)
+	}
+	layers := slices.Concat([]options{defaultOptions()}, inherited, []options{directives, env, cli})
+	opts := resolveConfig(layers...)
+	if err := opts.validatePlatforms(platforms); err != nil {
+		return options{}, err
+	}
+	if err := opts.Aliases.validatePlatforms(platforms); err != nil {
+		return options{}, err
+	}
+	if err := opts.validateDefines(); err != nil {
+		return options{}, err
+	}
+	return opts, nil
+}
+
+// Places the outputs in 'dir', given by -o, by prefixing it to a relative output template.
+func (this *options) placeOutputs(dir string) {
+	if dir == "" || filepath.IsAbs(string(this.Output)) {
+		return
+	}
+	// filepath.Join keeps $$ and {{...}} as they are.
+	this.Output = outputTemplate(filepath.ToSlash(filepath.Join(this.Output.literal(dir), string(this.Output))))
+	this.setOrigin(settingKey("output"), origin{source: sourceCommandLine, location: "-o " + dir})
+}
+
+// Placeholders with the same value for every target of a run, see placeRunValues.
+var runPlaceholders = []string{"VERSION", "COMMIT", "DATE"}
+
+// Returns whether the placeholder 'name' has the same value for every target of a run, but not
+// from one run to the next, i.e. it's one of runPlaceholders, or ${ENV:NAME}.
+func runPlaceholder(name string) bool {
+	return slices.Contains(runPlaceholders, name) || strings.HasPrefix(name, envPrefix)
+}
+
+// Fills in the placeholders of the output template which are the same for every target:
+//   - ${VERSION} with 'version', or if that's empty, what git describes the package at
+//     'packagePath' as (see outputVersion).
+//   - ${COMMIT} with the abbreviated commit the package is built from, as go build stamps it.
+//   - ${DATE} with the date of the build, 'now', or SOURCE_DATE_EPOCH, as YYYY-MM-DD in UTC.
+//   - ${ENV:NAME} with the value of the environment variable NAME, which must be set.
+//
+// Only the values the template uses are worked out.
+func (this *options) placeRunValues(version, packagePath string, now time.Time) error {
+	values := make(map[string]string)
+	for _, name := range runPlaceholders {
+		if !this.Output.has(name) {
+			continue
+		}
+		switch name {
+		case "VERSION":
+			if version == "" {
+				version = outputVersion(packagePath)
+			}
+			values[name] = version
+		case "COMMIT":
+			values[name] = vcsCommit(packagePath)
+		case "DATE":
+			t, err := buildTime(os.LookupEnv, now)
+			if err != nil {
+				return err
+			}
+			values[name] = t.Format(time.DateOnly)
+		}
+	}
+	for _, name := range this.Output.placeholders() {
+		if variable, ok := strings.CutPrefix(name, envPrefix); ok {
+			v, err := envPlaceholderValue(variable, os.LookupEnv)
+			if err != nil {
+				return err
+			}
+			values[name] = v
+		}
+	}
+	// Defined placeholders are the same from one run to the next, so they are filled in first.
+	this.Output = this.Output.fill(this.definedValues())
+	if len(values) == 0 {
+		return nil
+	}
+	this.unfilledOutput = this.Output
+	this.Output = this.Output.fill(values)
+	return nil
+}
+
+// Returns the version git describes the package at 'packagePath' as, e.g. v1.4.2, or
+// v1.4.2-3-gabcdef0-dirty after it, made safe to use in file names (see safeFileName).
+// Without tags, this is the abbreviated commit, and outside of git, "unknown".
+func outputVersion(packagePath string) string {
+	return safeFileName(vcsVersion(packagePath))
+}
+
+// Returns 's' with anything which can't be part of a file name replaced by -.
+func safeFileName(s string) string {
+	return strings.Map(func(c rune) rune {
+		if c == '/' || unsafeOutputChar(c, runtime.GOOS) != "" {
+			return '-'
+		}
+		return c
+	}, s)
+}
+
+// Returns the value of ${ENV:NAME}: that of the variable NAME, made safe to use in file names
+// (see safeFileName). 'lookup' is normally os.LookupEnv.
+func envPlaceholderValue(name string, lookup func(string) (string, bool)) (string, error) {
+	v, ok := lookup(name)
+	if !ok || v == "" {
+		return "", fmt.Errorf("the output template uses ${%s%s}, but %s is not set", envPrefix, name, name)
+	}
+	v = safeFileName(v)
+	if v == "." || v == ".." {
+		return "", fmt.Errorf("the output template uses ${%s%s}, but %s=%s is not a file name", envPrefix, name, name, v)
+	}
+	return v, nil
+}
+
+// Returns a hash identifying everything which affects the outputs of a build:
+// the settings in 'opts', 'goBuildArgs', and the values of the environment
+// variables in 'environ' which are allowed to affect the build (see buildEnvAllow).
+// Two machines building the same thing in the same way get the same hash.
+func configHash(opts options, goBuildArgs []string, environ []string) string {
+	h := sha256.New()
+	line := func(name string, values ...string) {
+		fmt.Fprintf(h, "%s=%q\n", name, values)
+	}
+	line("include", mapSlice(opts.Include, func(f filter) string { return string(f) })...)
+	line("exclude", mapSlice(opts.Exclude, func(f filter) string { return string(f) })...)
+	line("output", string(opts.Output))
+	line("define", mapSlice(opts.Defines, definedPlaceholder.String)...)
+	line("alias", opts.Aliases.String())
+	for _, te := range opts.Extensions {
+		line("extension."+string(te.filter), te.extension)
+	}
+	line("archive-entry", string(opts.ArchiveEntry))
+	line("format", mapSlice(opts.Format, func(f format) string { return string(f) })...)
+	line("compression", string(opts.Compression))
+	line("wasm-exec", opts.WasmExec)
+	for _, rb := range opts.Remote {
+		line("remote."+string(rb.filter), rb.String())
+	}
+	for _, vs := range opts.Variants {
+		line("variant."+string(vs.filter), vs.variants...)
+	}
+	line("env-allow", opts.EnvAllow...)
+	for _, v := range opts.TargetEnv {
+		line("env."+string(v.filter), v.String())
+	}
+	line("stamp", opts.Stamp)
+	line("ldflags", opts.Ldflags)
+	for _, tl := range opts.TargetLdflags {
+		line("ldflags."+string(tl.filter), tl.flags)
+	}
+	line("image", opts.Image)
+	line("image-base", opts.ImageBase)
+	for _, key := range packageKeys {
+		line("package."+key, *opts.Package.field(key))
+	}
+	line("args", goBuildArgs...)
+
+	allow := buildEnvAllow(opts.EnvAllow)
+	var env []string
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if slices.ContainsFunc(allow, func(a string) bool { return envName(a) == envName(name) }) {
+			env = append(env, kv)
+		}
+	}
+	slices.Sort(env)
+	line("env", env...)
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// Merges configuration layers, given in increasing order of precedence.
+//
+// The rules are:
+//   - output, alias, archive-entry, format, parallel, memory-limit, cpu-limit, partial,
+//     compression, keep-going, checksums, checksum-algorithm, checksum-sidecars, wasm-exec,
+//     directive-file, gocache, gocacheprog, stamp, image, image-base, ldflags, each package.
+//     setting and each class.CLASS. setting are replaced by the highest layer which sets them.
+//   - include, priority, class, remote, variant, ldflags.FILTER, extension, env, env-allow,
+//     deploy, registry-auth, upload, copy-to and publish are also replaced by the highest layer
+//     which sets them, so that e.g. a more specific source can narrow the target list.
+//   - define accumulates across all layers, except that a higher layer's define of a name
+//     replaces a lower one's.
+//   - exclude accumulates across all layers, as exclusions can only ever narrow
+//     the target list. Likewise, secret patterns accumulate, as they only add checks,
+//     and ignore-files patterns accumulate, as they apply while the layers are scanned.
+//
+// After merging, implicit rules are applied.
+func resolveConfig(layers ...options) options {
+	var out options
+	for _, layer := range layers {
+		if len(layer.Output) > 0 {
+			out.Output = layer.Output
+			delete(out.origins, settingKey("output"))
+			for _, o := range layer.originOf(settingKey("output")) {
+				out.setOrigin(settingKey("output"), o)
+			}
+		}
+		for _, d := range layer.Defines {
+			if i := slices.IndexFunc(out.Defines, func(o definedPlaceholder) bool { return o.name == d.name }); i >= 0 {
+				delete(out.origins, out.Defines[i].key())
+				out.Defines = slices.Delete(out.Defines, i, i+1)
+			}
+			out.Defines = append(out.Defines, d)
+			for _, o := range layer.originOf(d.key()) {
+				out.setOrigin(d.key(), o)
+			}
+		}
+		if layer.Parallel != 0 {
+			out.Parallel = layer.Parallel
+			delete(out.origins, settingKey("parallel"))
+			for _, o := range layer.originOf(settingKey("parallel")) {
+				out.setOrigin(settingKey("parallel"), o)
+			}
+		}
+		if layer.Limits.memory != 0 {
+			out.Limits.memory = layer.Limits.memory
+			delete(out.origins, settingKey("memory-limit"))
+			for _, o := range layer.originOf(settingKey("memory-limit")) {
+				out.setOrigin(settingKey("memory-limit"), o)
+			}
+		}
+		if layer.Limits.cpus != 0 {
+			out.Limits.cpus = layer.Limits.cpus
+			delete(out.origins, settingKey("cpu-limit"))
+			for _, o := range layer.originOf(settingKey("cpu-limit")) {
+				out.setOrigin(settingKey("cpu-limit"), o)
+			}
+		}
+		if layer.Partial != "" {
+			out.Partial = layer.Partial
+			delete(out.origins, settingKey("partial"))
+			for _, o := range layer.originOf(settingKey("partial")) {
+				out.setOrigin(settingKey("partial"), o)
+			}
+		}
+		if layer.Compression != "" {
+			out.Compression = layer.Compression
+			delete(out.origins, settingKey("compression"))
+			for _, o := range layer.originOf(settingKey("compression")) {
+				out.setOrigin(settingKey("compression"), o)
+			}
+		}
+		if layer.KeepGoing != "" {
+			out.KeepGoing = layer.KeepGoing
+			delete(out.origins, settingKey("keep-going"))
+			for _, o := range layer.originOf(settingKey("keep-going")) {
+				out.setOrigin(settingKey("keep-going"), o)
+			}
+		}
+		if layer.Checksums != "" {
+			out.Checksums = layer.Checksums
+			delete(out.origins, settingKey("checksums"))
+			for _, o := range layer.originOf(settingKey("checksums")) {
+				out.setOrigin(settingKey("checksums"), o)
+			}
+		}
+		if layer.ChecksumAlgorithm != "" {
+			out.ChecksumAlgorithm = layer.ChecksumAlgorithm
+			delete(out.origins, settingKey("checksum-algorithm"))
+			for _, o := range layer.originOf(settingKey("checksum-algorithm")) {
+				out.setOrigin(settingKey("checksum-algorithm"), o)
+			}
+		}
+		if layer.ChecksumSidecars != "" {
+			out.ChecksumSidecars = layer.ChecksumSidecars
+			delete(out.origins, settingKey("checksum-sidecars"))
+			for _, o := range layer.originOf(settingKey("checksum-sidecars")) {
+				out.setOrigin(settingKey("checksum-sidecars"), o)
+			}
+		}
+		if layer.WasmExec != "" {
+			out.WasmExec = layer.WasmExec
+			delete(out.origins, settingKey("wasm-exec"))
+			for _, o := range layer.originOf(settingKey("wasm-exec")) {
+				out.setOrigin(settingKey("wasm-exec"), o)
+			}
+		}
+		if layer.DirectiveFile != "" {
+			out.DirectiveFile = layer.DirectiveFile
+			delete(out.origins, settingKey("directive-file"))
+			for _, o := range layer.originOf(settingKey("directive-file")) {
+				out.setOrigin(settingKey("directive-file"), o)
+			}
+		}
+		if layer.GoCache != "" {
+			out.GoCache = layer.GoCache
+			delete(out.origins, settingKey("gocache"))
+			for _, o := range layer.originOf(settingKey("gocache")) {
+				out.setOrigin(settingKey("gocache"), o)
+			}
+		}
+		if layer.GoCacheProg != "" {
+			out.GoCacheProg = layer.GoCacheProg
+			delete(out.origins, settingKey("gocacheprog"))
+			for _, o := range layer.originOf(settingKey("gocacheprog")) {
+				out.setOrigin(settingKey("gocacheprog"), o)
+			}
+		}
+		if layer.Stamp != "" {
+			out.Stamp = layer.Stamp
+			delete(out.origins, settingKey("stamp"))
+			for _, o := range layer.originOf(settingKey("stamp")) {
+				out.setOrigin(settingKey("stamp"), o)
+			}
+		}
+		if layer.Ldflags != "" {
+			out.Ldflags = layer.Ldflags
+			delete(out.origins, settingKey("ldflags"))
+			for _, o := range layer.originOf(settingKey("ldflags")) {
+				out.setOrigin(settingKey("ldflags"), o)
+			}
+		}
+		if len(layer.TargetLdflags) > 0 {
+			for _, tl := range out.TargetLdflags {
+				delete(out.origins, settingKey("ldflags", string(tl.filter)))
+			}
+			out.TargetLdflags = layer.TargetLdflags
+			out.copyOrigins(layer, "ldflags", mapSlice(layer.TargetLdflags, func(tl targetLdflags) filter { return tl.filter }))
+		}
+		if len(layer.Extensions) > 0 {
+			for _, te := range out.Extensions {
+				delete(out.origins, settingKey("extension", string(te.filter)))
+			}
+			out.Extensions = layer.Extensions
+			out.copyOrigins(layer, "extension", mapSlice(layer.Extensions, func(te targetExtension) filter { return te.filter }))
+		}
+		if layer.Image != "" {
+			out.Image = layer.Image
+			delete(out.origins, settingKey("image"))
+			for _, o := range layer.originOf(settingKey("image")) {
+				out.setOrigin(settingKey("image"), o)
+			}
+		}
+		if layer.ImageBase != "" {
+			out.ImageBase = layer.ImageBase
+			delete(out.origins, settingKey("image-base"))
+			for _, o := range layer.originOf(settingKey("image-base")) {
+				out.setOrigin(settingKey("image-base"), o)
+			}
+		}
+		for _, key := range packageKeys {
+			if v := *layer.Package.field(key); v != "" {
+				*out.Package.field(key) = v
+				delete(out.origins, settingKey("package."+key))
+				for _, o := range layer.originOf(settingKey("package." + key)) {
+					out.setOrigin(settingKey("package."+key), o)
+				}
+			}
+		}
+		if len(layer.Aliases) > 0 {
+			out.Aliases = layer.Aliases
+			delete(out.origins, settingKey("alias"))
+			for _, o := range layer.originOf(settingKey("alias")) {
+				out.setOrigin(settingKey("alias"), o)
+			}
+		}
+		if layer.ArchiveEntry != "" {
+			out.ArchiveEntry = layer.ArchiveEntry
+			delete(out.origins, settingKey("archive-entry"))
+			for _, o := range layer.originOf(settingKey("archive-entry")) {
+				out.setOrigin(settingKey("archive-entry"), o)
+			}
+		}
+		if len(layer.Format) > 0 {
+			out.Format = layer.Format
+			delete(out.origins, settingKey("format"))
+			for _, o := range layer.originOf(settingKey("format")) {
+				out.setOrigin(settingKey("format"), o)
+			}
+		}
+		if len(layer.Include) > 0 {
+			for _, f := range out.Include {
+				delete(out.origins, settingKey("include", string(f)))
+			}
+			out.Include = layer.Include
+			out.copyOrigins(layer, "include", layer.Include)
+		}
+		if len(layer.Priority) > 0 {
+			for _, f := range out.Priority {
+				delete(out.origins, settingKey("priority", string(f)))
+			}
+			out.Priority = layer.Priority
+			out.copyOrigins(layer, "priority", layer.Priority)
+		}
+		if len(layer.Classes) > 0 {
+			for _, tag := range out.Classes {
+				delete(out.origins, settingKey("class."+string(tag.class), string(tag.filter)))
+			}
+			out.Classes = layer.Classes
+			for _, class := range resourceClasses {
+				out.copyOrigins(layer, "class."+string(class), layer.classFilters(class))
+			}
+		}
+		for _, class := range resourceClasses {
+			for _, key := range classKeys {
+				name := "class." + string(class) + "." + key
+				if layer.ClassSettings.of(class).get(key) == "" {
+					continue
+				}
+				out.ClassSettings.of(class).copy(*layer.ClassSettings.of(class), key)
+				delete(out.origins, settingKey(name))
+				for _, o := range layer.originOf(settingKey(name)) {
+					out.setOrigin(settingKey(name), o)
+				}
+			}
+		}
+		if len(layer.Remote) > 0 {
+			for _, rb := range out.Remote {
+				delete(out.origins, settingKey("remote", string(rb.filter)))
+			}
+			out.Remote = layer.Remote
+			out.copyOrigins(layer, "remote", mapSlice(layer.Remote, func(rb remoteBuilder) filter { return rb.filter }))
+		}
+		if len(layer.Variants) > 0 {
+			for _, vs := range out.Variants {
+				delete(out.origins, settingKey("variant", string(vs.filter)))
+			}
+			out.Variants = layer.Variants
+			out.copyOrigins(layer, "variant", mapSlice(layer.Variants, func(vs variantSetting) filter { return vs.filter }))
+		}
+		if len(layer.TargetEnv) > 0 {
+			for _, v := range out.TargetEnv {
+				delete(out.origins, v.key())
+			}
+			out.TargetEnv = layer.TargetEnv
+			seen := make(map[string]bool)
+			for _, v := range layer.TargetEnv {
+				if seen[v.key()] {
+					continue
+				}
+				seen[v.key()] = true
+				for _, o := range layer.originOf(v.key()) {
+					out.setOrigin(v.key(), o)
+				}
+			}
+		}
+		if len(layer.EnvAllow) > 0 {
+			for _, name := range out.EnvAllow {
+				delete(out.origins, settingKey("env-allow", name))
+			}
+			out.EnvAllow = layer.EnvAllow
+			out.copyOrigins(layer, "env-allow", mapSlice(layer.EnvAllow, func(name string) filter { return filter(name) }))
+		}
+		if len(layer.RegistryAuth) > 0 {
+			for _, ra := range out.RegistryAuth {
+				delete(out.origins, settingKey("registry-auth", ra.host))
+			}
+			out.RegistryAuth = layer.RegistryAuth
+			out.copyOrigins(layer, "registry-auth", mapSlice(layer.RegistryAuth, func(ra registryAuth) filter { return filter(ra.host) }))
+		}
+		if len(layer.Publish) > 0 {
+			for _, h := range out.Publish {
+				delete(out.origins, settingKey("publish", h))
+			}
+			out.Publish = layer.Publish
+			out.copyOrigins(layer, "publish", mapSlice(layer.Publish, func(h string) filter { return filter(h) }))
+		}
+		if len(layer.CopyTo) > 0 {
+			for _, cd := range out.CopyTo {
+				delete(out.origins, settingKey("copy-to", string(cd.filter)))
+			}
+			out.CopyTo = layer.CopyTo
+			out.copyOrigins(layer, "copy-to", mapSlice(layer.CopyTo, func(cd copyDest) filter { return cd.filter }))
+		}
+		if len(layer.Upload) > 0 {
+			for _, u := range out.Upload {
+				delete(out.origins, settingKey("upload", u))
+			}
+			out.Upload = layer.Upload
+			out.copyOrigins(layer, "upload", mapSlice(layer.Upload, func(u string) filter { return filter(u) }))
+		}
+		if len(layer.Deploy) > 0 {
+			for _, p := range out.Deploy {
+				delete(out.origins, settingKey("deploy", p))
+			}
+			out.Deploy = layer.Deploy
+			out.copyOrigins(layer, "deploy", mapSlice(layer.Deploy, func(p string) filter { return filter(p) }))
+		}
+		out.Exclude = append(out.Exclude, layer.Exclude...)
+		out.copyOrigins(layer, "exclude", layer.Exclude)
+		out.Secrets = append(out.Secrets, layer.Secrets...)
+		out.copyOrigins(layer, "secret", mapSlice(layer.Secrets, func(s string) filter { return filter(s) }))
+		out.IgnoreFiles = append(out.IgnoreFiles, layer.IgnoreFiles...)
+		out.copyOrigins(layer, "ignore-files", mapSlice(layer.IgnoreFiles, func(p string) filter { return filter(p) }))
+	}
+
+	// These require CGO_ENABLED=1, which I don't want to touch right now.
+	// As I don't have a use for it, let's just disable them.
+	for _, f := range []filter{"android/*", "ios/*"} {
+		out.Exclude = append(out.Exclude, f)
+		out.setOrigin(settingKey("exclude", string(f)), origin{source: sourceImplicit, location: "requires cgo"})
+	}
+
+	return out
+}
@@ -0,0 +1,355 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveConfig(t *testing.T) {
+	directive := func(location string) origin {
+		return origin{source: sourceDirective, location: location}
+	}
+
+	var low options
+	low.Output = "low/${TARGET}-${GOOS}-${GOARCH}"
+	low.setOrigin(settingKey("output"), directive("low.go:1"))
+	low.Include = []filter{"linux/*"}
+	low.setOrigin(settingKey("include", "linux/*"), directive("low.go:2"))
+	low.Exclude = []filter{"linux/386"}
+	low.setOrigin(settingKey("exclude", "linux/386"), directive("low.go:3"))
+	low.Defines = []definedPlaceholder{{"PRODUCT", "Widget"}, {"CHANNEL", "stable"}}
+	low.setOrigin(low.Defines[0].key(), directive("low.go:4"))
+	low.setOrigin(low.Defines[1].key(), directive("low.go:5"))
+
+	var high options
+	high.Include = []filter{"darwin/*"}
+	high.setOrigin(settingKey("include", "darwin/*"), directive("high.go:1"))
+	high.Exclude = []filter{"darwin/amd64"}
+	high.setOrigin(settingKey("exclude", "darwin/amd64"), directive("high.go:2"))
+	high.Defines = []definedPlaceholder{{"CHANNEL", "beta"}}
+	high.setOrigin(high.Defines[0].key(), directive("high.go:3"))
+
+	got := resolveConfig(defaultOptions(), low, high)
+
+	if got.Output != low.Output {
+		t.Errorf("output: got %q, want %q", got.Output, low.Output)
+	}
+	if o := got.originOf(settingKey("output")); len(o) != 1 || o[0] != directive("low.go:1") {
+		t.Errorf("output origin: got %v", o)
+	}
+	if !slices.Equal(got.Format, []format{formatRaw}) {
+		t.Errorf("format: got %v, want default", got.Format)
+	}
+	if o := got.originOf(settingKey("format")); len(o) != 1 || o[0].source != sourceDefault {
+		t.Errorf("format origin: got %v", o)
+	}
+
+	// The highest include wins, and the replaced includes are forgotten.
+	if !slices.Equal(got.Include, []filter{"darwin/*"}) {
+		t.Errorf("include: got %v", got.Include)
+	}
+	if o := got.originOf(settingKey("include", "linux/*")); len(o) != 0 {
+		t.Errorf("replaced include still has an origin: %v", o)
+	}
+	if o := got.originOf(settingKey("include", "*/*")); len(o) != 0 {
+		t.Errorf("replaced default include still has an origin: %v", o)
+	}
+
+	// Excludes accumulate, followed by the implicit ones.
+	wantExclude := []filter{"linux/386", "darwin/amd64", "android/*", "ios/*"}
+	if !slices.Equal(got.Exclude, wantExclude) {
+		t.Errorf("exclude: got %v, want %v", got.Exclude, wantExclude)
+	}
+	if o := got.originOf(settingKey("exclude", "darwin/amd64")); len(o) != 1 || o[0] != directive("high.go:2") {
+		t.Errorf("exclude origin: got %v", o)
+	}
+	if o := got.originOf(settingKey("exclude", "ios/*")); len(o) != 1 || o[0].source != sourceImplicit {
+		t.Errorf("implicit exclude origin: got %v", o)
+	}
+
+	// Defines accumulate, with the highest define of each name winning.
+	wantDefines := []definedPlaceholder{{"PRODUCT", "Widget"}, {"CHANNEL", "beta"}}
+	if !slices.Equal(got.Defines, wantDefines) {
+		t.Errorf("define: got %v, want %v", got.Defines, wantDefines)
+	}
+	if o := got.originOf(settingKey("define", "CHANNEL=stable")); len(o) != 0 {
+		t.Errorf("replaced define still has an origin: %v", o)
+	}
+	if o := got.originOf(settingKey("define", "CHANNEL=beta")); len(o) != 1 || o[0] != directive("high.go:3") {
+		t.Errorf("define origin: got %v", o)
+	}
+}
+
+func TestValidateDefines(t *testing.T) {
+	tests := []struct {
+		output  outputTemplate
+		defines []definedPlaceholder
+		wantErr bool
+	}{
+		{"bin/${TARGET}-${GOOS}-${GOARCH}", nil, false},
+		{"bin/${PRODUCT}/${TARGET}-${GOOS}-${GOARCH}", []definedPlaceholder{{"PRODUCT", "Widget"}}, false},
+		{"bin/${PRODUCT}/${TARGET}-${GOOS}-${GOARCH}", nil, true},
+		{"bin/${PRODUCT}/${TARGET}-${GOOS}-${GOARCH}", []definedPlaceholder{{"CHANNEL", "beta"}}, true},
+		{"bin/${ENV:PRODUCT}/${TARGET}-${GOOS}-${GOARCH}", nil, false},
+		// Each value is safe, but together they make a name which isn't.
+		{"bin/${PRODUCT}${CHANNEL}/${TARGET}-${GOOS}-${GOARCH}", []definedPlaceholder{{"PRODUCT", " "}, {"CHANNEL", "beta"}}, true},
+	}
+	for _, tt := range tests {
+		err := options{Output: tt.output, Defines: tt.defines}.validateDefines()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateDefines() of %q with %v: err = %v, wantErr %v", tt.output, tt.defines, err, tt.wantErr)
+		}
+	}
+}
+
+func TestOriginString(t *testing.T) {
+	tests := []struct {
+		origin origin
+		want   string
+	}{
+		{origin{source: sourceDefault}, "default"},
+		{origin{source: sourceDirective, location: "main.go:3"}, "directive at main.go:3"},
+		{origin{source: sourceImplicit, location: "requires cgo"}, "implicit (requires cgo)"},
+	}
+	for _, tt := range tests {
+		if got := tt.origin.String(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestPlaceOutputs(t *testing.T) {
+	tests := []struct {
+		output outputTemplate
+		dir    string
+		want   outputTemplate
+	}{
+		{"bin/${TARGET}-${GOOS}-${GOARCH}", "", "bin/${TARGET}-${GOOS}-${GOARCH}"},
+		{"bin/${TARGET}-${GOOS}-${GOARCH}", "dist/", "dist/bin/${TARGET}-${GOOS}-${GOARCH}"},
+		{"${TARGET}-${GOOS}-${GOARCH}", "./out", "out/${TARGET}-${GOOS}-${GOARCH}"},
+		{"${TARGET}-${GOOS}-${GOARCH}", "$tmp/", "$$tmp/${TARGET}-${GOOS}-${GOARCH}"},
+	}
+	for _, tt := range tests {
+		opts := options{Output: tt.output}
+		opts.placeOutputs(tt.dir)
+		if opts.Output != tt.want {
+			t.Errorf("placeOutputs(%q) on %q = %q, want %q", tt.dir, tt.output, opts.Output, tt.want)
+		}
+	}
+}
+
+func TestPlaceRunValues(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+	now := time.Date(2025, 3, 9, 23, 30, 0, 0, time.FixedZone("", -2*60*60))
+	tests := []struct {
+		output  outputTemplate
+		version string
+		want    outputTemplate
+	}{
+		{"bin/${TARGET}-${GOOS}-${GOARCH}", "v1.4.2", "bin/${TARGET}-${GOOS}-${GOARCH}"},
+		{"bin/${TARGET}-${VERSION}-${GOOS}-${GOARCH}", "v1.4.2", "bin/${TARGET}-v1.4.2-${GOOS}-${GOARCH}"},
+		{"${VERSION}/$${TARGET}-${GOOS}-${GOARCH}${GOVARIANT}", "v$1", "v$$1/$${TARGET}-${GOOS}-${GOARCH}${GOVARIANT}"},
+		{"nightly/${DATE}/${TARGET}-${GOOS}-${GOARCH}", "", "nightly/2025-03-10/${TARGET}-${GOOS}-${GOARCH}"},
+	}
+	for _, tt := range tests {
+		opts := options{Output: tt.output}
+		if err := opts.placeRunValues(tt.version, ".", now); err != nil {
+			t.Fatalf("placeRunValues(%q) on %q: %s", tt.version, tt.output, err)
+		}
+		if opts.Output != tt.want {
+			t.Errorf("placeRunValues(%q) on %q = %q, want %q", tt.version, tt.output, opts.Output, tt.want)
+		}
+	}
+
+	// Defined placeholders are filled in too, but aren't wildcards in .gitignore.
+	opts := options{Output: "${PRODUCT}/${VERSION}/${TARGET}-${GOOS}-${GOARCH}", Defines: []definedPlaceholder{{"PRODUCT", "Widget"}}}
+	if err := opts.placeRunValues("v1", ".", now); err != nil || opts.Output != "Widget/v1/${TARGET}-${GOOS}-${GOARCH}" || opts.unfilledOutput != "Widget/${VERSION}/${TARGET}-${GOOS}-${GOARCH}" {
+		t.Errorf("placeRunValues() with a define = %q (from %q), %v", opts.Output, opts.unfilledOutput, err)
+	}
+
+	// ${ENV:NAME} comes from the environment, and must be set.
+	t.Setenv("MULTIBUILD_TEST_RELEASE", "nightly/42")
+	opts = options{Output: "${ENV:MULTIBUILD_TEST_RELEASE}/${TARGET}-${GOOS}-${GOARCH}"}
+	if err := opts.placeRunValues("", ".", now); err != nil || opts.Output != "nightly-42/${TARGET}-${GOOS}-${GOARCH}" {
+		t.Errorf("placeRunValues() with ${ENV:...} = %q, %v", opts.Output, err)
+	}
+	for _, v := range []string{"", ".."} {
+		t.Setenv("MULTIBUILD_TEST_RELEASE", v)
+		opts = options{Output: "${ENV:MULTIBUILD_TEST_RELEASE}/${TARGET}-${GOOS}-${GOARCH}"}
+		if err := opts.placeRunValues("", ".", now); err == nil {
+			t.Errorf("placeRunValues() with ${ENV:...} of %q succeeded, as %q", v, opts.Output)
+		}
+	}
+
+	// SOURCE_DATE_EPOCH is the date of a reproducible build.
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	opts = options{Output: "${DATE}/${TARGET}-${GOOS}-${GOARCH}"}
+	if err := opts.placeRunValues("", ".", now); err != nil || opts.Output != "2023-11-14/${TARGET}-${GOOS}-${GOARCH}" {
+		t.Errorf("placeRunValues() with SOURCE_DATE_EPOCH = %q, %v", opts.Output, err)
+	}
+	t.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+	opts = options{Output: "${DATE}/${TARGET}-${GOOS}-${GOARCH}"}
+	if err := opts.placeRunValues("", ".", now); err == nil {
+		t.Errorf("placeRunValues() with a bad SOURCE_DATE_EPOCH succeeded")
+	}
+
+	// Without a version given, git describes the package.
+	dir := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=x", "GIT_AUTHOR_EMAIL=x@x", "GIT_COMMITTER_NAME=x", "GIT_COMMITTER_EMAIL=x@x")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Skipf("git %s: %v: %s", args[0], err, out)
+		}
+	}
+	git("init", "-q")
+	git("commit", "-q", "--allow-empty", "-m", "first")
+	git("tag", "release/1.4.2")
+	if got := outputVersion(dir); got != "release-1.4.2" {
+		t.Errorf("outputVersion() = %q, want release-1.4.2", got)
+	}
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = dir
+	head, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := vcsCommit(dir); len(got) != 12 || !strings.HasPrefix(string(head), got) {
+		t.Errorf("vcsCommit() = %q, want the start of %s", got, head)
+	}
+	if got := vcsCommit(t.TempDir()); got != "unknown" {
+		t.Errorf("vcsCommit() outside of git = %q, want unknown", got)
+	}
+}
+
+func TestEnvOptions(t *testing.T) {
+	env := map[string]string{
+		"MULTIBUILD_INCLUDE":     "linux/*,host",
+		"MULTIBUILD_EXCLUDE":     "linux/386",
+		"MULTIBUILD_OUTPUT":      "dist/${TARGET}_${GOOS}_${GOARCH}",
+		"MULTIBUILD_FORMAT":      "zip",
+		"MULTIBUILD_PARALLEL":    "8",
+		"MULTIBUILD_PARTIAL":     "manifest",
+		"MULTIBUILD_PRIORITY":    "", // set, but empty, is ignored
+		"MULTIBUILD_GOCACHE":     "/var/cache/go",
+		"MULTIBUILD_KEEP_GOING":  "false",
+		"MULTIBUILD_COMPRESSION": "fast",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	got, err := envOptions(lookup)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(got.Include, []filter{"linux/*", "host/host"}) {
+		t.Errorf("include: got %v", got.Include)
+	}
+	if !slices.Equal(got.Exclude, []filter{"linux/386"}) {
+		t.Errorf("exclude: got %v", got.Exclude)
+	}
+	if got.Output != "dist/${TARGET}_${GOOS}_${GOARCH}" {
+		t.Errorf("output: got %v", got.Output)
+	}
+	if !slices.Equal(got.Format, []format{formatZip}) {
+		t.Errorf("format: got %v", got.Format)
+	}
+	if got.Parallel != 8 {
+		t.Errorf("parallel: got %v", got.Parallel)
+	}
+	if got.Partial != partialManifest {
+		t.Errorf("partial: got %v", got.Partial)
+	}
+	if got.KeepGoing != "false" {
+		t.Errorf("keep-going: got %v", got.KeepGoing)
+	}
+	if got.Compression != compressionFast {
+		t.Errorf("compression: got %v", got.Compression)
+	}
+	if len(got.Priority) != 0 {
+		t.Errorf("priority: got %v", got.Priority)
+	}
+	if got.GoCache != "/var/cache/go" || got.GoCacheProg != "" {
+		t.Errorf("gocache: got %q, gocacheprog: got %q", got.GoCache, got.GoCacheProg)
+	}
+	if o := got.originOf(settingKey("parallel")); len(o) != 1 || o[0].String() != "environment: MULTIBUILD_PARALLEL" {
+		t.Errorf("parallel origin: got %v", o)
+	}
+
+	// The environment sits above directives, and below the command line.
+	var directives options
+	directives.Parallel = 2
+	directives.Output = "bin/${TARGET}-${GOOS}-${GOARCH}"
+	var cli options
+	cli.Parallel = 16
+	resolved := resolveConfig(defaultOptions(), directives, got, cli)
+	if resolved.Output != got.Output {
+		t.Errorf("environment did not override directive output: got %v", resolved.Output)
+	}
+	if resolved.Parallel != 16 {
+		t.Errorf("command line did not override environment parallel: got %v", resolved.Parallel)
+	}
+
+	for name, value := range map[string]string{
+		"MULTIBUILD_INCLUDE":     "linux",
+		"MULTIBUILD_OUTPUT":      "${GOOS}",
+		"MULTIBUILD_FORMAT":      "rar",
+		"MULTIBUILD_PARALLEL":    "0",
+		"MULTIBUILD_PARTIAL":     "some",
+		"MULTIBUILD_KEEP_GOING":  "sometimes",
+		"MULTIBUILD_COMPRESSION": "ultra",
+	} {
+		_, err := envOptions(func(n string) (string, bool) {
+			if n == name {
+				return value, true
+			}
+			return "", false
+		})
+		if err == nil {
+			t.Errorf("%s=%s: expected error", name, value)
+		}
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	opts := defaultOptions()
+	args := []string{"-trimpath"}
+	machineA := []string{"PATH=/usr/bin", "HOME=/home/a", "GOPROXY=direct", "EDITOR=vim"}
+	machineB := []string{"HOME=/Users/b", "PATH=/opt/bin", "EDITOR=emacs", "GOPROXY=direct"}
+
+	if configHash(opts, args, machineA) != configHash(opts, args, machineB) {
+		t.Errorf("machines with equivalent environments got different hashes")
+	}
+	if configHash(opts, args, machineA) == configHash(opts, nil, machineA) {
+		t.Errorf("build arguments do not change the hash")
+	}
+	machineC := []string{"PATH=/usr/bin", "HOME=/home/a", "GOPROXY=off"}
+	if configHash(opts, args, machineA) == configHash(opts, args, machineC) {
+		t.Errorf("GOPROXY does not change the hash")
+	}
+	// GOFLAGS isn't allowed by default, so it is scrubbed from sandboxed builds.
+	if configHash(opts, args, machineA) != configHash(opts, args, append(slices.Clone(machineA), "GOFLAGS=-tags=debug")) {
+		t.Errorf("GOFLAGS changes the hash without being allowed")
+	}
+
+	// With an allowlist, only the allowed variables matter.
+	opts.EnvAllow = []string{"EDITOR"}
+	if configHash(opts, args, machineA) == configHash(opts, args, machineB) {
+		t.Errorf("allowed variable does not change the hash")
+	}
+	machineD := []string{"PATH=/usr/bin", "HOME=/home/a", "GOPROXY=off", "EDITOR=vim"}
+	if configHash(opts, args, machineA) != configHash(opts, args, machineD) {
+		t.Errorf("variable outside the allowlist changes the hash")
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// The effective configuration of a package, as directives, and the targets it builds.
+type configPlan struct {
+	config  []string
+	targets []target
+}
+
+// Returns the root of the git repository containing 'dir', or 'dir' itself if it isn't in one.
+func repositoryRoot(dir string) string {
+	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if root := strings.TrimSpace(string(out)); err == nil && root != "" {
+		return root
+	}
+	return dir
+}
+
+// Writes the tree of 'ref' in the git repository at 'root' to the directory 'dest'.
+func extractRevision(root, ref, dest string) error {
+	cmd := exec.Command("git", "archive", "--format=tar", ref)
+	cmd.Dir = root
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("git archive %s: %w: %s", ref, err, strings.TrimSpace(stderr.String()))
+	}
+
+	tr := tar.NewReader(bytes.NewReader(out))
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("git archive %s: %w", ref, err)
+		}
+		if !filepath.IsLocal(hdr.Name) {
+			return fmt.Errorf("git archive %s: unexpected path %q", ref, hdr.Name)
+		}
+		path := filepath.Join(dest, hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0755)
+		case tar.TypeReg:
+			if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+				var data []byte
+				if data, err = io.ReadAll(tr); err == nil {
+					err = os.WriteFile(path, data, hdr.FileInfo().Mode().Perm())
+				}
+			}
+		case tar.TypeSymlink:
+			if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+				err = os.Symlink(hdr.Linkname, path)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Works out the configuration and targets of 'packagePath', as seen from 'dir'.
+func planIn(self, dir, packagePath string) (configPlan, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return configPlan{}, err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return configPlan{}, err
+	}
+	defer os.Chdir(wd)
+
+	args, err := parseArgs(self, []string{packagePath})
+	if err != nil {
+		return configPlan{}, err
+	}
+	opts, targets, _, _, err := planBuild(args)
+	if err != nil {
+		return configPlan{}, err
+	}
+	var buf bytes.Buffer
+	writeConfig(&buf, opts, false)
+	return configPlan{config: strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"), targets: targets}, nil
+}
+
+// Returns the lines only in 'before', prefixed with -, followed by those only in 'after',
+// prefixed with +. Both are treated as sets, as the order of directives doesn't matter.
+func diffLines(before, after []string) []string {
+	var diff []string
+	for _, line := range before {
+		if !slices.Contains(after, line) {
+			diff = append(diff, "-"+line)
+		}
+	}
+	for _, line := range after {
+		if !slices.Contains(before, line) {
+			diff = append(diff, "+"+line)
+		}
+	}
+	return diff
+}
+
+// Writes the differences between 'before' and 'after' to 'w', returning whether there were any.
+func writeConfigDiff(w io.Writer, before, after configPlan) bool {
+	config := diffLines(before.config, after.config)
+	targets := diffLines(mapSlice(before.targets, func(t target) string { return string(t) }), mapSlice(after.targets, func(t target) string { return string(t) }))
+	if len(config) > 0 {
+		fmt.Fprintln(w, "configuration:")
+		for _, line := range config {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+	if len(targets) > 0 {
+		fmt.Fprintln(w, "targets:")
+		for _, line := range targets {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+	return len(config) > 0 || len(targets) > 0
+}
+
+// Implements 'multibuild config'.
+func doConfig(self string, argv []string) {
+	if len(argv) == 0 || argv[0] != "diff" {
+		fatalCode(exitConfig, "usage: %s config diff <gitref|dir> [package]", self)
+	}
+	doConfigDiff(self, argv[1:])
+}
+
+// Works out the configuration and targets of 'packagePath' in 'against', a git revision or
+// another checkout of the repository, and in the working tree.
+func planAgainst(self, against, packagePath string) (configPlan, configPlan, error) {
+	wd, err := os.Getwd()
+	if err == nil {
+		// git reports the root with any symlinks resolved.
+		wd, err = filepath.EvalSymlinks(wd)
+	}
+	if err != nil {
+		return configPlan{}, configPlan{}, err
+	}
+	// The other tree is laid out like this one, from the root of the repository.
+	root := repositoryRoot(wd)
+	rel, err := filepath.Rel(root, wd)
+	if err != nil {
+		return configPlan{}, configPlan{}, err
+	}
+	otherRoot := against
+	if info, err := os.Stat(against); err != nil || !info.IsDir() {
+		tmp, err := os.MkdirTemp("", "multibuild-diff-")
+		if err != nil {
+			return configPlan{}, configPlan{}, err
+		}
+		defer os.RemoveAll(tmp)
+		if err := extractRevision(root, against, tmp); err != nil {
+			return configPlan{}, configPlan{}, err
+		}
+		otherRoot = tmp
+	}
+
+	before, err := planIn(self, filepath.Join(otherRoot, rel), packagePath)
+	if err != nil {
+		return configPlan{}, configPlan{}, fmt.Errorf("in %s: %w", against, err)
+	}
+	after, err := planIn(self, wd, packagePath)
+	if err != nil {
+		return configPlan{}, configPlan{}, fmt.Errorf("in the working tree: %w", err)
+	}
+	return before, after, nil
+}
+
+// Implements 'multibuild config diff': compares the configuration and targets of the package
+// in the working tree with those in another revision, or another checkout of the repository.
+func doConfigDiff(self string, argv []string) {
+	fs := flag.NewFlagSet(self+" config diff", flag.ExitOnError)
+	fs.Parse(argv)
+
+	packagePath := "."
+	switch fs.NArg() {
+	case 1:
+	case 2:
+		packagePath = fs.Arg(1)
+	default:
+		fatalCode(exitConfig, "usage: %s config diff <gitref|dir> [package]", self)
+	}
+
+	before, after, err := planAgainst(self, fs.Arg(0), packagePath)
+	if err != nil {
+		fatalCode(exitConfig, "multibuild: config diff: %s", err)
+	}
+	if !writeConfigDiff(os.Stdout, before, after) {
+		fmt.Fprintf(os.Stderr, "multibuild: no changes from %s\n", fs.Arg(0))
+	}
+}
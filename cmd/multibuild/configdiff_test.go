@@ -0,0 +1,86 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	got := diffLines([]string{"a", "b", "c"}, []string{"c", "d", "a"})
+	if want := []string{"-b", "+d"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := diffLines([]string{"a"}, []string{"a"}); len(got) != 0 {
+		t.Errorf("got %q, want no differences", got)
+	}
+}
+
+func TestWriteConfigDiff(t *testing.T) {
+	before := configPlan{config: []string{"//go:multibuild:include=linux/*", "//go:multibuild:exclude="}, targets: []target{"linux/386", "linux/amd64"}}
+	after := configPlan{config: []string{"//go:multibuild:include=linux/amd64", "//go:multibuild:exclude="}, targets: []target{"linux/amd64"}}
+
+	var buf bytes.Buffer
+	if !writeConfigDiff(&buf, before, after) {
+		t.Error("expected differences")
+	}
+	want := "configuration:\n  -//go:multibuild:include=linux/*\n  +//go:multibuild:include=linux/amd64\ntargets:\n  -linux/386\n"
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+	buf.Reset()
+	if writeConfigDiff(&buf, after, after) || buf.Len() != 0 {
+		t.Errorf("got differences %q, want none", buf.String())
+	}
+}
+
+func TestPlanAgainst(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	write := func(name, data string) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %q: %s: %s", args, err, out)
+		}
+	}
+	write("go.mod", "module example.com/app\n\ngo 1.24\n")
+	write("cmd/app/main.go", "//go:multibuild:include=linux/amd64,linux/arm64\npackage main\n\nfunc main() {}\n")
+	git("init", "-q")
+	git("add", "-A")
+	git("commit", "-q", "-m", "initial")
+	write("cmd/app/main.go", "//go:multibuild:include=linux/amd64,windows/amd64\npackage main\n\nfunc main() {}\n")
+
+	t.Chdir(filepath.Join(dir, "cmd", "app"))
+	before, after, err := planAgainst("multibuild", "HEAD", ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []target{"linux/amd64", "linux/arm64"}; !slices.Equal(before.targets, want) {
+		t.Errorf("before: got %v, want %v", before.targets, want)
+	}
+	if want := []target{"linux/amd64", "windows/amd64"}; !slices.Equal(after.targets, want) {
+		t.Errorf("after: got %v, want %v", after.targets, want)
+	}
+	if !slices.Contains(before.config, "//go:multibuild:include=linux/amd64,linux/arm64") {
+		t.Errorf("before: got %q", before.config)
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The name of a configuration file at the root of a module, for projects that would rather not
+// keep directives in their sources. It holds the same settings as directives, written as TOML:
+//
+//	include = ["linux/*", "darwin/*"]
+//	output = "dist/${TARGET}-${GOOS}-${GOARCH}"
+//
+//	[package]
+//	maintainer = "Jane Doe <jane@example.com>"
+//
+// is equivalent to the directives include=linux/*,darwin/*, output=..., and package.maintainer=...
+const configFileName = "multibuild.toml"
+
+// Reads the configuration file at 'path', see configFileName.
+func loadConfigFile(path string) (options, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return options{}, err
+	}
+	lines, err := parseConfigFile(string(data), path)
+	if err != nil {
+		return options{}, err
+	}
+	// Settings are checked exactly as directives are.
+	return parseDirectives(lines, path)
+}
+
+// Parses the TOML in 'data', read from 'path', into the directives it's equivalent to.
+// Only as much of TOML as settings need is supported: tables, keys, strings, numbers, and arrays
+// of those, which become comma separated lists.
+func parseConfigFile(data, path string) ([]directiveLine, error) {
+	p := &configFileParser{data: data, path: path, line: 1}
+	var lines []directiveLine
+	var table []string
+	seen := make(map[string]int)
+	for {
+		p.skipBlank(true)
+		if p.eof() {
+			return lines, nil
+		}
+		line := p.line
+		if p.peek() == '[' {
+			p.pos++
+			if p.peek() == '[' {
+				return nil, p.errorf("arrays of tables are not supported")
+			}
+			key, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			if p.peek() != ']' {
+				return nil, p.errorf("expected ] after the table name")
+			}
+			p.pos++
+			table = key
+		} else {
+			key, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			if p.peek() != '=' {
+				return nil, p.errorf("expected = after %s", strings.Join(key, "."))
+			}
+			p.pos++
+			p.skipBlank(false)
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			name := strings.Join(slices.Concat(table, key), ".")
+			if prev, ok := seen[name]; ok {
+				return nil, fmt.Errorf("%s:%d: %s was already set at line %d", path, line, name, prev)
+			}
+			seen[name] = line
+			lines = append(lines, directiveLine{line, "//go:multibuild:" + name + "=" + value})
+		}
+		p.skipBlank(false)
+		if !p.eof() && p.peek() != '\n' {
+			return nil, p.errorf("expected the end of the line")
+		}
+	}
+}
+
+// Reads TOML from 'data', keeping track of the line it's on for errors.
+type configFileParser struct {
+	data string
+	path string
+	pos  int
+	line int
+}
+
+func (this *configFileParser) eof() bool {
+	return this.pos >= len(this.data)
+}
+
+// Returns the next byte, or 0 at the end.
+func (this *configFileParser) peek() byte {
+	if this.eof() {
+		return 0
+	}
+	return this.data[this.pos]
+}
+
+func (this *configFileParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%s:%d: %s", this.path, this.line, fmt.Sprintf(format, args...))
+}
+
+// Skips whitespace and comments, and if 'newlines', line breaks too.
+func (this *configFileParser) skipBlank(newlines bool) {
+	for !this.eof() {
+		switch c := this.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			this.pos++
+		case c == '#':
+			for !this.eof() && this.peek() != '\n' {
+				this.pos++
+			}
+		case c == '\n' && newlines:
+			this.pos++
+			this.line++
+		default:
+			return
+		}
+	}
+}
+
+var bareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+`)
+
+// Reads a key, e.g. package.name or copy-to."linux/*", as its dotted parts.
+func (this *configFileParser) key() ([]string, error) {
+	var parts []string
+	for {
+		this.skipBlank(false)
+		var part string
+		switch this.peek() {
+		case '"', '\'':
+			s, err := this.string()
+			if err != nil {
+				return nil, err
+			}
+			part = s
+		default:
+			part = bareKey.FindString(this.data[this.pos:])
+			if part == "" {
+				return nil, this.errorf("expected a key")
+			}
+			this.pos += len(part)
+		}
+		parts = append(parts, part)
+		this.skipBlank(false)
+		if this.peek() != '.' {
+			return parts, nil
+		}
+		this.pos++
+	}
+}
+
+var number = regexp.MustCompile(`^[+-]?[0-9][0-9_]*(\.[0-9_]+)?`)
+
+// Reads a value: a string, a number, or an array of those, which is joined with commas.
+func (this *configFileParser) value() (string, error) {
+	if this.peek() != '[' {
+		return this.scalar()
+	}
+	this.pos++
+	var values []string
+	for {
+		this.skipBlank(true)
+		if this.peek() == ']' {
+			break
+		}
+		v, err := this.scalar()
+		if err != nil {
+			return "", err
+		}
+		values = append(values, v)
+		this.skipBlank(true)
+		if this.peek() == ',' {
+			this.pos++
+		} else if this.peek() != ']' {
+			return "", this.errorf("expected , or ] in the array")
+		}
+	}
+	this.pos++
+	return strings.Join(values, ","), nil
+}
+
+// Reads a string or a number.
+func (this *configFileParser) scalar() (string, error) {
+	switch this.peek() {
+	case '"', '\'':
+		return this.string()
+	}
+	if n := number.FindString(this.data[this.pos:]); n != "" {
+		this.pos += len(n)
+		return strings.ReplaceAll(n, "_", ""), nil
+	}
+	return "", this.errorf("expected a string, number, or array")
+}
+
+// Reads a "basic" or 'literal' string, on a single line.
+func (this *configFileParser) string() (string, error) {
+	quote := this.peek()
+	if strings.HasPrefix(this.data[this.pos:], strings.Repeat(string(quote), 3)) {
+		return "", this.errorf("multi-line strings are not supported")
+	}
+	start := this.pos
+	this.pos++
+	for {
+		if this.eof() || this.peek() == '\n' {
+			return "", this.errorf("unterminated string")
+		}
+		c := this.peek()
+		this.pos++
+		if c == '\\' && quote == '"' {
+			this.pos++
+		} else if c == quote {
+			break
+		}
+	}
+	raw := this.data[start:this.pos]
+	if quote == '\'' {
+		return raw[1 : len(raw)-1], nil
+	}
+	s, err := strconv.Unquote(raw)
+	if err != nil {
+		return "", this.errorf("invalid string %s", raw)
+	}
+	return s, nil
+}
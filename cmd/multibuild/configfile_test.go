@@ -0,0 +1,105 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseConfigFile(t *testing.T) {
+	data := `# Targets
+include = ["linux/*", "darwin/*"] # trailing comment
+exclude = [
+	"linux/386",
+	'linux/arm', # literal strings
+]
+output = "dist/${TARGET}-${GOOS}-${GOARCH}"
+parallel = 4
+cpu-limit = 1.5
+
+[package]
+maintainer = "Jane Doe <jane@example.com>"
+"description" = "A \"quoted\" tool"
+
+[copy-to]
+"linux/*" = ["host:/srv/app"]
+`
+	got, err := parseConfigFile(data, "multibuild.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []directiveLine{
+		{2, "//go:multibuild:include=linux/*,darwin/*"},
+		{3, "//go:multibuild:exclude=linux/386,linux/arm"},
+		{7, "//go:multibuild:output=dist/${TARGET}-${GOOS}-${GOARCH}"},
+		{8, "//go:multibuild:parallel=4"},
+		{9, "//go:multibuild:cpu-limit=1.5"},
+		{12, "//go:multibuild:package.maintainer=Jane Doe <jane@example.com>"},
+		{13, `//go:multibuild:package.description=A "quoted" tool`},
+		{16, "//go:multibuild:copy-to.linux/*=host:/srv/app"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+
+	invalid := map[string]string{
+		"duplicate key":      "include = \"linux/*\"\ninclude = \"darwin/*\"\n",
+		"missing =":          "include \"linux/*\"\n",
+		"missing value":      "include =\n",
+		"boolean":            "include = true\n",
+		"unterminated":       "output = \"dist\n",
+		"multi-line string":  "output = \"\"\"dist\"\"\"\n",
+		"array of tables":    "[[package]]\n",
+		"unclosed table":     "[package\n",
+		"two on a line":      "include = \"linux/*\" exclude = \"linux/386\"\n",
+		"unterminated array": "include = [\"linux/*\"\n",
+	}
+	for name, data := range invalid {
+		if lines, err := parseConfigFile(data, "multibuild.toml"); err == nil {
+			t.Errorf("%s: expected an error, got %q", name, lines)
+		}
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"go.mod":              "module example.com/app\n",
+		"multibuild.toml":     "include = [\"linux/*\", \"darwin/*\"]\nformat = \"tar.gz\"\n",
+		"cmd/.multibuild":     "//go:multibuild:format=zip\n",
+		"cmd/a/main.go":       "//go:multibuild:include=linux/*\npackage main\n",
+		"cmd/b/main.go":       "package main\n",
+		"bad/go.mod":          "module example.com/bad\n",
+		"bad/multibuild.toml": "include = \"nope\"\n",
+		"bad/main.go":         "package main\n",
+	})
+	t.Chdir(dir)
+
+	// Directives and .multibuild files take precedence over the file.
+	a, err := loadConfig([]string{filepath.Join("cmd", "a", "main.go")}, options{}, testPlatforms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(a.Include, []filter{"linux/*"}) || !slices.Equal(a.Format, []format{formatZip}) {
+		t.Errorf("a: got include=%v format=%v", a.Include, a.Format)
+	}
+
+	b, err := loadConfig([]string{filepath.Join("cmd", "b", "main.go")}, options{}, testPlatforms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(b.Include, []filter{"linux/*", "darwin/*"}) {
+		t.Errorf("b: got include=%v", b.Include)
+	}
+	if o := b.originOf(settingKey("include", "darwin/*")); len(o) != 1 || o[0].location != "multibuild.toml:1" {
+		t.Errorf("b: got include origins %v", o)
+	}
+
+	if _, err := loadConfig([]string{filepath.Join("bad", "main.go")}, options{}, testPlatforms); err == nil {
+		t.Errorf("expected an error for an invalid setting in the file")
+	}
+}
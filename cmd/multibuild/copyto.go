@@ -0,0 +1,80 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// Hosts to copy the binaries of some targets to when publishing, over rsync or scp.
+// e.g. //go:multibuild:copy-to.linux/amd64=deploy@web1:/opt/app/,deploy@web2:/opt/app/
+type copyDest struct {
+	// The targets to copy.
+	filter filter
+
+	// Destinations, as host:path, e.g. deploy@web1:/opt/app/
+	hosts []string
+}
+
+func (this copyDest) String() string {
+	return strings.Join(this.hosts, ",")
+}
+
+// Validates a copy-to directive, 'key' being the filter and 'value' the destinations.
+func validateCopyTo(key, value string) (copyDest, error) {
+	filters, err := validateFilterString(key)
+	if err != nil {
+		return copyDest{}, err
+	}
+	if len(filters) != 1 {
+		return copyDest{}, fmt.Errorf("expected a single filter, got %q", key)
+	}
+	var hosts []string
+	for dest := range strings.SplitSeq(value, ",") {
+		host, dir, ok := strings.Cut(dest, ":")
+		if !ok || host == "" || dir == "" || strings.HasPrefix(host, "-") || strings.ContainsAny(dest, " \t") {
+			return copyDest{}, fmt.Errorf("%q is not a valid destination, expected HOST:PATH", dest)
+		}
+		hosts = append(hosts, dest)
+	}
+	return copyDest{filter: filters[0], hosts: hosts}, nil
+}
+
+// Returns the command copying 'local' to 'dest', using rsync if it is available, or else scp.
+// As with both, if 'dest' ends in a /, the file keeps its name.
+func copyCommand(ctx context.Context, local, dest string) *exec.Cmd {
+	if _, err := exec.LookPath("rsync"); err == nil {
+		return exec.CommandContext(ctx, "rsync", "--compress", "--perms", "--times", "-e", "ssh -o BatchMode=yes", local, dest)
+	}
+	return exec.CommandContext(ctx, "scp", "-o", "BatchMode=yes", "-p", local, dest)
+}
+
+// Copies the binary of each target in 'binaries' to the hosts of every one of 'dests' matching it.
+func copyBinaries(ctx context.Context, dests []copyDest, binaries map[target]string) error {
+	for _, d := range dests {
+		for _, t := range slices.Sorted(maps.Keys(binaries)) {
+			if !d.filter.matches(t) {
+				continue
+			}
+			for _, host := range d.hosts {
+				var out bytes.Buffer
+				cmd := copyCommand(ctx, binaries[t], host)
+				cmd.Stdout, cmd.Stderr = &out, &out
+				if err := cmd.Run(); err != nil {
+					return fmt.Errorf("%s: copying to %s: %s: %s", t, host, err, strings.TrimSpace(out.String()))
+				}
+				fmt.Fprintf(os.Stderr, "%s: copied to %s\n", t, host)
+			}
+		}
+	}
+	return nil
+}
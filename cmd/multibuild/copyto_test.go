@@ -0,0 +1,89 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestValidateCopyTo(t *testing.T) {
+	for _, tt := range []struct {
+		key, value string
+		wantError  bool
+	}{
+		{"linux/amd64", "deploy@web1:/opt/app/,deploy@web2:/opt/app/", false},
+		{"linux/*", "web1:app", false},
+		{"linux/amd64", "web1", true},
+		{"linux/amd64", "-oProxyCommand=evil:/x", true},
+		{"linux/amd64,linux/arm64", "web1:/opt/app/", true},
+	} {
+		if _, err := validateCopyTo(tt.key, tt.value); (err != nil) != tt.wantError {
+			t.Errorf("validateCopyTo(%q, %q) error = %v, wantError %v", tt.key, tt.value, err, tt.wantError)
+		}
+	}
+}
+
+func TestCopyBinaries(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake rsync and scp are shell scripts")
+	}
+	for _, tool := range []string{"rsync", "scp"} {
+		t.Run(tool, func(t *testing.T) {
+			// A fake copying tool, logging how it was run. Nothing else is in PATH,
+			// so scp is used when there is no rsync.
+			bin := t.TempDir()
+			log := filepath.Join(t.TempDir(), "log")
+			fake := "#!/bin/sh\necho \"${0##*/} $*\" >> " + log + "\n"
+			if err := os.WriteFile(filepath.Join(bin, tool), []byte(fake), 0755); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("PATH", bin)
+
+			dests := []copyDest{
+				{filter: "linux/*", hosts: []string{"web1:/opt/app/", "web2:/opt/app/"}},
+				{filter: "linux/arm64", hosts: []string{"pi:app"}},
+			}
+			binaries := map[target]string{"linux/arm64": "out/app-linux-arm64", "linux/amd64": "out/app-linux-amd64", "darwin/arm64": "out/app-darwin-arm64"}
+			if err := copyBinaries(t.Context(), dests, binaries); err != nil {
+				t.Fatal(err)
+			}
+
+			got, _ := os.ReadFile(log)
+			var want string
+			if tool == "rsync" {
+				want = `rsync --compress --perms --times -e ssh -o BatchMode=yes out/app-linux-amd64 web1:/opt/app/
+rsync --compress --perms --times -e ssh -o BatchMode=yes out/app-linux-amd64 web2:/opt/app/
+rsync --compress --perms --times -e ssh -o BatchMode=yes out/app-linux-arm64 web1:/opt/app/
+rsync --compress --perms --times -e ssh -o BatchMode=yes out/app-linux-arm64 web2:/opt/app/
+rsync --compress --perms --times -e ssh -o BatchMode=yes out/app-linux-arm64 pi:app
+`
+			} else {
+				want = `scp -o BatchMode=yes -p out/app-linux-amd64 web1:/opt/app/
+scp -o BatchMode=yes -p out/app-linux-amd64 web2:/opt/app/
+scp -o BatchMode=yes -p out/app-linux-arm64 web1:/opt/app/
+scp -o BatchMode=yes -p out/app-linux-arm64 web2:/opt/app/
+scp -o BatchMode=yes -p out/app-linux-arm64 pi:app
+`
+			}
+			if string(got) != want {
+				t.Errorf("ran:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+
+	t.Run("failure", func(t *testing.T) {
+		bin := t.TempDir()
+		os.WriteFile(filepath.Join(bin, "scp"), []byte("#!/bin/sh\necho 'Permission denied (publickey)' >&2\nexit 1\n"), 0755)
+		t.Setenv("PATH", bin)
+		err := copyBinaries(t.Context(), []copyDest{{filter: "*/*", hosts: []string{"web1:/opt/app/"}}}, map[target]string{"linux/amd64": "app"})
+		if err == nil || !strings.Contains(err.Error(), "Permission denied") {
+			t.Errorf("copyBinaries() = %v, want scp's error", err)
+		}
+	})
+}
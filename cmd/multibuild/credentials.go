@@ -0,0 +1,202 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Where to find the credentials for a registry.
+// e.g. //go:multibuild:registry-auth.ghcr.io=env:GITHUB_ACTOR:GITHUB_TOKEN
+type registryAuth struct {
+	// The registry, e.g. ghcr.io
+	host string
+
+	// One of:
+	//   - docker: docker's config, as written by docker login (the default)
+	//   - helper:NAME: a docker credential helper, docker-credential-NAME
+	//   - env:USERNAME:PASSWORD: the names of environment variables holding them
+	source string
+}
+
+func (this registryAuth) String() string {
+	return this.source
+}
+
+// Validates a registry-auth directive, 'key' being the registry and 'value' the source.
+func validateRegistryAuth(key, value string) (registryAuth, error) {
+	if key == "" || strings.ContainsAny(key, "/ \t") {
+		return registryAuth{}, fmt.Errorf("%q is not a registry", key)
+	}
+	kind, rest, _ := strings.Cut(value, ":")
+	switch kind {
+	case "docker":
+		if rest != "" {
+			return registryAuth{}, fmt.Errorf("docker takes no arguments")
+		}
+	case "helper":
+		if rest == "" || strings.ContainsAny(rest, `/\ `) {
+			return registryAuth{}, fmt.Errorf("expected helper:NAME, for docker-credential-NAME")
+		}
+	case "env":
+		names, err := validateEnvAllow(strings.Replace(rest, ":", ",", 1))
+		if err != nil || len(names) != 2 {
+			return registryAuth{}, fmt.Errorf("expected env:USERNAME_VARIABLE:PASSWORD_VARIABLE")
+		}
+	default:
+		return registryAuth{}, fmt.Errorf("expected docker, helper:NAME or env:USERNAME_VARIABLE:PASSWORD_VARIABLE, got %q", value)
+	}
+	return registryAuth{host: normalizeRegistry(key), source: value}, nil
+}
+
+// Credentials for a registry. Empty credentials mean anonymous access.
+type registryCredentials struct {
+	username, password string
+
+	// An OAuth2 refresh token, used instead of the password, if set.
+	identityToken string
+}
+
+func (this registryCredentials) empty() bool {
+	return this.username == "" && this.password == "" && this.identityToken == ""
+}
+
+// Normalizes a registry as written in docker's config, e.g. https://index.docker.io/v1/
+func normalizeRegistry(s string) string {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "https://"), "http://")
+	s, _, _ = strings.Cut(s, "/")
+	if s == "index.docker.io" || s == "registry-1.docker.io" {
+		return "docker.io"
+	}
+	return s
+}
+
+// The parts of docker's config.json we use.
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredHelpers map[string]string `json:"credHelpers"`
+	CredsStore  string            `json:"credsStore"`
+}
+
+// Returns the credentials for 'host' from docker's config, in $DOCKER_CONFIG or ~/.docker.
+func dockerCredentials(host string, lookup func(string) (string, bool)) (registryCredentials, error) {
+	dir, ok := lookup("DOCKER_CONFIG")
+	if !ok || dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return registryCredentials{}, nil
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if os.IsNotExist(err) {
+		return registryCredentials{}, nil
+	} else if err != nil {
+		return registryCredentials{}, err
+	}
+	var config dockerConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return registryCredentials{}, fmt.Errorf("%s: %w", filepath.Join(dir, "config.json"), err)
+	}
+
+	for key, helper := range config.CredHelpers {
+		if normalizeRegistry(key) == host {
+			return helperCredentials(helper, key)
+		}
+	}
+	for key, auth := range config.Auths {
+		if normalizeRegistry(key) != host || (auth.Auth == "" && auth.IdentityToken == "") {
+			continue
+		}
+		if auth.IdentityToken != "" {
+			return registryCredentials{identityToken: auth.IdentityToken}, nil
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		username, password, ok := strings.Cut(string(decoded), ":")
+		if err != nil || !ok {
+			return registryCredentials{}, fmt.Errorf("docker config: invalid auth for %s", key)
+		}
+		return registryCredentials{username: username, password: password}, nil
+	}
+	if config.CredsStore != "" {
+		// The store may well not have anything for the registry.
+		creds, err := helperCredentials(config.CredsStore, host)
+		if err == nil {
+			return creds, nil
+		}
+	}
+	return registryCredentials{}, nil
+}
+
+// Returns the credentials for 'host' from docker-credential-'helper'.
+// See https://github.com/docker/docker-credential-helpers
+func helperCredentials(helper, host string) (registryCredentials, error) {
+	name := "docker-credential-" + helper
+	cmd := exec.Command(name, "get")
+	cmd.Stdin = strings.NewReader(host)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String() + string(out))
+		if msg != "" {
+			return registryCredentials{}, fmt.Errorf("%s: %s: %s", name, err, msg)
+		}
+		return registryCredentials{}, fmt.Errorf("%s: %s", name, err)
+	}
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return registryCredentials{}, fmt.Errorf("%s: %w", name, err)
+	}
+	// Helpers use this username for identity tokens.
+	if creds.Username == "<token>" {
+		return registryCredentials{identityToken: creds.Secret}, nil
+	}
+	return registryCredentials{username: creds.Username, password: creds.Secret}, nil
+}
+
+// Returns the credentials for 'host', using the first of 'auths' for it, or docker's config.
+// 'lookup' is normally os.LookupEnv.
+func registryCredentialsFor(host string, auths []registryAuth, lookup func(string) (string, bool)) (registryCredentials, error) {
+	source := "docker"
+	for _, a := range auths {
+		if a.host == normalizeRegistry(host) {
+			source = a.source
+			break
+		}
+	}
+
+	kind, rest, _ := strings.Cut(source, ":")
+	switch kind {
+	case "helper":
+		return helperCredentials(rest, host)
+	case "env":
+		var creds registryCredentials
+		userVar, passVar, _ := strings.Cut(rest, ":")
+		var ok bool
+		if creds.username, ok = lookup(userVar); !ok || creds.username == "" {
+			return registryCredentials{}, fmt.Errorf("%s is not set, for %s", userVar, host)
+		}
+		if creds.password, ok = lookup(passVar); !ok || creds.password == "" {
+			return registryCredentials{}, fmt.Errorf("%s is not set, for %s", passVar, host)
+		}
+		return creds, nil
+	default:
+		return dockerCredentials(normalizeRegistry(host), lookup)
+	}
+}
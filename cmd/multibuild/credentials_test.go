@@ -0,0 +1,98 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestValidateRegistryAuth(t *testing.T) {
+	for _, tt := range []struct {
+		key, value string
+		want       registryAuth
+		wantError  bool
+	}{
+		{"ghcr.io", "env:GITHUB_ACTOR:GITHUB_TOKEN", registryAuth{"ghcr.io", "env:GITHUB_ACTOR:GITHUB_TOKEN"}, false},
+		{"123.dkr.ecr.us-east-1.amazonaws.com", "helper:ecr-login", registryAuth{"123.dkr.ecr.us-east-1.amazonaws.com", "helper:ecr-login"}, false},
+		{"index.docker.io", "docker", registryAuth{"docker.io", "docker"}, false},
+		{"ghcr.io", "env:GITHUB_TOKEN", registryAuth{}, true},
+		{"ghcr.io", "helper:../evil", registryAuth{}, true},
+		{"ghcr.io", "password:hunter2", registryAuth{}, true},
+		{"ghcr.io/me", "docker", registryAuth{}, true},
+	} {
+		got, err := validateRegistryAuth(tt.key, tt.value)
+		if (err != nil) != tt.wantError || got != tt.want {
+			t.Errorf("validateRegistryAuth(%q, %q) = %+v, %v; want %+v, error %v", tt.key, tt.value, got, err, tt.want, tt.wantError)
+		}
+	}
+}
+
+// Adds a fake docker-credential-fake to PATH, which returns credentials for fake.example.com.
+func fakeCredentialHelper(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as a fake credential helper")
+	}
+	bin := t.TempDir()
+	script := `#!/bin/sh
+[ "$1" = get ] || exit 1
+read host
+[ "$host" = fake.example.com ] || { echo "credentials not found in native keychain"; exit 1; }
+echo '{"ServerURL":"fake.example.com","Username":"helper-user","Secret":"helper-secret"}'
+`
+	if err := os.WriteFile(filepath.Join(bin, "docker-credential-fake"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestDockerCredentials(t *testing.T) {
+	fakeCredentialHelper(t)
+	dir := t.TempDir()
+	config := `{
+		"auths": {
+			"https://index.docker.io/v1/": {"auth": "dXNlcjpwYXNz"},
+			"registry.example.com": {"identitytoken": "refresh"},
+			"empty.example.com": {}
+		},
+		"credHelpers": {"fake.example.com": "fake"}
+	}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := map[string]string{"DOCKER_CONFIG": dir, "USER_VAR": "env-user", "PASS_VAR": "env-pass"}[name]
+		return v, ok
+	}
+
+	for _, tt := range []struct {
+		host  string
+		auths []registryAuth
+		want  registryCredentials
+	}{
+		{"docker.io", nil, registryCredentials{username: "user", password: "pass"}},
+		{"registry.example.com", nil, registryCredentials{identityToken: "refresh"}},
+		{"fake.example.com", nil, registryCredentials{username: "helper-user", password: "helper-secret"}},
+		{"empty.example.com", nil, registryCredentials{}},
+		{"ghcr.io", nil, registryCredentials{}},
+		{"ghcr.io", []registryAuth{{"ghcr.io", "env:USER_VAR:PASS_VAR"}}, registryCredentials{username: "env-user", password: "env-pass"}},
+		{"docker.io", []registryAuth{{"ghcr.io", "env:USER_VAR:PASS_VAR"}}, registryCredentials{username: "user", password: "pass"}},
+	} {
+		got, err := registryCredentialsFor(tt.host, tt.auths, lookup)
+		if err != nil || got != tt.want {
+			t.Errorf("registryCredentialsFor(%s, %v) = %+v, %v; want %+v", tt.host, tt.auths, got, err, tt.want)
+		}
+	}
+
+	// An explicitly configured source has to work.
+	if _, err := registryCredentialsFor("ghcr.io", []registryAuth{{"ghcr.io", "env:MISSING:PASS_VAR"}}, lookup); err == nil {
+		t.Errorf("a missing variable didn't fail")
+	}
+	if _, err := registryCredentialsFor("ghcr.io", []registryAuth{{"ghcr.io", "helper:fake"}}, lookup); err == nil {
+		t.Errorf("a helper without credentials didn't fail")
+	}
+}
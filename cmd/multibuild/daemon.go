@@ -0,0 +1,303 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// How long the CLI waits on the daemon before doing the work itself.
+const daemonTimeout = 30 * time.Second
+
+// Returns the socket 'multibuild daemon' listens on, or "" if caching is off. It is in a
+// directory of its own, which only the user running the daemon can get into.
+func daemonSocket() string {
+	dir := cacheDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "daemon", "daemon.sock")
+}
+
+// Returns the absolute path of the go binary on PATH.
+func lookGo() (string, error) {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(goBin)
+}
+
+// A request to the daemon, one per connection.
+type daemonRequest struct {
+	// "targets", for the output of go tool dist list, or "directives".
+	Op string `json:"op"`
+
+	// For "targets": the go binary, the directory, and the GOTOOLCHAIN the CLI would run it with.
+	// The daemon only runs its own go binary, so 'Go' must be that.
+	Go        string `json:"go,omitempty"`
+	Dir       string `json:"dir,omitempty"`
+	Toolchain string `json:"toolchain,omitempty"`
+
+	// For "directives": absolute paths of the source files to scan.
+	Paths []string `json:"paths,omitempty"`
+}
+
+// The daemon's answer to a request.
+type daemonResponse struct {
+	Error      string            `json:"error,omitempty"`
+	Targets    []target          `json:"targets,omitempty"`
+	Directives [][]directiveLine `json:"directives,omitempty"`
+}
+
+// The state the daemon keeps warm between requests.
+type daemon struct {
+	verbose bool
+
+	// The go binary the daemon was started with, the only one it runs.
+	goBin string
+
+	mu         sync.Mutex
+	targets    map[string][]target
+	directives map[string]daemonFile
+}
+
+// The directives of a source file, and how to tell if it changed since.
+type daemonFile struct {
+	size  int64
+	mtime time.Time
+	lines []directiveLine
+}
+
+// Implements 'multibuild daemon'.
+func doDaemon(self string, argv []string) {
+	fs := flag.NewFlagSet(self+" daemon", flag.ExitOnError)
+	verbose := fs.Bool("v", false, "log each request")
+	fs.Parse(argv)
+
+	path := daemonSocket()
+	if path == "" {
+		fatalCode(exitConfig, "multibuild: daemon: MULTIBUILD_CACHE=off, so there is nowhere to listen")
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		fatal("multibuild: daemon: already running on %s", path)
+	}
+	goBin, err := lookGo()
+	if err != nil {
+		fatal("multibuild: daemon: %s", err)
+	}
+	os.Remove(path) // left behind by a daemon which didn't exit cleanly
+	// Anyone who can connect can have the daemon read files and run go as its user, so both the
+	// directory and the socket are private, whatever the umask, or the directory was created with.
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		fatal("multibuild: daemon: %s", err)
+	}
+	if err := os.Chmod(filepath.Dir(path), 0700); err != nil {
+		fatal("multibuild: daemon: %s", err)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		fatal("multibuild: daemon: %s", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		fatal("multibuild: daemon: %s", err)
+	}
+	fmt.Fprintf(os.Stderr, "multibuild: daemon listening on %s\n", path)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	d := &daemon{verbose: *verbose, goBin: goBin}
+	if err := d.serve(ctx, ln); err != nil {
+		fatal("multibuild: daemon: %s", err)
+	}
+}
+
+// Answers requests on 'ln' until 'ctx' is done.
+func (this *daemon) serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go this.handle(conn)
+	}
+}
+
+// Answers the request on 'conn'.
+func (this *daemon) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(daemonTimeout))
+	var req daemonRequest
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		return
+	}
+	start := time.Now()
+	var resp daemonResponse
+	var err error
+	switch req.Op {
+	case "targets":
+		resp.Targets, err = this.distList(req)
+	case "directives":
+		resp.Directives, err = this.scan(req.Paths)
+	default:
+		err = fmt.Errorf("unknown request %q", req.Op)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	if this.verbose {
+		fmt.Fprintf(os.Stderr, "multibuild: daemon: %s (%d paths) in %s: %v\n", req.Op, len(req.Paths), time.Since(start), err)
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// Returns the targets the toolchain described by 'req' can build, listing them
+// only the first time for each toolchain. Only the daemon's own go binary is run.
+func (this *daemon) distList(req daemonRequest) ([]target, error) {
+	if req.Go != this.goBin {
+		return nil, fmt.Errorf("the daemon runs %s, not %s", this.goBin, req.Go)
+	}
+	st, err := os.Stat(req.Go)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%s\n%d\n%s\n%s", req.Go, st.ModTime().UnixNano(), req.Dir, req.Toolchain)
+	this.mu.Lock()
+	targets, ok := this.targets[key]
+	this.mu.Unlock()
+	if ok {
+		return targets, nil
+	}
+
+	cmd := exec.Command(req.Go, "tool", "dist", "list")
+	cmd.Dir = req.Dir
+	cmd.Env = append(os.Environ(), "GOTOOLCHAIN="+req.Toolchain)
+	targets, err = parseTargetList(cmd)
+	if err != nil {
+		return nil, err
+	}
+	this.mu.Lock()
+	if this.targets == nil {
+		this.targets = make(map[string][]target)
+	}
+	this.targets[key] = targets
+	this.mu.Unlock()
+	return targets, nil
+}
+
+// Returns the directives in each of 'paths', only reading those which changed since last time.
+func (this *daemon) scan(paths []string) ([][]directiveLine, error) {
+	out := make([][]directiveLine, len(paths))
+	for idx, path := range paths {
+		st, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		this.mu.Lock()
+		cached, ok := this.directives[path]
+		this.mu.Unlock()
+		if ok && cached.size == st.Size() && cached.mtime.Equal(st.ModTime()) {
+			out[idx] = cached.lines
+			continue
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		lines, err := findDirectives(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		out[idx] = lines
+		// As with the cache on disk, a change within the resolution of timestamps might go unnoticed.
+		if time.Since(st.ModTime()) >= cacheModTimeCutoff {
+			this.mu.Lock()
+			if this.directives == nil {
+				this.directives = make(map[string]daemonFile)
+			}
+			this.directives[path] = daemonFile{st.Size(), st.ModTime(), lines}
+			this.mu.Unlock()
+		}
+	}
+	return out, nil
+}
+
+// Sends 'req' to the daemon at 'socket'. Fails if there isn't one running, or it couldn't
+// answer, in which case the CLI does the work itself.
+func askDaemon(socket string, req daemonRequest) (daemonResponse, error) {
+	if socket == "" {
+		return daemonResponse{}, errors.New("no daemon")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return daemonResponse{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(daemonTimeout))
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return daemonResponse{}, err
+	}
+	var resp daemonResponse
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&resp); err != nil {
+		return daemonResponse{}, err
+	}
+	if resp.Error != "" {
+		return daemonResponse{}, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// Returns the targets the toolchain can build, from the daemon if one is running.
+func daemonTargetList(socket string) ([]target, error) {
+	goBin, err := lookGo()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := askDaemon(socket, daemonRequest{Op: "targets", Go: goBin, Dir: dir, Toolchain: os.Getenv("GOTOOLCHAIN")})
+	return resp.Targets, err
+}
+
+// Returns the directives in each of 'sources', from the daemon if one is running.
+func daemonDirectives(socket string, sources []string) ([][]directiveLine, error) {
+	paths := make([]string, len(sources))
+	for idx, path := range sources {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		paths[idx] = abs
+	}
+	resp, err := askDaemon(socket, daemonRequest{Op: "directives", Paths: paths})
+	if err == nil && len(resp.Directives) != len(sources) {
+		err = fmt.Errorf("daemon answered for %d of %d sources", len(resp.Directives), len(sources))
+	}
+	return resp.Directives, err
+}
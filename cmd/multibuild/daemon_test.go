@@ -0,0 +1,73 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDaemon(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" || runtime.GOOS == "js" {
+		t.Skip("unix sockets are needed")
+	}
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "daemon.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	goBin, err := lookGo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &daemon{goBin: goBin}
+	go d.serve(t.Context(), ln)
+
+	path := filepath.Join(dir, "main.go")
+	write := func(src string, mtime time.Time) {
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, mtime, mtime)
+	}
+	old := time.Now().Add(-time.Hour)
+	write("package main\n//go:multibuild:include=linux/*\n", old)
+	got, err := daemonDirectives(socket, []string{path})
+	if want := []directiveLine{{2, "//go:multibuild:include=linux/*"}}; err != nil || len(got) != 1 || !slices.Equal(got[0], want) {
+		t.Fatalf("got %v (%v), want %v", got, err, want)
+	}
+	if _, ok := d.directives[path]; !ok {
+		t.Errorf("directives were not kept")
+	}
+	write("package main\n//go:multibuild:include=darwin/*\n", old.Add(time.Second))
+	if got, err := daemonDirectives(socket, []string{path}); err != nil || got[0][0].Text != "//go:multibuild:include=darwin/*" {
+		t.Errorf("change was not noticed: %v (%v)", got, err)
+	}
+	if _, err := daemonDirectives(socket, []string{filepath.Join(dir, "missing.go")}); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+
+	targets, err := daemonTargetList(socket)
+	if err != nil || !slices.Contains(targets, "linux/amd64") {
+		t.Fatalf("got targets %v (%v)", targets, err)
+	}
+	if len(d.targets) != 1 {
+		t.Errorf("targets were not kept")
+	}
+	// Only the daemon's own go is run, whatever the request says.
+	if _, err := askDaemon(socket, daemonRequest{Op: "targets", Go: "/bin/sh", Dir: dir}); err == nil {
+		t.Errorf("expected an error for another go binary")
+	}
+
+	if _, err := daemonTargetList(filepath.Join(dir, "nothing.sock")); err == nil {
+		t.Errorf("expected an error without a daemon")
+	}
+}
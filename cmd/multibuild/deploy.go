@@ -0,0 +1,307 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Validates a deploy= value: the path of a Helm values file or kustomization to update.
+func validateDeploy(s string) (string, error) {
+	if s == "" {
+		return "", fmt.Errorf("empty path")
+	}
+	if ext := filepath.Ext(s); ext != ".yaml" && ext != ".yml" {
+		return "", fmt.Errorf("%q is not a YAML file", s)
+	}
+	return filepath.ToSlash(s), nil
+}
+
+// Whether 'path' is a kustomization, rather than Helm values.
+func isKustomization(path string) bool {
+	base := filepath.Base(path)
+	return base == "kustomization.yaml" || base == "kustomization.yml"
+}
+
+// A line of YAML, split up enough to edit simple mappings.
+type yamlLine struct {
+	text string
+
+	// The column the line's key starts in, after any "- ", or -1 for blank lines and comments.
+	col int
+
+	// Whether the line starts a list item.
+	item bool
+
+	key, value string
+}
+
+func parseYAMLLine(text string) yamlLine {
+	l := yamlLine{text: text, col: -1}
+	trimmed := strings.TrimLeft(text, " ")
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return l
+	}
+	l.col = len(text) - len(trimmed)
+	if rest, ok := strings.CutPrefix(trimmed, "- "); ok {
+		l.item = true
+		l.col += 2 + len(rest) - len(strings.TrimLeft(rest, " "))
+		trimmed = strings.TrimLeft(rest, " ")
+	}
+	key, value, ok := strings.Cut(trimmed, ":")
+	if !ok || strings.ContainsAny(key, " \"'") {
+		return l
+	}
+	l.key = key
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = value[:i]
+	}
+	l.value = strings.Trim(strings.TrimSpace(value), `"'`)
+	return l
+}
+
+// Returns the range of lines [start, end) making up the mapping which line 'i' is a key of.
+func yamlMapping(lines []yamlLine, i int) (start, end int) {
+	col := lines[i].col
+	start = i
+	for j := i - 1; j >= 0 && !lines[i].item; j-- {
+		if lines[j].col < 0 || lines[j].col > col {
+			continue
+		}
+		if lines[j].col < col {
+			break
+		}
+		start = j
+		if lines[j].item {
+			break
+		}
+	}
+	end = i + 1
+	for j := i + 1; j < len(lines); j++ {
+		if lines[j].col < 0 || lines[j].col > col {
+			end = j + 1
+			continue
+		}
+		if lines[j].col < col || lines[j].item {
+			break
+		}
+		end = j + 1
+	}
+	// Trailing blank lines and comments belong to whatever follows.
+	for end > i+1 && lines[end-1].col < 0 {
+		end--
+	}
+	return start, end
+}
+
+// Sets 'key' to 'value' in the mapping [start, end) of 'lines', adding it after line 'after' if it's missing.
+func setYAMLKey(lines []yamlLine, start, end, after int, key, value string) []yamlLine {
+	col := lines[after].col
+	for j := start; j < end; j++ {
+		if lines[j].col == col && lines[j].key == key {
+			prefix := lines[j].text[:strings.Index(lines[j].text, key+":")]
+			lines[j] = parseYAMLLine(fmt.Sprintf("%s%s: %q", prefix, key, value))
+			return lines
+		}
+	}
+	added := parseYAMLLine(fmt.Sprintf("%s%s: %q", strings.Repeat(" ", col), key, value))
+	return append(lines[:after+1], append([]yamlLine{added}, lines[after+1:]...)...)
+}
+
+// Whether 'value' names the same repository as 'ref'. The registry may be given separately
+// (e.g. as a registry: key), so a bare repository also matches.
+func sameRepository(value string, ref imageRef) bool {
+	if value == ref.repository {
+		return true
+	}
+	r, err := parseImageRef(value)
+	return err == nil && r.registry == ref.registry && r.repository == ref.repository
+}
+
+// Updates the image 'ref' in 'content', to be pinned to 'digest'.
+//
+// For Helm values, this looks for a mapping with a repository: of the image, and sets its
+// tag: to ref's tag. If the mapping has a digest: it is set, otherwise the digest is added to the tag.
+// For kustomizations, the images: entry with a name: of the image gets a newTag: and digest:.
+func updateDeployFile(content string, kustomize bool, ref imageRef, digest string) (string, error) {
+	var lines []yamlLine
+	for _, text := range strings.Split(content, "\n") {
+		lines = append(lines, parseYAMLLine(text))
+	}
+
+	key := "repository"
+	if kustomize {
+		key = "name"
+	}
+	found := false
+	for i := 0; i < len(lines); i++ {
+		if lines[i].key != key || !sameRepository(lines[i].value, ref) {
+			continue
+		}
+		found = true
+		start, end := yamlMapping(lines, i)
+		hasDigest := false
+		for j := start; j < end; j++ {
+			hasDigest = hasDigest || (lines[j].col == lines[i].col && lines[j].key == "digest")
+		}
+		switch {
+		case kustomize:
+			lines = setYAMLKey(lines, start, end, i, "newTag", ref.reference)
+			start, end = yamlMapping(lines, i)
+			lines = setYAMLKey(lines, start, end, i, "digest", digest)
+		case hasDigest:
+			lines = setYAMLKey(lines, start, end, i, "tag", ref.reference)
+			start, end = yamlMapping(lines, i)
+			lines = setYAMLKey(lines, start, end, i, "digest", digest)
+		default:
+			lines = setYAMLKey(lines, start, end, i, "tag", ref.reference+"@"+digest)
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("no %s: %s found", key, ref.registry+"/"+ref.repository)
+	}
+	return strings.Join(mapSlice(lines, func(l yamlLine) string { return l.text }), "\n"), nil
+}
+
+// Updates the deployment files 'paths' (relative to 'dir') with 'image', a reference by digest
+// published as the tag in 'tagged'. If 'patch' is set, the changes are written there as a patch
+// instead of being made to the files.
+func updateDeployFiles(dir string, paths []string, tagged, image, patch string) error {
+	ref, err := parseImageRef(tagged)
+	if err != nil {
+		return err
+	}
+	_, digest, _ := strings.Cut(image, "@")
+
+	var diffs strings.Builder
+	for _, p := range paths {
+		path := filepath.Join(dir, filepath.FromSlash(p))
+		old, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		updated, err := updateDeployFile(string(old), isKustomization(path), ref, digest)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if patch != "" {
+			diffs.WriteString(unifiedDiff(filepath.ToSlash(path), string(old), updated))
+			continue
+		}
+		if updated != string(old) {
+			if err := os.WriteFile(path, []byte(updated), 0644); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "multibuild: updated %s\n", path)
+		}
+	}
+	if patch != "" {
+		return os.WriteFile(patch, []byte(diffs.String()), 0644)
+	}
+	return nil
+}
+
+// Returns a unified diff, with three lines of context, turning 'old' into 'updated' in 'path'.
+func unifiedDiff(path, old, updated string) string {
+	a, b := strings.SplitAfter(old, "\n"), strings.SplitAfter(updated, "\n")
+	if a[len(a)-1] == "" {
+		a = a[:len(a)-1]
+	}
+	if b[len(b)-1] == "" {
+		b = b[:len(b)-1]
+	}
+
+	// The longest common subsequence, from each position onwards.
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	// Each edit is ' ', '-' or '+', followed by the line.
+	type edit struct {
+		op   byte
+		line string
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i]})
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', a[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j]})
+			j++
+		}
+	}
+
+	const context = 3
+	var out strings.Builder
+	for k := 0; k < len(edits); {
+		if edits[k].op == ' ' {
+			k++
+			continue
+		}
+		// A hunk runs from some context before a change, to some context after the last change
+		// which isn't separated from it by more than twice the context.
+		first := max(0, k-context)
+		last := k
+		for n := k; n < len(edits); n++ {
+			if edits[n].op != ' ' {
+				last = n
+			} else if n-last > 2*context {
+				break
+			}
+		}
+		end := min(len(edits), last+context+1)
+
+		oldStart, newStart := 1, 1
+		for _, e := range edits[:first] {
+			if e.op != '+' {
+				oldStart++
+			}
+			if e.op != '-' {
+				newStart++
+			}
+		}
+		oldLen, newLen := 0, 0
+		for _, e := range edits[first:end] {
+			if e.op != '+' {
+				oldLen++
+			}
+			if e.op != '-' {
+				newLen++
+			}
+		}
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- a/%s\n+++ b/%s\n", path, path)
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", oldStart, oldLen, newStart, newLen)
+		for _, e := range edits[first:end] {
+			out.WriteByte(e.op)
+			out.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		k = end
+	}
+	return out.String()
+}
@@ -0,0 +1,99 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+const testDigest = "sha256:0123456789abcdef"
+
+func TestUpdateDeployFile(t *testing.T) {
+	ref := imageRef{"ghcr.io", "me/app", "v1.2"}
+	for _, tt := range []struct {
+		name      string
+		kustomize bool
+		input     string
+		want      string
+		wantError bool
+	}{
+		{
+			name:  "helm tag",
+			input: "replicas: 2\nimage:\n  repository: ghcr.io/me/app\n  tag: v1.1 # bumped by CI\n  pullPolicy: IfNotPresent\nservice:\n  port: 80\n",
+			want:  "replicas: 2\nimage:\n  repository: ghcr.io/me/app\n  tag: \"v1.2@sha256:0123456789abcdef\"\n  pullPolicy: IfNotPresent\nservice:\n  port: 80\n",
+		},
+		{
+			name:  "helm digest",
+			input: "image:\n  tag: \"\"\n  digest: \"\"\n\n  repository: ghcr.io/me/app\n",
+			want:  "image:\n  tag: \"v1.2\"\n  digest: \"sha256:0123456789abcdef\"\n\n  repository: ghcr.io/me/app\n",
+		},
+		{
+			name:  "helm separate registry, missing tag",
+			input: "api:\n  image:\n    registry: ghcr.io\n    repository: me/app\nworker:\n  image:\n    registry: ghcr.io\n    repository: me/other\n    tag: v1\n",
+			want:  "api:\n  image:\n    registry: ghcr.io\n    repository: me/app\n    tag: \"v1.2@sha256:0123456789abcdef\"\nworker:\n  image:\n    registry: ghcr.io\n    repository: me/other\n    tag: v1\n",
+		},
+		{
+			name:      "kustomize",
+			kustomize: true,
+			input:     "resources:\n- deployment.yaml\nimages:\n- name: ghcr.io/other\n  newTag: v1\n- name: ghcr.io/me/app\n  newTag: v1.1\n",
+			want:      "resources:\n- deployment.yaml\nimages:\n- name: ghcr.io/other\n  newTag: v1\n- name: ghcr.io/me/app\n  digest: \"sha256:0123456789abcdef\"\n  newTag: \"v1.2\"\n",
+		},
+		{
+			name:      "missing",
+			input:     "image:\n  repository: ghcr.io/me/other\n",
+			wantError: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := updateDeployFile(tt.input, tt.kustomize, ref, testDigest)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("updateDeployFile() error = %v, wantError %v", err, tt.wantError)
+			}
+			if got != tt.want {
+				t.Errorf("updateDeployFile() =\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateDeployFilesPatch(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs patch")
+	}
+	if _, err := exec.LookPath("patch"); err != nil {
+		t.Skip("needs patch")
+	}
+	dir := t.TempDir()
+	values := "# values\na: 1\nb: 2\nc: 3\nimage:\n  repository: ghcr.io/me/app\n  tag: v1\nd: 4\ne: 5\nf: 6\ng: 7\n"
+	if err := os.WriteFile(filepath.Join(dir, "values.yaml"), []byte(values), 0644); err != nil {
+		t.Fatal(err)
+	}
+	patch := filepath.Join(dir, "deploy.patch")
+	t.Chdir(dir)
+	if err := updateDeployFiles(".", []string{"values.yaml"}, "ghcr.io/me/app:v2", "ghcr.io/me/app@"+testDigest, patch); err != nil {
+		t.Fatal(err)
+	}
+	want := "--- a/values.yaml\n+++ b/values.yaml\n@@ -4,7 +4,7 @@\n c: 3\n image:\n   repository: ghcr.io/me/app\n-  tag: v1\n+  tag: \"v2@sha256:0123456789abcdef\"\n d: 4\n e: 5\n f: 6\n"
+	if got, _ := os.ReadFile(patch); string(got) != want {
+		t.Errorf("patch =\n%s\nwant:\n%s", got, want)
+	}
+
+	// The patch applies, and gives the same result as editing the file.
+	if out, err := exec.Command("patch", "-p1", "-i", patch).CombinedOutput(); err != nil {
+		t.Fatalf("patch: %s: %s", err, out)
+	}
+	patched, _ := os.ReadFile("values.yaml")
+	os.WriteFile("values.yaml", []byte(values), 0644)
+	if err := updateDeployFiles(".", []string{"values.yaml"}, "ghcr.io/me/app:v2", "ghcr.io/me/app@"+testDigest, ""); err != nil {
+		t.Fatal(err)
+	}
+	if edited, _ := os.ReadFile("values.yaml"); string(edited) != string(patched) {
+		t.Errorf("edited =\n%s\npatched:\n%s", edited, patched)
+	}
+}
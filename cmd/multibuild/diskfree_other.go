@@ -0,0 +1,12 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !(linux || darwin || freebsd || windows)
+
+package main
+
+// Returns the number of bytes available on the filesystem containing 'dir'.
+func diskFree(dir string) (int64, error) {
+	return 0, errDiskFreeUnsupported
+}
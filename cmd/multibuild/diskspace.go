@@ -0,0 +1,155 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Returned when there is nothing to base an estimate of disk usage on.
+var errNoEstimate = errors.New("no previous outputs to estimate from")
+
+// Returned by diskFree on platforms where it isn't implemented.
+var errDiskFreeUnsupported = errors.New("not supported on " + runtime.GOOS)
+
+// Estimates how many more bytes will be needed to write 'planned', where each
+// entry is the artifacts of a target (the binary first, see artifactPaths).
+//
+// Sizes are based on the binaries of a previous run: a target's binary is
+// expected to be as large as it was last time, or otherwise as large as the
+// average binary. Archives are assumed to be as large as the binary they contain,
+// which is pessimistic, but it's better to be pessimistic here. If there are no
+// previous binaries, 'measure' is used to find the size of one, if it is not nil.
+//
+// Files which already exist are replaced, so their size is subtracted.
+func estimateDiskUsage(planned [][]string, measure func() (int64, error)) (int64, error) {
+	size := func(path string) (int64, bool) {
+		st, err := os.Stat(path)
+		if err != nil {
+			return 0, false
+		}
+		return st.Size(), true
+	}
+
+	var known, total int64
+	for _, artifacts := range planned {
+		if n, ok := size(artifacts[0]); ok {
+			known++
+			total += n
+		}
+	}
+	var average int64
+	switch {
+	case known > 0:
+		average = total / known
+	case measure != nil:
+		n, err := measure()
+		if err != nil {
+			return 0, err
+		}
+		average = n
+	default:
+		return 0, errNoEstimate
+	}
+
+	var need int64
+	for _, artifacts := range planned {
+		bin, ok := size(artifacts[0])
+		if !ok {
+			bin = average
+		}
+		for _, a := range artifacts {
+			need += bin
+			if n, ok := size(a); ok {
+				need -= n
+			}
+		}
+	}
+	return max(need, 0), nil
+}
+
+// Returns the closest directory to 'path' which exists.
+func existingDir(path string) string {
+	dir := filepath.Dir(path)
+	for {
+		if st, err := os.Stat(dir); err == nil && st.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// Checks there is enough free space to write the outputs of 'targets'.
+// Normally, this is a warning, and only done if there are previous outputs to estimate from.
+// With --multibuild-preflight, the host binary is built to estimate from if need be,
+// and a lack of space is fatal.
+func preflightDiskSpace(opts options, args cliArgs, env []string, targets []target) {
+	if len(targets) == 0 {
+		return
+	}
+	var planned [][]string
+	for _, t := range targets {
+		out, outBin := opts.outputPaths(args.output, t)
+		planned = append(planned, artifactPaths(opts.Format, t, out, outBin))
+	}
+
+	var measure func() (int64, error)
+	if args.preflight {
+		measure = func() (int64, error) {
+			tmp, err := os.MkdirTemp("", "multibuild-preflight")
+			if err != nil {
+				return 0, err
+			}
+			defer os.RemoveAll(tmp)
+			bin := filepath.Join(tmp, "bin")
+			buildArgs := append([]string{"-o", bin}, args.goBuildArgs...)
+			if err := runBuild(context.Background(), env, nil, buildLimits{}, buildArgs, target(runtime.GOOS+"/"+runtime.GOARCH), nil); err != nil {
+				return 0, err
+			}
+			st, err := os.Stat(bin)
+			if err != nil {
+				return 0, err
+			}
+			return st.Size(), nil
+		}
+	}
+
+	need, err := estimateDiskUsage(planned, measure)
+	if err != nil {
+		if args.preflight {
+			fatal("multibuild: preflight: failed to estimate disk usage: %s", err)
+		}
+		return
+	}
+	need += need / 10 // headroom
+
+	dir := existingDir(planned[0][0])
+	free, err := diskFree(dir)
+	if err != nil {
+		if args.preflight {
+			fatal("multibuild: preflight: failed to find free space in %s: %s", dir, err)
+		}
+		return
+	}
+	if args.verbose {
+		fmt.Fprintf(os.Stderr, "multibuild: preflight: need about %s, %s free in %s\n", formatSize(need), formatSize(free), dir)
+	}
+	if need <= free {
+		return
+	}
+	if args.preflight {
+		fatal("multibuild: preflight: need about %s, but only %s is free in %s", formatSize(need), formatSize(free), dir)
+	}
+	warn(warnDiskSpace, "need about %s, but only %s is free in %s", formatSize(need), formatSize(free), dir)
+}
@@ -0,0 +1,56 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEstimateDiskUsage(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	write := func(name string, size int) {
+		if err := os.WriteFile(path(name), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nothing to go on.
+	planned := [][]string{{path("a")}, {path("b"), path("b.zip")}}
+	if _, err := estimateDiskUsage(planned, nil); !errors.Is(err, errNoEstimate) {
+		t.Errorf("expected errNoEstimate, got %v", err)
+	}
+
+	// Measured: 'a' is 100, 'b' is 100 plus a 100 byte archive.
+	need, err := estimateDiskUsage(planned, func() (int64, error) { return 100, nil })
+	if err != nil || need != 300 {
+		t.Errorf("measured: got %d, %v, want 300", need, err)
+	}
+
+	// From a previous run: 'a' exists, and is replaced, so only 'b' (the average size) needs space.
+	write("a", 200)
+	need, err = estimateDiskUsage(planned, nil)
+	if err != nil || need != 400 {
+		t.Errorf("previous run: got %d, %v, want 400", need, err)
+	}
+
+	// Everything exists already.
+	write("b", 50)
+	write("b.zip", 50)
+	need, err = estimateDiskUsage(planned, nil)
+	if err != nil || need != 0 {
+		t.Errorf("rebuild: got %d, %v, want 0", need, err)
+	}
+}
+
+func TestExistingDir(t *testing.T) {
+	dir := t.TempDir()
+	if got := existingDir(filepath.Join(dir, "dist", "linux", "app")); got != dir {
+		t.Errorf("got %s, want %s", got, dir)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Something that happened to a target during a run, for --multibuild-events.
+type buildEvent struct {
+	Time   time.Time `json:"time"`
+	Target target    `json:"target"`
+
+	// "queued", "build", the name of each later stage (e.g. "archive"), then "done".
+	// Along the way, "artifact" as each artifact is written, and "error" if a step fails.
+	Event string `json:"event"`
+
+	// For "artifact": its path.
+	Artifact string `json:"artifact,omitempty"`
+
+	// For "error": the step that failed, e.g. "build" or "archive".
+	Step string `json:"step,omitempty"`
+
+	// For "done": how the target ended up, and its artifacts.
+	Status    string   `json:"status,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	Artifacts []string `json:"artifacts,omitempty"`
+}
+
+// Writes events as JSON lines, as they happen. A nil eventLog discards them.
+type eventLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// Returns an eventLog writing to 'w'.
+func newEventLog(w io.Writer) *eventLog {
+	return &eventLog{enc: json.NewEncoder(w)}
+}
+
+// Opens the event log at 'path', stdout if it is "-", or an inherited file descriptor if it is
+// fd:N, e.g. fd:3. A nil eventLog is returned for "".
+func openEventLog(path string) (*eventLog, func(), error) {
+	switch path {
+	case "":
+		return nil, func() {}, nil
+	case "-":
+		return newEventLog(os.Stdout), func() {}, nil
+	}
+	if n, ok := strings.CutPrefix(path, "fd:"); ok {
+		fd, err := strconv.Atoi(n)
+		if err != nil || fd < 1 {
+			return nil, nil, fmt.Errorf("%s: expected fd:N, with N a file descriptor, e.g. fd:3", path)
+		}
+		f := os.NewFile(uintptr(fd), path)
+		if _, err := f.Stat(); err != nil {
+			return nil, nil, fmt.Errorf("%s is not open: %w", path, err)
+		}
+		// Left open, as it belongs to whoever started us.
+		return newEventLog(f), func() {}, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newEventLog(f), func() { f.Close() }, nil
+}
+
+// Records that 'event' happened to 'r'.
+func (this *eventLog) emit(event string, r *targetResult) {
+	if this == nil {
+		return
+	}
+	e := buildEvent{Time: time.Now(), Target: r.target, Event: event}
+	if event == "done" {
+		e.Status, e.Artifacts = r.status(), r.artifacts
+		if r.err != nil {
+			e.Error = r.err.Error()
+		}
+	}
+	this.write(e)
+}
+
+// Records that 'path' was written for 'r'.
+func (this *eventLog) emitArtifact(r *targetResult, path string) {
+	if this == nil {
+		return
+	}
+	this.write(buildEvent{Time: time.Now(), Target: r.target, Event: "artifact", Artifact: path})
+}
+
+// Records that 'step' failed for 'r', with its error.
+func (this *eventLog) emitError(r *targetResult, step string) {
+	if this == nil || r.err == nil {
+		return
+	}
+	this.write(buildEvent{Time: time.Now(), Target: r.target, Event: "error", Step: step, Error: r.err.Error()})
+}
+
+func (this *eventLog) write(e buildEvent) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.enc.Encode(e)
+}
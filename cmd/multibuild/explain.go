@@ -0,0 +1,81 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Returns the steps deciding whether 't' is built, in the order they are applied, each naming
+// the filter involved and where it came from: includes, excludes (including implicit ones),
+// --multibuild-restrict, and priority.
+func (this options) explainTarget(t target, restrict []filter) []string {
+	var steps []string
+	// Describes each distinct filter of setting 'name' which matches 't'.
+	matching := func(name string, filters []filter, verb string) bool {
+		seen := make(map[filter]bool)
+		for _, f := range filters {
+			if seen[f] || !f.matches(t) {
+				continue
+			}
+			seen[f] = true
+			steps = append(steps, fmt.Sprintf("%s=%s %s it: %s", name, f, verb, describeOrigins(this.originOf(settingKey(name, string(f))))))
+		}
+		return len(seen) > 0
+	}
+
+	included := matching("include", this.Include, "includes")
+	if !included {
+		steps = append(steps, fmt.Sprintf("no include filter matches it (include=%s)", strings.Join(mapSlice(this.Include, func(f filter) string { return string(f) }), ",")))
+	}
+	excluded := matching("exclude", this.Exclude, "excludes")
+	if included && !excluded && len(restrict) > 0 {
+		if slices.ContainsFunc(restrict, func(f filter) bool { return f.matches(t) }) {
+			steps = append(steps, "--multibuild-restrict matches it")
+		} else {
+			steps = append(steps, fmt.Sprintf("--multibuild-restrict=%s doesn't match it", strings.Join(mapSlice(restrict, func(f filter) string { return string(f) }), ",")))
+		}
+	}
+	matching("priority", this.Priority, "prioritizes")
+	return steps
+}
+
+// Implements --multibuild-explain: describes why 't' is or isn't one of 'targets', and exits.
+// If the target list couldn't be built, 'planErr' says why, and is reported after the
+// explanation, as the configuration is still worth explaining.
+func explainTargetAndExit(args cliArgs, opts options, targets []target, t target, code int, planErr error) {
+	allTargets, err := targetList()
+	if err != nil {
+		fatalCode(exitTargets, "multibuild: failed to list targets: %s", err)
+	}
+	if !knownTarget(allTargets, t) {
+		fatalCode(exitTargets, "multibuild: --multibuild-explain: %s is not a target go supports", t)
+	}
+
+	if slices.Contains(targets, t) {
+		fmt.Fprintf(os.Stderr, "%s: built\n", t)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: not built\n", t)
+	}
+	if args.targetsFrom != "" {
+		// The configuration didn't decide it.
+		if slices.Contains(targets, t) {
+			fmt.Fprintf(os.Stderr, "    --multibuild-targets-from=%s lists it\n", args.targetsFrom)
+		} else {
+			fmt.Fprintf(os.Stderr, "    --multibuild-targets-from=%s doesn't list it\n", args.targetsFrom)
+		}
+	} else {
+		for _, step := range opts.explainTarget(t, args.restrict) {
+			fmt.Fprintf(os.Stderr, "    %s\n", step)
+		}
+	}
+	if planErr != nil {
+		fatalCode(code, "multibuild: %s", planErr)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,56 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestExplainTarget(t *testing.T) {
+	var opts options
+	opts.Include = []filter{"*/*", "linux/*"}
+	opts.setOrigin(settingKey("include", "*/*"), origin{source: sourceDefault})
+	opts.setOrigin(settingKey("include", "linux/*"), origin{source: sourceDirective, location: "main.go:3"})
+	opts.Exclude = []filter{"linux/386", "android/*"}
+	opts.setOrigin(settingKey("exclude", "linux/386"), origin{source: sourceCommandLine, location: "--multibuild-exclude=linux/386"})
+	opts.setOrigin(settingKey("exclude", "android/*"), origin{source: sourceImplicit, location: "requires cgo"})
+	opts.Priority = []filter{"linux/amd64"}
+	opts.setOrigin(settingKey("priority", "linux/amd64"), origin{source: sourceDirective, location: "main.go:4"})
+
+	for _, tc := range []struct {
+		t        target
+		restrict []filter
+		want     []string
+	}{
+		{"linux/386", nil, []string{
+			"include=*/* includes it: default",
+			"include=linux/* includes it: directive at main.go:3",
+			"exclude=linux/386 excludes it: command line: --multibuild-exclude=linux/386",
+		}},
+		{"android/arm64", nil, []string{
+			"include=*/* includes it: default",
+			"exclude=android/* excludes it: implicit (requires cgo)",
+		}},
+		{"linux/amd64", nil, []string{
+			"include=*/* includes it: default",
+			"include=linux/* includes it: directive at main.go:3",
+			"priority=linux/amd64 prioritizes it: directive at main.go:4",
+		}},
+		{"darwin/arm64", []filter{"linux/*"}, []string{
+			"include=*/* includes it: default",
+			"--multibuild-restrict=linux/* doesn't match it",
+		}},
+	} {
+		if got := opts.explainTarget(tc.t, tc.restrict); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.t, got, tc.want)
+		}
+	}
+
+	opts.Include = []filter{"linux/*"}
+	if got, want := opts.explainTarget("darwin/arm64", nil), []string{"no include filter matches it (include=linux/*)"}; !slices.Equal(got, want) {
+		t.Errorf("darwin/arm64: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// The extension of the binaries of the targets matching a filter, instead of the usual one.
+// e.g. //go:multibuild:extension.windows/386=.com, or //go:multibuild:extension.windows/*= for none.
+type targetExtension struct {
+	filter    filter
+	extension string
+}
+
+// Validates an extension.FILTER directive, 'key' being the filter and 'value' the extension,
+// which may be empty.
+func validateTargetExtension(key, value string) (targetExtension, error) {
+	filters, err := validateFilterString(key)
+	if err != nil {
+		return targetExtension{}, err
+	}
+	if len(filters) != 1 {
+		return targetExtension{}, fmt.Errorf("expected a single filter, got %q", key)
+	}
+	if value != "" && (!strings.HasPrefix(value, ".") || value == ".") {
+		return targetExtension{}, fmt.Errorf("%q is not an extension, e.g. .exe", value)
+	}
+	for _, c := range value {
+		if c == '/' {
+			return targetExtension{}, fmt.Errorf("unexpected character %q in %s", c, value)
+		}
+		if why := unsafeOutputChar(c, runtime.GOOS); why != "" {
+			return targetExtension{}, fmt.Errorf("unexpected character %q in %s: %s", c, value, why)
+		}
+	}
+	return targetExtension{filter: filters[0], extension: value}, nil
+}
+
+// Returns the extension of the binary of 't': that set for it, if any (if more than one
+// setting matches, the first wins), or otherwise .exe for windows, .wasm for wasm, and nothing
+// for the rest.
+func (this options) extensionFor(t target) string {
+	for _, te := range this.Extensions {
+		if te.filter.matches(t) {
+			return te.extension
+		}
+	}
+	switch goos, goarch := t.osArch(); {
+	case goos == "windows":
+		return ".exe"
+	case goarch == "wasm":
+		return ".wasm"
+	}
+	return ""
+}
@@ -0,0 +1,70 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestValidateTargetExtension(t *testing.T) {
+	tests := []struct {
+		key, value string
+		wantErr    bool
+	}{
+		{"js/wasm", ".wasm", false},
+		{"windows/*", "", false},
+		{"linux/*", ".bin", false},
+		{"windows/*", "exe", true},
+		{"windows/*", ".", true},
+		{"windows/*", ".a/b", true},
+		{"windows/*", ".ex*", true},
+		{"windows/*,linux/*", ".exe", true},
+	}
+	for _, tt := range tests {
+		_, err := validateTargetExtension(tt.key, tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateTargetExtension(%q, %q): err = %v, wantErr %v", tt.key, tt.value, err, tt.wantErr)
+		}
+	}
+}
+
+func TestExtensionFor(t *testing.T) {
+	opts := options{
+		Output: "bin/${TARGET}-${GOOS}-${GOARCH}",
+		Extensions: []targetExtension{
+			{filter: "windows/arm64", extension: ""},
+			{filter: "*/wasm", extension: ".wasm"},
+			{filter: "windows/*", extension: ".com"},
+		},
+	}
+	tests := []struct {
+		target target
+		want   string
+	}{
+		{"windows/arm64", "bin/app-windows-arm64"},
+		{"windows/amd64", "bin/app-windows-amd64.com"},
+		{"js/wasm", "bin/app-js-wasm.wasm"},
+		{"wasip1/wasm", "bin/app-wasip1-wasm.wasm"},
+		{"linux/amd64", "bin/app-linux-amd64"},
+	}
+	for _, tt := range tests {
+		if _, got := opts.outputPaths("app", tt.target); got != tt.want {
+			t.Errorf("outputPaths(%s) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+
+	// Without any settings, windows binaries are .exe.
+	if _, got := (options{Output: "${TARGET}-${GOOS}-${GOARCH}"}).outputPaths("app", "windows/386"); got != "app-windows-386.exe" {
+		t.Errorf("outputPaths(windows/386) = %q", got)
+	}
+
+	// .gitignore follows the extensions too.
+	opts.Format = []format{formatRaw}
+	got := ignorePatterns(opts, "app", []target{"windows/amd64", "js/wasm"}, false, "")
+	if want := []string{"bin/app-*-*.com", "bin/app-*-*.wasm"}; !slices.Equal(got, want) {
+		t.Errorf("ignorePatterns() = %q, want %q", got, want)
+	}
+}
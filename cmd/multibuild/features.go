@@ -0,0 +1,74 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// The platforms supporting each build mode (beyond the default, exe and archive, which
+// every platform supports), as in the go command's internal/platform.
+var buildModePlatforms = map[string][]string{
+	"c-archive": {"aix/*", "darwin/*", "ios/*", "windows/*", "freebsd/amd64",
+		"linux/386", "linux/amd64", "linux/arm", "linux/armbe", "linux/arm64", "linux/arm64be",
+		"linux/loong64", "linux/ppc64le", "linux/riscv64", "linux/s390x"},
+	"c-shared": {"linux/amd64", "linux/arm", "linux/arm64", "linux/loong64", "linux/386",
+		"linux/ppc64le", "linux/riscv64", "linux/s390x", "android/amd64", "android/arm",
+		"android/arm64", "android/386", "freebsd/amd64", "darwin/amd64", "darwin/arm64",
+		"windows/amd64", "windows/386", "windows/arm64", "wasip1/wasm"},
+	"pie": {"linux/386", "linux/amd64", "linux/arm", "linux/arm64", "linux/loong64",
+		"linux/ppc64le", "linux/riscv64", "linux/s390x", "android/amd64", "android/arm",
+		"android/arm64", "android/386", "freebsd/amd64", "darwin/amd64", "darwin/arm64",
+		"ios/amd64", "ios/arm64", "aix/ppc64", "openbsd/arm64", "windows/386", "windows/amd64",
+		"windows/arm", "windows/arm64"},
+	"shared": {"linux/386", "linux/amd64", "linux/arm", "linux/arm64", "linux/ppc64le", "linux/s390x"},
+	"plugin": {"linux/amd64", "linux/arm", "linux/arm64", "linux/386", "linux/loong64",
+		"linux/s390x", "linux/ppc64le", "android/amd64", "android/386", "darwin/amd64",
+		"darwin/arm64", "freebsd/amd64"},
+}
+
+// The build modes which link with the system's C toolchain, so need cgo.
+var cgoBuildModes = []string{"c-archive", "c-shared", "plugin", "shared"}
+
+// The operating systems the plugin package can load plugins on, given cgo.
+var pluginOS = []string{"linux", "darwin", "freebsd"}
+
+// Returns the build mode set by -buildmode in 'goBuildArgs', or "" if it isn't set.
+func buildMode(goBuildArgs []string) string {
+	mode := ""
+	for i, arg := range goBuildArgs {
+		arg = "-" + strings.TrimLeft(arg, "-") // flags may start with - or --
+		if v, ok := strings.CutPrefix(arg, "-buildmode="); ok {
+			mode = v
+		} else if arg == "-buildmode" && i+1 < len(goBuildArgs) {
+			mode = goBuildArgs[i+1]
+		}
+	}
+	return mode
+}
+
+// Returns whether 't' supports build mode 'mode'. Unknown modes are left to go build.
+func buildModeSupported(mode string, t target) bool {
+	platforms, ok := buildModePlatforms[mode]
+	if !ok {
+		return true
+	}
+	return slices.ContainsFunc(platforms, func(p string) bool { return filter(p).matches(t) })
+}
+
+// Returns why 't' can't be built in build mode 'mode', or "" if it can.
+func unsupportedBuildMode(mode string, t target) string {
+	if buildModeSupported(mode, t) {
+		return ""
+	}
+	return "-buildmode=" + mode + " isn't supported on " + string(t)
+}
+
+// Returns whether plugins can be loaded on the target of 'ctx'. The plugin package builds
+// everywhere, but elsewhere plugin.Open always fails.
+func pluginsSupported(ctx supportContext) bool {
+	return ctx.cgo && slices.Contains(pluginOS, ctx.goos)
+}
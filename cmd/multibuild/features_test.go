@@ -0,0 +1,88 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildMode(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{nil, ""},
+		{[]string{"-trimpath", "-buildmode=pie"}, "pie"},
+		{[]string{"--buildmode", "plugin"}, "plugin"},
+		{[]string{"-buildmode=pie", "-buildmode=exe"}, "exe"},
+	} {
+		if got := buildMode(tc.args); got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestUnsupportedBuildMode(t *testing.T) {
+	for _, tc := range []struct {
+		mode string
+		t    target
+		want string
+	}{
+		{"", "plan9/386", ""},
+		{"exe", "plan9/386", ""},
+		{"pie", "linux/amd64", ""},
+		{"pie", "openbsd/amd64", "-buildmode=pie isn't supported on openbsd/amd64"},
+		{"c-archive", "darwin/arm64", ""},
+		{"c-archive", "freebsd/386", "-buildmode=c-archive isn't supported on freebsd/386"},
+		{"plugin", "windows/amd64", "-buildmode=plugin isn't supported on windows/amd64"},
+		{"something-new", "plan9/386", ""},
+	} {
+		if got := unsupportedBuildMode(tc.mode, tc.t); got != tc.want {
+			t.Errorf("%s on %s: got %q, want %q", tc.mode, tc.t, got, tc.want)
+		}
+	}
+}
+
+func TestPluginsSupported(t *testing.T) {
+	for _, tc := range []struct {
+		ctx  supportContext
+		want bool
+	}{
+		{supportContext{goos: "linux", goarch: "amd64", cgo: true}, true},
+		{supportContext{goos: "linux", goarch: "amd64"}, false},
+		{supportContext{goos: "windows", goarch: "amd64", cgo: true}, false},
+	} {
+		if got := pluginsSupported(tc.ctx); got != tc.want {
+			t.Errorf("%+v: got %v, want %v", tc.ctx, got, tc.want)
+		}
+	}
+}
+
+// Standard library packages which are only for some platforms are followed too.
+func TestSupportGraphStandard(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"go.mod":  "module example.com/app\n\ngo 1.24\n",
+		"main.go": "package main\n\nimport (\n\t\"plugin\"\n\t\"syscall/js\"\n)\n\nvar _ = js.Global\nvar _ = plugin.Open\n\nfunc main() {}\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(dir)
+	graph, err := loadSupportGraph([]string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := graph.unsupported(supportContext{goos: "linux", goarch: "amd64"}), "syscall/js (imported by example.com/app) has no Go files for linux/amd64"; got != want {
+		t.Errorf("linux/amd64: got %q, want %q", got, want)
+	}
+	needed, reason := graph.walk(supportContext{goos: "js", goarch: "wasm"})
+	if reason != "" || !needed["plugin"] {
+		t.Errorf("js/wasm: got %q, plugin needed: %v", reason, needed["plugin"])
+	}
+}
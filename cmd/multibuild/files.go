@@ -0,0 +1,107 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// The source files of a package which are part of the build for a target, and those which
+// build constraints leave out.
+type targetFiles struct {
+	importPath string
+	dir        string
+	included   []string
+	ignored    []string
+}
+
+// Lists the files built for 't' in the packages matching 'patterns', and the packages of the
+// main module they depend on, as go build would pick them with 'env' and 'goBuildArgs'.
+func listTargetFiles(env []string, patterns []string, goBuildArgs []string, t target) ([]targetFiles, error) {
+	listArgs := []string{"list", "-e", "-deps", "-json=ImportPath,Dir,Standard,DepOnly,Module,GoFiles,CgoFiles,CFiles,CXXFiles,HFiles,SFiles,SysoFiles,EmbedFiles,IgnoredGoFiles,IgnoredOtherFiles"}
+	if tags := buildTags(goBuildArgs); len(tags) > 0 {
+		listArgs = append(listArgs, "-tags="+strings.Join(tags, ","))
+	}
+	cmd := exec.Command("go", append(listArgs, patterns...)...)
+	cmd.Env = targetEnv(env, t)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var files []targetFiles
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for dec.More() {
+		var p struct {
+			listedPackage
+			DepOnly           bool
+			IgnoredGoFiles    []string
+			IgnoredOtherFiles []string
+		}
+		if err := dec.Decode(&p); err != nil {
+			return nil, fmt.Errorf("go list: %w", err)
+		}
+		if p.Standard || (p.DepOnly && (p.Module == nil || !p.Module.Main)) {
+			continue
+		}
+		included := slices.Concat(p.GoFiles, p.CgoFiles, p.CFiles, p.CXXFiles, p.HFiles, p.SFiles, p.SysoFiles, p.EmbedFiles)
+		ignored := slices.Concat(p.IgnoredGoFiles, p.IgnoredOtherFiles)
+		slices.Sort(included)
+		slices.Sort(ignored)
+		files = append(files, targetFiles{importPath: p.ImportPath, dir: p.Dir, included: included, ignored: ignored})
+	}
+	slices.SortFunc(files, func(a, b targetFiles) int { return strings.Compare(a.importPath, b.importPath) })
+	return files, nil
+}
+
+// Implements --multibuild-files: lists the files built for 't', and with 'verbose', those left
+// out, and exits.
+func displayFilesAndExit(args cliArgs, opts options, targets []target, t target) {
+	allTargets, err := targetList()
+	if err != nil {
+		fatalCode(exitTargets, "multibuild: failed to list targets: %s", err)
+	}
+	if !knownTarget(allTargets, t) {
+		fatalCode(exitTargets, "multibuild: --multibuild-files: %s is not a target go supports", t)
+	}
+	if !slices.Contains(targets, t) {
+		fmt.Fprintf(os.Stderr, "multibuild: note: %s is not one of the targets being built\n", t)
+	}
+
+	env, cleanupEnv, err := buildEnviron(args.sandbox, opts)
+	if err != nil {
+		fatal("multibuild: failed to set up the build environment: %s", err)
+	}
+	patterns := args.sources
+	if len(patterns) == 0 {
+		patterns = []string{args.packagePath}
+	}
+	files, err := listTargetFiles(append(slices.Clone(env), opts.environFor(t)...), patterns, args.goBuildArgs, t)
+	cleanupEnv()
+	if err != nil {
+		fatal("multibuild: %s", err)
+	}
+
+	for _, pkg := range files {
+		fmt.Fprintf(os.Stderr, "%s (%s)\n", pkg.importPath, pkg.dir)
+		for _, name := range pkg.included {
+			fmt.Fprintf(os.Stderr, "    %s\n", name)
+		}
+		if args.verbose {
+			for _, name := range pkg.ignored {
+				fmt.Fprintf(os.Stderr, "    %s (excluded by build constraints)\n", name)
+			}
+		}
+	}
+	os.Exit(0)
+}
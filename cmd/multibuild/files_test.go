@@ -0,0 +1,59 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestListTargetFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":                     "module example.com/app\n\ngo 1.24\n",
+		"main.go":                    "package main\n\nimport (\n\t\"fmt\"\n\n\t\"example.com/app/term\"\n)\n\nfunc main() { fmt.Println(term.Name) }\n",
+		"main_windows.go":            "package main\n",
+		"debug.go":                   "//go:build debug\n\npackage main\n",
+		"term/term_unix.go":          "//go:build unix\n\npackage term\n\nconst Name = \"unix\"\n",
+		"term/term_windows.go":       "package term\n\nconst Name = \"windows\"\n",
+		"unused/unused.go":           "package unused\n",
+		"term/testdata/ignored.json": "{}\n",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Chdir(dir)
+	got, err := listTargetFiles(os.Environ(), []string{"."}, nil, "windows/amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %+v, want example.com/app and example.com/app/term", got)
+	}
+	if p := got[0]; p.importPath != "example.com/app" || !slices.Equal(p.included, []string{"main.go", "main_windows.go"}) || !slices.Equal(p.ignored, []string{"debug.go"}) {
+		t.Errorf("got %+v", p)
+	}
+	if p := got[1]; p.importPath != "example.com/app/term" || !slices.Equal(p.included, []string{"term_windows.go"}) || !slices.Equal(p.ignored, []string{"term_unix.go"}) {
+		t.Errorf("got %+v", p)
+	}
+
+	// -tags given to go build are used too.
+	got, err = listTargetFiles(os.Environ(), []string{"."}, []string{"-tags=debug"}, "linux/amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := got[0]; !slices.Equal(p.included, []string{"debug.go", "main.go"}) || !slices.Equal(p.ignored, []string{"main_windows.go"}) {
+		t.Errorf("with -tags=debug: got %+v", p)
+	}
+}
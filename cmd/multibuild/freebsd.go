@@ -0,0 +1,131 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FreeBSD's names for GOARCH values, as used in package ABIs.
+var freebsdArches = map[string]string{
+	"amd64":   "amd64",
+	"arm64":   "aarch64",
+	"386":     "i386",
+	"arm":     "armv7",
+	"riscv64": "riscv64",
+}
+
+// The parts of a FreeBSD package manifest we set.
+// See pkg-create(8) for the format, of which JSON is a subset.
+type freebsdManifest struct {
+	Name         string            `json:"name"`
+	Origin       string            `json:"origin"`
+	Version      string            `json:"version"`
+	Comment      string            `json:"comment"`
+	Desc         string            `json:"desc"`
+	Maintainer   string            `json:"maintainer"`
+	WWW          string            `json:"www,omitempty"`
+	ABI          string            `json:"abi"`
+	Prefix       string            `json:"prefix"`
+	FlatSize     int64             `json:"flatsize"`
+	LicenseLogic string            `json:"licenselogic,omitempty"`
+	Licenses     []string          `json:"licenses,omitempty"`
+	Files        map[string]string `json:"files,omitempty"`
+}
+
+// Returns 'version', in a form pkg accepts: pkg uses - to separate the version from the name.
+func freebsdVersion(version string) string {
+	return strings.ReplaceAll(version, "-", ".")
+}
+
+// Writes a FreeBSD package at 'arPath' which installs 'outBin', for use with pkg add.
+// 'info' should have had defaults filled in, see withDefaults.
+func writeFreeBSDPkg(t target, arPath, outBin string, info packageInfo) error {
+	_, goarch := t.osArch()
+	arch, ok := freebsdArches[goarch]
+	if !ok {
+		arch = goarch
+	}
+	st, err := os.Stat(outBin)
+	if err != nil {
+		return fmt.Errorf("failed to stat raw %s: %s", outBin, err)
+	}
+	_, sum, err := artifactInfo(outBin)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %s", outBin, err)
+	}
+	installed := path.Join(info.installDir(), filepath.Base(outBin))
+
+	m := freebsdManifest{
+		Name:       info.Name,
+		Origin:     "local/" + info.Name,
+		Version:    freebsdVersion(info.Version),
+		Comment:    info.Description,
+		Desc:       info.Description,
+		Maintainer: info.Maintainer,
+		WWW:        info.Homepage,
+		ABI:        "FreeBSD:*:" + arch,
+		Prefix:     "/usr/local",
+		FlatSize:   st.Size(),
+	}
+	if m.Comment == "" {
+		m.Comment, m.Desc = info.Name, info.Name
+	}
+	if m.Maintainer == "" {
+		m.Maintainer = "unknown"
+	}
+	if info.License != "" {
+		m.LicenseLogic, m.Licenses = "single", []string{info.License}
+	}
+	compact, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	m.Files = map[string]string{installed: "1$" + sum}
+	full, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(arPath)
+	if err != nil {
+		return fmt.Errorf("failed to create package %s: %s", arPath, err)
+	}
+	defer f.Close()
+	gz, err := newGzipWriter(f, compressionDefault.deflate())
+	if err != nil {
+		return fmt.Errorf("failed to create package %s: %s", arPath, err)
+	}
+	tw := tar.NewWriter(gz)
+	for _, e := range []archiveFile{{name: "+COMPACT_MANIFEST", mode: 0644, data: compact}, {name: "+MANIFEST", mode: 0644, data: full}} {
+		hdr := &tar.Header{Name: e.name, Mode: e.mode, Size: int64(len(e.data)), Uname: "root", Gname: "wheel"}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("failed to create header %s: %s", arPath, err)
+		}
+		if _, err := tw.Write(e.data); err != nil {
+			return fmt.Errorf("failed to write %s: %s", e.name, err)
+		}
+	}
+	hdr := &tar.Header{Name: installed, Mode: 0755, Size: st.Size(), Uname: "root", Gname: "wheel"}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to create header %s: %s", arPath, err)
+	}
+	if err := copyRaw(tw, outBin); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish package %s: %s", arPath, err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to finish package %s: %s", arPath, err)
+	}
+	return f.Close()
+}
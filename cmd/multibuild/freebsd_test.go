@@ -0,0 +1,72 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestWriteFreeBSDPkg(t *testing.T) {
+	dir := t.TempDir()
+	outBin := filepath.Join(dir, "app-freebsd-arm64")
+	if err := os.WriteFile(outBin, []byte("binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	info := packageInfo{Name: "app", Version: "1.2.3-4-gabcdef", License: "BSD-3-Clause", Description: "An app"}
+	arPath := outBin + ".pkg"
+	if err := writeFreeBSDPkg("freebsd/arm64", arPath, outBin, info); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(arPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var names []string
+	var manifest freebsdManifest
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+		if hdr.Name == "+MANIFEST" {
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// pkg expects the manifests first.
+	want := []string{"+COMPACT_MANIFEST", "+MANIFEST", "/usr/local/bin/app-freebsd-arm64"}
+	if !slices.Equal(names, want) {
+		t.Errorf("got entries %v, want %v", names, want)
+	}
+	if manifest.Name != "app" || manifest.Version != "1.2.3.4.gabcdef" || manifest.ABI != "FreeBSD:*:aarch64" {
+		t.Errorf("unexpected manifest: %+v", manifest)
+	}
+	if !slices.Equal(manifest.Licenses, []string{"BSD-3-Clause"}) || manifest.Comment != "An app" {
+		t.Errorf("unexpected manifest: %+v", manifest)
+	}
+	sum := "1$9a3a45d01531a20e89ac6ae10b0b0beb0492acd7216a368aa062d1a5fecaf9cd"
+	if manifest.Files["/usr/local/bin/app-freebsd-arm64"] != sum || manifest.FlatSize != 6 {
+		t.Errorf("unexpected files: %v, flatsize %d", manifest.Files, manifest.FlatSize)
+	}
+}
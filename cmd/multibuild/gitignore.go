@@ -0,0 +1,209 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Heads the entries multibuild adds to a .gitignore.
+const gitignoreHeader = "# Outputs of multibuild"
+
+// Returns patterns matching every file a run of 'targets' writes, with ${GOOS}, ${GOARCH} and
+// the placeholders of the run (e.g. ${VERSION}) as wildcards, so that the entries don't change as targets come and go. 'name' is the value
+// of ${TARGET}. The most general patterns come first.
+func ignorePatterns(opts options, name string, targets []target, attest bool, manifest string) []string {
+	// Versions, commits, dates and values from the environment come and go too.
+	if opts.unfilledOutput != "" {
+		wildcards := make(map[string]string)
+		for _, name := range opts.unfilledOutput.placeholders() {
+			if runPlaceholder(name) {
+				wildcards[name] = "*"
+			}
+		}
+		opts.Output = opts.unfilledOutput.fill(wildcards)
+	}
+	var patterns []string
+	for _, t := range targets {
+		// Functions in a text/template can't be given wildcards, e.g. title would make nothing of *.
+		wildcard := target("*/*")
+		if opts.Output.isGoTemplate() {
+			wildcard = t
+		}
+		out, _ := opts.outputPaths(name, wildcard)
+		outBin := out + opts.extensionFor(t)
+		artifacts := artifactPaths(opts.Format, t, out, outBin)[1:] // the binary isn't among the outputs without raw, see doMultibuild
+		if slices.Contains(opts.Format, formatRaw) {
+			artifacts = append(artifacts, outBin)
+		}
+		patterns = append(patterns, artifacts...)
+		if opts.ChecksumSidecars == "true" {
+			for _, a := range artifacts {
+				patterns = append(patterns, a+opts.ChecksumAlgorithm.extension())
+			}
+		}
+		if attest {
+			patterns = append(patterns, out+".intoto.json")
+		}
+	}
+	for _, f := range opts.Format {
+		if f.bundles() {
+			patterns = append(patterns, opts.bundlePath(name, f))
+			if opts.ChecksumSidecars == "true" {
+				patterns = append(patterns, opts.bundlePath(name, f)+opts.ChecksumAlgorithm.extension())
+			}
+		}
+	}
+	if opts.Checksums != "" {
+		patterns = append(patterns, opts.Checksums)
+	}
+	if manifest != "" {
+		patterns = append(patterns, manifest)
+	}
+	slices.SortFunc(patterns, func(a, b string) int {
+		if len(a) != len(b) {
+			return len(a) - len(b)
+		}
+		return strings.Compare(a, b)
+	})
+	return slices.Compact(patterns)
+}
+
+// A line of a .gitignore. Only the parts of the syntax multibuild's own entries could
+// conflict with are understood: a pattern which isn't (e.g. ** spanning directories)
+// never matches, so at worst, a redundant entry is added.
+type ignoreRule struct {
+	pattern  string
+	negate   bool // !pattern: the user wants the file tracked
+	anchored bool // only matches relative to the .gitignore
+	dirOnly  bool // pattern/: only matches directories
+}
+
+// Parses a line of a .gitignore, returning false for blank lines and comments.
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+	var rule ignoreRule
+	if rule.negate = strings.HasPrefix(line, "!"); rule.negate {
+		line = line[1:]
+	}
+	if rule.dirOnly = strings.HasSuffix(line, "/"); rule.dirOnly {
+		line = strings.TrimRight(line, "/")
+	}
+	rule.anchored = strings.Contains(line, "/")
+	rule.pattern = strings.TrimPrefix(line, "/")
+	return rule, rule.pattern != ""
+}
+
+// Returns whether the rule matches 'p', a slash separated path relative to the .gitignore,
+// or one of the directories it is in. Wildcards in 'p' only match wildcards in the pattern,
+// so this also tells whether the rule covers everything a pattern of ours would.
+func (this ignoreRule) matches(p string) bool {
+	parts := strings.Split(p, "/")
+	for idx := range parts {
+		if this.dirOnly && idx == len(parts)-1 {
+			break
+		}
+		subject := parts[idx]
+		if this.anchored {
+			subject = strings.Join(parts[:idx+1], "/")
+		}
+		if ok, _ := path.Match(this.pattern, subject); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Adds the entries of 'patterns' which aren't ignored already to the .gitignore at 'path',
+// creating it if needed. Patterns the user has explicitly unignored are left alone, and
+// nothing already there is changed. Returns the entries added.
+func updateGitignore(path string, patterns []string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var rules []ignoreRule
+	for _, line := range strings.Split(string(data), "\n") {
+		if rule, ok := parseIgnoreRule(line); ok {
+			rules = append(rules, rule)
+		}
+	}
+
+	var added []string
+	for _, p := range patterns {
+		// As with git, the last rule matching wins.
+		var last *ignoreRule
+		for idx := range rules {
+			if rules[idx].matches(p) {
+				last = &rules[idx]
+			}
+		}
+		if last != nil {
+			if last.negate {
+				fmt.Fprintf(os.Stderr, "multibuild: not ignoring %s, as %s says it should be tracked\n", p, path)
+			}
+			continue
+		}
+		entry := "/" + p
+		rule, _ := parseIgnoreRule(entry)
+		rules = append(rules, rule)
+		added = append(added, entry)
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+
+	var b strings.Builder
+	b.Write(data)
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		b.WriteString("\n")
+	}
+	if !slices.Contains(strings.Split(string(data), "\n"), gitignoreHeader) {
+		if len(data) > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(gitignoreHeader + "\n")
+	}
+	for _, entry := range added {
+		b.WriteString(entry + "\n")
+	}
+	return added, os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// Makes sure the .gitignore in the current directory ignores everything the run writes.
+func ignoreOutputs(opts options, args cliArgs, targets []target, manifest string) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	var patterns []string
+	for _, p := range ignorePatterns(opts, args.output, targets, args.attest, manifest) {
+		rel := p
+		if filepath.IsAbs(p) {
+			if rel, err = filepath.Rel(wd, p); err != nil {
+				rel = p
+			}
+		}
+		rel = filepath.ToSlash(filepath.Clean(rel))
+		if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+			fmt.Fprintf(os.Stderr, "multibuild: not ignoring %s, as it is outside the current directory\n", p)
+			continue
+		}
+		patterns = append(patterns, rel)
+	}
+	added, err := updateGitignore(".gitignore", patterns)
+	for _, entry := range added {
+		fmt.Fprintf(os.Stderr, "multibuild: added %s to .gitignore\n", entry)
+	}
+	return err
+}
@@ -0,0 +1,139 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestIgnorePatterns(t *testing.T) {
+	targets := []target{"linux/amd64", "windows/amd64", "darwin/arm64"}
+	tcs := []struct {
+		name     string
+		formats  []format
+		attest   bool
+		manifest string
+		want     []string
+	}{
+		{"raw", []format{formatRaw}, false, "", []string{"bin/app-*-*", "bin/app-*-*.exe"}},
+		{"archives", []format{formatRaw, formatZip, formatDmg}, false, "", []string{"bin/app-*-*", "bin/app-*-*.dmg", "bin/app-*-*.exe", "bin/app-*-*.zip"}},
+		{"no raw", []format{formatTgz}, false, "", []string{"bin/app-*-*.tar.gz"}},
+		{"bundle", []format{formatBundleZip}, false, "", []string{"bin/app-all-all.zip"}},
+		{"attest and manifest", []format{formatRaw}, true, "app.manifest.json", []string{"bin/app-*-*", "bin/app-*-*.exe", "app.manifest.json", "bin/app-*-*.intoto.json"}},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := options{Output: "bin/${TARGET}-${GOOS}-${GOARCH}", Format: tc.formats}
+			got := ignorePatterns(opts, "app", targets, tc.attest, tc.manifest)
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	// The version, date and environment change from one run to the next, so they're wildcards too.
+	t.Setenv("MULTIBUILD_TEST_BUILD", "42")
+	opts := options{Output: "bin/${DATE}/${TARGET}-${VERSION}-${GOOS}-${GOARCH}-${ENV:MULTIBUILD_TEST_BUILD}", Format: []format{formatRaw}}
+	if err := opts.placeRunValues("v1.4.2", ".", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	got := ignorePatterns(opts, "app", []target{"linux/amd64"}, false, "")
+	if want := []string{"bin/*/app-*-*-*-*"}; !slices.Equal(got, want) {
+		t.Errorf("with a version: got %q, want %q", got, want)
+	}
+
+	// Functions in a text/template can't make anything of a wildcard, so each target has its own.
+	opts = options{Output: "bin/{{.TARGET}}-{{title .GOOS}}-{{.GOARCH}}-{{.VERSION}}", Format: []format{formatRaw}}
+	if err := opts.placeRunValues("v1.4.2", ".", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	got = ignorePatterns(opts, "app", []target{"linux/amd64", "windows/amd64"}, false, "")
+	if want := []string{"bin/app-Linux-amd64-*", "bin/app-Windows-amd64-*.exe"}; !slices.Equal(got, want) {
+		t.Errorf("with a text/template: got %q, want %q", got, want)
+	}
+}
+
+func TestIgnoreRuleMatches(t *testing.T) {
+	tcs := []struct {
+		line string
+		path string
+		want bool
+	}{
+		{"bin/", "bin/app-*-*", true},
+		{"/bin", "bin/app-*-*", true},
+		{"bin", "cmd/bin/app-*-*", true},
+		{"/bin", "cmd/bin/app-*-*", false},
+		{"app-*", "bin/app-*-*", true},
+		{"*.zip", "bin/app-*-*.zip", true},
+		{"*.zip", "bin/app-*-*", false},
+		{"/bin/app-*-*", "bin/app-*-*.exe", true},
+		{"/bin/app-linux-*", "bin/app-*-*", false},
+		{"app-*-*/", "bin/app-*-*", false},
+		{"**/app-*", "bin/app-*-*", true},
+	}
+	for _, tc := range tcs {
+		rule, ok := parseIgnoreRule(tc.line)
+		if !ok {
+			t.Fatalf("%q: not parsed", tc.line)
+		}
+		if got := rule.matches(tc.path); got != tc.want {
+			t.Errorf("%q matches %q: got %v, want %v", tc.line, tc.path, got, tc.want)
+		}
+	}
+	for _, line := range []string{"", "   ", "# bin/"} {
+		if _, ok := parseIgnoreRule(line); ok {
+			t.Errorf("%q: parsed, but is not a rule", line)
+		}
+	}
+}
+
+func TestUpdateGitignore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".gitignore")
+	patterns := []string{"bin/app-*-*", "bin/app-*-*.exe", "bin/app-*-*.zip", "app.manifest.json"}
+	read := func() string {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	// A new .gitignore only gets the most general patterns.
+	added, err := updateGitignore(path, patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/bin/app-*-*", "/app.manifest.json"}; !slices.Equal(added, want) {
+		t.Errorf("added %q, want %q", added, want)
+	}
+	want := "# Outputs of multibuild\n/bin/app-*-*\n/app.manifest.json\n"
+	if got := read(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Running again changes nothing.
+	if added, err := updateGitignore(path, patterns); err != nil || len(added) != 0 {
+		t.Errorf("second run added %q, %v", added, err)
+	}
+	if got := read(); got != want {
+		t.Errorf("second run changed it to %q", got)
+	}
+
+	// Existing entries are kept as they are, and respected.
+	existing := "*.log\nbin/\n!app.manifest.json"
+	if err := os.WriteFile(path, []byte(existing), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if added, err := updateGitignore(path, append(patterns, "dist/app.sbom")); err != nil || !slices.Equal(added, []string{"/dist/app.sbom"}) {
+		t.Errorf("added %q, %v", added, err)
+	}
+	if got, want := read(), existing+"\n\n# Outputs of multibuild\n/dist/app.sbom\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
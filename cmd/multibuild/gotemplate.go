@@ -0,0 +1,234 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Output templates may also be written with text/template, for names the ${...} placeholders
+// can't express, e.g.
+//
+//	//go:multibuild:output=dist/MyApp_{{title .GOOS}}_{{.GOARCH | replace "amd64" "x64"}}
+//
+// The placeholders are fields, e.g. {{.GOOS}}, and ${ENV:NAME} is {{.ENV.NAME}}. Templates are
+// told apart by {{, and in them, $ is not special outside of actions.
+
+// The functions available to text/template output templates. Those taking more than one
+// argument take the string to work on last, so they can be used in pipelines.
+var templateFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"title": func(s string) string {
+		r, size := utf8.DecodeRuneInString(s)
+		return string(unicode.ToUpper(r)) + s[size:]
+	},
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"trimprefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimsuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+}
+
+// Returns whether the template is written with text/template.
+func (this outputTemplate) isGoTemplate() bool {
+	return strings.Contains(string(this), "{{")
+}
+
+// Parses a text/template output template.
+func parseGoTemplate(s string) (*template.Template, error) {
+	tmpl, err := template.New("output").Funcs(templateFuncs).Option("missingkey=zero").Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(tmpl.Templates()) > 1 {
+		return nil, fmt.Errorf("{{define}} and {{block}} aren't supported")
+	}
+	if tmpl.Tree == nil {
+		return nil, fmt.Errorf("the template is empty")
+	}
+	return tmpl, nil
+}
+
+// Calls 'visit' with each field of a text/template, as the name of the placeholder it is,
+// e.g. GOOS for {{.GOOS}}, or ENV:HOME for {{.ENV.HOME}}. If 'visit' returns a value, the field
+// is replaced by that string.
+func walkGoTemplateFields(node parse.Node, visit func(name string) (string, bool)) {
+	// Returns the replacement of 'arg', if it is a field which is replaced.
+	replace := func(arg parse.Node) parse.Node {
+		field, ok := arg.(*parse.FieldNode)
+		if !ok {
+			walkGoTemplateFields(arg, visit)
+			return arg
+		}
+		name := strings.Join(field.Ident, ".")
+		if len(field.Ident) == 2 && field.Ident[0] == "ENV" {
+			name = envPrefix + field.Ident[1]
+		}
+		v, ok := visit(name)
+		if !ok {
+			return arg
+		}
+		return &parse.StringNode{NodeType: parse.NodeString, Pos: field.Pos, Quoted: strconv.Quote(v), Text: v}
+	}
+
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walkGoTemplateFields(child, visit)
+		}
+	case *parse.ActionNode:
+		walkGoTemplateFields(n.Pipe, visit)
+	case *parse.IfNode:
+		walkGoTemplateFields(&n.BranchNode, visit)
+	case *parse.RangeNode:
+		walkGoTemplateFields(&n.BranchNode, visit)
+	case *parse.WithNode:
+		walkGoTemplateFields(&n.BranchNode, visit)
+	case *parse.BranchNode:
+		walkGoTemplateFields(n.Pipe, visit)
+		walkGoTemplateFields(n.List, visit)
+		walkGoTemplateFields(n.ElseList, visit)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			for i, arg := range cmd.Args {
+				cmd.Args[i] = replace(arg)
+			}
+		}
+	case *parse.ChainNode:
+		n.Node = replace(n.Node)
+	}
+}
+
+// Returns the names of the placeholders a text/template output template uses, in order.
+func goTemplatePlaceholders(s string) []string {
+	tmpl, err := parseGoTemplate(s)
+	if err != nil {
+		return nil // validateTemplate reports it
+	}
+	var names []string
+	walkGoTemplateFields(tmpl.Tree.Root, func(name string) (string, bool) {
+		names = append(names, name)
+		return "", false
+	})
+	return names
+}
+
+// Returns a text/template output template with the fields in 'values' replaced by their
+// values, and the others kept.
+func fillGoTemplate(s string, values map[string]string) string {
+	tmpl, err := parseGoTemplate(s)
+	if err != nil {
+		return s // validateTemplate reports it
+	}
+	walkGoTemplateFields(tmpl.Tree.Root, func(name string) (string, bool) {
+		v, ok := values[name]
+		return v, ok
+	})
+	return tmpl.Tree.Root.String()
+}
+
+// Executes a text/template output template with 'values', writing each literal $ as 'dollar'.
+func expandGoTemplate(s string, values map[string]string, dollar string) (string, error) {
+	tmpl, err := parseGoTemplate(fillGoTemplate(s, values))
+	if err != nil {
+		return "", err
+	}
+	var escape func(parse.Node)
+	escape = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				escape(child)
+			}
+		case *parse.TextNode:
+			n.Text = []byte(strings.ReplaceAll(string(n.Text), "$", dollar))
+		case *parse.IfNode:
+			escape(n.List)
+			escape(n.ElseList)
+		case *parse.RangeNode:
+			escape(n.List)
+			escape(n.ElseList)
+		case *parse.WithNode:
+			escape(n.List)
+			escape(n.ElseList)
+		}
+	}
+	escape(tmpl.Tree.Root)
+	var b strings.Builder
+	if err := tmpl.Execute(&b, map[string]string{}); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Validates a text/template output template, written on 'goos', which must have the 'required'
+// placeholders.
+func validateGoTemplate(s string, goos string, required map[string]struct{}) (outputTemplate, error) {
+	if _, err := parseGoTemplate(s); err != nil {
+		return "", err
+	}
+
+	found := make(map[string]struct{})
+	for _, name := range goTemplatePlaceholders(s) {
+		variable, env := strings.CutPrefix(name, envPrefix)
+		if env {
+			name = variable
+		}
+		if name == "" || strings.ContainsFunc(name, func(c rune) bool {
+			return c > unicode.MaxASCII || !(isPlaceholderChar(byte(c)) || (env && c >= 'a' && c <= 'z'))
+		}) {
+			return "", fmt.Errorf("unexpected field .%s, expected a placeholder, e.g. .GOOS", name)
+		}
+		if env {
+			name = envPrefix + variable
+		}
+		found[name] = struct{}{}
+	}
+	for name := range required {
+		if _, ok := found[name]; !ok {
+			return "", fmt.Errorf("placeholder .%s was not found", name)
+		}
+	}
+
+	// Unlike ${...} placeholders, functions can make anything of the values, so the names are
+	// checked with those of a few targets, which also finds functions called wrongly.
+	for _, t := range []target{"linux/amd64", "windows/arm64", "darwin/arm64", "linux/arm/v7"} {
+		values := make(map[string]string)
+		for name := range found {
+			values[name] = "x"
+		}
+		tgoos, tgoarch := t.osArch()
+		values["GOOS"], values["GOARCH"], values["GOVARIANT"] = tgoos, tgoarch, t.variant()
+		out, err := expandGoTemplate(s, values, "$")
+		if err != nil {
+			return "", err
+		}
+		for _, c := range out {
+			if why := unsafeOutputChar(c, goos); why != "" {
+				return "", fmt.Errorf("for %s, %q has an unexpected character %q: %s", t, out, c, why)
+			}
+		}
+		for _, name := range strings.Split(out, "/") {
+			if why := unsafeOutputName(name, goos); why != "" {
+				return "", fmt.Errorf("for %s, %q is not a safe file name: %s", t, name, why)
+			}
+		}
+	}
+	return outputTemplate(s), nil
+}
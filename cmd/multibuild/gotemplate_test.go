@@ -0,0 +1,93 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestValidateGoTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{"fields", "bin/{{.TARGET}}-{{.GOOS}}-{{.GOARCH}}", false},
+		{"functions", `dist/MyApp_{{title .GOOS}}_{{.GOARCH | replace "amd64" "x64"}}/{{.TARGET}}`, false},
+		{"run placeholders", `dist/{{.VERSION | trimprefix "v"}}/{{.TARGET}}-{{.GOOS}}-{{.GOARCH}}-{{.ENV.BUILD_NUMBER}}`, false},
+		{"conditions", `bin/{{.TARGET}}-{{if eq .GOOS "darwin"}}macOS{{else}}{{.GOOS}}{{end}}-{{.GOARCH}}{{with .GOVARIANT}}-{{.}}{{end}}`, false},
+		{"user placeholder", "bin/{{.PRODUCT | lower}}-{{.TARGET}}-{{.GOOS}}-{{.GOARCH}}", false},
+		{"literal dollar", "bin/$/{{.TARGET}}-{{.GOOS}}-{{.GOARCH}}", false},
+
+		{"missing GOARCH", "bin/{{.TARGET}}-{{.GOOS}}", true},
+		{"lowercase field", "bin/{{.TARGET}}-{{.goos}}-{{.GOARCH}}", true},
+		{"unknown function", "bin/{{.TARGET}}-{{camel .GOOS}}-{{.GOARCH}}", true},
+		{"wrong arguments", `bin/{{.TARGET}}-{{replace "amd64" .GOOS}}-{{.GOARCH}}`, true},
+		{"unclosed action", "bin/{{.TARGET}}-{{.GOOS-{{.GOARCH}}", true},
+		{"define", `{{define "x"}}y{{end}}bin/{{.TARGET}}-{{.GOOS}}-{{.GOARCH}}`, true},
+		{"wildcard", "bin/{{.TARGET}}-{{.GOOS}}-{{.GOARCH}}*", true},
+		{"unsafe for some targets", `bin/{{.TARGET}}-{{.GOOS}}-{{.GOARCH}}{{if eq .GOOS "darwin"}} {{end}}/x`, true},
+		{"empty for some targets", `bin/{{if ne .GOOS "linux"}}{{.GOOS}}{{end}}/{{.TARGET}}-{{.GOARCH}}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := validateTemplate(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got nil (output=%q)", out)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(out) != tt.input {
+				t.Fatalf("output mismatch: got %q, want %q", out, tt.input)
+			}
+		})
+	}
+}
+
+func TestGoTemplateOutputPaths(t *testing.T) {
+	opts := options{Output: `dist/MyApp_{{title .GOOS}}_{{.GOARCH | replace "amd64" "x64"}}/{{.TARGET}}`}
+	tests := []struct {
+		target  target
+		wantBin string
+	}{
+		{"windows/amd64", "dist/MyApp_Windows_x64/app.exe"},
+		{"linux/arm64", "dist/MyApp_Linux_arm64/app"},
+		{"linux/arm/v7", "dist/MyApp_Linux_armv7/app"},
+	}
+	for _, tt := range tests {
+		if _, bin := opts.outputPaths("app", tt.target); bin != tt.wantBin {
+			t.Errorf("outputPaths(%s) = %q, want %q", tt.target, bin, tt.wantBin)
+		}
+	}
+}
+
+func TestGoTemplateFill(t *testing.T) {
+	template := outputTemplate(`{{.VERSION | trimprefix "v"}}/{{.ENV.BUILD}}-{{.TARGET}}-{{.GOOS}}-{{.GOARCH}}`)
+	if got, want := template.placeholders(), []string{"VERSION", "ENV:BUILD", "TARGET", "GOOS", "GOARCH"}; !slices.Equal(got, want) {
+		t.Errorf("placeholders() = %q, want %q", got, want)
+	}
+
+	filled := template.fill(map[string]string{"VERSION": "v1.4.2", "ENV:BUILD": `"42"`})
+	if got, want := filled.placeholders(), []string{"TARGET", "GOOS", "GOARCH"}; !slices.Equal(got, want) {
+		t.Errorf("placeholders() after fill() = %q, want %q", got, want)
+	}
+	if _, bin := (options{Output: filled}).outputPaths("app", "linux/amd64"); bin != `1.4.2/"42"-app-linux-amd64` {
+		t.Errorf("outputPaths() after fill() = %q", bin)
+	}
+
+	// A literal $ is escaped, but not one that is filled in.
+	dollars := outputTemplate("$/{{.TARGET}}").fill(map[string]string{"TARGET": "$x"})
+	if got := dollars.expand(nil, `\$`); got != `\$/$x` {
+		t.Errorf("expand() = %q, want %q", got, `\$/$x`)
+	}
+	if got := dollars.literal("{{x}}/"); got != `{{"{{"}}x}}/` {
+		t.Errorf("literal() = %q", got)
+	}
+}
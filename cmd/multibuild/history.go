@@ -0,0 +1,276 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// How many runs of each package are remembered.
+const historyLimit = 100
+
+// How many successful builds of a target its expected duration is averaged over, so that
+// one unusually slow run doesn't skew things, but the estimate still follows real changes.
+const historyAveraged = 5
+
+// A run, as remembered in the history.
+type historyRun struct {
+	Time    time.Time       `json:"time"`
+	Targets []historyTarget `json:"targets"`
+}
+
+// A target of a run, as remembered in the history.
+type historyTarget struct {
+	Target   target        `json:"target"`
+	Status   string        `json:"status"`
+	Duration time.Duration `json:"duration"`
+
+	// The total size of the target's artifacts, if it succeeded.
+	Size int64 `json:"size,omitempty"`
+}
+
+// Returns where the history of runs of the package at 'packagePath' is kept,
+// or "" if caching is off.
+func historyPath(packagePath string) string {
+	dir := cacheDir()
+	if dir == "" {
+		return ""
+	}
+	abs, err := filepath.Abs(packagePath)
+	if err != nil {
+		return ""
+	}
+	key := sha256.Sum256(fmt.Appendf(nil, "history v1\n%s\n", abs))
+	return filepath.Join(dir, "history", hex.EncodeToString(key[:])+".jsonl")
+}
+
+// Returns the runs remembered at 'path', oldest first. The history is only a guide, so
+// runs which can't be read are skipped, and a missing history is the same as an empty one.
+func loadHistory(path string) []historyRun {
+	if path == "" {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var runs []historyRun
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var run historyRun
+		if json.Unmarshal(scanner.Bytes(), &run) == nil {
+			runs = append(runs, run)
+		}
+	}
+	return runs
+}
+
+// Adds the run which produced 'results' to the history at 'path', forgetting the oldest runs
+// beyond historyLimit.
+func appendHistory(path string, start time.Time, results []targetResult) error {
+	if path == "" {
+		return nil
+	}
+	run := historyRun{Time: start.UTC()}
+	for _, r := range results {
+		ht := historyTarget{Target: r.target, Status: r.status(), Duration: r.duration()}
+		if r.err == nil && !r.discarded {
+			for _, a := range r.artifacts {
+				if st, err := os.Stat(a); err == nil {
+					ht.Size += st.Size()
+				}
+			}
+		}
+		run.Targets = append(run.Targets, ht)
+	}
+
+	runs := append(loadHistory(path), run)
+	runs = runs[max(len(runs)-historyLimit, 0):]
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, run := range runs {
+		if err := enc.Encode(run); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// Returns how long each target usually takes to build and package, going by its
+// last few successful builds in 'runs'.
+func expectedDurations(runs []historyRun) map[target]time.Duration {
+	sums := make(map[target]time.Duration)
+	counts := make(map[target]int)
+	for _, run := range slices.Backward(runs) {
+		for _, ht := range run.Targets {
+			if ht.Status != "ok" || counts[ht.Target] == historyAveraged {
+				continue
+			}
+			sums[ht.Target] += ht.Duration
+			counts[ht.Target]++
+		}
+	}
+	expected := make(map[target]time.Duration, len(sums))
+	for t, sum := range sums {
+		expected[t] = sum / time.Duration(counts[t])
+	}
+	return expected
+}
+
+// Statistics about a target over a number of runs.
+type targetStats struct {
+	Target   target  `json:"target"`
+	Runs     int     `json:"runs"`
+	Failures int     `json:"failures"`
+	FailRate float64 `json:"failRate"`
+
+	// Durations of successful builds, in seconds.
+	AverageSeconds float64 `json:"averageSeconds"`
+	SlowestSeconds float64 `json:"slowestSeconds"`
+
+	// The size of the artifacts in the first and last successful build.
+	FirstSize int64 `json:"firstSize"`
+	LastSize  int64 `json:"lastSize"`
+}
+
+// The statistics printed by 'multibuild stats'.
+type historyStats struct {
+	Runs    int           `json:"runs"`
+	Since   time.Time     `json:"since,omitzero"`
+	Targets []targetStats `json:"targets"`
+}
+
+// Summarises 'runs', with the slowest targets first.
+func summariseHistory(runs []historyRun) historyStats {
+	stats := historyStats{Runs: len(runs), Targets: []targetStats{}}
+	if len(runs) > 0 {
+		stats.Since = runs[0].Time
+	}
+	byTarget := make(map[target]*targetStats)
+	var order []target
+	var total = make(map[target]time.Duration)
+	for _, run := range runs {
+		for _, ht := range run.Targets {
+			ts, ok := byTarget[ht.Target]
+			if !ok {
+				ts = &targetStats{Target: ht.Target}
+				byTarget[ht.Target] = ts
+				order = append(order, ht.Target)
+			}
+			ts.Runs++
+			if ht.Status == "failed" {
+				ts.Failures++
+				continue
+			}
+			total[ht.Target] += ht.Duration
+			ts.SlowestSeconds = max(ts.SlowestSeconds, ht.Duration.Seconds())
+			if ht.Size > 0 {
+				if ts.FirstSize == 0 {
+					ts.FirstSize = ht.Size
+				}
+				ts.LastSize = ht.Size
+			}
+		}
+	}
+	for _, t := range order {
+		ts := byTarget[t]
+		ts.FailRate = float64(ts.Failures) / float64(ts.Runs)
+		if ok := ts.Runs - ts.Failures; ok > 0 {
+			ts.AverageSeconds = total[t].Seconds() / float64(ok)
+		}
+		stats.Targets = append(stats.Targets, *ts)
+	}
+	slices.SortStableFunc(stats.Targets, func(a, b targetStats) int {
+		if a.AverageSeconds != b.AverageSeconds {
+			if a.AverageSeconds > b.AverageSeconds {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(string(a.Target), string(b.Target))
+	})
+	return stats
+}
+
+// Formats the change from 'first' to 'last', e.g. +1.2%.
+func formatSizeChange(first, last int64) string {
+	if first == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%+.1f%%", float64(last-first)/float64(first)*100)
+}
+
+// Writes 'stats' as a table.
+func writeStatsTable(w io.Writer, stats historyStats) {
+	if stats.Runs == 0 {
+		fmt.Fprintln(w, "no runs recorded")
+		return
+	}
+	fmt.Fprintf(w, "%d runs since %s\n\n", stats.Runs, stats.Since.Local().Format(time.DateTime))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tRUNS\tFAILED\tAVERAGE\tSLOWEST\tSIZE\tCHANGE")
+	for _, ts := range stats.Targets {
+		size := "-"
+		if ts.LastSize > 0 {
+			size = formatSize(ts.LastSize)
+		}
+		average := roundDuration(time.Duration(ts.AverageSeconds * float64(time.Second)))
+		slowest := roundDuration(time.Duration(ts.SlowestSeconds * float64(time.Second)))
+		fmt.Fprintf(tw, "%s\t%d\t%.0f%%\t%s\t%s\t%s\t%s\n", ts.Target, ts.Runs, ts.FailRate*100, average, slowest, size, formatSizeChange(ts.FirstSize, ts.LastSize))
+	}
+	tw.Flush()
+}
+
+// Implements 'multibuild stats'.
+func doStats(self string, argv []string) {
+	fs := flag.NewFlagSet(self+" stats", flag.ExitOnError)
+	n := fs.Int("n", 20, "how many of the most recent runs to look at")
+	asJSON := fs.Bool("json", false, "print JSON, rather than a table")
+	fs.Parse(argv)
+
+	packagePath := "."
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		packagePath = fs.Arg(0)
+	default:
+		fatalCode(exitConfig, "usage: %s stats [-n runs] [-json] [package]", self)
+	}
+	if *n < 1 {
+		fatalCode(exitConfig, "multibuild: stats: -n must be at least 1")
+	}
+	path := historyPath(packagePath)
+	if path == "" {
+		fatalCode(exitConfig, "multibuild: stats: MULTIBUILD_CACHE=off, so no history is kept")
+	}
+
+	runs := loadHistory(path)
+	stats := summariseHistory(runs[max(len(runs)-*n, 0):])
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(stats)
+		return
+	}
+	writeStatsTable(os.Stdout, stats)
+}
@@ -0,0 +1,128 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("MULTIBUILD_CACHE", dir)
+	path := historyPath(".")
+	if path == "" || filepath.Dir(filepath.Dir(path)) != dir {
+		t.Fatalf("unexpected path %q", path)
+	}
+	if runs := loadHistory(path); len(runs) != 0 {
+		t.Fatalf("got runs before any were recorded: %v", runs)
+	}
+
+	bin := filepath.Join(dir, "app-linux-amd64")
+	if err := os.WriteFile(bin, make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	results := []targetResult{
+		{target: "linux/amd64", started: start, finished: start.Add(10 * time.Second), artifacts: []string{bin}},
+		{target: "darwin/arm64", started: start, finished: start.Add(4 * time.Second), err: errors.New("failed")},
+	}
+	if err := appendHistory(path, start, results); err != nil {
+		t.Fatal(err)
+	}
+	results[0].finished = start.Add(20 * time.Second)
+	if err := appendHistory(path, start.Add(time.Minute), results); err != nil {
+		t.Fatal(err)
+	}
+
+	runs := loadHistory(path)
+	if len(runs) != 2 || runs[0].Targets[0].Size != 1000 || runs[1].Targets[1].Status != "failed" {
+		t.Fatalf("unexpected history: %+v", runs)
+	}
+	// Failed builds aren't any guide to how long a target takes.
+	want := map[target]time.Duration{"linux/amd64": 15 * time.Second}
+	if got := expectedDurations(runs); !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Only the most recent runs are kept.
+	for range historyLimit {
+		if err := appendHistory(path, start, results); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if runs := loadHistory(path); len(runs) != historyLimit {
+		t.Errorf("got %d runs, want %d", len(runs), historyLimit)
+	}
+
+	t.Setenv("MULTIBUILD_CACHE", "off")
+	if path := historyPath("."); path != "" {
+		t.Errorf("got %q with caching off", path)
+	}
+}
+
+func TestExpectedDurations(t *testing.T) {
+	var runs []historyRun
+	for _, d := range []time.Duration{100, 1, 2, 3, 4, 5} {
+		runs = append(runs, historyRun{Targets: []historyTarget{{Target: "linux/amd64", Status: "ok", Duration: d * time.Second}}})
+	}
+	// Only the last few builds count.
+	want := map[target]time.Duration{"linux/amd64": 3 * time.Second}
+	if got := expectedDurations(runs); !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSummariseHistory(t *testing.T) {
+	since := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	runs := []historyRun{
+		{Time: since, Targets: []historyTarget{
+			{Target: "linux/amd64", Status: "ok", Duration: 10 * time.Second, Size: 1000},
+			{Target: "windows/amd64", Status: "ok", Duration: 20 * time.Second, Size: 2000},
+		}},
+		{Time: since.Add(time.Hour), Targets: []historyTarget{
+			{Target: "linux/amd64", Status: "ok", Duration: 20 * time.Second, Size: 1100},
+			{Target: "windows/amd64", Status: "failed", Duration: time.Second},
+		}},
+	}
+	stats := summariseHistory(runs)
+	want := []targetStats{
+		{Target: "windows/amd64", Runs: 2, Failures: 1, FailRate: 0.5, AverageSeconds: 20, SlowestSeconds: 20, FirstSize: 2000, LastSize: 2000},
+		{Target: "linux/amd64", Runs: 2, AverageSeconds: 15, SlowestSeconds: 20, FirstSize: 1000, LastSize: 1100},
+	}
+	if stats.Runs != 2 || !stats.Since.Equal(since) || len(stats.Targets) != len(want) {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	for idx := range want {
+		if stats.Targets[idx] != want[idx] {
+			t.Errorf("target %d: got %+v, want %+v", idx, stats.Targets[idx], want[idx])
+		}
+	}
+
+	var buf bytes.Buffer
+	writeStatsTable(&buf, stats)
+	lines := strings.Split(buf.String(), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("unexpected table:\n%s", buf.String())
+	}
+	if got := strings.Fields(lines[3]); strings.Join(got, " ") != "windows/amd64 2 50% 20s 20s 2.0 KiB +0.0%" {
+		t.Errorf("unexpected row %q", lines[3])
+	}
+	if got := strings.Fields(lines[4]); strings.Join(got, " ") != "linux/amd64 2 0% 15s 20s 1.1 KiB +10.0%" {
+		t.Errorf("unexpected row %q", lines[4])
+	}
+
+	buf.Reset()
+	writeStatsTable(&buf, summariseHistory(nil))
+	if buf.String() != "no runs recorded\n" {
+		t.Errorf("got %q without any runs", buf.String())
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// The placeholders publish= commands may use.
+var hookPlaceholders = []string{"ARTIFACT", "ARCHIVE", "NAME", "SHA256", "TARGET", "GOOS", "GOARCH"}
+
+// Validates a publish= value: a command, run for each artifact, e.g.
+// ./scripts/upload.sh ${ARTIFACT} ${GOOS} ${GOARCH} ${SHA256}
+func validateHook(s string) (string, error) {
+	if strings.TrimSpace(s) == "" {
+		return "", fmt.Errorf("empty command")
+	}
+	for rest := s; strings.Contains(rest, "${"); {
+		_, rest, _ = strings.Cut(rest, "${")
+		var placeholder string
+		placeholder, rest, _ = strings.Cut(rest, "}")
+		if !slices.Contains(hookPlaceholders, placeholder) {
+			return "", fmt.Errorf("unknown placeholder ${%s}, expected one of ${%s}", placeholder, strings.Join(hookPlaceholders, "}, ${"))
+		}
+	}
+	return s, nil
+}
+
+// Returns the arguments to run 'hook' with for the artifact at 'path', of target 't'.
+// The command is split into words first, so values with spaces stay as one argument.
+// 'name' is the value of ${TARGET}.
+func hookArgs(hook, path, sha256 string, name string, t target) []string {
+	goos, goarch := t.osArch()
+	replacer := strings.NewReplacer(
+		"${ARTIFACT}", path,
+		"${ARCHIVE}", path,
+		"${NAME}", filepath.Base(path),
+		"${SHA256}", sha256,
+		"${TARGET}", name,
+		"${GOOS}", goos,
+		"${GOARCH}", goarch,
+	)
+	return mapSlice(strings.Fields(hook), replacer.Replace)
+}
+
+// Runs each of 'hooks' for each of the artifacts of 'r', in 'dir'. If one fails, so does the target.
+// 'name' is the value of ${TARGET}.
+func runHooks(ctx context.Context, hooks []string, dir, name string, r *targetResult, verbose bool) {
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "%s: publish: %s\n", r.target, err)
+		r.log += err.Error() + "\n"
+		r.err, r.code = fmt.Errorf("publish: %w", err), exitPublish
+	}
+	for _, a := range r.artifacts {
+		path, err := filepath.Abs(a)
+		if err != nil {
+			fail(err)
+			return
+		}
+		_, sum, err := artifactInfo(path)
+		if err != nil {
+			fail(err)
+			return
+		}
+		for _, hook := range hooks {
+			args := hookArgs(hook, path, sum, name, r.target)
+			if verbose {
+				fmt.Fprintf(os.Stderr, "%s: publish: %s\n", r.target, strings.Join(args, " "))
+			}
+			var out bytes.Buffer
+			cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+			cmd.Dir, cmd.Stdout, cmd.Stderr = dir, &out, &out
+			err := cmd.Run()
+			r.log += out.String()
+			if err != nil {
+				if out.Len() > 0 {
+					os.Stderr.Write(out.Bytes())
+				}
+				fail(fmt.Errorf("%s: %w", args[0], err))
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateHook(t *testing.T) {
+	for _, tt := range []struct {
+		input     string
+		wantError bool
+	}{
+		{"./scripts/upload.sh ${ARCHIVE} ${GOOS} ${GOARCH} ${SHA256}", false},
+		{"gh release upload v1 ${ARTIFACT}", false},
+		{"./upload.sh ${VERSION}", true},
+		{"  ", true},
+	} {
+		if _, err := validateHook(tt.input); (err != nil) != tt.wantError {
+			t.Errorf("validateHook(%q) error = %v, wantError %v", tt.input, err, tt.wantError)
+		}
+	}
+}
+
+func TestHookArgs(t *testing.T) {
+	got := hookArgs("./upload.sh --name=${NAME} ${ARTIFACT} ${TARGET}-${GOOS}-${GOARCH} ${SHA256}", "/my builds/app.zip", "abc", "app", "linux/arm64")
+	want := []string{"./upload.sh", "--name=app.zip", "/my builds/app.zip", "app-linux-arm64", "abc"}
+	if !slices.Equal(got, want) {
+		t.Errorf("hookArgs() = %q, want %q", got, want)
+	}
+}
+
+func TestRunHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hooks are shell scripts")
+	}
+	dir := t.TempDir()
+	log := filepath.Join(dir, "log")
+	os.MkdirAll(filepath.Join(dir, "scripts"), 0755)
+	script := "#!/bin/sh\necho \"$@\" >> " + log + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "scripts", "upload.sh"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "scripts", "fail.sh"), []byte("#!/bin/sh\necho nope\nexit 3\n"), 0755)
+	artifact := filepath.Join(dir, "app")
+	os.WriteFile(artifact, []byte("binary"), 0644)
+
+	r := targetResult{target: "linux/amd64", artifacts: []string{artifact}}
+	runHooks(t.Context(), []string{"./scripts/upload.sh ${NAME} ${GOARCH} ${SHA256}"}, dir, "app", &r, false)
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if got, _ := os.ReadFile(log); string(got) != "app amd64 "+sha256Hex("binary")+"\n" {
+		t.Errorf("hook ran with %q", got)
+	}
+
+	r = targetResult{target: "linux/amd64", artifacts: []string{artifact}}
+	runHooks(t.Context(), []string{"./scripts/fail.sh", "./scripts/upload.sh"}, dir, "app", &r, false)
+	if r.err == nil || r.code != exitPublish || !strings.Contains(r.log, "nope") {
+		t.Errorf("failing hook gave %v, code %d, log %q", r.err, r.code, r.log)
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The base image used if image-base= isn't set.
+const defaultImageBase = "gcr.io/distroless/static-debian12"
+
+// An image base of "scratch" means no base at all.
+const scratchImage = "scratch"
+
+// Validates an image= value: a reference to push to, which can't be a digest.
+func validateImage(s string) (string, error) {
+	if strings.Contains(s, "@") {
+		return "", fmt.Errorf("%q is a digest, expected a repository with an optional tag", s)
+	}
+	if _, err := parseImageRef(s); err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
+// Validates an image-base= value.
+func validateImageBase(s string) (string, error) {
+	if s == scratchImage {
+		return s, nil
+	}
+	if _, err := parseImageRef(s); err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+type ociDescriptor struct {
+	MediaType string       `json:"mediaType"`
+	Digest    string       `json:"digest"`
+	Size      int64        `json:"size"`
+	Platform  *ociPlatform `json:"platform,omitempty"`
+}
+
+// An image manifest, or an index (in which case Manifests is set, instead of Config and Layers).
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Config        *ociDescriptor  `json:"config,omitempty"`
+	Layers        []ociDescriptor `json:"layers,omitempty"`
+	Manifests     []ociDescriptor `json:"manifests,omitempty"`
+}
+
+// Returns the image platform for a linux target.
+func imagePlatform(t target) ociPlatform {
+	goos, goarch := t.osArch()
+	p := ociPlatform{OS: goos, Architecture: goarch}
+	switch goarch {
+	case "arm":
+		p.Variant = "v7" // the default GOARM
+		if v := t.variant(); v != "" {
+			p.Variant = v
+		}
+	case "arm64":
+		p.Variant = "v8"
+		if v := t.variant(); v != "" {
+			p.Variant = strings.TrimSuffix(v, ".0")
+		}
+	case "amd64":
+		p.Variant = t.variant()
+	}
+	return p
+}
+
+// Whether 'p' is suitable for 'want'. A missing variant matches anything.
+func (this ociPlatform) matches(want ociPlatform) bool {
+	return this.OS == want.OS && this.Architecture == want.Architecture &&
+		(this.Variant == "" || want.Variant == "" || this.Variant == want.Variant)
+}
+
+// The parts of a base image an image is built on.
+type imageBase struct {
+	ref      imageRef
+	manifest ociManifest
+	config   map[string]any
+}
+
+// Fetches the image for 'platform' from 'base', which may be an index.
+func fetchImageBase(ctx context.Context, rc *registryClient, base string, platform ociPlatform) (imageBase, error) {
+	if base == scratchImage {
+		return imageBase{manifest: ociManifest{MediaType: mediaOCIManifest}, config: map[string]any{}}, nil
+	}
+	ref, err := parseImageRef(base)
+	if err != nil {
+		return imageBase{}, err
+	}
+	data, mediaType, err := rc.getManifest(ctx, ref)
+	if err != nil {
+		return imageBase{}, err
+	}
+	if mediaType == mediaOCIIndex || mediaType == mediaDockerList {
+		var index ociManifest
+		if err := json.Unmarshal(data, &index); err != nil {
+			return imageBase{}, fmt.Errorf("%s: %w", base, err)
+		}
+		var found *ociDescriptor
+		for i, m := range index.Manifests {
+			if m.Platform != nil && m.Platform.matches(platform) {
+				found = &index.Manifests[i]
+				break
+			}
+		}
+		if found == nil {
+			return imageBase{}, fmt.Errorf("%s has no image for %s/%s", base, platform.OS, platform.Architecture)
+		}
+		byDigest := ref
+		byDigest.reference = found.Digest
+		if data, mediaType, err = rc.getManifest(ctx, byDigest); err != nil {
+			return imageBase{}, err
+		}
+	}
+	if mediaType != mediaOCIManifest && mediaType != mediaDockerManifest {
+		return imageBase{}, fmt.Errorf("%s: unsupported manifest type %q", base, mediaType)
+	}
+
+	b := imageBase{ref: ref}
+	if err := json.Unmarshal(data, &b.manifest); err != nil || b.manifest.Config == nil {
+		return imageBase{}, fmt.Errorf("%s: invalid manifest", base)
+	}
+	b.manifest.MediaType = mediaType
+	config, err := rc.getBlob(ctx, ref, b.manifest.Config.Digest)
+	if err != nil {
+		return imageBase{}, err
+	}
+	if err := json.Unmarshal(config, &b.config); err != nil {
+		return imageBase{}, fmt.Errorf("%s: invalid config: %w", base, err)
+	}
+	return b, nil
+}
+
+// Returns a gzipped layer holding 'binary' as /name, and the digest of its uncompressed form.
+// Timestamps are fixed, so that the same binary always gives the same layer.
+func imageLayer(name string, binary []byte) (layer []byte, diffID string, err error) {
+	var tarball bytes.Buffer
+	tw := tar.NewWriter(&tarball)
+	hdr := &tar.Header{Name: name, Mode: 0755, Size: int64(len(binary)), ModTime: time.Unix(0, 0), Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return nil, "", err
+	}
+	if _, err := tw.Write(binary); err != nil {
+		return nil, "", err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, "", err
+	}
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	if _, err := zw.Write(tarball.Bytes()); err != nil {
+		return nil, "", err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, "", err
+	}
+	return gz.Bytes(), digestOf(tarball.Bytes()), nil
+}
+
+// Returns the config for an image adding a layer with 'diffID' to 'base', to run /name.
+func imageConfig(base map[string]any, platform ociPlatform, name, diffID string) map[string]any {
+	created := time.Unix(0, 0).UTC().Format(time.RFC3339)
+	config := make(map[string]any, len(base))
+	for k, v := range base {
+		config[k] = v
+	}
+	config["os"], config["architecture"], config["created"] = platform.OS, platform.Architecture, created
+	delete(config, "variant")
+	if platform.Variant != "" {
+		config["variant"] = platform.Variant
+	}
+
+	run, _ := config["config"].(map[string]any)
+	if run == nil {
+		run = make(map[string]any)
+	}
+	run["Entrypoint"] = []string{"/" + name}
+	delete(run, "Cmd")
+	config["config"] = run
+
+	rootfs, _ := config["rootfs"].(map[string]any)
+	diffIDs, _ := rootfs["diff_ids"].([]any)
+	config["rootfs"] = map[string]any{"type": "layers", "diff_ids": append(diffIDs, diffID)}
+
+	history, _ := config["history"].([]any)
+	config["history"] = append(history, map[string]any{"created": created, "created_by": "multibuild"})
+	return config
+}
+
+// Builds and pushes the image for 't' from 'binary' on 'base' to 'dest', returning its descriptor.
+func pushPlatformImage(ctx context.Context, rc *registryClient, dest imageRef, base string, t target, name string, binary []byte) (ociDescriptor, error) {
+	platform := imagePlatform(t)
+	b, err := fetchImageBase(ctx, rc, base, platform)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	configType, layerType := mediaOCIConfig, mediaOCILayer
+	if b.manifest.MediaType == mediaDockerManifest {
+		configType, layerType = mediaDockerConfig, mediaDockerLayer
+	}
+
+	// The base's layers are copied across, if the destination doesn't have them.
+	for _, l := range b.manifest.Layers {
+		fetch := func() ([]byte, error) { return rc.getBlob(ctx, b.ref, l.Digest) }
+		if err := rc.putBlob(ctx, dest, l.Digest, fetch, &b.ref); err != nil {
+			return ociDescriptor{}, err
+		}
+	}
+
+	layer, diffID, err := imageLayer(name, binary)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	config, err := json.Marshal(imageConfig(b.config, platform, name, diffID))
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	for _, blob := range [][]byte{layer, config} {
+		if err := rc.putBlob(ctx, dest, digestOf(blob), func() ([]byte, error) { return blob, nil }, nil); err != nil {
+			return ociDescriptor{}, err
+		}
+	}
+
+	m := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     b.manifest.MediaType,
+		Config:        &ociDescriptor{MediaType: configType, Digest: digestOf(config), Size: int64(len(config))},
+		Layers:        append(b.manifest.Layers, ociDescriptor{MediaType: layerType, Digest: digestOf(layer), Size: int64(len(layer))}),
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	byDigest := dest
+	byDigest.reference = digestOf(data)
+	if _, err := rc.putManifest(ctx, byDigest, m.MediaType, data); err != nil {
+		return ociDescriptor{}, err
+	}
+	return ociDescriptor{MediaType: m.MediaType, Digest: digestOf(data), Size: int64(len(data)), Platform: &platform}, nil
+}
+
+// Publishes an image to 'dest' running 'name', built from the linux binaries in 'binaries'
+// on top of 'base'. Returns the image's reference, by digest.
+func publishImage(ctx context.Context, rc *registryClient, dest, base, name string, binaries map[target]string) (string, error) {
+	ref, err := parseImageRef(dest)
+	if err != nil {
+		return "", err
+	}
+	targets := filterSlice(slices.Sorted(maps.Keys(binaries)), func(t target) bool {
+		return strings.HasPrefix(string(t), "linux/")
+	})
+	if len(targets) == 0 {
+		return "", fmt.Errorf("no linux targets were built")
+	}
+
+	index := ociManifest{SchemaVersion: 2, MediaType: mediaOCIIndex}
+	for _, t := range targets {
+		binary, err := os.ReadFile(binaries[t])
+		if err != nil {
+			return "", err
+		}
+		desc, err := pushPlatformImage(ctx, rc, ref, base, t, name, binary)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", t, err)
+		}
+		if desc.MediaType == mediaDockerManifest {
+			index.MediaType = mediaDockerList
+		}
+		index.Manifests = append(index.Manifests, desc)
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return "", err
+	}
+	digest, err := rc.putManifest(ctx, ref, index.MediaType, data)
+	if err != nil {
+		return "", err
+	}
+	byDigest := ref
+	byDigest.reference = digest
+	return byDigest.String(), nil
+}
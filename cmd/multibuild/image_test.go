@@ -0,0 +1,173 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Writes a fake binary for each target, returning their paths.
+func fakeBinaries(t *testing.T, targets ...target) map[target]string {
+	dir := t.TempDir()
+	binaries := make(map[target]string)
+	for _, tgt := range targets {
+		path := filepath.Join(dir, strings.ReplaceAll(string(tgt), "/", "-"))
+		if err := os.WriteFile(path, []byte("binary for "+tgt), 0755); err != nil {
+			t.Fatal(err)
+		}
+		binaries[tgt] = path
+	}
+	return binaries
+}
+
+// Fetches the image for 'platform' from the index 'digest' in 'repo', returning its manifest and config.
+func pulledImage(t *testing.T, reg *fakeRegistry, repo, digest string, platform ociPlatform) (ociManifest, map[string]any) {
+	var index ociManifest
+	if err := json.Unmarshal(reg.manifest(repo, digest), &index); err != nil {
+		t.Fatal(err)
+	}
+	i := slices.IndexFunc(index.Manifests, func(d ociDescriptor) bool { return *d.Platform == platform })
+	if i < 0 {
+		t.Fatalf("no image for %v in %+v", platform, index)
+	}
+	var m ociManifest
+	if err := json.Unmarshal(reg.manifest(repo, index.Manifests[i].Digest), &m); err != nil {
+		t.Fatal(err)
+	}
+	var config map[string]any
+	if err := json.Unmarshal(reg.blob(repo, m.Config.Digest), &config); err != nil {
+		t.Fatal(err)
+	}
+	return m, config
+}
+
+// Returns the files in a gzipped layer.
+func layerFiles(t *testing.T, layer []byte) map[string]string {
+	zr, err := gzip.NewReader(bytes.NewReader(layer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		files[hdr.Name] = string(data)
+	}
+}
+
+func TestPublishImageScratch(t *testing.T) {
+	reg := newFakeRegistry(t)
+	binaries := fakeBinaries(t, "linux/amd64", "linux/arm64", "darwin/arm64")
+
+	got, err := publishImage(t.Context(), newRegistryClient(nil), reg.host()+"/example/app:v1", scratchImage, "app", binaries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := reg.host() + "/example/app@sha256:"
+	if !strings.HasPrefix(got, prefix) {
+		t.Fatalf("publishImage() = %s, want %s...", got, prefix)
+	}
+	digest := strings.TrimPrefix(got, reg.host()+"/example/app@")
+	if !bytes.Equal(reg.manifest("example/app", "v1"), reg.manifest("example/app", digest)) {
+		t.Errorf("tag v1 doesn't point at %s", digest)
+	}
+
+	var index ociManifest
+	json.Unmarshal(reg.manifest("example/app", digest), &index)
+	if index.MediaType != mediaOCIIndex || len(index.Manifests) != 2 {
+		t.Fatalf("index = %+v, want the two linux images", index)
+	}
+
+	m, config := pulledImage(t, reg, "example/app", digest, ociPlatform{OS: "linux", Architecture: "arm64", Variant: "v8"})
+	if len(m.Layers) != 1 || m.Layers[0].MediaType != mediaOCILayer {
+		t.Fatalf("layers = %+v", m.Layers)
+	}
+	if files := layerFiles(t, reg.blob("example/app", m.Layers[0].Digest)); files["app"] != "binary for linux/arm64" {
+		t.Errorf("layer = %v", files)
+	}
+	run := config["config"].(map[string]any)
+	if ep, _ := json.Marshal(run["Entrypoint"]); string(ep) != `["/app"]` {
+		t.Errorf("Entrypoint = %s", ep)
+	}
+	if config["architecture"] != "arm64" || config["os"] != "linux" {
+		t.Errorf("config = %v", config)
+	}
+
+	// The same binaries give the same image.
+	again, err := publishImage(t.Context(), newRegistryClient(nil), reg.host()+"/example/app:v1", scratchImage, "app", binaries)
+	if err != nil || again != got {
+		t.Errorf("publishing again = %s, %v; want %s", again, err, got)
+	}
+}
+
+func TestPublishImageBase(t *testing.T) {
+	reg := newFakeRegistry(t)
+	reg.token = "token"
+
+	// A single platform base, in docker's format.
+	baseLayer := reg.addBlob("base", []byte("base layer"))
+	baseConfig, _ := json.Marshal(map[string]any{
+		"architecture": "amd64", "os": "linux",
+		"config": map[string]any{"Env": []string{"PATH=/bin"}, "Cmd": []string{"/bin/sh"}},
+		"rootfs": map[string]any{"type": "layers", "diff_ids": []string{"sha256:base"}},
+	})
+	configDigest := reg.addBlob("base", baseConfig)
+	manifest, _ := json.Marshal(ociManifest{
+		SchemaVersion: 2, MediaType: mediaDockerManifest,
+		Config: &ociDescriptor{MediaType: mediaDockerConfig, Digest: configDigest, Size: int64(len(baseConfig))},
+		Layers: []ociDescriptor{{MediaType: mediaDockerLayer, Digest: baseLayer, Size: 10}},
+	})
+	manifestDigest := reg.addManifest("base", "amd64", mediaDockerManifest, manifest)
+	index, _ := json.Marshal(ociManifest{SchemaVersion: 2, MediaType: mediaDockerList, Manifests: []ociDescriptor{
+		{MediaType: mediaDockerManifest, Digest: manifestDigest, Size: int64(len(manifest)), Platform: &ociPlatform{OS: "linux", Architecture: "amd64"}},
+	}})
+	reg.addManifest("base", "latest", mediaDockerList, index)
+
+	got, err := publishImage(t.Context(), newRegistryClient(nil), reg.host()+"/app", reg.host()+"/base", "app", fakeBinaries(t, "linux/amd64"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, config := pulledImage(t, reg, "app", strings.TrimPrefix(got, reg.host()+"/app@"), ociPlatform{OS: "linux", Architecture: "amd64"})
+	if m.MediaType != mediaDockerManifest || len(m.Layers) != 2 || m.Layers[0].Digest != baseLayer {
+		t.Fatalf("manifest = %+v", m)
+	}
+	if reg.blob("app", baseLayer) == nil {
+		t.Errorf("the base layer wasn't copied")
+	}
+	run := config["config"].(map[string]any)
+	if run["Cmd"] != nil || run["Env"] == nil {
+		t.Errorf("config = %v, want the base's Env, without its Cmd", run)
+	}
+	if diffIDs := config["rootfs"].(map[string]any)["diff_ids"].([]any); len(diffIDs) != 2 {
+		t.Errorf("diff_ids = %v", diffIDs)
+	}
+
+	_, err = publishImage(t.Context(), newRegistryClient(nil), reg.host()+"/app", reg.host()+"/base", "app", fakeBinaries(t, "linux/arm64"))
+	if err == nil || !strings.Contains(err.Error(), "has no image for linux/arm64") {
+		t.Errorf("publishing arm64 = %v, want an error", err)
+	}
+}
+
+func TestPublishImageNoLinux(t *testing.T) {
+	_, err := publishImage(t.Context(), newRegistryClient(nil), "example.com/app", scratchImage, "app", fakeBinaries(t, "darwin/arm64"))
+	if err == nil {
+		t.Errorf("publishImage() succeeded with no linux targets")
+	}
+}
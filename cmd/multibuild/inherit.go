@@ -0,0 +1,78 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// The name of a file of directives, which provide defaults for every package in its directory
+// and the directories below it, within the module.
+const inheritedConfigName = ".multibuild"
+
+// Returns the paths of the inherited configuration files which apply to the package in 'dir',
+// from the root of the module down to 'dir' itself, so that nearer files take precedence.
+// The module's configuration file (see configFileName), if it has one, comes first.
+func inheritedConfigPaths(dir string) []string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil
+	}
+	own := filepath.Join(dir, inheritedConfigName)
+	var paths []string
+	for {
+		path := filepath.Join(dir, inheritedConfigName)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			paths = append(paths, path)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			// The module's configuration file applies beneath everything else.
+			path := filepath.Join(dir, configFileName)
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+				paths = append(paths, path)
+			}
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			// Not in a module, so there is no telling where the project starts: only
+			// the package's own directory counts.
+			return slices.DeleteFunc(paths, func(path string) bool { return path != own })
+		}
+		dir = parent
+	}
+	slices.Reverse(paths)
+	return paths
+}
+
+// Returns a configuration layer for each inherited configuration file applying to 'sources',
+// in increasing order of precedence.
+func inheritedConfig(sources []string) ([]options, error) {
+	if len(sources) == 0 {
+		return nil, nil
+	}
+	var layers []options
+	wd, _ := os.Getwd()
+	for _, path := range inheritedConfigPaths(filepath.Dir(sources[0])) {
+		// Like sources, paths are kept short for messages.
+		if rel, err := filepath.Rel(wd, path); err == nil && wd != "" {
+			path = rel
+		}
+		var layer options
+		var err error
+		if filepath.Base(path) == configFileName {
+			layer, err = loadConfigFile(path)
+		} else {
+			layer, err = scanDirectives([]string{path}, options{})
+		}
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, layer)
+	}
+	return layers, nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Writes 'files' under 'dir'.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestInheritedConfigPaths(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		".multibuild":             "",
+		"mod/go.mod":              "module example.com/app\n",
+		"mod/multibuild.toml":     "",
+		"mod/cmd/multibuild.toml": "",
+		"mod/.multibuild":         "",
+		"mod/cmd/.multibuild":     "",
+		"mod/cmd/app/main.go":     "package main\n",
+		"mod/cmd/app/.multibuild": "",
+		"loose/.multibuild":       "",
+		"loose/pkg/.multibuild":   "",
+	})
+
+	// Files above the module aren't used, and only the module root's configuration file is.
+	got := inheritedConfigPaths(filepath.Join(dir, "mod", "cmd", "app"))
+	want := []string{
+		filepath.Join(dir, "mod", "multibuild.toml"),
+		filepath.Join(dir, "mod", ".multibuild"),
+		filepath.Join(dir, "mod", "cmd", ".multibuild"),
+		filepath.Join(dir, "mod", "cmd", "app", ".multibuild"),
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Outside a module, only the package's own file is.
+	got = inheritedConfigPaths(filepath.Join(dir, "loose", "pkg"))
+	if want := []string{filepath.Join(dir, "loose", "pkg", ".multibuild")}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLoadConfigInherited(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"go.mod":          "module example.com/app\n",
+		".multibuild":     "//go:multibuild:include=linux/*,darwin/*\n//go:multibuild:exclude=linux/386\n//go:multibuild:format=tar.gz\n",
+		"cmd/.multibuild": "//go:multibuild:format=zip\n",
+		"cmd/a/main.go":   "//go:multibuild:include=linux/*\npackage main\n",
+		"cmd/b/main.go":   "package main\n",
+	})
+	t.Chdir(dir)
+
+	a, err := loadConfig([]string{filepath.Join("cmd", "a", "main.go")}, options{}, testPlatforms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(a.Include, []filter{"linux/*"}) || !slices.Contains(a.Exclude, "linux/386") || !slices.Equal(a.Format, []format{formatZip}) {
+		t.Errorf("a: got include=%v exclude=%v format=%v", a.Include, a.Exclude, a.Format)
+	}
+	if o := a.originOf(settingKey("exclude", "linux/386")); len(o) != 1 || o[0].location != ".multibuild:2" {
+		t.Errorf("a: got exclude origins %v", o)
+	}
+
+	b, err := loadConfig([]string{filepath.Join("cmd", "b", "main.go")}, options{}, testPlatforms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(b.Include, []filter{"linux/*", "darwin/*"}) || !slices.Equal(b.Format, []format{formatZip}) {
+		t.Errorf("b: got include=%v format=%v", b.Include, b.Format)
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"strings"
+)
+
+// Settings gathered by 'multibuild init', as raw directive values.
+type initSettings struct {
+	include string
+	exclude string
+	output  string
+	format  string
+}
+
+// Returns the directive lines to write for these settings.
+func (this initSettings) directives() []string {
+	var lines []string
+	lines = append(lines, "//go:multibuild:include="+this.include)
+	if this.exclude != "" {
+		lines = append(lines, "//go:multibuild:exclude="+this.exclude)
+	}
+	lines = append(lines, "//go:multibuild:output="+this.output)
+	lines = append(lines, "//go:multibuild:format="+this.format)
+	return lines
+}
+
+// Checks that all settings are well-formed.
+func (this initSettings) validate() error {
+	if _, err := validateFilterString(this.include); err != nil {
+		return fmt.Errorf("include=%s is invalid: %s", this.include, err)
+	}
+	if this.exclude != "" {
+		if _, err := validateFilterString(this.exclude); err != nil {
+			return fmt.Errorf("exclude=%s is invalid: %s", this.exclude, err)
+		}
+	}
+	if _, err := validateTemplate(this.output); err != nil {
+		return fmt.Errorf("output=%s is invalid: %s", this.output, err)
+	}
+	if _, err := validateFormatString(this.format); err != nil {
+		return fmt.Errorf("format=%s is invalid: %s", this.format, err)
+	}
+	return nil
+}
+
+// Returns true if stdin looks like someone is there to answer questions.
+func isInteractive() bool {
+	st, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return st.Mode()&os.ModeCharDevice != 0
+}
+
+// Asks for a value on 'out', reading the answer from 'in'.
+// An empty answer (or end of input) selects 'def'.
+// The answer is re-requested until 'validate' accepts it.
+func prompt(in *bufio.Reader, out io.Writer, question, def string, validate func(string) error) (string, error) {
+	for {
+		fmt.Fprintf(out, "%s [%s]: ", question, def)
+		line, err := in.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		if err == io.EOF {
+			fmt.Fprintln(out)
+		}
+		answer := strings.TrimSpace(line)
+		if answer == "" {
+			answer = def
+		}
+		if answer == "" {
+			return "", nil
+		}
+		if verr := validate(answer); verr != nil {
+			fmt.Fprintf(out, "%s\n", verr)
+			if err == io.EOF {
+				return "", verr
+			}
+			continue
+		}
+		return answer, nil
+	}
+}
+
+// Finds the file declaring func main among 'sources'.
+func findMainFile(sources []string) (string, error) {
+	fset := token.NewFileSet()
+	for _, path := range sources {
+		f, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return "", err
+		}
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if ok && fn.Recv == nil && fn.Name.Name == "main" {
+				return path, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no func main found, is this a main package?")
+}
+
+// Returns 'src' with 'directives' inserted after the package clause.
+func insertDirectives(src []byte, path string, directives []string) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, src, parser.PackageClauseOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	// Split after the line holding the package clause.
+	line := fset.Position(f.Name.End()).Line
+	lines := bytes.SplitAfter(src, []byte("\n"))
+	head := bytes.Join(lines[:line], nil)
+	tail := bytes.Join(lines[line:], nil)
+
+	var out bytes.Buffer
+	out.Write(head)
+	if !bytes.HasSuffix(head, []byte("\n")) {
+		out.WriteString("\n")
+	}
+	out.WriteString("\n")
+	for _, d := range directives {
+		out.WriteString(d + "\n")
+	}
+	if len(tail) > 0 && !bytes.HasPrefix(tail, []byte("\n")) {
+		out.WriteString("\n")
+	}
+	out.Write(tail)
+	return out.Bytes(), nil
+}
+
+// Implements 'multibuild init'.
+func doInit(self string, argv []string) {
+	fs := flag.NewFlagSet(self+" init", flag.ExitOnError)
+	include := fs.String("include", "", "targets to include (default */*)")
+	exclude := fs.String("exclude", "", "targets to exclude")
+	output := fs.String("output", "", "output template (default ${TARGET}-${GOOS}-${GOARCH})")
+	format := fs.String("format", "", "output formats (default raw)")
+	fs.Parse(argv)
+
+	packagePath := "."
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		packagePath = fs.Arg(0)
+	default:
+		fatal("multibuild: init takes at most one package")
+	}
+
+	sources, _, err := sourcesList(packagePath)
+	if err != nil {
+		fatal("multibuild: failed to discover sources: %s", err)
+	}
+	for _, path := range sources {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			fatal("multibuild: %s", err)
+		}
+		if bytes.Contains(buf, []byte("//go:multibuild:")) {
+			fatal("multibuild: %s already contains go:multibuild directives", path)
+		}
+	}
+	mainFile, err := findMainFile(sources)
+	if err != nil {
+		fatal("multibuild: %s: %s", packagePath, err)
+	}
+
+	settings := initSettings{include: *include, exclude: *exclude, output: *output, format: *format}
+	if isInteractive() {
+		in := bufio.NewReader(os.Stdin)
+		ask := func(value *string, question, def string, validate func(string) error) {
+			if *value != "" {
+				return
+			}
+			answer, err := prompt(in, os.Stderr, question, def, validate)
+			if err != nil {
+				fatal("multibuild: %s", err)
+			}
+			*value = answer
+		}
+		validFilters := func(s string) error { _, err := validateFilterString(s); return err }
+		ask(&settings.include, "Platforms to build", "*/*", validFilters)
+		ask(&settings.exclude, "Platforms to skip", "", validFilters)
+		ask(&settings.output, "Output layout", "${TARGET}-${GOOS}-${GOARCH}", func(s string) error { _, err := validateTemplate(s); return err })
+		ask(&settings.format, "Output formats", "raw", func(s string) error { _, err := validateFormatString(s); return err })
+	}
+	if settings.include == "" {
+		settings.include = "*/*"
+	}
+	if settings.output == "" {
+		settings.output = "${TARGET}-${GOOS}-${GOARCH}"
+	}
+	if settings.format == "" {
+		settings.format = "raw"
+	}
+	if err := settings.validate(); err != nil {
+		fatal("multibuild: %s", err)
+	}
+
+	src, err := os.ReadFile(mainFile)
+	if err != nil {
+		fatal("multibuild: %s", err)
+	}
+	src, err = insertDirectives(src, mainFile, settings.directives())
+	if err != nil {
+		fatal("multibuild: %s", err)
+	}
+	if err := os.WriteFile(mainFile, src, 0644); err != nil {
+		fatal("multibuild: %s", err)
+	}
+	fmt.Fprintf(os.Stderr, "multibuild: wrote configuration to %s\n", mainFile)
+}
@@ -0,0 +1,99 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestInsertDirectives(t *testing.T) {
+	directives := []string{"//go:multibuild:include=linux/*", "//go:multibuild:format=raw"}
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "package then import",
+			in:   "package main\n\nimport \"fmt\"\n",
+			want: "package main\n\n//go:multibuild:include=linux/*\n//go:multibuild:format=raw\n\nimport \"fmt\"\n",
+		},
+		{
+			name: "doc comment is preserved above the package",
+			in:   "// Hello.\npackage main\nfunc main() {}\n",
+			want: "// Hello.\npackage main\n\n//go:multibuild:include=linux/*\n//go:multibuild:format=raw\n\nfunc main() {}\n",
+		},
+		{
+			name: "no trailing newline",
+			in:   "package main",
+			want: "package main\n\n//go:multibuild:include=linux/*\n//go:multibuild:format=raw\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := insertDirectives([]byte(tt.in), "main.go", directives)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInitSettingsValidate(t *testing.T) {
+	good := initSettings{include: "linux/*", output: "${TARGET}-${GOOS}-${GOARCH}", format: "raw"}
+	if err := good.validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	bad := good
+	bad.exclude = "linux/amd*"
+	if err := bad.validate(); err == nil {
+		t.Errorf("expected error for invalid exclude")
+	}
+
+	bad = good
+	bad.output = "${GOOS}"
+	if err := bad.validate(); err == nil {
+		t.Errorf("expected error for invalid output")
+	}
+}
+
+func TestPrompt(t *testing.T) {
+	validFilters := func(s string) error { _, err := validateFilterString(s); return err }
+
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"answer", "linux/*\n", "linux/*", false},
+		{"default", "\n", "*/*", false},
+		{"end of input", "", "*/*", false},
+		{"retry after invalid", "linux/amd*\nlinux/amd64\n", "linux/amd64", false},
+		{"invalid at end of input", "linux/amd*", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := bufio.NewReader(strings.NewReader(tt.input))
+			got, err := prompt(in, io.Discard, "Platforms", "*/*", validFilters)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Linker flags for the targets matching a filter, added to those of ldflags=.
+// e.g. //go:multibuild:ldflags.windows/*=-H windowsgui
+type targetLdflags struct {
+	filter filter
+	flags  string
+}
+
+// Validates that 's' is a list of linker flags.
+func validateLdflags(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", fmt.Errorf("empty string is not a valid list of flags")
+	}
+	if !strings.HasPrefix(s, "-") {
+		return "", fmt.Errorf("%q does not start with a flag", s)
+	}
+	return s, nil
+}
+
+// Validates an ldflags.FILTER directive, 'key' being the filter and 'value' the flags.
+func validateTargetLdflags(key, value string) (targetLdflags, error) {
+	filters, err := validateFilterString(key)
+	if err != nil {
+		return targetLdflags{}, err
+	}
+	if len(filters) != 1 {
+		return targetLdflags{}, fmt.Errorf("expected a single filter, got %q", key)
+	}
+	flags, err := validateLdflags(value)
+	if err != nil {
+		return targetLdflags{}, err
+	}
+	return targetLdflags{filter: filters[0], flags: flags}, nil
+}
+
+// Returns the linker flags set for 't': those of ldflags=, followed by those of each
+// ldflags.FILTER matching it, in order, so that they take precedence.
+func (this options) ldflagsFor(t target) string {
+	flags := []string{}
+	if this.Ldflags != "" {
+		flags = append(flags, this.Ldflags)
+	}
+	for _, tl := range this.TargetLdflags {
+		if tl.filter.matches(t) {
+			flags = append(flags, tl.flags)
+		}
+	}
+	return strings.Join(flags, " ")
+}
+
+// Returns 'goBuildArgs', with the linker flags set for 't' added before any -ldflags already given,
+// so that those given on the command line take precedence.
+func (this options) ldflagsArgs(goBuildArgs []string, t target) []string {
+	flags := this.ldflagsFor(t)
+	if flags == "" {
+		return goBuildArgs
+	}
+	return editLdflags(goBuildArgs, func(ldflags string) string { return strings.TrimSpace(flags + " " + ldflags) })
+}
+
+// Returns a copy of 'goBuildArgs', with the value of the last -ldflags replaced by 'edit' of it,
+// or if there is none, with -ldflags added with 'edit' of "". go build only uses the last one.
+func editLdflags(goBuildArgs []string, edit func(string) string) []string {
+	args := append([]string(nil), goBuildArgs...)
+	for i := len(args) - 1; i >= 0; i-- {
+		arg := strings.TrimPrefix(args[i], "-")
+		switch {
+		case strings.HasPrefix(arg, "-ldflags=") || strings.HasPrefix(arg, "ldflags="):
+			name, value, _ := strings.Cut(args[i], "=")
+			args[i] = name + "=" + edit(value)
+			return args
+		case (arg == "-ldflags" || arg == "ldflags") && i+1 < len(args):
+			args[i+1] = edit(args[i+1])
+			return args
+		}
+	}
+	return append([]string{"-ldflags=" + edit("")}, args...)
+}
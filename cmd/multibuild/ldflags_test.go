@@ -0,0 +1,63 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLdflagsArgs(t *testing.T) {
+	opts := options{
+		Ldflags: "-s -w",
+		TargetLdflags: []targetLdflags{
+			{filter: "windows/*", flags: "-H windowsgui"},
+			{filter: "*/amd64", flags: "-X main.fast=1"},
+		},
+	}
+	tests := []struct {
+		name   string
+		target target
+		args   []string
+		want   []string
+	}{
+		{
+			name:   "no ldflags given",
+			target: "linux/arm64",
+			args:   []string{"-o", "out", "."},
+			want:   []string{"-ldflags=-s -w", "-o", "out", "."},
+		},
+		{
+			name:   "every matching filter",
+			target: "windows/amd64",
+			args:   []string{"."},
+			want:   []string{"-ldflags=-s -w -H windowsgui -X main.fast=1", "."},
+		},
+		{
+			name:   "command line last",
+			target: "windows/arm64",
+			args:   []string{"-ldflags=-X main.version=1.0", "."},
+			want:   []string{"-ldflags=-s -w -H windowsgui -X main.version=1.0", "."},
+		},
+		{
+			name:   "separate ldflags",
+			target: "linux/arm64",
+			args:   []string{"-ldflags", "-X main.version=1.0", "."},
+			want:   []string{"-ldflags", "-s -w -X main.version=1.0", "."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := opts.ldflagsArgs(tt.args, tt.target); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	args := []string{"-trimpath", "."}
+	if got := (options{}).ldflagsArgs(args, "linux/amd64"); !slices.Equal(got, args) {
+		t.Errorf("without ldflags: got %q, want %q", got, args)
+	}
+}
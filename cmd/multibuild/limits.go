@@ -0,0 +1,84 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Returned by newLimiter on platforms where limits aren't implemented.
+var errLimitsUnsupported = errors.New("not supported on " + runtime.GOOS)
+
+// Limits on the resources each go build may use, including everything it runs
+// (such as the compiler and linker). Zero means unlimited.
+type buildLimits struct {
+	// Bytes of memory.
+	memory int64
+
+	// CPUs, which may be fractional (e.g. 1.5).
+	cpus float64
+}
+
+// Returns whether any limits are set.
+func (this buildLimits) any() bool {
+	return this.memory > 0 || this.cpus > 0
+}
+
+// Binary units accepted in memory limits, largest first.
+var memoryUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+	{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+}
+
+// Validates that 's' is an amount of memory, in bytes or with a binary unit, e.g. 2GiB or 512M.
+func validateMemoryLimit(s string) (int64, error) {
+	value, size := s, int64(1)
+	for _, unit := range memoryUnits {
+		if v, ok := strings.CutSuffix(s, unit.suffix); ok {
+			value, size = v, unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 1 || n > math.MaxInt64/size {
+		return 0, fmt.Errorf("%q is not an amount of memory, e.g. 2GiB", s)
+	}
+	return n * size, nil
+}
+
+// Formats a memory limit as it is written in a directive.
+func formatMemoryLimit(n int64) string {
+	for _, unit := range memoryUnits[:3] {
+		if n%unit.size == 0 {
+			return strconv.FormatInt(n/unit.size, 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// Validates that 's' is a number of CPUs, e.g. 2 or 0.5.
+func validateCPULimit(s string) (float64, error) {
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("%q is not a number of CPUs", s)
+	}
+	if n < 0.01 {
+		return 0, fmt.Errorf("must be at least 0.01, got %s", s)
+	}
+	return n, nil
+}
+
+// Formats a CPU limit as it is written in a directive.
+func formatCPULimit(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
@@ -0,0 +1,209 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// Where the cgroup v2 hierarchy is mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// The period cpu.max quotas are given over, in microseconds.
+const cgroupCPUPeriod = 100000
+
+// Applies limits to builds by starting each in its own cgroup, under a cgroup for the run,
+// which is created under the cgroup multibuild runs in. Everything a build runs stays in its
+// cgroup, so the limits cover the compiler and linker too.
+type limiter struct {
+	dir  string // the cgroup for the run
+	next atomic.Int64
+}
+
+// A build running in its own cgroup.
+type limitedProcess struct {
+	limits buildLimits
+	dir    string
+	fd     int
+}
+
+// Returns a limiter able to apply each of 'limits', or nil if there are none.
+func newLimiter(limits ...buildLimits) (*limiter, error) {
+	if !slices.ContainsFunc(limits, buildLimits.any) {
+		return nil, nil
+	}
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return nil, err
+	}
+	own, err := parseOwnCgroup(string(data))
+	if err != nil {
+		return nil, err
+	}
+	return newCgroupLimiter(filepath.Join(cgroupRoot, own), limits)
+}
+
+// Returns the cgroup v2 path in the contents of /proc/self/cgroup, e.g. "/user.slice".
+func parseOwnCgroup(data string) (string, error) {
+	for line := range strings.SplitSeq(data, "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return path, nil
+		}
+	}
+	return "", errors.New("cgroup v2 is required, but this process isn't in a cgroup v2 hierarchy")
+}
+
+// Returns a limiter creating a cgroup for the run under 'parent'.
+func newCgroupLimiter(parent string, limits []buildLimits) (*limiter, error) {
+	var controllers []string
+	if slices.ContainsFunc(limits, func(l buildLimits) bool { return l.memory > 0 }) {
+		controllers = append(controllers, "memory")
+	}
+	if slices.ContainsFunc(limits, func(l buildLimits) bool { return l.cpus > 0 }) {
+		controllers = append(controllers, "cpu")
+	}
+	if err := enableControllers(parent, controllers); err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(parent, fmt.Sprintf("multibuild-%d", os.Getpid()))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, err
+	}
+	// The run's cgroup never has processes of its own, so this can't conflict with them.
+	if err := writeCgroupFile(dir, "cgroup.subtree_control", "+"+strings.Join(controllers, " +")); err != nil {
+		os.Remove(dir)
+		return nil, err
+	}
+	return &limiter{dir: dir}, nil
+}
+
+// Makes 'controllers' available to the children of the cgroup 'dir'.
+func enableControllers(dir string, controllers []string) error {
+	available, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return fmt.Errorf("cgroup v2 is required: %w", err)
+	}
+	enabled, err := os.ReadFile(filepath.Join(dir, "cgroup.subtree_control"))
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for _, c := range controllers {
+		if !slices.Contains(strings.Fields(string(available)), c) {
+			return fmt.Errorf("the %s controller isn't available in the cgroup %s", c, dir)
+		}
+		if !slices.Contains(strings.Fields(string(enabled)), c) {
+			missing = append(missing, "+"+c)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if err := writeCgroupFile(dir, "cgroup.subtree_control", strings.Join(missing, " ")); err != nil {
+		if errors.Is(err, syscall.EBUSY) {
+			// A cgroup with processes in can't also have children using controllers.
+			return fmt.Errorf("can't enable controllers in the cgroup %s, as other processes are in it; run multibuild in a cgroup of its own (e.g. with systemd-run --user --scope -p Delegate=yes)", dir)
+		}
+		return err
+	}
+	return nil
+}
+
+// Writes 'value' to the control file 'name' of the cgroup 'dir'.
+func writeCgroupFile(dir, name, value string) error {
+	return os.WriteFile(filepath.Join(dir, name), []byte(value), 0644)
+}
+
+// Prepares 'cmd' to start in a new cgroup with 'limits' applied.
+func (this *limiter) prepare(cmd *exec.Cmd, limits buildLimits) (*limitedProcess, error) {
+	if this == nil || !limits.any() {
+		return nil, nil
+	}
+	dir := filepath.Join(this.dir, strconv.FormatInt(this.next.Add(1), 10))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, err
+	}
+	p := &limitedProcess{limits: limits, dir: dir, fd: -1}
+	if err := p.apply(cmd); err != nil {
+		p.finish()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (this *limitedProcess) apply(cmd *exec.Cmd) error {
+	if this.limits.memory > 0 {
+		if err := writeCgroupFile(this.dir, "memory.max", strconv.FormatInt(this.limits.memory, 10)); err != nil {
+			return err
+		}
+		// Swapping would get around the limit (and slow everything else down), so there's none.
+		if _, err := os.Stat(filepath.Join(this.dir, "memory.swap.max")); err == nil {
+			if err := writeCgroupFile(this.dir, "memory.swap.max", "0"); err != nil {
+				return err
+			}
+		}
+	}
+	if this.limits.cpus > 0 {
+		quota := max(int64(this.limits.cpus*cgroupCPUPeriod), 1000)
+		if err := writeCgroupFile(this.dir, "cpu.max", fmt.Sprintf("%d %d", quota, cgroupCPUPeriod)); err != nil {
+			return err
+		}
+	}
+	fd, err := syscall.Open(this.dir, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	this.fd = fd
+	// Starting the process in the cgroup, rather than moving it there after, means
+	// nothing it starts can escape the limits.
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = fd
+	return nil
+}
+
+// Called once the process has started.
+func (this *limitedProcess) started(p *os.Process) error {
+	return nil
+}
+
+// Cleans up after the process exits, and returns an error if it was killed for going over
+// the memory limit.
+func (this *limitedProcess) finish() error {
+	if this == nil {
+		return nil
+	}
+	if this.fd >= 0 {
+		syscall.Close(this.fd)
+	}
+	var err error
+	if data, rerr := os.ReadFile(filepath.Join(this.dir, "memory.events")); rerr == nil {
+		for line := range strings.SplitSeq(string(data), "\n") {
+			if n, ok := strings.CutPrefix(line, "oom_kill "); ok && n != "0" {
+				err = fmt.Errorf("killed for exceeding the memory limit of %s", formatMemoryLimit(this.limits.memory))
+			}
+		}
+	}
+	os.Remove(this.dir)
+	return err
+}
+
+// Removes the cgroup for the run.
+func (this *limiter) close() {
+	if this != nil {
+		os.Remove(this.dir)
+	}
+}
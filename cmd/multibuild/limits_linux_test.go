@@ -0,0 +1,82 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseOwnCgroup(t *testing.T) {
+	got, err := parseOwnCgroup("12:cpu,cpuacct:/user.slice\n0::/user.slice/user-1000.slice/session-2.scope\n")
+	if err != nil || got != "/user.slice/user-1000.slice/session-2.scope" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	if _, err := parseOwnCgroup("12:cpu,cpuacct:/user.slice\n"); err == nil {
+		t.Error("expected an error without cgroup v2")
+	}
+}
+
+func TestCgroupLimiter(t *testing.T) {
+	// A stand in for a cgroup, as far as the limiter can tell.
+	parent := t.TempDir()
+	write := func(path, data string) {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(path string) string {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	write(filepath.Join(parent, "cgroup.controllers"), "cpuset cpu io memory pids\n")
+	write(filepath.Join(parent, "cgroup.subtree_control"), "cpu\n")
+
+	limits := buildLimits{memory: 1 << 30, cpus: 1.5}
+	l, err := newCgroupLimiter(parent, []buildLimits{limits})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := read(filepath.Join(parent, "cgroup.subtree_control")); got != "+memory" {
+		t.Errorf("parent subtree_control: got %q", got)
+	}
+	if got := read(filepath.Join(l.dir, "cgroup.subtree_control")); got != "+memory +cpu" {
+		t.Errorf("run subtree_control: got %q", got)
+	}
+
+	cmd := exec.Command("true")
+	p, err := l.prepare(cmd, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmd.SysProcAttr.UseCgroupFD {
+		t.Error("the command doesn't start in the cgroup")
+	}
+	if got := read(filepath.Join(p.dir, "memory.max")); got != "1073741824" {
+		t.Errorf("memory.max: got %q", got)
+	}
+	if got := read(filepath.Join(p.dir, "cpu.max")); got != "150000 100000" {
+		t.Errorf("cpu.max: got %q", got)
+	}
+
+	write(filepath.Join(p.dir, "memory.events"), "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n")
+	if err := p.finish(); err == nil || !strings.Contains(err.Error(), "memory limit of 1GiB") {
+		t.Errorf("finish: got %v", err)
+	}
+
+	if _, err := newCgroupLimiter(t.TempDir(), []buildLimits{limits}); err == nil {
+		t.Error("expected an error outside a cgroup")
+	}
+	write(filepath.Join(parent, "cgroup.controllers"), "cpu\n")
+	if _, err := newCgroupLimiter(parent, []buildLimits{limits}); err == nil {
+		t.Error("expected an error without the memory controller")
+	}
+}
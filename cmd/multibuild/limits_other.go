@@ -0,0 +1,35 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !(linux || windows)
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"slices"
+)
+
+type limiter struct{}
+
+type limitedProcess struct{}
+
+// Returns a limiter able to apply each of 'limits', or nil if there are none.
+func newLimiter(limits ...buildLimits) (*limiter, error) {
+	if !slices.ContainsFunc(limits, buildLimits.any) {
+		return nil, nil
+	}
+	return nil, errLimitsUnsupported
+}
+
+func (this *limiter) prepare(cmd *exec.Cmd, limits buildLimits) (*limitedProcess, error) {
+	return nil, nil
+}
+
+func (this *limiter) close() {}
+
+func (this *limitedProcess) started(p *os.Process) error { return nil }
+
+func (this *limitedProcess) finish() error { return nil }
@@ -0,0 +1,55 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestValidateMemoryLimit(t *testing.T) {
+	for _, tc := range []struct {
+		in     string
+		want   int64
+		format string
+	}{
+		{"2GiB", 2 << 30, "2GiB"},
+		{"512M", 512 << 20, "512MiB"},
+		{"1536MiB", 1536 << 20, "1536MiB"},
+		{"64K", 64 << 10, "64KiB"},
+		{"1000", 1000, "1000"},
+	} {
+		got, err := validateMemoryLimit(tc.in)
+		if err != nil {
+			t.Errorf("%s: %s", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.in, got, tc.want)
+		}
+		if f := formatMemoryLimit(got); f != tc.format {
+			t.Errorf("%s: formatted as %s, want %s", tc.in, f, tc.format)
+		}
+	}
+	for _, in := range []string{"", "GiB", "0", "-1G", "1.5G", "2TB", "9999999999999G"} {
+		if _, err := validateMemoryLimit(in); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
+
+func TestValidateCPULimit(t *testing.T) {
+	for in, want := range map[string]float64{"1": 1, "1.5": 1.5, "0.25": 0.25} {
+		got, err := validateCPULimit(in)
+		if err != nil || got != want {
+			t.Errorf("%s: got %v, %v", in, got, err)
+		}
+		if f := formatCPULimit(got); f != in {
+			t.Errorf("%s: formatted as %s", in, f)
+		}
+	}
+	for _, in := range []string{"", "0", "0.001", "-1", "NaN", "Inf", "two"} {
+		if _, err := validateCPULimit(in); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
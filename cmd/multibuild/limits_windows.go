@@ -0,0 +1,173 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"syscall"
+	"unsafe"
+)
+
+// See SetInformationJobObject and OpenProcess.
+const (
+	jobObjectExtendedLimitInformationClass  = 9
+	jobObjectCPURateControlInformationClass = 15
+
+	jobObjectLimitJobMemory        = 0x00000200
+	jobObjectLimitKillOnJobClose   = 0x00002000
+	jobObjectCPURateControlEnable  = 0x1
+	jobObjectCPURateControlHardCap = 0x4
+
+	processSetQuota = 0x0100
+)
+
+var (
+	procCreateJobObjectW          = syscall.NewLazyDLL("kernel32.dll").NewProc("CreateJobObjectW")
+	procSetInformationJobObject   = syscall.NewLazyDLL("kernel32.dll").NewProc("SetInformationJobObject")
+	procQueryInformationJobObject = syscall.NewLazyDLL("kernel32.dll").NewProc("QueryInformationJobObject")
+	procAssignProcessToJobObject  = syscall.NewLazyDLL("kernel32.dll").NewProc("AssignProcessToJobObject")
+)
+
+type jobObjectBasicLimitInformation struct {
+	PerProcessUserTimeLimit int64
+	PerJobUserTimeLimit     int64
+	LimitFlags              uint32
+	MinimumWorkingSetSize   uintptr
+	MaximumWorkingSetSize   uintptr
+	ActiveProcessLimit      uint32
+	Affinity                uintptr
+	PriorityClass           uint32
+	SchedulingClass         uint32
+
+	// The structure is 8-byte aligned, which Go doesn't do for 32-bit platforms.
+	_ [8 - unsafe.Sizeof(uintptr(0))]byte
+}
+
+type jobObjectExtendedLimitInformation struct {
+	BasicLimitInformation jobObjectBasicLimitInformation
+	IoInfo                [6]uint64
+	ProcessMemoryLimit    uintptr
+	JobMemoryLimit        uintptr
+	PeakProcessMemoryUsed uintptr
+	PeakJobMemoryUsed     uintptr
+}
+
+type jobObjectCPURateControlInformation struct {
+	ControlFlags uint32
+	CPURate      uint32
+}
+
+// Applies limits to builds by putting each in its own job object. Processes started by a
+// process in a job are in it too, so the limits cover the compiler and linker.
+type limiter struct{}
+
+// A build running in its own job object.
+type limitedProcess struct {
+	limits buildLimits
+	job    syscall.Handle
+}
+
+// Returns a limiter able to apply each of 'limits', or nil if there are none.
+func newLimiter(limits ...buildLimits) (*limiter, error) {
+	if !slices.ContainsFunc(limits, buildLimits.any) {
+		return nil, nil
+	}
+	if err := procCreateJobObjectW.Find(); err != nil {
+		return nil, err
+	}
+	return &limiter{}, nil
+}
+
+// Prepares to start 'cmd' in a new job object with 'limits' applied.
+func (this *limiter) prepare(cmd *exec.Cmd, limits buildLimits) (*limitedProcess, error) {
+	if this == nil || !limits.any() {
+		return nil, nil
+	}
+	job, _, err := procCreateJobObjectW.Call(0, 0)
+	if job == 0 {
+		return nil, err
+	}
+	p := &limitedProcess{limits: limits, job: syscall.Handle(job)}
+
+	// Anything still running when the build finishes is killed as the job is closed.
+	info := jobObjectExtendedLimitInformation{}
+	info.BasicLimitInformation.LimitFlags = jobObjectLimitKillOnJobClose
+	if limits.memory > 0 {
+		info.BasicLimitInformation.LimitFlags |= jobObjectLimitJobMemory
+		info.JobMemoryLimit = uintptr(min(limits.memory, int64(^uintptr(0)>>1)))
+	}
+	if err := p.set(jobObjectExtendedLimitInformationClass, unsafe.Pointer(&info), unsafe.Sizeof(info)); err != nil {
+		p.finish()
+		return nil, err
+	}
+	if limits.cpus > 0 {
+		// The rate is in hundredths of a percent of every CPU.
+		rate := int(limits.cpus * 10000 / float64(runtime.NumCPU()))
+		cpu := jobObjectCPURateControlInformation{
+			ControlFlags: jobObjectCPURateControlEnable | jobObjectCPURateControlHardCap,
+			CPURate:      uint32(min(max(rate, 1), 10000)),
+		}
+		if err := p.set(jobObjectCPURateControlInformationClass, unsafe.Pointer(&cpu), unsafe.Sizeof(cpu)); err != nil {
+			p.finish()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (this *limitedProcess) set(class uintptr, info unsafe.Pointer, size uintptr) error {
+	if ok, _, err := procSetInformationJobObject.Call(uintptr(this.job), class, uintptr(info), size); ok == 0 {
+		return err
+	}
+	return nil
+}
+
+// Called once the process has started, to put it in the job.
+//
+// Windows can only put a process in a job once it exists, so anything the process starts
+// before this isn't limited. go build spends a moment loading packages before it starts
+// the compiler, so in practice everything is.
+func (this *limitedProcess) started(p *os.Process) error {
+	if this == nil {
+		return nil
+	}
+	process, err := syscall.OpenProcess(processSetQuota|syscall.PROCESS_TERMINATE, false, uint32(p.Pid))
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(process)
+	if ok, _, err := procAssignProcessToJobObject.Call(uintptr(this.job), uintptr(process)); ok == 0 {
+		return err
+	}
+	return nil
+}
+
+// Cleans up after the process exits, and returns an error if it ran out of memory under
+// the memory limit.
+func (this *limitedProcess) finish() error {
+	if this == nil {
+		return nil
+	}
+	defer syscall.CloseHandle(this.job)
+	if this.limits.memory == 0 {
+		return nil
+	}
+	info := jobObjectExtendedLimitInformation{}
+	if ok, _, _ := procQueryInformationJobObject.Call(uintptr(this.job), jobObjectExtendedLimitInformationClass, uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info), 0); ok == 0 {
+		return nil
+	}
+	// Allocations past the limit fail rather than being killed, so tell by how close it came.
+	if int64(info.PeakJobMemoryUsed) >= this.limits.memory-this.limits.memory/100 {
+		return fmt.Errorf("ran out of memory under the memory limit of %s", formatMemoryLimit(this.limits.memory))
+	}
+	return nil
+}
+
+// Called once every build has finished.
+func (this *limiter) close() {}
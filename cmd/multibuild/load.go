@@ -0,0 +1,101 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Returned by readLoad on platforms where it isn't implemented.
+var errLoadUnsupported = errors.New("not supported on " + runtime.GOOS)
+
+// How busy the machine is.
+type loadSample struct {
+	// The load average over the last minute, and the number of runnable threads right now.
+	// The load average is smoothed, so it lags behind builds starting and finishing.
+	load1    float64
+	runnable int
+
+	// Bytes of memory available without swapping.
+	memAvailable uint64
+}
+
+const (
+	// How often the load is checked while waiting to start another build.
+	loadPollInterval = 500 * time.Millisecond
+
+	// How long a build is given to get going before the load is checked again, as
+	// go build takes a moment to start compiling.
+	loadSettleTime = 2 * time.Second
+
+	// How much memory must be available to start another build. Linking a large
+	// binary can take this much by itself.
+	loadMemPerBuild = 1 << 30
+)
+
+// Decides when another target may start building with parallel=auto: as long as the
+// machine has idle CPUs and enough memory, up to 'max' builds run at once, otherwise
+// builds wait. A single build is always allowed, so that the run makes progress.
+type loadGovernor struct {
+	max  int
+	cpus int
+	read func() (loadSample, error)
+
+	mu        sync.Mutex
+	lastStart time.Time
+}
+
+// Returns a governor for parallel=auto, allowing up to a build per CPU.
+func newLoadGovernor() *loadGovernor {
+	return &loadGovernor{max: runtime.NumCPU(), cpus: runtime.NumCPU(), read: readLoad}
+}
+
+// Returns whether another build may start, with 'running' going already.
+func (this *loadGovernor) allow(running int, now time.Time) bool {
+	if running == 0 {
+		return true
+	}
+	if running >= this.max {
+		return false
+	}
+	this.mu.Lock()
+	settling := now.Sub(this.lastStart) < loadSettleTime
+	this.mu.Unlock()
+	if settling {
+		return false
+	}
+	sample, err := this.read()
+	if err != nil {
+		// Without a way to tell, use every CPU, as a fixed parallel= would.
+		return true
+	}
+	busy := max(sample.load1, float64(sample.runnable))
+	return busy < float64(this.cpus) && sample.memAvailable >= loadMemPerBuild
+}
+
+// Waits until another build may start, as decided by allow, with 'running' telling how many
+// are going. Returns false if 'ctx' is done first. A nil governor doesn't wait.
+func (this *loadGovernor) wait(ctx context.Context, running func() int) bool {
+	if this == nil {
+		return ctx.Err() == nil
+	}
+	ticker := time.NewTicker(loadPollInterval)
+	defer ticker.Stop()
+	for !this.allow(running(), time.Now()) {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	this.mu.Lock()
+	this.lastStart = time.Now()
+	this.mu.Unlock()
+	return ctx.Err() == nil
+}
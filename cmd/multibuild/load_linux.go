@@ -0,0 +1,61 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Returns how busy the machine is, from /proc.
+func readLoad() (loadSample, error) {
+	loadavg, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return loadSample{}, err
+	}
+	meminfo, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return loadSample{}, err
+	}
+	return parseLoad(string(loadavg), string(meminfo))
+}
+
+// Parses the contents of /proc/loadavg and /proc/meminfo.
+func parseLoad(loadavg, meminfo string) (loadSample, error) {
+	// e.g. "0.52 0.58 0.59 3/1234 5678": the load averages, then runnable/total threads.
+	var sample loadSample
+	fields := strings.Fields(loadavg)
+	if len(fields) < 4 {
+		return loadSample{}, fmt.Errorf("unexpected /proc/loadavg: %q", loadavg)
+	}
+	load1, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return loadSample{}, fmt.Errorf("unexpected /proc/loadavg: %q", loadavg)
+	}
+	runnable, _, _ := strings.Cut(fields[3], "/")
+	n, err := strconv.Atoi(runnable)
+	if err != nil {
+		return loadSample{}, fmt.Errorf("unexpected /proc/loadavg: %q", loadavg)
+	}
+	// Not counting this process, which is running to read it.
+	sample.load1, sample.runnable = load1, max(n-1, 0)
+
+	// e.g. "MemAvailable:   12345678 kB"
+	for line := range strings.SplitSeq(meminfo, "\n") {
+		value, ok := strings.CutPrefix(line, "MemAvailable:")
+		if !ok {
+			continue
+		}
+		kb, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(value), " kB"), 10, 64)
+		if err != nil {
+			return loadSample{}, fmt.Errorf("unexpected /proc/meminfo: %q", line)
+		}
+		sample.memAvailable = kb * 1024
+		return sample, nil
+	}
+	return loadSample{}, fmt.Errorf("no MemAvailable in /proc/meminfo")
+}
@@ -0,0 +1,32 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestParseLoad(t *testing.T) {
+	meminfo := "MemTotal:       16307636 kB\nMemFree:         1044580 kB\nMemAvailable:    8388608 kB\n"
+	got, err := parseLoad("1.50 0.58 0.59 3/1234 5678\n", meminfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (loadSample{load1: 1.5, runnable: 2, memAvailable: 8 << 30}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	for _, tc := range [][2]string{
+		{"", meminfo},
+		{"x 0.58 0.59 3/1234 5678", meminfo},
+		{"1.50 0.58 0.59 3/1234 5678", "MemTotal: 1 kB\n"},
+	} {
+		if _, err := parseLoad(tc[0], tc[1]); err == nil {
+			t.Errorf("parseLoad(%q, %q) succeeded", tc[0], tc[1])
+		}
+	}
+
+	if _, err := readLoad(); err != nil {
+		t.Errorf("readLoad: %s", err)
+	}
+}
@@ -0,0 +1,12 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package main
+
+// Returns how busy the machine is.
+func readLoad() (loadSample, error) {
+	return loadSample{}, errLoadUnsupported
+}
@@ -0,0 +1,69 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLoadGovernor(t *testing.T) {
+	var sample loadSample
+	var readErr error
+	g := &loadGovernor{max: 4, cpus: 4, read: func() (loadSample, error) { return sample, readErr }}
+	now := time.Now()
+
+	tcs := []struct {
+		name    string
+		running int
+		sample  loadSample
+		err     error
+		want    bool
+	}{
+		{"first build", 0, loadSample{load1: 100, runnable: 100}, nil, true},
+		{"idle", 1, loadSample{load1: 1, runnable: 1, memAvailable: 8 << 30}, nil, true},
+		{"busy now", 1, loadSample{load1: 1, runnable: 4, memAvailable: 8 << 30}, nil, false},
+		{"busy lately", 1, loadSample{load1: 4.5, runnable: 1, memAvailable: 8 << 30}, nil, false},
+		{"short of memory", 1, loadSample{load1: 1, runnable: 1, memAvailable: 512 << 20}, nil, false},
+		{"at most max", 4, loadSample{memAvailable: 8 << 30}, nil, false},
+		{"unknown load", 3, loadSample{}, errLoadUnsupported, true},
+	}
+	for _, tc := range tcs {
+		sample, readErr = tc.sample, tc.err
+		if got := g.allow(tc.running, now); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	// Builds get a moment to start before the load is looked at again.
+	sample, readErr = loadSample{memAvailable: 8 << 30}, nil
+	if !g.wait(context.Background(), func() int { return 1 }) {
+		t.Fatal("wait failed on an idle machine")
+	}
+	if g.allow(1, time.Now()) {
+		t.Error("allowed another build straight after starting one")
+	}
+	if !g.allow(1, time.Now().Add(loadSettleTime)) {
+		t.Error("didn't allow another build once the last one settled")
+	}
+
+	// Waiting gives up when the context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 3*loadPollInterval)
+	defer cancel()
+	sample = loadSample{runnable: 100}
+	if g.wait(ctx, func() int { return 1 }) {
+		t.Error("wait succeeded on a busy machine")
+	}
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		t.Errorf("wait returned before the context was done: %v", ctx.Err())
+	}
+
+	var none *loadGovernor
+	if !none.wait(context.Background(), func() int { return 100 }) {
+		t.Error("a nil governor waited")
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Writes a disk image at 'arPath' containing 'outBin', using hdiutil.
+func writeDmg(ctx context.Context, t target, arPath, outBin string, log io.Writer) error {
+	dir, err := stageBinary(outBin)
+	if err != nil {
+		return fmt.Errorf("failed to stage %s: %s", outBin, err)
+	}
+	defer os.RemoveAll(dir)
+	volname := strings.TrimSuffix(filepath.Base(arPath), ".dmg")
+	return runPackager(t, log, exec.CommandContext(ctx, "hdiutil", "create", "-volname", volname, "-srcfolder", dir, "-ov", "-format", "UDZO", arPath))
+}
+
+// Writes an installer package at 'arPath' which installs 'outBin', using pkgbuild.
+func writePkg(ctx context.Context, t target, arPath, outBin string, info packageInfo, log io.Writer) error {
+	dir, err := stageBinary(outBin)
+	if err != nil {
+		return fmt.Errorf("failed to stage %s: %s", outBin, err)
+	}
+	defer os.RemoveAll(dir)
+	return runPackager(t, log, exec.CommandContext(ctx, "pkgbuild", "--root", dir, "--identifier", info.Identifier,
+		"--version", info.Version, "--install-location", info.installDir(), arPath))
+}
@@ -0,0 +1,82 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestModuleIdentifier(t *testing.T) {
+	for in, want := range map[string]string{
+		"example.com/app":            "com.example.app",
+		"github.com/rburchell/multi": "com.github.rburchell.multi",
+		"app":                        "app",
+	} {
+		if got := moduleIdentifier(in); got != want {
+			t.Errorf("%s: got %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestPackageVersion(t *testing.T) {
+	for in, want := range map[string]string{
+		"v1.2.3":           "1.2.3",
+		"v1.2.3-4-gabcdef": "1.2.3-4-gabcdef",
+		"abcdef":           "abcdef",
+		"unknown":          "0",
+	} {
+		if got := packageVersion(in); got != want {
+			t.Errorf("%s: got %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestWritePkg(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake pkgbuild is a shell script")
+	}
+
+	// A fake pkgbuild, which records its arguments and the staged files in the package.
+	bin := t.TempDir()
+	fake := "#!/bin/sh\necho \"$@\" > \"$FAKE_PKG\"\nls -l \"$2\" >> \"$FAKE_PKG\"\neval out=\\${$#}\ncp \"$FAKE_PKG\" \"$out\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "pkgbuild"), []byte(fake), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+	t.Setenv("FAKE_PKG", filepath.Join(dir, "args"))
+	outBin := filepath.Join(dir, "app-darwin-arm64")
+	if err := os.WriteFile(outBin, []byte("binary"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	arPath := outBin + ".pkg"
+	var log bytes.Buffer
+	info := packageInfo{Identifier: "com.example.app", Version: "1.2.3"}
+	if err := writePkg(context.Background(), "darwin/arm64", arPath, outBin, info, &log); err != nil {
+		t.Fatalf("writePkg: %s\n%s", err, log.String())
+	}
+	data, err := os.ReadFile(arPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(data)
+	for _, want := range []string{"--identifier com.example.app", "--version 1.2.3", "--install-location /usr/local/bin", "-rwxr-xr-x", "app-darwin-arm64"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+
+	t.Setenv("PATH", t.TempDir())
+	if err := writeDmg(context.Background(), "darwin/arm64", outBin+".dmg", outBin, &log); err == nil || !strings.Contains(err.Error(), "hdiutil is required") {
+		t.Errorf("expected an error for a missing hdiutil, got %v", err)
+	}
+}
@@ -9,13 +9,25 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 )
 
 func displayUsageAndExit(self string) {
 	fmt.Fprintf(os.Stderr, "usage: %s [-o output] [build flags] [packages]\n", self)
+	fmt.Fprintf(os.Stderr, "       %s init [-include filters] [-exclude filters] [-output template] [-format formats] [package]\n", self)
+	fmt.Fprintf(os.Stderr, "       %s fix [-n] [-all] [packages]\n", self)
+	fmt.Fprintf(os.Stderr, "       %s scaffold docker [-scratch] [-compose] [-force] [package]\n", self)
+	fmt.Fprintf(os.Stderr, "       %s scaffold bazel|please [-force] [package]\n", self)
+	fmt.Fprintf(os.Stderr, "       %s daemon [-v]\n", self)
+	fmt.Fprintf(os.Stderr, "       %s rpc\n", self)
+	fmt.Fprintf(os.Stderr, "       %s stats [-n runs] [-json] [package]\n", self)
+	fmt.Fprintf(os.Stderr, "       %s config diff <gitref|dir> [package]\n", self)
 	fmt.Fprintln(os.Stderr, "multibuild is a thin wrapper around 'go build'.")
 	fmt.Fprintln(os.Stderr, "For documentation on multibuild's configuration, see https://github.com/rburchell/multibuild")
 	fmt.Fprintln(os.Stderr, "Otherwise, run 'go help build' for command line flags.")
@@ -23,18 +35,271 @@ func displayUsageAndExit(self string) {
 	fmt.Fprintln(os.Stderr, "multibuild-specific options:")
 	fmt.Fprintln(os.Stderr, "    -v: enable verbose logs during building. this will also imply `go build -v`")
 	fmt.Fprintln(os.Stderr, "    --multibuild-configuration: display the multibuild configuration parsed from the package")
+	fmt.Fprintln(os.Stderr, "        --explain: also show where each setting came from")
 	fmt.Fprintln(os.Stderr, "    --multibuild-targets: list targets that will be built")
+	fmt.Fprintln(os.Stderr, "    --multibuild-explain=os/arch: explain why a target is or isn't built, and where the settings deciding it came from")
+	fmt.Fprintln(os.Stderr, "    --multibuild-check: type check every target, without building, and report what would fail")
+	fmt.Fprintln(os.Stderr, "    --multibuild-files=os/arch: list the source files built for a target (with -v, also those left out)")
+	fmt.Fprintln(os.Stderr, "    --multibuild-all: build every main package in the module, instead of the packages named")
+	fmt.Fprintln(os.Stderr, "    --multibuild-targets-from=file|-: build exactly the targets listed in file, or on stdin, one os/arch per line")
+	fmt.Fprintln(os.Stderr, "    --multibuild-restrict=filters: only build configured targets that match these filters (e.g. host)")
+	fmt.Fprintln(os.Stderr, "    --multibuild-output=template: name the outputs with template, instead of output=")
+	fmt.Fprintln(os.Stderr, "    --multibuild-version=version: the value of ${VERSION} in output=, instead of what git describe says")
+	fmt.Fprintln(os.Stderr, "    --multibuild-define=NAME=value: fill in ${NAME} in output= with value, as define= does")
+	fmt.Fprintln(os.Stderr, "    --multibuild-parallel=n|auto: build at most n targets at once, or as many as the machine can take")
+	fmt.Fprintln(os.Stderr, "    --multibuild-memory-limit=size, --multibuild-cpu-limit=n: limit the memory and CPUs each build may use")
+	fmt.Fprintln(os.Stderr, "    --multibuild-partial=keep|discard|manifest: what to do with built targets if others fail")
+	fmt.Fprintln(os.Stderr, "    --multibuild-keep-going[=true|false]: build every target when one fails, or with false, stop at the first failure")
+	fmt.Fprintln(os.Stderr, "    --multibuild-manifest=path: write a JSON manifest of the targets built")
+	fmt.Fprintln(os.Stderr, "    --multibuild-schema: print the JSON schema of the manifest, which is versioned, and exit")
+	fmt.Fprintln(os.Stderr, "    --multibuild-summary=markdown|html[:path]: write a summary of the targets built to stdout, or to path")
+	fmt.Fprintln(os.Stderr, "    --multibuild-pushgateway=url: push build metrics to a Prometheus Pushgateway")
+	fmt.Fprintln(os.Stderr, "    --multibuild-trace=path: write a timeline of the build, for chrome://tracing or Perfetto")
+	fmt.Fprintln(os.Stderr, "    --multibuild-events=path|fd:N|-: write an event for each step of each target as JSON lines, to path, a file descriptor, or stdout")
+	fmt.Fprintln(os.Stderr, "    --multibuild-deadline=duration: stop building once the run has taken this long (e.g. 10m)")
+	fmt.Fprintln(os.Stderr, "    --multibuild-preflight: fail before building if there may not be enough disk space")
+	fmt.Fprintln(os.Stderr, "    --multibuild-offline: fail if building would need the network (e.g. to download modules)")
+	fmt.Fprintln(os.Stderr, "    --multibuild-sandbox: build with a scrubbed environment, and private temporary directories")
+	fmt.Fprintln(os.Stderr, "    --multibuild-nice: build at a lower CPU and I/O priority, to keep the machine responsive")
+	fmt.Fprintln(os.Stderr, "    --multibuild-secret-scan: fail if binaries contain anything that looks like a credential")
+	fmt.Fprintln(os.Stderr, "    --multibuild-gocache=dir, --multibuild-gocacheprog=command: the build cache for every target, instead of gocache=")
+	fmt.Fprintln(os.Stderr, "    --multibuild-stamp=package: set build provenance variables in package (see README)")
+	fmt.Fprintln(os.Stderr, "    --multibuild-attest: write an in-toto attestation of how each target was built")
+	fmt.Fprintln(os.Stderr, "    --multibuild-gitignore: add patterns matching the outputs to .gitignore, if they aren't ignored already")
+	fmt.Fprintln(os.Stderr, "    --multibuild-image=ref: the repository to publish an image to, instead of image=")
+	fmt.Fprintln(os.Stderr, "    --multibuild-publish: push an image of the linux targets and print its digest, and upload artifacts")
+	fmt.Fprintln(os.Stderr, "    --multibuild-upload=url: upload artifacts under url when publishing, instead of upload=")
+	fmt.Fprintln(os.Stderr, "    --multibuild-registry-auth=registry=source: where to find credentials for a registry (see README)")
+	fmt.Fprintln(os.Stderr, "    --multibuild-deploy-patch=path: write the updates to deploy= files as a patch, instead of making them")
+	fmt.Fprintln(os.Stderr, "    --multibuild-strict: treat warnings as errors")
 	os.Exit(0)
 }
 
-func displayConfigAndExit(opts options) {
-	fmt.Fprintf(os.Stderr, "//go:multibuild:include=%s\n", strings.Join(mapSlice(opts.Include, func(f filter) string { return string(f) }), ","))
-	fmt.Fprintf(os.Stderr, "//go:multibuild:exclude=%s\n", strings.Join(mapSlice(opts.Exclude, func(f filter) string { return string(f) }), ","))
-	fmt.Fprintf(os.Stderr, "//go:multibuild:output=%s\n", opts.Output)
-	fmt.Fprintf(os.Stderr, "//go:multibuild:format=%s\n", strings.Join(mapSlice(opts.Format, func(f format) string { return string(f) }), ","))
+func displayConfigAndExit(opts options, explain bool) {
+	writeConfig(os.Stderr, opts, explain)
 	os.Exit(0)
 }
 
+// Displays the configuration of each main package in 'pkgs', and exits.
+func displayPackageConfigsAndExit(args cliArgs, pkgs []listedPackage) {
+	allTargets, err := targetList()
+	if err != nil {
+		fatalCode(exitTargets, "multibuild: failed to list targets: %s", err)
+	}
+	first := true
+	for _, pkg := range pkgs {
+		if pkg.Name != "main" {
+			continue
+		}
+		opts, err := loadConfig(pkg.sources(), args.config, allTargets)
+		if err != nil {
+			fatalCode(exitConfig, "multibuild: %s: failed to load configuration: %s", pkg.ImportPath, err)
+		}
+		opts.placeOutputs(args.outputDir)
+		if !first {
+			fmt.Fprintln(os.Stderr)
+		}
+		first = false
+		fmt.Fprintf(os.Stderr, "# %s\n", pkg.ImportPath)
+		writeConfig(os.Stderr, opts, args.explainConfig)
+	}
+	os.Exit(0)
+}
+
+// Writes 'opts' to 'w' as directives, and if explaining, where each setting came from.
+func writeConfig(w io.Writer, opts options, explain bool) {
+	// Prints a list setting, and if explaining, where each value came from.
+	list := func(name string, values []filter) {
+		fmt.Fprintf(w, "//go:multibuild:%s=%s\n", name, strings.Join(mapSlice(values, func(f filter) string { return string(f) }), ","))
+		if explain {
+			seen := make(map[filter]bool)
+			for _, v := range values {
+				if seen[v] {
+					continue
+				}
+				seen[v] = true
+				for _, o := range opts.originOf(settingKey(name, string(v))) {
+					fmt.Fprintf(w, "    %s: %s\n", v, o)
+				}
+			}
+		}
+	}
+	// Prints a setting which takes one value per directive, and if explaining, where each came from.
+	each := func(name string, values []string) {
+		for _, v := range values {
+			fmt.Fprintf(w, "//go:multibuild:%s=%s\n", name, v)
+			if explain {
+				for _, o := range opts.originOf(settingKey(name, v)) {
+					fmt.Fprintf(w, "    %s\n", o)
+				}
+			}
+		}
+	}
+	// Prints a single valued setting, and if explaining, where it came from.
+	single := func(name string, value string) {
+		fmt.Fprintf(w, "//go:multibuild:%s=%s\n", name, value)
+		if explain {
+			for _, o := range opts.originOf(settingKey(name)) {
+				fmt.Fprintf(w, "    %s\n", o)
+			}
+		}
+	}
+
+	list("include", opts.Include)
+	list("exclude", opts.Exclude)
+	single("output", string(opts.Output))
+	each("define", mapSlice(opts.Defines, definedPlaceholder.String))
+	if len(opts.Aliases) > 0 {
+		single("alias", opts.Aliases.String())
+	}
+	if opts.ArchiveEntry != "" {
+		single("archive-entry", string(opts.ArchiveEntry))
+	}
+	single("format", strings.Join(mapSlice(opts.Format, func(f format) string { return string(f) }), ","))
+	single("parallel", formatParallel(opts.Parallel))
+	if opts.Limits.memory != 0 {
+		single("memory-limit", formatMemoryLimit(opts.Limits.memory))
+	}
+	if opts.Limits.cpus != 0 {
+		single("cpu-limit", formatCPULimit(opts.Limits.cpus))
+	}
+	single("partial", string(opts.Partial))
+	if opts.Compression != "" {
+		single("compression", string(opts.Compression))
+	}
+	single("keep-going", opts.KeepGoing)
+	if opts.Checksums != "" {
+		single("checksums", opts.Checksums)
+	}
+	if opts.ChecksumAlgorithm != "" {
+		single("checksum-algorithm", string(opts.ChecksumAlgorithm))
+	}
+	if opts.ChecksumSidecars != "" {
+		single("checksum-sidecars", opts.ChecksumSidecars)
+	}
+	if opts.WasmExec != "" {
+		single("wasm-exec", opts.WasmExec)
+	}
+	if opts.GoCache != "" {
+		single("gocache", opts.GoCache)
+	}
+	if opts.GoCacheProg != "" {
+		single("gocacheprog", opts.GoCacheProg)
+	}
+	if opts.Stamp != "" {
+		single("stamp", opts.Stamp)
+	}
+	if opts.Ldflags != "" {
+		single("ldflags", opts.Ldflags)
+	}
+	if opts.Image != "" {
+		single("image", opts.Image)
+	}
+	if opts.ImageBase != "" {
+		single("image-base", opts.ImageBase)
+	}
+	for _, key := range packageKeys {
+		if v := *opts.Package.field(key); v != "" {
+			single("package."+key, v)
+		}
+	}
+	if len(opts.Priority) > 0 {
+		list("priority", opts.Priority)
+	}
+	for _, class := range resourceClasses {
+		if filters := opts.classFilters(class); len(filters) > 0 {
+			list("class."+string(class), filters)
+		}
+		for _, key := range classKeys {
+			if v := opts.ClassSettings.of(class).get(key); v != "" {
+				single("class."+string(class)+"."+key, v)
+			}
+		}
+	}
+	if opts.DirectiveFile != "" {
+		single("directive-file", opts.DirectiveFile)
+	}
+	if len(opts.IgnoreFiles) > 0 {
+		list("ignore-files", mapSlice(opts.IgnoreFiles, func(p string) filter { return filter(p) }))
+	}
+	if len(opts.EnvAllow) > 0 {
+		list("env-allow", mapSlice(opts.EnvAllow, func(name string) filter { return filter(name) }))
+	}
+	each("publish", opts.Publish)
+	each("upload", opts.Upload)
+	each("deploy", opts.Deploy)
+	seenSecrets := make(map[string]bool)
+	for _, secret := range opts.Secrets {
+		// Patterns may contain commas, so these are listed one per line.
+		if seenSecrets[secret] {
+			continue
+		}
+		seenSecrets[secret] = true
+		fmt.Fprintf(w, "//go:multibuild:secret=%s\n", secret)
+		if explain {
+			for _, o := range opts.originOf(settingKey("secret", secret)) {
+				fmt.Fprintf(w, "    %s\n", o)
+			}
+		}
+	}
+	for _, rb := range opts.Remote {
+		fmt.Fprintf(w, "//go:multibuild:remote.%s=%s\n", rb.filter, rb)
+		if explain {
+			for _, o := range opts.originOf(settingKey("remote", string(rb.filter))) {
+				fmt.Fprintf(w, "    %s\n", o)
+			}
+		}
+	}
+	for _, v := range opts.TargetEnv {
+		fmt.Fprintf(w, "//go:multibuild:env.%s=%s\n", v.filter, v)
+		if explain {
+			for _, o := range opts.originOf(v.key()) {
+				fmt.Fprintf(w, "    %s\n", o)
+			}
+		}
+	}
+	for _, te := range opts.Extensions {
+		fmt.Fprintf(w, "//go:multibuild:extension.%s=%s\n", te.filter, te.extension)
+		if explain {
+			for _, o := range opts.originOf(settingKey("extension", string(te.filter))) {
+				fmt.Fprintf(w, "    %s\n", o)
+			}
+		}
+	}
+	for _, tl := range opts.TargetLdflags {
+		fmt.Fprintf(w, "//go:multibuild:ldflags.%s=%s\n", tl.filter, tl.flags)
+		if explain {
+			for _, o := range opts.originOf(settingKey("ldflags", string(tl.filter))) {
+				fmt.Fprintf(w, "    %s\n", o)
+			}
+		}
+	}
+	for _, vs := range opts.Variants {
+		fmt.Fprintf(w, "//go:multibuild:variant.%s=%s\n", vs.filter, strings.Join(vs.variants, ","))
+		if explain {
+			for _, o := range opts.originOf(settingKey("variant", string(vs.filter))) {
+				fmt.Fprintf(w, "    %s\n", o)
+			}
+		}
+	}
+	for _, cd := range opts.CopyTo {
+		fmt.Fprintf(w, "//go:multibuild:copy-to.%s=%s\n", cd.filter, cd)
+		if explain {
+			for _, o := range opts.originOf(settingKey("copy-to", string(cd.filter))) {
+				fmt.Fprintf(w, "    %s\n", o)
+			}
+		}
+	}
+	for _, ra := range opts.RegistryAuth {
+		fmt.Fprintf(w, "//go:multibuild:registry-auth.%s=%s\n", ra.host, ra)
+		if explain {
+			for _, o := range opts.originOf(settingKey("registry-auth", ra.host)) {
+				fmt.Fprintf(w, "    %s\n", o)
+			}
+		}
+	}
+}
+
 func displayTargetsAndExit(targets []target) {
 	for _, target := range targets {
 		fmt.Fprintln(os.Stderr, target)
@@ -53,28 +318,118 @@ type cliArgs struct {
 	// In case it's not specified explicitly, it is autodetected.
 	output string
 
+	// The directory given by -o, if it named one rather than a file (see placeOutputs).
+	outputDir string
+
+	// The value of ${VERSION} in output templates, if given, see placeRunValues.
+	version string
+
 	// The package path being built
 	// In case it's not specified explicitly, it is set to ".".
 	packagePath string
 
+	// The packages named on the command line.
+	// If there is more than one, each is built separately, see buildPackages.
+	packagePaths []string
+
+	// The command line without the packages in packagePaths.
+	commonArgs []string
+
+	// Build every main package in the module, see --multibuild-all
+	all bool
+
 	// The sources to be built
 	// This will usually, but not always, be empty.
 	// (e.g. multibuild foo/main.go)
 	sources []string
 
+	// Configuration set on the command line, e.g. --multibuild-parallel=8
+	config options
+
+	// Filters further restricting the configured targets, e.g. --multibuild-restrict=host
+	restrict []filter
+
+	// Where to read the targets to build from, instead of the configuration: a file, or - for stdin.
+	targetsFrom string
+
+	// Treat warnings as errors
+	strict bool
+
+	// Where to write the manifest, if anywhere, e.g. --multibuild-manifest=dist/manifest.json
+	manifest string
+
+	// Summaries to write once the build is done, e.g. --multibuild-summary=markdown
+	summaries []summarySpec
+
+	// A Prometheus Pushgateway to push metrics to, e.g. --multibuild-pushgateway=http://localhost:9091
+	pushgateway string
+
+	// Where to write a trace of the build, e.g. --multibuild-trace=trace.json
+	trace string
+
+	// Where to write events as the build progresses, e.g. --multibuild-events=-
+	events string
+
+	// How long the whole run may take, e.g. --multibuild-deadline=10m
+	deadline time.Duration
+
+	// Check there is enough disk space before building
+	preflight bool
+
+	// Assert the build doesn't need the network
+	offline bool
+
+	// Build with a scrubbed environment
+	sandbox bool
+
+	// Build at a lower CPU and I/O priority, see lowerPriority
+	nice bool
+
+	// Write an in-toto attestation for each target
+	attest bool
+
+	// Add the outputs to .gitignore, see ignoreOutputs
+	gitignore bool
+
+	// Push an image of the linux targets, see publishImage
+	publish bool
+
+	// Write changes to deploy= files here as a patch, instead of making them
+	deployPatch string
+
 	displayUsage   bool
 	displayConfig  bool
+	explainConfig  bool
 	displayTargets bool
+	displaySchema  bool
+	check          bool
 	verbose        bool
+
+	// The target to list the source files of, if set, see --multibuild-files.
+	files target
+
+	// The target to explain the inclusion or exclusion of, if set, see --multibuild-explain.
+	explainTarget target
 }
 
 func buildArgs() (cliArgs, error) {
+	return parseArgs(filepath.Base(os.Args[0]), os.Args[1:])
+}
+
+// Parses the command line 'argv' of the program called 'self'.
+func parseArgs(self string, argv []string) (cliArgs, error) {
 	args := cliArgs{}
-	args.self = filepath.Base(os.Args[0])
-	args.goBuildArgs = os.Args[1:]
+	args.self = self
 	expectOutput := false // seen -o, waiting for the rest
 
-	for _, arg := range args.goBuildArgs {
+	for _, arg := range argv {
+		// Our own arguments must not be passed on to go build, nor -o, as each target is
+		// built to its own output.
+		isOutput := expectOutput || arg == "-o" || strings.HasPrefix(arg, "-o=")
+		if !strings.HasPrefix(arg, "--multibuild") && arg != "--explain" && !isOutput {
+			args.goBuildArgs = append(args.goBuildArgs, arg)
+		}
+
 		switch {
 		case expectOutput:
 			args.output = arg
@@ -91,40 +446,256 @@ func buildArgs() (cliArgs, error) {
 			args.verbose = true
 		case arg == "--multibuild-configuration":
 			args.displayConfig = true
+		case arg == "--multibuild-attest":
+			args.attest = true
+		case arg == "--multibuild-publish":
+			args.publish = true
+		case arg == "--multibuild-gitignore":
+			args.gitignore = true
+		case arg == "--multibuild-strict":
+			args.strict = true
+		case arg == "--multibuild-preflight":
+			args.preflight = true
+		case arg == "--multibuild-offline":
+			args.offline = true
+		case arg == "--multibuild-sandbox":
+			args.sandbox = true
+		case arg == "--multibuild-nice":
+			args.nice = true
+		case arg == "--multibuild-secret-scan":
+			args.config.Secrets = append(args.config.Secrets, builtinSecrets)
+			args.config.setOrigin(settingKey("secret", builtinSecrets), origin{source: sourceCommandLine, location: arg})
+		case arg == "--explain":
+			args.explainConfig = true
 		case arg == "--multibuild-targets":
 			args.displayTargets = true
+		case arg == "--multibuild-schema":
+			args.displaySchema = true
+		case arg == "--multibuild-all":
+			args.all = true
+			continue // each package's run is given the package instead, see buildPackages
+		case arg == "--multibuild-check":
+			args.check = true
+		case strings.HasPrefix(arg, "--multibuild-explain="):
+			args.explainTarget = target(strings.TrimPrefix(arg, "--multibuild-explain="))
+			if goos, goarch, ok := strings.Cut(string(args.explainTarget), "/"); !ok || goos == "" || goarch == "" {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: expected os/arch, e.g. linux/amd64", arg)
+			}
+		case strings.HasPrefix(arg, "--multibuild-files="):
+			args.files = target(strings.TrimPrefix(arg, "--multibuild-files="))
+			if goos, goarch, ok := strings.Cut(string(args.files), "/"); !ok || goos == "" || goarch == "" {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: expected os/arch, e.g. linux/amd64", arg)
+			}
+		case strings.HasPrefix(arg, "--multibuild-output="):
+			output, err := validateTemplate(strings.TrimPrefix(arg, "--multibuild-output="))
+			if err != nil {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: %s", arg, err)
+			}
+			args.config.Output = output
+			args.config.setOrigin(settingKey("output"), origin{source: sourceCommandLine, location: arg})
+		case strings.HasPrefix(arg, "--multibuild-define="):
+			d, err := validateDefine(strings.TrimPrefix(arg, "--multibuild-define="))
+			if err != nil {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: %s", arg, err)
+			}
+			if slices.ContainsFunc(args.config.Defines, func(o definedPlaceholder) bool { return o.name == d.name }) {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: %s was already defined", arg, d.name)
+			}
+			args.config.Defines = append(args.config.Defines, d)
+			args.config.setOrigin(d.key(), origin{source: sourceCommandLine, location: arg})
+		case strings.HasPrefix(arg, "--multibuild-version="):
+			args.version = strings.TrimPrefix(arg, "--multibuild-version=")
+			if args.version == "" || safeFileName(args.version) != args.version {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: expected a version which can be part of a file name", arg)
+			}
+		case strings.HasPrefix(arg, "--multibuild-parallel="):
+			parallel, err := validateParallel(strings.TrimPrefix(arg, "--multibuild-parallel="))
+			if err != nil {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: %s", arg, err)
+			}
+			args.config.Parallel = parallel
+			args.config.setOrigin(settingKey("parallel"), origin{source: sourceCommandLine, location: arg})
+		case strings.HasPrefix(arg, "--multibuild-memory-limit="):
+			memory, err := validateMemoryLimit(strings.TrimPrefix(arg, "--multibuild-memory-limit="))
+			if err != nil {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: %s", arg, err)
+			}
+			args.config.Limits.memory = memory
+			args.config.setOrigin(settingKey("memory-limit"), origin{source: sourceCommandLine, location: arg})
+		case strings.HasPrefix(arg, "--multibuild-cpu-limit="):
+			cpus, err := validateCPULimit(strings.TrimPrefix(arg, "--multibuild-cpu-limit="))
+			if err != nil {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: %s", arg, err)
+			}
+			args.config.Limits.cpus = cpus
+			args.config.setOrigin(settingKey("cpu-limit"), origin{source: sourceCommandLine, location: arg})
+		case strings.HasPrefix(arg, "--multibuild-partial="):
+			partial, err := validatePartial(strings.TrimPrefix(arg, "--multibuild-partial="))
+			if err != nil {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: %s", arg, err)
+			}
+			args.config.Partial = partial
+			args.config.setOrigin(settingKey("partial"), origin{source: sourceCommandLine, location: arg})
+		case strings.HasPrefix(arg, "--multibuild-gocache="):
+			v, err := validateGoCache(strings.TrimPrefix(arg, "--multibuild-gocache="))
+			if err != nil {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: %s", arg, err)
+			}
+			args.config.GoCache = v
+			args.config.setOrigin(settingKey("gocache"), origin{source: sourceCommandLine, location: arg})
+		case strings.HasPrefix(arg, "--multibuild-gocacheprog="):
+			v, err := validateGoCache(strings.TrimPrefix(arg, "--multibuild-gocacheprog="))
+			if err != nil {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: %s", arg, err)
+			}
+			args.config.GoCacheProg = v
+			args.config.setOrigin(settingKey("gocacheprog"), origin{source: sourceCommandLine, location: arg})
+		case arg == "--multibuild-keep-going" || strings.HasPrefix(arg, "--multibuild-keep-going="):
+			v, ok := strings.CutPrefix(arg, "--multibuild-keep-going=")
+			if !ok {
+				v = "true"
+			}
+			keepGoing, err := validateKeepGoing(v)
+			if err != nil {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: %s", arg, err)
+			}
+			args.config.KeepGoing = keepGoing
+			args.config.setOrigin(settingKey("keep-going"), origin{source: sourceCommandLine, location: arg})
+		case strings.HasPrefix(arg, "--multibuild-stamp="):
+			stamp, err := validateStamp(strings.TrimPrefix(arg, "--multibuild-stamp="))
+			if err != nil {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: %s", arg, err)
+			}
+			args.config.Stamp = stamp
+			args.config.setOrigin(settingKey("stamp"), origin{source: sourceCommandLine, location: arg})
+		case strings.HasPrefix(arg, "--multibuild-image="):
+			image, err := validateImage(strings.TrimPrefix(arg, "--multibuild-image="))
+			if err != nil {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: %s", arg, err)
+			}
+			args.config.Image = image
+			args.config.setOrigin(settingKey("image"), origin{source: sourceCommandLine, location: arg})
+		case strings.HasPrefix(arg, "--multibuild-manifest="):
+			args.manifest = strings.TrimPrefix(arg, "--multibuild-manifest=")
+			if args.manifest == "" {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: empty path", arg)
+			}
+		case strings.HasPrefix(arg, "--multibuild-summary="):
+			spec, err := validateSummarySpec(strings.TrimPrefix(arg, "--multibuild-summary="))
+			if err != nil {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: %s", arg, err)
+			}
+			args.summaries = append(args.summaries, spec)
+		case strings.HasPrefix(arg, "--multibuild-pushgateway="):
+			args.pushgateway = strings.TrimPrefix(arg, "--multibuild-pushgateway=")
+			if u, err := url.Parse(args.pushgateway); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: expected an http(s) URL", arg)
+			}
+		case strings.HasPrefix(arg, "--multibuild-upload="):
+			upload, err := validateUpload(strings.TrimPrefix(arg, "--multibuild-upload="))
+			if err != nil {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: %s", arg, err)
+			}
+			args.config.Upload = append(args.config.Upload, upload)
+			args.config.setOrigin(settingKey("upload", upload), origin{source: sourceCommandLine, location: arg})
+		case strings.HasPrefix(arg, "--multibuild-registry-auth="):
+			host, source, _ := strings.Cut(strings.TrimPrefix(arg, "--multibuild-registry-auth="), "=")
+			ra, err := validateRegistryAuth(host, source)
+			if err != nil {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: %s", arg, err)
+			}
+			args.config.RegistryAuth = append(args.config.RegistryAuth, ra)
+			args.config.setOrigin(settingKey("registry-auth", ra.host), origin{source: sourceCommandLine, location: arg})
+		case strings.HasPrefix(arg, "--multibuild-deploy-patch="):
+			args.deployPatch = strings.TrimPrefix(arg, "--multibuild-deploy-patch=")
+			if args.deployPatch == "" {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: empty path", arg)
+			}
+		case strings.HasPrefix(arg, "--multibuild-trace="):
+			args.trace = strings.TrimPrefix(arg, "--multibuild-trace=")
+			if args.trace == "" {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: empty path", arg)
+			}
+		case strings.HasPrefix(arg, "--multibuild-events="):
+			args.events = strings.TrimPrefix(arg, "--multibuild-events=")
+			if args.events == "" {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: empty path", arg)
+			}
+		case strings.HasPrefix(arg, "--multibuild-deadline="):
+			d, err := time.ParseDuration(strings.TrimPrefix(arg, "--multibuild-deadline="))
+			if err != nil || d <= 0 {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: expected a positive duration, e.g. 10m", arg)
+			}
+			args.deadline = d
+		case strings.HasPrefix(arg, "--multibuild-targets-from="):
+			args.targetsFrom = strings.TrimPrefix(arg, "--multibuild-targets-from=")
+			if args.targetsFrom == "" {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: expected a file, or - for stdin", arg)
+			}
+		case strings.HasPrefix(arg, "--multibuild-restrict="):
+			filters, err := validateFilterString(strings.TrimPrefix(arg, "--multibuild-restrict="))
+			if err != nil {
+				return cliArgs{}, fmt.Errorf("multibuild: %s is invalid: %s", arg, err)
+			}
+			args.restrict = append(args.restrict, filters...)
 		case strings.HasPrefix(arg, "--multibuild"):
 			return cliArgs{}, fmt.Errorf("multibuild: unrecognized argument %q", arg)
 		case !strings.HasPrefix(arg, "-"):
-			if args.packagePath != "" {
-				// For now, I'm cowardly refusing to handle this.
-				// I think we need to refactor some to handle two cases:
-				// - specifying a list of .go sources in a single ultimate package
-				// - specifying a list of packages
-				//
-				// The former is handled quite easily I think, the latter will
-				// require some additional thought and handling, as it's essentially
-				// another level of looping on top of what we have now.
-				//
-				// We will need to discover sources for each package, scan independently,
-				// and build independently.
-				return cliArgs{}, fmt.Errorf("multibuild: cannot build multiple packages")
-			}
-			args.packagePath = arg
+			args.packagePaths = append(args.packagePaths, arg)
+		}
+		if !isOutput && !strings.HasPrefix(arg, "-") {
+			continue
 		}
+		args.commonArgs = append(args.commonArgs, arg)
+	}
+
+	if args.targetsFrom != "" && len(args.restrict) > 0 {
+		return cliArgs{}, fmt.Errorf("multibuild: --multibuild-targets-from can't be combined with --multibuild-restrict")
+	}
+
+	if args.explainConfig && !args.displayConfig {
+		return cliArgs{}, fmt.Errorf("multibuild: --explain requires --multibuild-configuration")
 	}
 
+	if args.all {
+		if len(args.packagePaths) > 0 {
+			return cliArgs{}, fmt.Errorf("multibuild: --multibuild-all can't be combined with packages")
+		}
+		pattern, err := modulePattern()
+		if err != nil {
+			return cliArgs{}, fmt.Errorf("multibuild: --multibuild-all: %s", err)
+		}
+		args.packagePaths = []string{pattern}
+	}
+	if len(args.packagePaths) == 1 {
+		args.packagePath = args.packagePaths[0]
+	}
 	if args.packagePath == "" {
 		args.packagePath = "."
 	}
 
+	// As with go build, -o naming a directory writes the outputs there, with their usual names.
+	if args.output != "" && (strings.HasSuffix(args.output, "/") || strings.HasSuffix(args.output, string(filepath.Separator))) {
+		args.outputDir, args.output = args.output, ""
+	} else if st, err := os.Stat(args.output); err == nil && st.IsDir() {
+		args.outputDir, args.output = args.output, ""
+	}
+
+	if args.multiplePackages() {
+		// Each package is built separately, see buildPackages.
+		if err := checkMultiplePackages(args); err != nil {
+			return cliArgs{}, err
+		}
+		return args, nil
+	}
+
 	if args.output == "" {
 		if args.packagePath == "." {
 			// implicit case: multibuild on the current dir -> multibuild .
 			args.packagePath = "."
 			wd, err := os.Getwd()
 			if err != nil {
-				fatal("multibuild: failed to get cwd: %s", err)
+				return cliArgs{}, fmt.Errorf("multibuild: failed to get cwd: %s", err)
 			}
 			args.output = filepath.Base(wd)
 		} else {
@@ -146,14 +717,46 @@ func buildArgs() (cliArgs, error) {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "init":
+			doInit(filepath.Base(os.Args[0]), os.Args[2:])
+			return
+		case "fix":
+			doFix(filepath.Base(os.Args[0]), os.Args[2:])
+			return
+		case "scaffold":
+			doScaffold(filepath.Base(os.Args[0]), os.Args[2:])
+			return
+		case "daemon":
+			doDaemon(filepath.Base(os.Args[0]), os.Args[2:])
+			return
+		case "rpc":
+			doRPC(filepath.Base(os.Args[0]), os.Args[2:])
+			return
+		case "stats":
+			doStats(filepath.Base(os.Args[0]), os.Args[2:])
+			return
+		case "config":
+			doConfig(filepath.Base(os.Args[0]), os.Args[2:])
+			return
+		}
+	}
+
 	args, err := buildArgs()
 	if err != nil {
-		fatal(err.Error())
+		fatalCode(exitConfig, "%s", err)
 	}
 
 	if args.displayUsage {
 		displayUsageAndExit(args.self)
 	}
+	if args.displaySchema {
+		displaySchemaAndExit()
+	}
 
+	if args.multiplePackages() {
+		buildPackages(args)
+	}
 	doMultibuild(args)
 }
@@ -0,0 +1,127 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Returns the path of the module containing 'packagePath', or "unknown".
+func modulePath(packagePath string) string {
+	out, err := exec.Command("go", "list", "-f", "{{if .Module}}{{.Module.Path}}{{end}}", packagePath).Output()
+	if path := strings.TrimSpace(string(out)); err == nil && path != "" {
+		return path
+	}
+	return "unknown"
+}
+
+// Returns a description of the version of the source in 'dir' from git, or "unknown".
+func vcsVersion(dir string) string {
+	cmd := exec.Command("git", "describe", "--tags", "--always", "--dirty")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if v := strings.TrimSpace(string(out)); err == nil && v != "" {
+		return v
+	}
+	return "unknown"
+}
+
+// Returns the abbreviated commit the package in 'dir' is built from, or "unknown" outside of git.
+// go build stamps the full commit as vcs.revision.
+func vcsCommit(dir string) string {
+	cmd := exec.Command("git", "rev-parse", "--short=12", "HEAD")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if v := strings.TrimSpace(string(out)); err == nil && v != "" {
+		return v
+	}
+	return "unknown"
+}
+
+// Escapes a label value for the Prometheus text format.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// Writes metrics for 'results' in the Prometheus text exposition format.
+func writeMetrics(w io.Writer, results []targetResult, now time.Time) {
+	labels := func(r targetResult) string {
+		goos, goarch := r.target.osArch()
+		return fmt.Sprintf(`target="%s",goos="%s",goarch="%s"`, escapeLabel(string(r.target)), escapeLabel(goos), escapeLabel(goarch))
+	}
+
+	fmt.Fprintln(w, "# HELP multibuild_target_duration_seconds How long the target took to build and archive.")
+	fmt.Fprintln(w, "# TYPE multibuild_target_duration_seconds gauge")
+	for _, r := range results {
+		fmt.Fprintf(w, "multibuild_target_duration_seconds{%s} %g\n", labels(r), r.duration().Seconds())
+	}
+
+	fmt.Fprintln(w, "# HELP multibuild_target_success Whether the target was built successfully.")
+	fmt.Fprintln(w, "# TYPE multibuild_target_success gauge")
+	for _, r := range results {
+		success := 0
+		if r.err == nil {
+			success = 1
+		}
+		fmt.Fprintf(w, "multibuild_target_success{%s} %d\n", labels(r), success)
+	}
+
+	fmt.Fprintln(w, "# HELP multibuild_artifact_size_bytes The size of each artifact produced.")
+	fmt.Fprintln(w, "# TYPE multibuild_artifact_size_bytes gauge")
+	for _, r := range results {
+		for _, a := range r.artifacts {
+			st, err := os.Stat(a)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "multibuild_artifact_size_bytes{%s,artifact=\"%s\"} %d\n", labels(r), escapeLabel(filepath.Base(a)), st.Size())
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP multibuild_last_run_timestamp_seconds When multibuild last finished a run.")
+	fmt.Fprintln(w, "# TYPE multibuild_last_run_timestamp_seconds gauge")
+	fmt.Fprintf(w, "multibuild_last_run_timestamp_seconds %d\n", now.Unix())
+}
+
+// Returns the URL metrics for 'module' at 'version' are pushed to.
+// Label values are base64 encoded, as module paths contain slashes.
+func pushgatewayURL(base, module, version string) string {
+	enc := base64.RawURLEncoding.EncodeToString
+	return fmt.Sprintf("%s/metrics/job/multibuild/module@base64/%s/version@base64/%s",
+		strings.TrimSuffix(base, "/"), enc([]byte(module)), enc([]byte(version)))
+}
+
+// Pushes metrics for 'results' to the Pushgateway at 'base', replacing any
+// previously pushed for the same module and version.
+func pushMetrics(base, module, version string, results []targetResult) error {
+	var buf bytes.Buffer
+	writeMetrics(&buf, results, time.Now())
+
+	req, err := http.NewRequest(http.MethodPut, pushgatewayURL(base, module, version), &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
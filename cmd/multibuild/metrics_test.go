@@ -0,0 +1,75 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	bin := filepath.Join(t.TempDir(), "app-linux-amd64")
+	if err := os.WriteFile(bin, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1000, 0)
+	results := []targetResult{
+		{target: "linux/amd64", artifacts: []string{bin}, started: start, finished: start.Add(1500 * time.Millisecond)},
+		{target: "windows/amd64", err: errors.New("broken"), started: start, finished: start.Add(time.Second)},
+	}
+
+	var buf bytes.Buffer
+	writeMetrics(&buf, results, time.Unix(2000, 0))
+	got := buf.String()
+	for _, want := range []string{
+		`multibuild_target_duration_seconds{target="linux/amd64",goos="linux",goarch="amd64"} 1.5`,
+		`multibuild_target_success{target="linux/amd64",goos="linux",goarch="amd64"} 1`,
+		`multibuild_target_success{target="windows/amd64",goos="windows",goarch="amd64"} 0`,
+		`multibuild_artifact_size_bytes{target="linux/amd64",goos="linux",goarch="amd64",artifact="app-linux-amd64"} 5`,
+		`multibuild_last_run_timestamp_seconds 2000`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("metrics do not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestPushMetrics(t *testing.T) {
+	var gotMethod, gotPath, gotBody string
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotMethod, gotPath, gotBody = r.Method, r.URL.Path, string(body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	results := []targetResult{{target: "linux/amd64"}}
+	if err := pushMetrics(srv.URL+"/", "example.com/app", "v1.0.0", results); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotMethod != http.MethodPut {
+		t.Errorf("method: got %s", gotMethod)
+	}
+	if want := "/metrics/job/multibuild/module@base64/ZXhhbXBsZS5jb20vYXBw/version@base64/djEuMC4w"; gotPath != want {
+		t.Errorf("path: got %s, want %s", gotPath, want)
+	}
+	if !strings.Contains(gotBody, "multibuild_target_success") {
+		t.Errorf("body: got %s", gotBody)
+	}
+
+	status = http.StatusBadRequest
+	if err := pushMetrics(srv.URL, "example.com/app", "v1.0.0", results); err == nil {
+		t.Errorf("expected an error for a failed push")
+	}
+}
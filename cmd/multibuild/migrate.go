@@ -0,0 +1,220 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// A change to the directive syntax.
+// Each change is tagged with a version, which is bumped whenever a batch of changes is made.
+// Directives using the old spelling keep working, with a warning,
+// and 'multibuild fix' rewrites them to the new spelling.
+type migration struct {
+	// The directive syntax version which introduced the change.
+	version int
+
+	// The old directive prefix, including the =
+	old string
+
+	// What it is now spelled as
+	new string
+}
+
+// All known migrations, oldest first. No directive has been renamed yet, so there are none,
+// but a rename only needs adding here: see TestDeprecatedDirective.
+var migrations []migration
+
+// Rewrites a directive (with surrounding space trimmed) to the current syntax.
+// Returns the rewritten directive, and the migrations which applied to it, if any.
+func migrateDirective(line string) (string, []migration) {
+	var applied []migration
+	for _, m := range migrations {
+		if strings.HasPrefix(line, m.old) {
+			line = m.new + strings.TrimPrefix(line, m.old)
+			applied = append(applied, m)
+		}
+	}
+	return line, applied
+}
+
+// Rewrites all outdated directives in 'src'.
+// Returns the new source, and a description of each change made.
+func migrateSource(src []byte, path string) ([]byte, []string) {
+	var changes []string
+	lines := bytes.SplitAfter(src, []byte("\n"))
+	for idx, line := range lines {
+		trimmed := strings.TrimSpace(string(line))
+		if !strings.HasPrefix(trimmed, "//go:multibuild:") {
+			continue
+		}
+		migrated, applied := migrateDirective(trimmed)
+		if len(applied) == 0 {
+			continue
+		}
+		lines[idx] = replaceDirective(line, trimmed, migrated)
+		changes = append(changes, fmt.Sprintf("%s:%d: %s -> %s", path, idx+1, trimmed, migrated))
+	}
+	return bytes.Join(lines, nil), changes
+}
+
+// Filter directives, whose values may be misspelled.
+var filterDirectives = []string{"//go:multibuild:include=", "//go:multibuild:exclude=", "//go:multibuild:priority="}
+
+// Corrects mistakes in the directives in 'src' which have only one sensible fix: a GOOS or
+// GOARCH in a filter that isn't among 'targets', but is close to one. Other likely mistakes
+// would change what is built, or what it is named, so they are only fixed with 'all', and
+// otherwise suggested: include= lines which were probably meant to be one (each replaces
+// the last, so the merged line builds more), and an output= without ${TARGET}.
+// Returns the new source, a description of each change made, and the suggestions.
+func correctSource(src []byte, path string, targets []target, all bool) ([]byte, []string, []string) {
+	oses, arches := platformValues(targets)
+	correct := func(value string, valid []string) string {
+		if value == "*" || value == hostKeyword || slices.Contains(valid, value) {
+			return value
+		}
+		if s := suggestPlatform(value, valid); s != "" {
+			return s
+		}
+		return value
+	}
+
+	// Changes are described in line order, once the merged include= line is known.
+	type change struct {
+		line int
+		desc string
+	}
+	var changes []change
+	var suggestions []string
+	lines := bytes.SplitAfter(src, []byte("\n"))
+	firstInclude := -1
+	var includes []string // the values of the first include= line
+	for idx, line := range lines {
+		trimmed := strings.TrimSpace(string(line))
+		fixed := trimmed
+		for _, prefix := range filterDirectives {
+			if !strings.HasPrefix(trimmed, prefix) {
+				continue
+			}
+			var values []string
+			for value := range strings.SplitSeq(strings.TrimPrefix(trimmed, prefix), ",") {
+				if goos, goarch, ok := strings.Cut(value, "/"); ok {
+					value = correct(goos, oses) + "/" + correct(goarch, arches)
+				}
+				if !slices.Contains(values, value) {
+					values = append(values, value)
+				}
+			}
+			if prefix != "//go:multibuild:include=" {
+				fixed = prefix + strings.Join(values, ",")
+			} else if firstInclude == -1 {
+				firstInclude, includes = idx, values
+				if !all {
+					fixed = prefix + strings.Join(values, ",") // otherwise, along with the merge below
+				}
+			} else if !all {
+				fixed = prefix + strings.Join(values, ",")
+				suggestions = append(suggestions, fmt.Sprintf("%s:%d: %s replaces the include= on line %d; to build the targets of both, merge them", path, idx+1, trimmed, firstInclude+1))
+			} else {
+				// Merged into the first include= line, below.
+				for _, value := range values {
+					if !slices.Contains(includes, value) {
+						includes = append(includes, value)
+					}
+				}
+				lines[idx] = nil
+				changes = append(changes, change{idx, fmt.Sprintf("%s:%d: %s -> merged into line %d", path, idx+1, trimmed, firstInclude+1)})
+			}
+		}
+		if value, ok := strings.CutPrefix(trimmed, "//go:multibuild:output="); ok && !strings.Contains(value, "${TARGET}") {
+			// Name the binary after the target, as the default output does.
+			dir, file := "", value
+			if slash := strings.LastIndex(value, "/"); slash != -1 {
+				dir, file = value[:slash+1], value[slash+1:]
+			}
+			if _, err := validateTemplate(dir + "${TARGET}-" + file); err == nil {
+				if all {
+					fixed = "//go:multibuild:output=" + dir + "${TARGET}-" + file
+				} else {
+					suggestions = append(suggestions, fmt.Sprintf("%s:%d: %s has no ${TARGET}, so the outputs aren't named after the binary; e.g. //go:multibuild:output=%s", path, idx+1, trimmed, dir+"${TARGET}-"+file))
+				}
+			}
+		}
+		if fixed != trimmed {
+			lines[idx] = replaceDirective(line, trimmed, fixed)
+			changes = append(changes, change{idx, fmt.Sprintf("%s:%d: %s -> %s", path, idx+1, trimmed, fixed)})
+		}
+	}
+	if firstInclude != -1 && all {
+		line := lines[firstInclude]
+		trimmed := strings.TrimSpace(string(line))
+		if merged := "//go:multibuild:include=" + strings.Join(includes, ","); merged != trimmed {
+			lines[firstInclude] = replaceDirective(line, trimmed, merged)
+			changes = append(changes, change{firstInclude, fmt.Sprintf("%s:%d: %s -> %s", path, firstInclude+1, trimmed, merged)})
+		}
+	}
+	slices.SortStableFunc(changes, func(a, b change) int { return a.line - b.line })
+	return bytes.Join(lines, nil), mapSlice(changes, func(c change) string { return c.desc }), suggestions
+}
+
+// Replaces the directive 'old' in 'line' with 'new', keeping the surrounding whitespace as it was.
+func replaceDirective(line []byte, old, new string) []byte {
+	start := bytes.Index(line, []byte(old))
+	var out []byte
+	out = append(out, line[:start]...)
+	out = append(out, new...)
+	out = append(out, line[start+len(old):]...)
+	return out
+}
+
+// Implements 'multibuild fix'.
+func doFix(self string, argv []string) {
+	fs := flag.NewFlagSet(self+" fix", flag.ExitOnError)
+	dryRun := fs.Bool("n", false, "print the changes that would be made, without making them")
+	all := fs.Bool("all", false, "also make the suggested fixes, which change what is built, or what it is named")
+	fs.Parse(argv)
+
+	packages := fs.Args()
+	if len(packages) == 0 {
+		packages = []string{"."}
+	}
+
+	pkgs, err := listPackages(packages)
+	if err != nil {
+		fatal("multibuild: failed to discover sources: %s", err)
+	}
+	targets, err := targetList()
+	if err != nil {
+		fatalCode(exitTargets, "multibuild: failed to list targets: %s", err)
+	}
+	for _, pkg := range pkgs {
+		for _, path := range pkg.sources() {
+			src, err := os.ReadFile(path)
+			if err != nil {
+				fatal("multibuild: %s", err)
+			}
+			fixed, changes := migrateSource(src, path)
+			fixed, corrections, suggestions := correctSource(fixed, path, targets, *all)
+			changes = append(changes, corrections...)
+			for _, change := range changes {
+				fmt.Fprintln(os.Stderr, change)
+			}
+			for _, suggestion := range suggestions {
+				fmt.Fprintf(os.Stderr, "%s (suggested, run fix -all to make it)\n", suggestion)
+			}
+			if len(changes) == 0 || *dryRun {
+				continue
+			}
+			if err := os.WriteFile(path, fixed, 0644); err != nil {
+				fatal("multibuild: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Replaces the migrations for the duration of the test, as no directive has been renamed yet.
+func setMigrations(t *testing.T, ms ...migration) {
+	old := migrations
+	migrations = ms
+	t.Cleanup(func() { migrations = old })
+}
+
+func TestMigrateDirective(t *testing.T) {
+	setMigrations(t,
+		migration{version: 1, old: "//go:multibuild:old-include=", new: "//go:multibuild:include="},
+		migration{version: 1, old: "//go:multibuild:old-format=", new: "//go:multibuild:format="},
+	)
+	tests := []struct {
+		in      string
+		want    string
+		applied int
+	}{
+		{"//go:multibuild:include=linux/*", "//go:multibuild:include=linux/*", 0},
+		{"//go:multibuild:old-include=linux/*", "//go:multibuild:include=linux/*", 1},
+		{"//go:multibuild:old-format=zip", "//go:multibuild:format=zip", 1},
+	}
+
+	for _, tt := range tests {
+		got, applied := migrateDirective(tt.in)
+		if got != tt.want || len(applied) != tt.applied {
+			t.Errorf("migrateDirective(%q) = %q, %d migrations; want %q, %d", tt.in, got, len(applied), tt.want, tt.applied)
+		}
+	}
+}
+
+func TestMigrateSource(t *testing.T) {
+	setMigrations(t, migration{version: 1, old: "//go:multibuild:old-format=", new: "//go:multibuild:format="})
+	src := "package main\n\n\t//go:multibuild:old-format=zip \n//go:multibuild:include=linux/*\n// go:multibuild:old-format=not-a-directive\n"
+	want := "package main\n\n\t//go:multibuild:format=zip \n//go:multibuild:include=linux/*\n// go:multibuild:old-format=not-a-directive\n"
+
+	got, changes := migrateSource([]byte(src), "main.go")
+	if string(got) != want {
+		t.Errorf("got:\n%q\nwant:\n%q", got, want)
+	}
+	if len(changes) != 1 || changes[0] != "main.go:3: //go:multibuild:old-format=zip -> //go:multibuild:format=zip" {
+		t.Errorf("unexpected changes: %q", changes)
+	}
+}
+
+func TestCorrectSource(t *testing.T) {
+	targets := []target{"darwin/arm64", "linux/amd64", "linux/arm64", "windows/amd64"}
+	src := "package main\n\n" +
+		"//go:multibuild:include=linx/amd64,darwin/*\n" +
+		"\t//go:multibuild:exclude=windows/amd46 \n" +
+		"//go:multibuild:include=linux/arm64,darwin/*\n" +
+		"//go:multibuild:output=bin/${GOOS}-${GOARCH}\n" +
+		"//go:multibuild:priority=host,plan9/*,linux/*\n"
+	want := "package main\n\n" +
+		"//go:multibuild:include=linux/amd64,darwin/*,linux/arm64\n" +
+		"\t//go:multibuild:exclude=windows/amd64 \n" +
+		"//go:multibuild:output=bin/${TARGET}-${GOOS}-${GOARCH}\n" +
+		"//go:multibuild:priority=host,plan9/*,linux/*\n"
+	wantChanges := []string{
+		"main.go:3: //go:multibuild:include=linx/amd64,darwin/* -> //go:multibuild:include=linux/amd64,darwin/*,linux/arm64",
+		"main.go:4: //go:multibuild:exclude=windows/amd46 -> //go:multibuild:exclude=windows/amd64",
+		"main.go:5: //go:multibuild:include=linux/arm64,darwin/* -> merged into line 3",
+		"main.go:6: //go:multibuild:output=bin/${GOOS}-${GOARCH} -> //go:multibuild:output=bin/${TARGET}-${GOOS}-${GOARCH}",
+	}
+
+	got, changes, suggestions := correctSource([]byte(src), "main.go", targets, true)
+	if string(got) != want {
+		t.Errorf("got:\n%q\nwant:\n%q", got, want)
+	}
+	if !slices.Equal(changes, wantChanges) {
+		t.Errorf("got changes:\n%q\nwant:\n%q", changes, wantChanges)
+	}
+	if len(suggestions) != 0 {
+		t.Errorf("got suggestions %q with all", suggestions)
+	}
+
+	// Correct source is left alone.
+	if again, changes, _ := correctSource(got, "main.go", targets, true); string(again) != want || len(changes) != 0 {
+		t.Errorf("second pass changed %q", changes)
+	}
+
+	// Without all, only the spelling is fixed, and the rest is suggested.
+	want = "package main\n\n" +
+		"//go:multibuild:include=linux/amd64,darwin/*\n" +
+		"\t//go:multibuild:exclude=windows/amd64 \n" +
+		"//go:multibuild:include=linux/arm64,darwin/*\n" +
+		"//go:multibuild:output=bin/${GOOS}-${GOARCH}\n" +
+		"//go:multibuild:priority=host,plan9/*,linux/*\n"
+	wantChanges = []string{
+		"main.go:3: //go:multibuild:include=linx/amd64,darwin/* -> //go:multibuild:include=linux/amd64,darwin/*",
+		"main.go:4: //go:multibuild:exclude=windows/amd46 -> //go:multibuild:exclude=windows/amd64",
+	}
+	wantSuggestions := []string{
+		"main.go:5: //go:multibuild:include=linux/arm64,darwin/* replaces the include= on line 3; to build the targets of both, merge them",
+		"main.go:6: //go:multibuild:output=bin/${GOOS}-${GOARCH} has no ${TARGET}, so the outputs aren't named after the binary; e.g. //go:multibuild:output=bin/${TARGET}-${GOOS}-${GOARCH}",
+	}
+	got, changes, suggestions = correctSource([]byte(src), "main.go", targets, false)
+	if string(got) != want {
+		t.Errorf("got:\n%q\nwant:\n%q", got, want)
+	}
+	if !slices.Equal(changes, wantChanges) {
+		t.Errorf("got changes:\n%q\nwant:\n%q", changes, wantChanges)
+	}
+	if !slices.Equal(suggestions, wantSuggestions) {
+		t.Errorf("got suggestions:\n%q\nwant:\n%q", suggestions, wantSuggestions)
+	}
+}
+
+// No directive has been renamed yet, so the migration layer is exercised with a made up one.
+func TestDeprecatedDirective(t *testing.T) {
+	setMigrations(t, migration{version: 1, old: "//go:multibuild:old-include=", new: "//go:multibuild:include="})
+	var opts options
+	var err error
+	kinds := collectWarnings(func() {
+		opts, err = scanBuildPath(strings.NewReader("//go:multibuild:old-include=linux/*\n"), "fake.go")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(opts.Include, []filter{"linux/*"}) {
+		t.Errorf("got include %v", opts.Include)
+	}
+	if !slices.Equal(kinds, []warningKind{warnDeprecatedDirective}) {
+		t.Errorf("got warnings %v, want a single %s", kinds, warnDeprecatedDirective)
+	}
+	// Without a migration, an unknown directive is still an error.
+	setMigrations(t)
+	if _, err := scanBuildPath(strings.NewReader("//go:multibuild:old-include=linux/*\n"), "fake.go"); err == nil {
+		t.Error("expected an error for an unknown directive")
+	}
+}
@@ -5,26 +5,26 @@
 package main
 
 import (
-	"archive/tar"
-	"archive/zip"
-	"bufio"
 	"bytes"
-	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
-// Discovers all source files for this package.
-// This is smarter than Walk() looking for *.go, because it will obey build constraints.
-func sourcesList(packagePath string) ([]string, error) {
-	cmd := exec.Command("go", "list", "-compiled", "-json=CompiledGoFiles", packagePath)
+// Lists the packages matching 'patterns' with a single go list, rather than running one for
+// each package, which dominates startup in large repositories.
+func listPackages(patterns []string) ([]listedPackage, error) {
+	cmd := exec.Command("go", append([]string{"list", "-compiled", "-json=ImportPath,Name,Dir,CompiledGoFiles,CgoFiles"}, patterns...)...)
 
 	var buf bytes.Buffer
 	cmd.Stdout = &buf
@@ -34,26 +34,56 @@ func sourcesList(packagePath string) ([]string, error) {
 		return nil, fmt.Errorf("list: %w", err)
 	}
 
-	var v struct {
-		CompiledGoFiles []string `json:"CompiledGoFiles"`
-	}
-	if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
-		return nil, fmt.Errorf("unmarshal: %w", err)
+	// go list prints one object after another, rather than an array.
+	var pkgs []listedPackage
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var pkg listedPackage
+		if err := dec.Decode(&pkg); err != nil {
+			return nil, fmt.Errorf("unmarshal: %w", err)
+		}
+		pkgs = append(pkgs, pkg)
 	}
+	return pkgs, nil
+}
 
-	// We must prepend packagePath to each of the paths to scan, so that
-	// we can actually find the paths in the case where we are building
-	// a package from an unexpected location.
-	for idx, p := range v.CompiledGoFiles {
-		v.CompiledGoFiles[idx] = filepath.Join(packagePath, p)
+// Returns the paths of the source files of this package, which obey build constraints
+// (unlike a Walk() looking for *.go would).
+func (this listedPackage) sources() []string {
+	// Paths are relative to the working directory where possible, so that they are
+	// short in messages, but can still be found when building a package from an
+	// unexpected location.
+	dir := this.Dir
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, dir); err == nil {
+			dir = rel
+		}
 	}
+	return mapSlice(this.CompiledGoFiles, func(p string) string { return filepath.Join(dir, p) })
+}
 
-	return v.CompiledGoFiles, nil
+// Discovers all source files for this package, and whether it uses cgo.
+func sourcesList(packagePath string) ([]string, bool, error) {
+	pkgs, err := listPackages([]string{packagePath})
+	if err != nil {
+		return nil, false, err
+	}
+	if len(pkgs) != 1 {
+		return nil, false, fmt.Errorf("%s matches %d packages, expected one", packagePath, len(pkgs))
+	}
+	return pkgs[0].sources(), len(pkgs[0].CgoFiles) > 0, nil
 }
 
 // Returns a list of targets that can be built.
 func targetList() ([]target, error) {
-	cmd := exec.Command("go", "tool", "dist", "list")
+	if targets, err := daemonTargetList(daemonSocket()); err == nil {
+		return targets, nil
+	}
+	return parseTargetList(exec.Command("go", "tool", "dist", "list"))
+}
+
+// Runs 'cmd', a go tool dist list, and returns the targets it lists.
+func parseTargetList(cmd *exec.Cmd) ([]target, error) {
 	var buf bytes.Buffer
 	cmd.Stdout = &buf
 	cmd.Stderr = os.Stderr
@@ -63,208 +93,640 @@ func targetList() ([]target, error) {
 	}
 
 	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
-	return mapSlice(lines, func(str string) target {
+	targets := mapSlice(lines, func(str string) target {
 		return target(str)
-	}), nil
+	})
+	slices.Sort(targets)
+	return targets, nil
 }
 
-func doMultibuild(args cliArgs) {
+// Reads the targets listed in 'path', or on stdin if it is -, see readTargetList.
+func targetsFrom(path string, allTargets []target) ([]target, error) {
+	if path == "-" {
+		return readTargetList(os.Stdin, allTargets)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	targets, err := readTargetList(f, allTargets)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return targets, nil
+}
+
+// Works out the configuration for 'args', and the targets to build, in order, and whether
+// the package uses cgo. On failure, also returns the exit code to use. If only the target
+// list couldn't be worked out, the configuration is still returned, to be explained.
+func planBuild(args cliArgs) (options, []target, bool, int, error) {
 	sources := args.sources
+	usesCgo := false
 
 	if len(sources) == 0 {
 		var err error
-		sources, err = sourcesList(args.packagePath)
+		sources, usesCgo, err = sourcesList(args.packagePath)
 		if err != nil {
-			fatal("multibuild: failed to discover sources: %s", err)
+			return options{}, nil, false, exitConfig, fmt.Errorf("failed to discover sources: %s", err)
 		}
 	}
 
-	opts, err := scanBuildDir(sources)
+	if args.offline {
+		if err := enterOffline(args.packagePath); err != nil {
+			return options{}, nil, false, exitConfig, fmt.Errorf("--multibuild-offline: %s", err)
+		}
+	}
+
+	allTargets, err := targetList()
 	if err != nil {
-		fatal("multibuild: failed to scan sources: %s", err)
+		return options{}, nil, false, exitTargets, fmt.Errorf("failed to list targets: %s", err)
 	}
 
-	targets, err := targetList()
+	opts, err := loadConfig(sources, args.config, allTargets)
 	if err != nil {
-		fatal("multibuild: failed to list targets: %s", err)
+		return options{}, nil, false, exitConfig, fmt.Errorf("failed to load configuration: %s", err)
+	}
+	warnDuplicateSettings(opts)
+	warnEnvironmentSettings(opts)
+	opts.placeOutputs(args.outputDir)
+	if err := opts.placeRunValues(args.version, args.packagePath, time.Now()); err != nil {
+		return options{}, nil, false, exitConfig, fmt.Errorf("failed to fill in the output template: %s", err)
+	}
+
+	if args.targetsFrom != "" {
+		// The list is decided elsewhere, so none of the filters apply.
+		targets, err := targetsFrom(args.targetsFrom, allTargets)
+		if err != nil {
+			return opts, nil, false, exitTargets, fmt.Errorf("--multibuild-targets-from: %s", err)
+		}
+		return opts, targets, usesCgo, 0, nil
+	}
+
+	if err := validateFilterPlatforms(args.restrict, allTargets); err != nil {
+		return options{}, nil, false, exitConfig, fmt.Errorf("invalid --multibuild-restrict: %s", err)
+	}
+	// Platforms with variants are replaced by them, so filters can name a single variant.
+	candidates := opts.expandVariants(allTargets)
+	warnUnmatchedFilters(opts, opts.includedTargets(candidates))
+	warnConflictingClasses(opts, opts.includedTargets(candidates))
+	targets, err := opts.buildTargetList(candidates)
+	if err != nil {
+		return opts, nil, false, exitTargets, fmt.Errorf("failed to build target list: %s", err)
+	}
+	targets, err = restrictTargetList(targets, args.restrict)
+	if err != nil {
+		return opts, nil, false, exitTargets, fmt.Errorf("failed to build target list: %s", err)
+	}
+	if targets = excludeUnsupported(&opts, args, targets); len(targets) == 0 {
+		return opts, nil, false, exitTargets, fmt.Errorf("no targets left to build, as the dependencies don't support any of them")
+	}
+	return opts, opts.orderTargets(targets), usesCgo, 0, nil
+}
+
+func doMultibuild(args cliArgs) {
+	if args.displayConfig && len(args.sources) == 0 {
+		// e.g. ./cmd/..., to see how shared configuration works out for each package.
+		if pkgs, err := listPackages([]string{args.packagePath}); err == nil && len(pkgs) > 1 {
+			displayPackageConfigsAndExit(args, pkgs)
+		}
+	}
+
+	opts, targets, usesCgo, code, err := planBuild(args)
+	if args.explainTarget != "" && (err == nil || code == exitTargets) {
+		explainTargetAndExit(args, opts, targets, args.explainTarget, code, err)
 	}
-	targets, err = opts.buildTargetList(targets)
 	if err != nil {
-		fatal("multibuild: failed to build target list: %s", err)
+		fatalCode(code, "multibuild: %s", err)
+	}
+
+	_, hasCgo := os.LookupEnv("CGO_ENABLED")
+	if usesCgo && !hasCgo {
+		warn(warnImplicitCgo, "package uses cgo, but CGO_ENABLED=0 will be set; set CGO_ENABLED explicitly to silence this")
 	}
+	hasGOOS, hasGOARCH := os.Getenv("GOOS") != "", os.Getenv("GOARCH") != ""
+	if hasGOOS || hasGOARCH {
+		warn(warnEnvOverride, "GOOS/GOARCH set in the environment, building only that target with plain go build")
+	}
+	checkWarnings(args.strict)
 
 	if args.displayConfig {
-		displayConfigAndExit(opts)
+		displayConfigAndExit(opts, args.explainConfig)
 	}
 	if args.displayTargets {
 		displayTargetsAndExit(targets)
 	}
+	if args.check {
+		checkAndExit(args, opts, targets)
+	}
+	if args.files != "" {
+		displayFilesAndExit(args, opts, targets, args.files)
+	}
+
+	if args.nice {
+		// Lowering the priority of this process means everything it runs gets it too.
+		if err := lowerPriority(); err != nil {
+			warn(warnNice, "--multibuild-nice: %s, building at normal priority", err)
+		}
+	}
+
+	env, cleanupEnv, err := buildEnviron(args.sandbox, opts)
+	if err != nil {
+		fatal("multibuild: failed to set up the build environment: %s", err)
+	}
 
 	// If there's an explicit GOOS/GOARCH, pass through.
 	// We want to stay out of the way here.
 	// TODO: But this might be a confusing mistake to fall over if you set it in .bashrc etc..
-	if os.Getenv("GOOS") != "" || os.Getenv("GOARCH") != "" {
-		runBuild(args.goBuildArgs, "", "")
+	if hasGOOS || hasGOARCH {
+		err := runBuild(context.Background(), passthroughEnv(env, os.Environ()), nil, buildLimits{}, args.goBuildArgs, "", nil)
+		cleanupEnv()
+		if err != nil {
+			os.Exit(exitBuild)
+		}
 		return
 	}
 
-	wg := sync.WaitGroup{}
-	sem := make(chan struct{}, 4) // limit max parallel builds to save sanity...
+	preflightDiskSpace(opts, args, env, targets)
 
-	formattedOutput := string(opts.Output)
-	formattedOutput = strings.ReplaceAll(formattedOutput, "${TARGET}", args.output)
+	// Each build runs under the limits, so that one can't take the whole machine down with it.
+	limiter, err := newLimiter(opts.allLimits()...)
+	if err != nil {
+		warn(warnLimits, "memory-limit/cpu-limit: %s, building without limits", err)
+	}
+	checkWarnings(args.strict)
 
-	for _, t := range targets {
-		parts := strings.Split(string(t), "/")
-		goos, goarch := parts[0], parts[1]
+	manifestPath := args.manifest
+	if manifestPath == "" && opts.Partial == partialManifest {
+		manifestPath = args.output + ".manifest.json"
+	}
+	if args.gitignore {
+		if err := ignoreOutputs(opts, args, targets, manifestPath); err != nil {
+			cleanupEnv()
+			fatal("multibuild: failed to update .gitignore: %s", err)
+		}
+	}
 
-		out := formattedOutput
-		out = strings.ReplaceAll(out, "${GOOS}", goos)
-		out = strings.ReplaceAll(out, "${GOARCH}", goarch)
-		outBin := out
+	if slices.ContainsFunc(opts.Format, func(f format) bool { return f == formatPkg || f == formatFreeBSDPkg || f == formatSnap }) {
+		opts.Package = opts.Package.withDefaults(args.packagePath)
+	}
 
-		if goos == "windows" {
-			outBin += ".exe"
+	if opts.Package.Icon != "" && !filepath.IsAbs(opts.Package.Icon) {
+		if st, err := os.Stat(args.packagePath); err == nil && st.IsDir() {
+			opts.Package.Icon = filepath.Join(args.packagePath, opts.Package.Icon)
 		}
+	}
 
-		buildArgs := []string{"-o", outBin}
-		buildArgs = append(buildArgs, args.goBuildArgs...)
+	var prov provenance
+	if opts.Stamp != "" {
+		var err error
+		if prov, err = currentProvenance(os.LookupEnv, time.Now()); err != nil {
+			cleanupEnv()
+			fatalCode(exitConfig, "multibuild: stamp: %s", err)
+		}
+		// Only the target differs between builds, and targets never need quoting, so this
+		// catches a provenance which can't be stamped before anything is built.
+		if _, err := stampArgs(nil, opts.Stamp, prov.variables("")); err != nil {
+			cleanupEnv()
+			fatalCode(exitConfig, "multibuild: stamp: %s", err)
+		}
+	}
 
-		wg.Add(1) // acquire for global
-		go func(out, outBin, goos, goarch string, buildArgs []string) {
-			if args.verbose {
-				fmt.Fprintf(os.Stderr, "%s/%s: waiting\n", goos, goarch)
-			}
-			sem <- struct{}{} // acquire for job
-			if args.verbose {
-				fmt.Fprintf(os.Stderr, "%s/%s: build\n", goos, goarch)
-			}
-			runBuild(buildArgs, goos, goarch)
-			if args.verbose {
-				fmt.Fprintf(os.Stderr, "%s/%s: archive\n", goos, goarch)
+	if args.publish {
+		if err := validatePublish(opts); err != nil {
+			cleanupEnv()
+			fatalCode(exitConfig, "multibuild: %s", err)
+		}
+		if opts.ImageBase == "" {
+			opts.ImageBase = defaultImageBase
+		}
+	}
+
+	ctx := context.Background()
+	if args.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, args.deadline)
+		defer cancel()
+	}
+	// With keep-going=false, the first target to fail stops the rest.
+	ctx, stop := context.WithCancelCause(ctx)
+	defer stop(nil)
+
+	// With parallel=auto, there is a slot per CPU, but builds only start while the machine can take them.
+	parallel := opts.Parallel
+	var governor *loadGovernor
+	if parallel == parallelAuto {
+		governor = newLoadGovernor()
+		parallel = governor.max
+	}
+
+	wg := sync.WaitGroup{}
+	// Each job takes a worker slot, which limits parallelism, and identifies the worker in traces.
+	slots := make(chan int, parallel)
+	for slot := range parallel {
+		slots <- slot
+	}
+	results := make([]targetResult, len(targets))
+	binPaths := make([]string, len(targets)) // for bundles
+	events, closeEvents, err := openEventLog(args.events)
+	if err != nil {
+		cleanupEnv()
+		limiter.close()
+		fatal("multibuild: failed to write events: %s", err)
+	}
+	defer closeEvents()
+	packaging, attesting, publishing := newStage("archive", parallel, events), newStage("attest", parallel, events), newStage("publish", parallel, events)
+	checksumming := newStage("checksum", parallel, events)
+	// Checksums are only worked out if something uses them.
+	needChecksums := manifestPath != "" || opts.Checksums != "" || opts.ChecksumSidecars == "true" || args.attest || len(args.summaries) > 0 || (args.publish && len(opts.Publish) > 0)
+
+	// Without raw in the format list, binaries are only needed to package them, so
+	// they are built somewhere else, and never appear among the outputs.
+	var rawDir string
+	if !slices.Contains(opts.Format, formatRaw) {
+		var err error
+		if rawDir, err = os.MkdirTemp("", "multibuild-raw-"); err != nil {
+			cleanupEnv()
+			limiter.close()
+			fatal("multibuild: %s", err)
+		}
+	}
+
+	history := historyPath(args.packagePath)
+	expected := expectedDurations(loadHistory(history))
+	var prog *progress
+	if !args.verbose {
+		prog = newProgress(os.Stderr, targets, parallel, expected)
+	}
+
+	queued := time.Now()
+	for _, t := range targets {
+		if args.verbose {
+			if d, ok := expected[t]; ok {
+				fmt.Fprintf(os.Stderr, "%s: waiting (usually takes ~%s)\n", t, roundDuration(d))
+			} else {
+				fmt.Fprintf(os.Stderr, "%s: waiting\n", t)
 			}
+		}
+		events.emit("queued", &targetResult{target: t})
+	}
 
-			for _, format := range opts.Format {
-				switch format {
-				case formatRaw:
-					// already built (obvs)..
-				case formatZip:
-					arPath := out + ".zip"
-					f, err := os.Create(arPath)
-					defer f.Close()
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "%s/%s: failed to create archive %s: %s\n", goos, goarch, arPath, err)
-						os.Exit(1)
-					}
-
-					zw := zip.NewWriter(f)
-					defer zw.Close()
-
-					w, err := zw.Create(outBin)
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "%s/%s: failed to create header %s: %s\n", goos, goarch, arPath, err)
-						os.Exit(1)
-					}
-
-					st, err := os.Stat(outBin)
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "%s/%s: failed to stat raw %s: %s\n", goos, goarch, outBin, err)
-						os.Exit(1)
-					}
-					bin, err := os.Open(outBin)
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "%s/%s: failed to open raw %s: %s\n", goos, goarch, outBin, err)
-						os.Exit(1)
-					}
-					defer bin.Close()
-					sz, err := io.Copy(w, bin)
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "%s/%s: failed to copy %s: %s\n", goos, goarch, outBin, err)
-						os.Exit(1)
-					}
-					if sz != st.Size() {
-						fmt.Fprintf(os.Stderr, "%s/%s: size mismatch in copy of %s: (%d vs %d)\n", goos, goarch, outBin, sz, st.Size())
-						os.Exit(1)
-					}
-				case formatTgz:
-					arPath := out + ".tar.gz"
-					f, err := os.Create(arPath)
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "%s/%s: failed to create archive %s: %s\n", goos, goarch, arPath, err)
-						os.Exit(1)
-					}
-					defer f.Close()
-
-					gz := gzip.NewWriter(f)
-					defer gz.Close()
-
-					tw := tar.NewWriter(gz)
-					defer tw.Close()
-
-					st, err := os.Stat(outBin)
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "%s/%s: failed to stat raw %s: %s\n", goos, goarch, outBin, err)
-						os.Exit(1)
-					}
-					bin, err := os.Open(outBin)
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "%s/%s: failed to open raw %s: %s\n", goos, goarch, outBin, err)
-						os.Exit(1)
-					}
-					defer bin.Close()
-
-					hdr := &tar.Header{Name: outBin, Mode: 0755, Size: st.Size()}
-					tw.WriteHeader(hdr)
-					sz, err := io.Copy(tw, bin)
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "%s/%s: failed to copy %s: %s\n", goos, goarch, outBin, err)
-						os.Exit(1)
-					}
-					if sz != st.Size() {
-						fmt.Fprintf(os.Stderr, "%s/%s: size mismatch in copy of %s: (%d vs %d)\n", goos, goarch, outBin, sz, st.Size())
-						os.Exit(1)
-					}
+	classes := newClassScheduler(opts)
+	pending, pendingIdx := slices.Clone(targets), make([]int, len(targets))
+	for idx := range targets {
+		pendingIdx[idx] = idx
+	}
+	for len(pending) > 0 {
+		// Jobs are started in target order, so that prioritized targets go first, though a target
+		// waiting for its resource class lets those behind it go ahead.
+		var slot int
+		next := -1
+		if governor.wait(ctx, func() int { return parallel - len(slots) }) {
+			select {
+			case slot = <-slots: // acquire for job
+				if next = classes.next(ctx, pending); next < 0 {
+					slots <- slot
 				}
+			case <-ctx.Done():
 			}
+		}
+		if next < 0 {
+			// Out of time, or stopped: the remaining targets never get started.
+			now := time.Now()
+			for _, idx := range pendingIdx {
+				err, code := cancelReason(ctx)
+				results[idx] = targetResult{target: targets[idx], err: err, code: code, queued: queued, started: now, finished: now}
+				events.emit("done", &results[idx])
+				prog.finish(targets[idx])
+			}
+			break
+		}
+		idx, t := pendingIdx[next], pending[next]
+		pending, pendingIdx = slices.Delete(pending, next, next+1), slices.Delete(pendingIdx, next, next+1)
+
+		out, outBin := opts.outputPaths(args.output, t)
+		binPath := outBin
+		if rawDir != "" {
+			binPath = filepath.Join(rawDir, strconv.Itoa(idx), filepath.Base(outBin))
+			os.Mkdir(filepath.Dir(binPath), 0755) // if this fails, so will the build
+		}
+		binPaths[idx] = binPath
+		goBuildArgs := opts.ldflagsArgs(args.goBuildArgs, t)
+		if opts.Stamp != "" {
+			goBuildArgs, _ = stampArgs(goBuildArgs, opts.Stamp, prov.variables(t)) // checked above
+		}
 
-			// If the format list specifically excluded raw, remove the binary.
-			// I don't know why one would want to do this, but nevertheless...
-			if !slices.Contains(opts.Format, formatRaw) {
-				err := os.Remove(outBin)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "%s/%s: failed to remove unwanted raw output %s: %s\n", goos, goarch, outBin, err)
-				}
+		wg.Add(1) // acquire for global
+		go func(idx, slot int, t target, out, outBin, binPath string) {
+			r := &results[idx]
+			events.emit("build", &targetResult{target: t})
+			prog.start(t)
+			*r = buildTarget(ctx, env, limiter, t, binPath, goBuildArgs, opts, args.verbose)
+			r.queued, r.worker = queued, slot
+			for _, path := range r.artifacts {
+				events.emitArtifact(r, path)
+			}
+			events.emitError(r, "build")
+			classes.done(t)
+			slots <- slot // release for job
+
+			if r.err == nil {
+				packaging.run(ctx, r, func() {
+					packageTarget(ctx, r, out, outBin, opts.archiveEntry(args.output, t), binPath, opts, args.verbose)
+				})
+			}
+			if needChecksums && r.err == nil {
+				checksumming.run(ctx, r, func() { checksumTarget(r, opts) })
+			}
+			if args.attest && r.err == nil {
+				attesting.run(ctx, r, func() {
+					attestTarget(ctx, append(slices.Clone(env), opts.environFor(t)...), args.packagePath, r, out, outBin, goBuildArgs)
+				})
 			}
-			<-sem     // release for job
+			// Hooks run as soon as the target is done, rather than waiting for the whole run.
+			if args.publish && len(opts.Publish) > 0 && r.err == nil {
+				publishing.run(ctx, r, func() {
+					runHooks(ctx, opts.Publish, packageDir(args.packagePath), filepath.Base(args.output), r, args.verbose)
+				})
+			}
+			if r.err != nil && opts.KeepGoing == "false" {
+				stop(errStopped)
+			}
+			r.finished = time.Now()
+			events.emit("done", r)
+			prog.finish(t)
 			wg.Done() // release for global
-		}(out, outBin, goos, goarch, buildArgs)
+		}(idx, slot, t, out, outBin, binPath)
 	}
 
 	wg.Wait()
+	prog.close()
+	cleanupEnv()
+	limiter.close()
+
+	// Bundles are of every target, so they are only written once all of them are built.
+	var bundles []string
+	var bundleErr error
+	if slices.ContainsFunc(opts.Format, format.bundles) {
+		failed := 0
+		for _, r := range results {
+			if r.err != nil {
+				failed++
+			}
+		}
+		if failed > 0 {
+			fmt.Fprintf(os.Stderr, "multibuild: not bundling, as %d targets failed\n", failed)
+		} else {
+			bundles, bundleErr = writeBundles(ctx, opts, args.output, results, binPaths)
+			if bundleErr == nil && opts.ChecksumSidecars == "true" {
+				_, bundleErr = writeSidecars(bundles, opts.ChecksumAlgorithm)
+			}
+		}
+	}
+	if rawDir != "" {
+		os.RemoveAll(rawDir)
+	}
+	if err, _ := cancelReason(ctx); ctx.Err() != nil && err == errDeadline {
+		fmt.Fprintf(os.Stderr, "multibuild: deadline of %s exceeded, outstanding work was cancelled\n", args.deadline)
+	}
+
+	failed, code := applyPartialPolicy(opts.Partial, results)
+	appendHistory(history, queued, results) // only a guide, so failing to record the run doesn't matter
+
+	// Only complete runs are published.
+	var image string
+	var publishErr error
+	if args.publish && failed > 0 {
+		fmt.Fprintf(os.Stderr, "multibuild: not publishing, as %d targets failed\n", failed)
+	} else if args.publish {
+		image, publishErr = publishResults(ctx, opts, args, results)
+	}
+
+	if opts.Checksums != "" {
+		if err := writeChecksums(opts.Checksums, opts.ChecksumAlgorithm, results, bundles); err != nil {
+			fatalCode(exitArchive, "multibuild: failed to write checksums: %s", err)
+		}
+	}
+	if manifestPath != "" {
+		if err := writeManifest(manifestPath, configHash(opts, args.goBuildArgs, os.Environ()), image, results); err != nil {
+			fatalCode(exitArchive, "multibuild: failed to write manifest: %s", err)
+		}
+	}
+	for _, spec := range args.summaries {
+		if err := writeSummary(spec, results); err != nil {
+			fatal("multibuild: failed to write %s summary: %s", spec.format, err)
+		}
+	}
+	if args.trace != "" {
+		if err := writeTrace(args.trace, results); err != nil {
+			fatal("multibuild: failed to write trace: %s", err)
+		}
+	}
+	if args.pushgateway != "" {
+		module, version := modulePath(args.packagePath), vcsVersion(args.packagePath)
+		if err := pushMetrics(args.pushgateway, module, version, results); err != nil {
+			warn(warnMetricsPush, "failed to push metrics to %s: %s", args.pushgateway, err)
+		}
+	}
+	writeResultsTable(os.Stderr, results)
+	if failed > 0 {
+		fatalCode(code, "multibuild: %d of %d targets failed (partial=%s)", failed, len(results), opts.Partial)
+	}
+	if bundleErr != nil {
+		fatalCode(exitArchive, "multibuild: failed to write bundle: %s", bundleErr)
+	}
+	if publishErr != nil {
+		fatalCode(exitPublish, "multibuild: failed to publish: %s", publishErr)
+	}
+	checkWarnings(args.strict)
 }
 
-func runBuild(args []string, goos, goarch string) {
-	cmd := exec.Command("go", append([]string{"build"}, args...)...)
-	cmd.Env = os.Environ()
-	stdout, _ := cmd.StdoutPipe()
-	stderr, _ := cmd.StderrPipe()
+// Returns the output path for 't' without any extension, and the path of the binary.
+// 'name' is the value of ${TARGET}. The output template, aliases and extensions are those of the options.
+func (this options) outputPaths(name string, t target) (string, string) {
+	goos, goarch := t.osArch()
+	variant := t.variant()
+	goos, goarch = this.Aliases.of(goos), this.Aliases.of(goarch)
+	if !this.Output.has("GOVARIANT") {
+		// Variants of an architecture would otherwise overwrite each other, e.g. armv6 and armv7.
+		goarch += variant
+	}
+	out := this.Output.expand(map[string]string{"TARGET": name, "GOOS": goos, "GOARCH": goarch, "GOVARIANT": variant}, "$")
+	return out, out + this.extensionFor(t)
+}
 
-	interceptor := func(source io.ReadCloser, dest io.Writer) {
-		scanner := bufio.NewScanner(source)
-		for scanner.Scan() {
-			line := fmt.Sprintf("%s/%s: %s", goos, goarch, scanner.Text())
-			fmt.Fprintln(dest, line)
+// Returns the paths of all files written for a target: the binary (always, even
+// if it is removed afterwards), followed by any archives.
+func artifactPaths(formats []format, t target, out, outBin string) []string {
+	paths := []string{outBin}
+	for _, f := range formats {
+		if f != formatRaw && f.appliesTo(t) {
+			paths = append(paths, f.path(out, outBin))
 		}
 	}
+	return paths
+}
+
+// Builds a single target into 'outBin' under the limits of 'limiter', and checks it for secrets.
+func buildTarget(ctx context.Context, env []string, limiter *limiter, t target, outBin string, goBuildArgs []string, opts options, verbose bool) (result targetResult) {
+	result = targetResult{target: t, started: time.Now()}
+	env = append(slices.Clone(env), opts.environFor(t)...)
 
-	go interceptor(stdout, os.Stdout)
-	go interceptor(stderr, os.Stderr)
+	if verbose {
+		fmt.Fprintf(os.Stderr, "%s: build\n", t)
+	}
+	var log bytes.Buffer
+	defer func() { result.log = log.String() }()
+	result.environment = envNames(targetEnv(env, t))
+	build := func() error {
+		return runBuild(ctx, env, limiter, opts.limitsFor(t), append([]string{"-o", outBin}, goBuildArgs...), t, &log)
+	}
+	if rb, ok := opts.remoteFor(t); ok {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "%s: building on %s\n", t, rb.host)
+		}
+		build = func() error {
+			err := runRemoteBuild(ctx, rb, outBin, goBuildArgs, t, opts.environFor(t), &log)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", t, err)
+				fmt.Fprintln(&log, err)
+			}
+			return err
+		}
+	}
+	if err := build(); err != nil {
+		result.err, result.code = err, exitBuild
+		if ctx.Err() != nil {
+			result.err, result.code = cancelReason(ctx)
+		}
+		return result
+	}
+	result.built = time.Now()
+	if slices.Contains(opts.Format, formatRaw) {
+		result.artifacts = append(result.artifacts, outBin)
+	}
 
-	if goos != "" {
-		cmd.Env = append(cmd.Env,
+	if patterns := secretPatterns(opts.Secrets); len(patterns) > 0 {
+		matches, err := scanSecrets(outBin, patterns)
+		if err == nil && len(matches) > 0 {
+			for _, m := range matches {
+				fmt.Fprintf(os.Stderr, "%s: possible secret in %s: %s\n", t, outBin, m)
+				fmt.Fprintf(&log, "possible secret in %s: %s\n", outBin, m)
+			}
+			err = fmt.Errorf("%d possible secret(s) found in %s", len(matches), outBin)
+		}
+		if err != nil {
+			result.err, result.code = err, exitSecret
+			return result
+		}
+	}
+	return result
+}
+
+// Produces the archives and packages of 'r' in each format, from the binary at 'binPath'.
+// 'out' is the output path without an extension, 'outBin' the path of the binary, and 'entry'
+// the binary's name in archives (see archiveEntry).
+func packageTarget(ctx context.Context, r *targetResult, out, outBin, entry, binPath string, opts options, verbose bool) {
+	t := r.target
+	if verbose {
+		fmt.Fprintf(os.Stderr, "%s: archive\n", t)
+	}
+	var log bytes.Buffer
+	defer func() { r.log += log.String() }()
+
+	var extra []archiveFile
+	if slices.ContainsFunc(opts.Format, func(f format) bool { return f.isArchive() && !f.bundles() }) {
+		var err error
+		if extra, err = archiveExtras(t, entry, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", t, err)
+			fmt.Fprintln(&log, err)
+			r.err, r.code = err, exitArchive
+			return
+		}
+	}
+	for _, format := range opts.Format {
+		if ctx.Err() != nil {
+			os.Remove(binPath)
+			r.err, r.code = cancelReason(ctx)
+			return
+		}
+		if !format.appliesTo(t) {
+			continue
+		}
+		arPath := format.path(out, outBin)
+		var err error
+		switch format {
+		case formatRaw:
+			// already built (obvs)..
+			continue
+		case formatZip:
+			err = writeZip(arPath, entry, binPath, binaryMode(t), opts.Compression, extra)
+		case formatTgz:
+			err = writeTgz(arPath, entry, binPath, binaryMode(t), opts.Compression, extra)
+		case formatTarZst:
+			err = writeTarZst(ctx, arPath, entry, binPath, binaryMode(t), opts.Compression, extra)
+		case formatZst:
+			err = writeZst(ctx, arPath, binPath, opts.Compression)
+		case formatGz:
+			err = writeGz(arPath, binPath, opts.Compression)
+		case formatDmg:
+			err = writeDmg(ctx, t, arPath, binPath, &log)
+		case formatPkg:
+			err = writePkg(ctx, t, arPath, binPath, opts.Package, &log)
+		case formatFreeBSDPkg:
+			err = writeFreeBSDPkg(t, arPath, binPath, opts.Package)
+		case formatSnap:
+			err = writeSnap(ctx, t, arPath, binPath, opts.Package, &log)
+		case formatAppImage:
+			err = writeAppImage(ctx, t, arPath, binPath, opts.Package, &log)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", t, err)
+			fmt.Fprintln(&log, err)
+			os.Remove(arPath) // don't leave a broken archive lying around
+			r.err, r.code = err, exitArchive
+			return
+		}
+		r.artifacts = append(r.artifacts, arPath)
+	}
+
+	// If the format list excluded raw, the binary was only built to package it,
+	// so make room for others, unless it is still to be bundled.
+	if !slices.Contains(opts.Format, formatRaw) && !slices.ContainsFunc(opts.Format, format.bundles) {
+		os.Remove(binPath)
+	}
+}
+
+// Returns the generated files to put next to the binary of 't' in archives, where it is 'entry'.
+func archiveExtras(t target, entry string, opts options) ([]archiveFile, error) {
+	goos, _ := t.osArch()
+	var extra []archiveFile
+	if goos == "linux" && opts.Package.Service != "" {
+		extra = append(extra, archiveFile{name: entry + ".service", mode: 0644, data: []byte(systemdUnit(path.Base(entry), opts.Package))})
+	}
+	if goos == "js" && opts.WasmExec == "true" {
+		f, err := wasmExecFile()
+		if err != nil {
+			return nil, err
+		}
+		f.name = path.Join(path.Dir(entry), f.name)
+		extra = append(extra, f)
+	}
+	return extra, nil
+}
+
+// Returns the environment for building 't' (or the host, if 't' is empty), based on 'env'.
+func targetEnv(env []string, t target) []string {
+	out := slices.Clone(env)
+	if t != "" {
+		goos, goarch := t.osArch()
+		out = append(out,
 			"GOOS="+goos,
 			"GOARCH="+goarch,
 		)
+		if v := variantEnv(t); v != "" {
+			out = append(out, v)
+		}
 
 		// multibuild is primarily a tool for cross compilation:
 		// making a binary in one place, that will run in many other places.
@@ -279,13 +741,101 @@ func runBuild(args []string, goos, goarch string) {
 		// amount of unhelpful confusion.
 		//
 		// So, my executive decision is that we'll turn CGO_ENABLED off unless you explicitly turn it on.
-		_, hasCgo := os.LookupEnv("CGO_ENABLED")
+		hasCgo := slices.ContainsFunc(env, func(kv string) bool { return strings.HasPrefix(kv, "CGO_ENABLED=") })
 		if !hasCgo {
-			cmd.Env = append(cmd.Env, "CGO_ENABLED=0")
+			out = append(out, "CGO_ENABLED=0")
 		}
 	}
+	return out
+}
 
-	if err := cmd.Run(); err != nil {
-		os.Exit(1)
+// Runs go build for 't', or for the host if 't' is empty, with 'env' as the base environment,
+// under 'limits' applied by 'limiter' (if not nil).
+// Output is prefixed and passed through, and if 'log' is not nil, also copied to it.
+func runBuild(ctx context.Context, env []string, limiter *limiter, limits buildLimits, args []string, t target, log io.Writer) error {
+	cmd := exec.CommandContext(ctx, "go", append([]string{"build"}, args...)...)
+	cmd.Env = targetEnv(env, t)
+	proc, err := limiter.prepare(cmd, limits)
+	if err != nil {
+		return fmt.Errorf("go build: applying limits: %w", err)
+	}
+	err = runPrefixedLimited(cmd, proc, t, log)
+	if lerr := proc.finish(); lerr != nil && err != nil {
+		// Say why, rather than just that it was killed or ran out of memory.
+		err = lerr
+	}
+	if err != nil {
+		return fmt.Errorf("go build: %w", err)
+	}
+	return nil
+}
+
+// Writes whole lines to 'dest', prefixed with goos/goarch, and copies them to 'log'.
+type prefixWriter struct {
+	dest   io.Writer
+	prefix string
+	log    io.Writer
+	logMu  *sync.Mutex
+	buf    []byte
+}
+
+func (this *prefixWriter) Write(p []byte) (int, error) {
+	this.buf = append(this.buf, p...)
+	for {
+		idx := bytes.IndexByte(this.buf, '\n')
+		if idx < 0 {
+			break
+		}
+		this.line(string(this.buf[:idx]))
+		this.buf = this.buf[idx+1:]
+	}
+	return len(p), nil
+}
+
+// Writes out anything left over which didn't end in a newline.
+func (this *prefixWriter) flush() {
+	if len(this.buf) > 0 {
+		this.line(string(this.buf))
+		this.buf = nil
+	}
+}
+
+func (this *prefixWriter) line(s string) {
+	fmt.Fprintln(this.dest, this.prefix+s)
+	if this.log != nil {
+		this.logMu.Lock()
+		fmt.Fprintln(this.log, s)
+		this.logMu.Unlock()
+	}
+}
+
+// Runs 'cmd', passing its output through prefixed with 't'.
+// If 'log' is not nil, the output is also copied to it.
+func runPrefixed(cmd *exec.Cmd, t target, log io.Writer) error {
+	return runPrefixedLimited(cmd, nil, t, log)
+}
+
+// Like runPrefixed, but tells 'proc' (if not nil) once the command has started.
+func runPrefixedLimited(cmd *exec.Cmd, proc *limitedProcess, t target, log io.Writer) error {
+	var logMu sync.Mutex
+	prefix := fmt.Sprintf("%s: ", t)
+	stdout := &prefixWriter{dest: os.Stdout, prefix: prefix, log: log, logMu: &logMu}
+	stderr := &prefixWriter{dest: os.Stderr, prefix: prefix, log: log, logMu: &logMu}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	// If the command is cancelled, its children may keep the output open, so don't wait forever.
+	cmd.WaitDelay = 5 * time.Second
+
+	err := cmd.Start()
+	if err == nil {
+		if err = proc.started(cmd.Process); err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+		} else {
+			err = cmd.Wait()
+		}
 	}
+	stdout.flush()
+	stderr.flush()
+	return err
 }
@@ -0,0 +1,46 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestListPackages(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":          "module example.com/m\n\ngo 1.24\n",
+		"main.go":         "package main\n\nfunc main() {}\n",
+		"tool/main.go":    "package main\n\nfunc main() {}\n",
+		"tool/windows.go": "//go:build windows\n\npackage main\n",
+	}
+	for name, data := range files {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(dir)
+	t.Setenv("GOOS", "linux")
+
+	pkgs, err := listPackages([]string{"./..."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, pkg := range pkgs {
+		got = append(got, pkg.sources()...)
+	}
+	if want := []string{"main.go", filepath.Join("tool", "main.go")}; !slices.Equal(got, want) {
+		t.Errorf("got sources %q, want %q", got, want)
+	}
+
+	if _, _, err := sourcesList("./..."); err == nil {
+		t.Errorf("sourcesList accepted a pattern matching two packages")
+	}
+}
@@ -0,0 +1,16 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"runtime"
+)
+
+// The niceness --multibuild-nice runs at, as nice(1) does by default.
+const niceness = 10
+
+// Returned by lowerPriority on platforms where it isn't implemented.
+var errNiceUnsupported = errors.New("not supported on " + runtime.GOOS)
@@ -0,0 +1,31 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLowerPriority(t *testing.T) {
+	if err := lowerPriority(); err != nil {
+		t.Fatal(err)
+	}
+	// Processes started afterwards, from any thread, run at the lower priority.
+	for range 4 {
+		out, err := exec.Command("cat", "/proc/self/stat").Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The command name is in parentheses, and may contain spaces, so count from after it.
+		_, rest, _ := strings.Cut(string(out), ") ")
+		fields := strings.Fields(rest)
+		if nice, err := strconv.Atoi(fields[16]); err != nil || nice < niceness {
+			t.Errorf("child runs at niceness %s, want %d", fields[16], niceness)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly || windows)
+
+package main
+
+// Lowers the priority of this process, and so of every build it starts.
+func lowerPriority() error {
+	return errNiceUnsupported
+}
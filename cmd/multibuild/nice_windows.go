@@ -0,0 +1,24 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "syscall"
+
+// See SetPriorityClass. Processes started from a process in this class are also put in it.
+const belowNormalPriorityClass = 0x00004000
+
+var procSetPriorityClass = syscall.NewLazyDLL("kernel32.dll").NewProc("SetPriorityClass")
+
+// Lowers the CPU priority of this process, and so of every build it starts.
+func lowerPriority() error {
+	process, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return err
+	}
+	if ok, _, err := procSetPriorityClass.Call(uintptr(process), belowNormalPriorityClass); ok == 0 {
+		return err
+	}
+	return nil
+}
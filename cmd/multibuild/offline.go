@@ -0,0 +1,56 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Returns the environment which stops go from touching the network:
+// no module proxy (so no downloads), no toolchain switching, and no go.mod updates.
+// 'goflags' is the existing GOFLAGS, and 'vendored' is whether the module vendors its dependencies.
+func offlineEnv(goflags string, vendored bool) []string {
+	if !strings.Contains(goflags, "-mod=") {
+		mode := "-mod=readonly"
+		if vendored {
+			mode = "-mod=vendor"
+		}
+		goflags = strings.TrimSpace(goflags + " " + mode)
+	}
+	return []string{
+		"GOPROXY=off",
+		"GOTOOLCHAIN=local",
+		"GOFLAGS=" + goflags,
+	}
+}
+
+// Sets up the environment so that builds can't use the network, and checks
+// everything needed to build 'packagePath' is already available.
+func enterOffline(packagePath string) error {
+	vendored := false
+	if root, err := moduleRoot(); err == nil {
+		_, err := os.Stat(filepath.Join(root, "vendor", "modules.txt"))
+		vendored = err == nil
+	}
+	for _, kv := range offlineEnv(os.Getenv("GOFLAGS"), vendored) {
+		k, v, _ := strings.Cut(kv, "=")
+		os.Setenv(k, v)
+	}
+
+	// Loading every dependency fails if any module would need to be downloaded.
+	cmd := exec.Command("go", "list", "-deps", packagePath)
+	var stderr bytes.Buffer
+	cmd.Stdout = nil
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("the build would need the network: %s", strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestOfflineEnv(t *testing.T) {
+	tests := []struct {
+		goflags  string
+		vendored bool
+		want     string
+	}{
+		{goflags: "", want: "GOFLAGS=-mod=readonly"},
+		{goflags: "", vendored: true, want: "GOFLAGS=-mod=vendor"},
+		{goflags: "-trimpath", want: "GOFLAGS=-trimpath -mod=readonly"},
+		{goflags: "-mod=mod", want: "GOFLAGS=-mod=mod"},
+	}
+	for _, tt := range tests {
+		got := offlineEnv(tt.goflags, tt.vendored)
+		if !slices.Contains(got, "GOPROXY=off") || !slices.Contains(got, "GOTOOLCHAIN=local") {
+			t.Errorf("%q: network not disabled: %v", tt.goflags, got)
+		}
+		if !slices.Contains(got, tt.want) {
+			t.Errorf("%q: got %v, want %s", tt.goflags, got, tt.want)
+		}
+	}
+}
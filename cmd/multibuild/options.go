@@ -7,11 +7,18 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
 	"io"
 	"log"
-	"os"
+	"path/filepath"
+	"runtime"
 	"slices"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 // debug logging
@@ -20,11 +27,37 @@ const dlog = false
 // A filter specification, e.g. windows/arm64, windows/*, */arm64
 // This is supposed to match against targets.
 // NOTE: wildcarding must be the entire os or arch, partial matching is not supported.
+//
+// The special value "host" may be used in place of the os and/or arch, and is
+// resolved to the platform multibuild is running on, e.g. host/host, linux/host.
 type filter string
 
-// goos/goarch string
+// The keyword used in filters to refer to the current platform.
+const hostKeyword = "host"
+
+// goos/goarch string, or goos/goarch/variant for a variant of the architecture, see archVariants
 type target string
 
+// Returns the GOOS and GOARCH of the target.
+func (this target) osArch() (string, string) {
+	goos, rest, _ := strings.Cut(string(this), "/")
+	goarch, _, _ := strings.Cut(rest, "/")
+	return goos, goarch
+}
+
+// Returns the variant of the target's architecture, e.g. v7 for linux/arm/v7, or "" if it has none.
+func (this target) variant() string {
+	_, rest, _ := strings.Cut(string(this), "/")
+	_, variant, _ := strings.Cut(rest, "/")
+	return variant
+}
+
+// Returns the target without its variant, i.e. goos/goarch, as go tool dist list names it.
+func (this target) platform() target {
+	goos, goarch := this.osArch()
+	return target(goos + "/" + goarch)
+}
+
 // e.g. ${TARGET}_${GOOS}_${GOARCH}
 type outputTemplate string
 
@@ -32,9 +65,189 @@ type outputTemplate string
 type format string
 
 const (
-	formatRaw format = "raw"
-	formatZip        = "zip"
-	formatTgz        = "tar.gz"
+	formatRaw          format = "raw"
+	formatZip                 = "zip"
+	formatTgz                 = "tar.gz"
+	formatDmg                 = "dmg"
+	formatPkg                 = "pkg"
+	formatAppImage            = "appimage"
+	formatFreeBSDPkg          = "freebsd-pkg"
+	formatSnap                = "snap"
+	formatTarZst              = "tar.zst"
+	formatZst                 = "zst"
+	formatGz                  = "gz"
+	formatBundleZip           = "bundle.zip"
+	formatBundleTgz           = "bundle.tar.gz"
+	formatBundleTarZst        = "bundle.tar.zst"
+)
+
+// Returns whether the format can be produced for 't'.
+// Formats which don't apply are skipped for that target.
+func (this format) appliesTo(t target) bool {
+	goos, goarch := t.osArch()
+	switch this {
+	case formatBundleZip, formatBundleTgz, formatBundleTarZst:
+		return false // written once, for every target, see bundles
+	case formatDmg, formatPkg:
+		return goos == "darwin"
+	case formatAppImage:
+		_, ok := appImageArches[goarch]
+		return goos == "linux" && ok
+	case formatFreeBSDPkg:
+		return goos == "freebsd"
+	case formatSnap:
+		_, ok := snapArches[goarch]
+		return goos == "linux" && ok
+	}
+	return true
+}
+
+// Returns the extension added to the output path for this format, or "" for raw.
+func (this format) extension() string {
+	switch this {
+	case formatZip, formatBundleZip:
+		return ".zip"
+	case formatTgz, formatBundleTgz:
+		return ".tar.gz"
+	case formatTarZst, formatBundleTarZst:
+		return ".tar.zst"
+	case formatZst:
+		return ".zst"
+	case formatGz:
+		return ".gz"
+	case formatDmg:
+		return ".dmg"
+	case formatPkg, formatFreeBSDPkg:
+		return ".pkg"
+	case formatSnap:
+		return ".snap"
+	case formatAppImage:
+		return ".AppImage"
+	}
+	return ""
+}
+
+// Returns whether the format is an archive, which generated files (e.g. systemd units) are
+// put in, next to the binary.
+func (this format) isArchive() bool {
+	switch this {
+	case formatZip, formatTgz, formatTarZst, formatBundleZip, formatBundleTgz, formatBundleTarZst:
+		return true
+	}
+	return false
+}
+
+// Returns whether the format is the binary alone, compressed, rather than an archive or package.
+func (this format) compressesBinary() bool {
+	return this == formatZst || this == formatGz
+}
+
+// Returns the path of the file written in this format, given the output path without an
+// extension 'out', and that of the binary 'outBin'. A compressed binary keeps the binary's
+// name, so that decompressing it gives back e.g. app.exe.
+func (this format) path(out, outBin string) string {
+	if this.compressesBinary() {
+		return outBin + this.extension()
+	}
+	return out + this.extension()
+}
+
+// Metadata for installable packages (as opposed to archives), e.g. pkg.
+type packageInfo struct {
+	// The name of the package, e.g. app
+	Name string
+
+	// A reverse-DNS identifier, e.g. com.example.app
+	Identifier string
+
+	// e.g. 1.2.3
+	Version string
+
+	// Path to an icon (a PNG), relative to the package being built
+	Icon string
+
+	// Comma separated freedesktop.org menu categories, e.g. Development,Utility
+	Categories string
+
+	// Where the binary is installed, see installDir
+	BinDir string
+
+	// If set, the user a systemd service runs the binary as
+	Service string
+
+	// A one line description
+	Description string
+
+	// Who to contact about the package, e.g. Jo Bloggs <jo@example.com>
+	Maintainer string
+
+	// e.g. https://example.com/app
+	Homepage string
+
+	// An SPDX license identifier, e.g. BSD-3-Clause
+	License string
+}
+
+// The keys of package. directives, see packageInfo.
+var packageKeys = []string{"name", "identifier", "version", "icon", "categories", "bindir", "service",
+	"description", "maintainer", "homepage", "license"}
+
+// Returns a pointer to the field of 'info' for 'key', which must be in packageKeys.
+func (this *packageInfo) field(key string) *string {
+	switch key {
+	case "name":
+		return &this.Name
+	case "identifier":
+		return &this.Identifier
+	case "version":
+		return &this.Version
+	case "icon":
+		return &this.Icon
+	case "categories":
+		return &this.Categories
+	case "bindir":
+		return &this.BinDir
+	case "service":
+		return &this.Service
+	case "description":
+		return &this.Description
+	case "maintainer":
+		return &this.Maintainer
+	case "homepage":
+		return &this.Homepage
+	case "license":
+		return &this.License
+	}
+	panic("unknown package key " + key)
+}
+
+// Validates the value of a package. setting.
+func validatePackageValue(key, value string) error {
+	switch {
+	case value == "":
+		return fmt.Errorf("empty value")
+	case key == "bindir" && !strings.HasPrefix(value, "/"):
+		return fmt.Errorf("%q is not an absolute path", value)
+	case key == "service":
+		return validateServiceUser(value)
+	case key == "name" && strings.ContainsAny(value, " \t/"):
+		return fmt.Errorf("%q is not a package name", value)
+	}
+	return nil
+}
+
+// What to do with the artifacts of a run where some targets failed.
+type partialPolicy string
+
+const (
+	// Keep the artifacts of the targets which built successfully.
+	partialKeep partialPolicy = "keep"
+
+	// Remove all artifacts, so that nothing from a failed run is used by mistake.
+	partialDiscard partialPolicy = "discard"
+
+	// Keep the artifacts, and write a manifest marking which targets are missing.
+	partialManifest partialPolicy = "manifest"
 )
 
 // All options for multibuild go here..
@@ -42,6 +255,18 @@ type options struct {
 	// Output filename format
 	Output outputTemplate
 
+	// Placeholders defined for the output template, e.g. ${PRODUCT}
+	Defines []definedPlaceholder
+
+	// What GOOS and GOARCH values are called in output names, e.g. x86_64 for amd64
+	Aliases nameAliases
+
+	// The extensions of binaries, for the targets which don't have the usual one
+	Extensions []targetExtension
+
+	// The path of the binary in archives, e.g. ${NAME}, instead of that of the binary
+	ArchiveEntry outputTemplate
+
 	// Output formats to produce
 	Format []format
 
@@ -50,12 +275,107 @@ type options struct {
 
 	// Targets to exclude
 	Exclude []filter
+
+	// Targets to build first, in order of preference
+	Priority []filter
+
+	// How many targets to build at once
+	Parallel int
+
+	// Limits on the memory and CPU each build may use
+	Limits buildLimits
+
+	// The resource classes of targets, and how each class is built
+	Classes       []classTag
+	ClassSettings classesSettings
+
+	// What to do with successful targets if other targets fail
+	Partial partialPolicy
+
+	// How hard archives and compressed binaries are compressed, or "" for the default
+	Compression compressionLevel
+
+	// Whether to build every target when one fails ("true"), or stop at the first failure ("false")
+	KeepGoing string
+
+	// Where to write the checksums of the binaries and archives of the run, e.g. SHA256SUMS
+	Checksums string
+
+	// The algorithm of the checksums file and sidecars, or "" for sha256
+	ChecksumAlgorithm checksumAlgorithm
+
+	// Whether each artifact gets a sidecar with its checksum, e.g. app.zip.sha256 ("true"), or not ("false")
+	ChecksumSidecars string
+
+	// Whether archives of js/wasm targets include wasm_exec.js ("true"), or not ("false")
+	WasmExec string
+
+	// Machines to build some targets on, instead of locally
+	Remote []remoteBuilder
+
+	// The sub-architectures to build some platforms as, e.g. v6 and v7 of linux/arm
+	Variants []variantSetting
+
+	// The only environment variables allowed to affect the build, if set
+	EnvAllow []string
+
+	// Environment variables for the builds of some targets
+	TargetEnv []targetEnvVar
+
+	// Patterns for secrets which must not appear in binaries, see secretPatterns
+	Secrets []string
+
+	// The package to stamp build provenance into, if any
+	Stamp string
+
+	// Flags for the linker, for every target, and for the targets matching a filter
+	Ldflags       string
+	TargetLdflags []targetLdflags
+
+	// The build cache for child builds, as GOCACHE and GOCACHEPROG, see goCacheEnviron
+	GoCache     string
+	GoCacheProg string
+
+	// Metadata for installable packages
+	Package packageInfo
+
+	// The repository to publish an image to, see publishImage
+	Image string
+
+	// The image to build published images on
+	ImageBase string
+
+	// Helm values files or kustomizations to update with published images, see updateDeployFiles
+	Deploy []string
+
+	// Where to find credentials for registries
+	RegistryAuth []registryAuth
+
+	// URLs to upload artifacts under, see validateUpload
+	Upload []string
+
+	// Hosts to copy binaries to
+	CopyTo []copyDest
+
+	// Commands to run for each artifact when publishing, see runHooks
+	Publish []string
+
+	// File name patterns whose directives are ignored, see ignoredSource
+	IgnoreFiles []string
+
+	// If set, the only source file directives are allowed in, see validateDirectiveFile
+	DirectiveFile string
+
+	// Where each setting came from, see settingKey.
+	origins map[string][]origin
+
+	// The output template before the placeholders of the run were filled in, see placeRunValues.
+	unfilledOutput outputTemplate
 }
 
-// Take targets, only allow 'Include', and then drop 'Exclude'.
-func (this options) buildTargetList(targets []target) ([]target, error) {
-	// Drop any matches that aren't included
-	targets = filterSlice(targets, func(target target) bool {
+// Returns the targets matching 'Include', before any exclusions.
+func (this options) includedTargets(targets []target) []target {
+	return filterSlice(targets, func(target target) bool {
 		for _, filter := range this.Include {
 			if filter.matches(target) {
 				return true
@@ -63,9 +383,15 @@ func (this options) buildTargetList(targets []target) ([]target, error) {
 		}
 		return false
 	})
+}
+
+// Take targets, only allow 'Include', and then drop 'Exclude'.
+func (this options) buildTargetList(targets []target) ([]target, error) {
+	// Drop any matches that aren't included
+	included := this.includedTargets(targets)
 
 	// If exclude specified: We should remove matches from 'targets'
-	targets = filterSlice(targets, func(target target) bool {
+	targets = filterSlice(included, func(target target) bool {
 		for _, filter := range this.Exclude {
 			if filter.matches(target) {
 				return false
@@ -77,112 +403,559 @@ func (this options) buildTargetList(targets []target) ([]target, error) {
 	// Check includes still present
 	for _, inc := range this.Include {
 		found := slices.ContainsFunc(targets, inc.matches)
-		if !found {
-			return nil, fmt.Errorf("multibuild: required target %q was not found, or was excluded", inc)
+		if found {
+			continue
+		}
+		// Name the excludes which contradict it, if that's why.
+		var conflicts []string
+		for _, exc := range this.Exclude {
+			c := fmt.Sprintf("exclude=%s (%s)", exc, describeOrigins(this.originOf(settingKey("exclude", string(exc)))))
+			if slices.ContainsFunc(included, func(t target) bool { return inc.matches(t) && exc.matches(t) }) && !slices.Contains(conflicts, c) {
+				conflicts = append(conflicts, c)
+			}
+		}
+		if len(conflicts) > 0 {
+			return nil, fmt.Errorf("multibuild: include=%s (%s) is contradicted by %s", inc, describeOrigins(this.originOf(settingKey("include", string(inc)))), strings.Join(conflicts, ", "))
+		}
+		return nil, fmt.Errorf("multibuild: required target %q was not found, or was excluded", inc)
+	}
+
+	return targets, nil
+}
+
+// Sorts targets into a stable order: targets matching a 'Priority' filter come
+// first, in the order of the filters, and everything else follows alphabetically.
+func (this options) orderTargets(targets []target) []target {
+	rank := func(t target) int {
+		for idx, f := range this.Priority {
+			if f.matches(t) {
+				return idx
+			}
+		}
+		return len(this.Priority)
+	}
+
+	out := slices.Clone(targets)
+	slices.SortStableFunc(out, func(a, b target) int {
+		if ra, rb := rank(a), rank(b); ra != rb {
+			return ra - rb
+		}
+		return strings.Compare(string(a), string(b))
+	})
+	return out
+}
+
+// Narrow an already resolved target list to those matching 'restrict'.
+// Every filter in 'restrict' must match at least one of the targets.
+func restrictTargetList(targets []target, restrict []filter) ([]target, error) {
+	if len(restrict) == 0 {
+		return targets, nil
+	}
+	for _, r := range restrict {
+		if !slices.ContainsFunc(targets, r.matches) {
+			return nil, fmt.Errorf("restricted target %q is not in the configured targets", r)
 		}
 	}
+	return filterSlice(targets, func(target target) bool {
+		return slices.ContainsFunc(restrict, func(f filter) bool { return f.matches(target) })
+	}), nil
+}
 
+// Reads an explicit target list from 'r', one os/arch per line, for --multibuild-targets-from.
+// Blank lines and lines starting with # are skipped. Every target must be one of 'allTargets',
+// and the order is kept.
+func readTargetList(r io.Reader, allTargets []target) ([]target, error) {
+	var targets []target
+	scanner := bufio.NewScanner(r)
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		t := target(line)
+		if !knownTarget(allTargets, t) {
+			return nil, fmt.Errorf("line %d: %s is not a target go supports", i, t)
+		}
+		if slices.Contains(targets, t) {
+			return nil, fmt.Errorf("line %d: %s is listed more than once", i, t)
+		}
+		targets = append(targets, t)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets are listed")
+	}
 	return targets, nil
 }
 
+// Returns the filter with any "host" keywords replaced by the current platform.
+func (this filter) resolve() filter {
+	parts := strings.SplitN(string(this), "/", 3)
+	if len(parts) < 2 {
+		return this
+	}
+	if parts[0] == hostKeyword {
+		parts[0] = runtime.GOOS
+	}
+	if parts[1] == hostKeyword {
+		parts[1] = runtime.GOARCH
+	}
+	return filter(strings.Join(parts, "/"))
+}
+
 // Returns true if this filter matches target.
 func (this filter) matches(target target) bool {
-	parts := strings.SplitN(string(this), "/", 2)
-	if len(parts) != 2 {
+	parts := strings.SplitN(string(this.resolve()), "/", 3)
+	if len(parts) < 2 {
 		return string(target) == string(this)
 	}
 	filterOS, filterArch := parts[0], parts[1]
-	targetParts := strings.SplitN(string(target), "/", 2)
-	if len(targetParts) != 2 {
+	if !strings.Contains(string(target), "/") {
 		return false
 	}
-	targetOS, targetArch := targetParts[0], targetParts[1]
+	// Filters without a variant match every variant of a platform.
+	targetOS, targetArch := target.osArch()
 	matchOS := filterOS == "*" || filterOS == targetOS
 	matchArch := filterArch == "*" || filterArch == targetArch
-	return matchOS && matchArch
+	matchVariant := len(parts) < 3 || parts[2] == target.variant()
+	return matchOS && matchArch && matchVariant
 }
 
 // Validates that the 's' is a template, and builds a template from it.
+// Besides placeholders, a template may contain any printable character which is safe in a
+// file name on the host (see unsafeOutputChar), with $$ standing for a literal $.
 func validateTemplate(s string) (outputTemplate, error) {
-	if s == "" {
-		return "", fmt.Errorf("empty string is not a valid template")
+	return validateTemplateOn(s, runtime.GOOS)
+}
+
+// Returns why 'c' can't be part of an output path written on 'goos', or "" if it can.
+func unsafeOutputChar(c rune, goos string) string {
+	switch {
+	case c == '\\':
+		return "use / to separate directories"
+	case c == '*' || c == '?' || c == '[' || c == ']':
+		return "wildcards would be expanded by shells and .gitignore"
+	case unicode.IsControl(c) || !unicode.IsPrint(c):
+		return "it isn't printable"
+	case goos == "windows" && strings.ContainsRune(`<>:"|`, c):
+		return "it isn't allowed in file names on windows"
 	}
+	return ""
+}
 
-	isAllowedPathChar := func(c byte) bool {
-		switch {
-		case c >= 'a' && c <= 'z':
-			return true
-		case c >= 'A' && c <= 'Z':
-			return true
-		case c >= '0' && c <= '9':
-			return true
-		case c == '_' || c == '-' || c == '/' || c == '.':
-			return true
-		default:
-			return false
+// Names windows reserves for devices, with or without an extension.
+var windowsReservedNames = []string{"CON", "PRN", "AUX", "NUL",
+	"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+	"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9"}
+
+// Returns why 'name', a part of an output path written on 'goos', is unsafe, or "" if it isn't.
+func unsafeOutputName(name string, goos string) string {
+	if name == "." || name == ".." {
+		return ""
+	}
+	if strings.TrimSpace(name) != name {
+		return "it begins or ends with a space"
+	}
+	if goos == "windows" {
+		if strings.HasSuffix(name, ".") {
+			return "windows drops trailing dots"
+		}
+		base, _, _ := strings.Cut(name, ".")
+		if slices.ContainsFunc(windowsReservedNames, func(r string) bool { return strings.EqualFold(base, r) }) {
+			return "windows reserves it for a device"
 		}
 	}
+	return ""
+}
 
-	isAllowedPlaceholderChar := func(c byte) bool {
-		return (c >= 'A' && c <= 'Z') || c == '_' || (c >= '0' && c <= '9')
-	}
+// Placeholders which every output template must have.
+var requiredPlaceholders = map[string]struct{}{
+	"GOOS":   {},
+	"GOARCH": {},
+	"TARGET": {},
+}
 
-	found := make(map[string]struct{})
+// Placeholders which multibuild fills in, but which may be left out.
+var optionalPlaceholders = map[string]struct{}{
+	"GOVARIANT": {},
+	"VERSION":   {},
+	"COMMIT":    {},
+	"DATE":      {},
+}
+
+// The prefix of placeholders filled in from the environment, e.g. ${ENV:BUILD_NUMBER}.
+const envPrefix = "ENV:"
+
+// Returns whether 'c' may be part of the name of a placeholder.
+func isPlaceholderChar(c byte) bool {
+	return (c >= 'A' && c <= 'Z') || c == '_' || (c >= '0' && c <= '9')
+}
+
+// Returns whether multibuild fills in the placeholder 'name', rather than the user defining it.
+func builtinPlaceholder(name string) bool {
+	_, required := requiredPlaceholders[name]
+	_, optional := optionalPlaceholders[name]
+	return required || optional || strings.HasPrefix(name, envPrefix)
+}
+
+// Like validateTemplate, for output paths written on 'goos'.
+func validateTemplateOn(s string, goos string) (outputTemplate, error) {
+	return validateTemplateWith(s, goos, requiredPlaceholders)
+}
 
-	var allowedPlaceholders = map[string]struct{}{
-		"GOOS":   {},
-		"GOARCH": {},
-		"TARGET": {},
+// Like validateTemplateOn, for templates which must have the 'required' placeholders.
+func validateTemplateWith(s string, goos string, required map[string]struct{}) (outputTemplate, error) {
+	if s == "" {
+		return "", fmt.Errorf("empty string is not a valid template")
+	}
+	if !utf8.ValidString(s) {
+		return "", fmt.Errorf("%q is not valid UTF-8", s)
 	}
+	if outputTemplate(s).isGoTemplate() {
+		return validateGoTemplate(s, goos, required)
+	}
+
+	found := make(map[string]struct{})
 
 	for i := 0; i < len(s); {
-		c := s[i]
+		c, size := utf8.DecodeRuneInString(s[i:])
 
 		switch {
-		case isAllowedPathChar(c):
-			i++
+		// Escaped dollar: $$
+		case c == '$' && strings.HasPrefix(s[i:], "$$"):
+			i += 2
 
 		// Placeholder start: ${...}
 		case c == '$':
 			if i+1 >= len(s) || s[i+1] != '{' {
-				return "", fmt.Errorf("at %d: expected {, got %c", i+1, s[i+1])
+				return "", fmt.Errorf("at %d: expected { after $ (write $$ for a literal $)", i+1)
 			}
 			j := i + 2 // start of ...
 
+			isChar := isPlaceholderChar
+			if strings.HasPrefix(s[j:], envPrefix) {
+				// The names of environment variables may be lower case.
+				j += len(envPrefix)
+				isChar = func(c byte) bool { return isPlaceholderChar(c) || (c >= 'a' && c <= 'z') }
+			}
 			for j < len(s) && s[j] != '}' {
-				if !isAllowedPlaceholderChar(s[j]) {
+				if !isChar(s[j]) {
 					return "", fmt.Errorf("at %d: bad placeholder char %c", j, s[j])
 				}
 				j++
 			}
 
 			if j >= len(s) || s[j] != '}' {
-				return "", fmt.Errorf("at %d: expected }, got %c", j, s[j])
+				return "", fmt.Errorf("at %d: expected }", j)
 			}
 
+			// Besides the builtin placeholders and ${ENV:NAME}, anything is defined by the user,
+			// see validateDefines.
 			name := s[i+2 : j]
-			if _, ok := allowedPlaceholders[name]; !ok {
-				return "", fmt.Errorf("at %d: unexpected placeholder %s", i, name)
+			if name == "" || name == envPrefix {
+				return "", fmt.Errorf("at %d: empty placeholder", i)
 			}
 
 			found[name] = struct{}{}
 			i = j + 1
 
 		default:
-			return "", fmt.Errorf("at %d: unexpected character: %c", i, s[i])
+			if why := unsafeOutputChar(c, goos); why != "" {
+				return "", fmt.Errorf("at %d: unexpected character %q: %s", i, c, why)
+			}
+			i += size
 		}
 	}
 
 	// Ensure all required placeholders were found
-	for name := range allowedPlaceholders {
+	for name := range required {
 		if _, ok := found[name]; !ok {
 			return "", fmt.Errorf("placeholder %s was not found", name)
 		}
 	}
 
+	// Placeholders never expand to anything unsafe, so the names can be checked with any values.
+	values := make(map[string]string)
+	for name := range found {
+		values[name] = "x"
+	}
+	sample := outputTemplate(s).expand(values, "$")
+	for _, name := range strings.Split(sample, "/") {
+		if why := unsafeOutputName(name, goos); why != "" {
+			return "", fmt.Errorf("%q is not a safe file name: %s", name, why)
+		}
+	}
+
 	return outputTemplate(s), nil
 }
 
+// Returns the names of the placeholders in the template, in order.
+func (this outputTemplate) placeholders() []string {
+	if this.isGoTemplate() {
+		return goTemplatePlaceholders(string(this))
+	}
+	var names []string
+	for rest := strings.ReplaceAll(string(this), "$$", ""); strings.Contains(rest, "${"); {
+		_, rest, _ = strings.Cut(rest, "${")
+		var name string
+		name, rest, _ = strings.Cut(rest, "}")
+		names = append(names, name)
+	}
+	return names
+}
+
+// Returns 's' escaped, so that it's taken literally as part of the template.
+func (this outputTemplate) literal(s string) string {
+	if this.isGoTemplate() {
+		return strings.ReplaceAll(s, "{{", `{{"{{"}}`)
+	}
+	return strings.ReplaceAll(s, "$", "$$")
+}
+
+// Returns whether the template has the placeholder 'name'.
+func (this outputTemplate) has(name string) bool {
+	return slices.Contains(this.placeholders(), name)
+}
+
+// Returns the template with the placeholders in 'values' replaced by them, and the others kept.
+func (this outputTemplate) fill(values map[string]string) outputTemplate {
+	if this.isGoTemplate() {
+		return outputTemplate(fillGoTemplate(string(this), values))
+	}
+	keep := make(map[string]string)
+	for _, name := range this.placeholders() {
+		if v, ok := values[name]; ok {
+			keep[name] = strings.ReplaceAll(v, "$", "$$")
+		} else {
+			keep[name] = "${" + name + "}"
+		}
+	}
+	return outputTemplate(this.expand(keep, "$$"))
+}
+
+// A placeholder defined for the output template.
+// e.g. //go:multibuild:define=PRODUCT=Widget
+type definedPlaceholder struct {
+	name  string
+	value string
+}
+
+func (this definedPlaceholder) String() string {
+	return this.name + "=" + this.value
+}
+
+// Returns the key the origins of the setting are recorded under.
+func (this definedPlaceholder) key() string {
+	return settingKey("define", this.String())
+}
+
+// Validates a define directive, NAME=VALUE.
+func validateDefine(s string) (definedPlaceholder, error) {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return definedPlaceholder{}, fmt.Errorf("expected NAME=VALUE, got %q", s)
+	}
+	if name == "" || strings.ContainsFunc(name, func(c rune) bool { return c > unicode.MaxASCII || !isPlaceholderChar(byte(c)) }) {
+		return definedPlaceholder{}, fmt.Errorf("%q is not a valid placeholder name, expected A-Z, 0-9 and _", name)
+	}
+	if builtinPlaceholder(name) {
+		return definedPlaceholder{}, fmt.Errorf("${%s} is filled in by multibuild", name)
+	}
+	if value == "" {
+		return definedPlaceholder{}, fmt.Errorf("%s has no value", name)
+	}
+	for _, c := range value {
+		if c == '/' {
+			return definedPlaceholder{}, fmt.Errorf("unexpected character %q in the value of %s: placeholders name files, not directories", c, name)
+		}
+		if why := unsafeOutputChar(c, runtime.GOOS); why != "" {
+			return definedPlaceholder{}, fmt.Errorf("unexpected character %q in the value of %s: %s", c, name, why)
+		}
+	}
+	return definedPlaceholder{name: name, value: value}, nil
+}
+
+// Returns the values of the defined placeholders, by name.
+func (this options) definedValues() map[string]string {
+	values := make(map[string]string)
+	for _, d := range this.Defines {
+		values[d.name] = d.value
+	}
+	return values
+}
+
+// Checks that the output template only uses placeholders which are filled in by multibuild or
+// defined, and that it's still valid once the defined ones are filled in.
+func (this options) validateDefines() error {
+	values := this.definedValues()
+	for _, name := range this.Output.placeholders() {
+		if _, ok := values[name]; !ok && !builtinPlaceholder(name) {
+			return fmt.Errorf("output %s uses ${%s}, which is not defined (see define=)", this.Output, name)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	filled := this.Output.fill(values)
+	if _, err := validateTemplate(string(filled)); err != nil {
+		return fmt.Errorf("output %s is invalid with the defined placeholders filled in, as %s: %s", this.Output, filled, err)
+	}
+	return nil
+}
+
+// Expands the placeholders of the template with 'values', and each $$ to 'dollar'.
+func (this outputTemplate) expand(values map[string]string, dollar string) string {
+	if this.isGoTemplate() {
+		// validateTemplate has executed it, so it doesn't fail.
+		out, _ := expandGoTemplate(string(this), values, dollar)
+		return out
+	}
+	var b strings.Builder
+	s := string(this)
+	for i := 0; i < len(s); {
+		if strings.HasPrefix(s[i:], "$$") {
+			b.WriteString(dollar)
+			i += 2
+		} else if strings.HasPrefix(s[i:], "${") {
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				b.WriteString(s[i:])
+				break
+			}
+			b.WriteString(values[s[i+2:i+end]])
+			i += end + 1
+		} else {
+			b.WriteByte(s[i])
+			i++
+		}
+	}
+	return b.String()
+}
+
+// The value of parallel=auto, which adjusts the number of builds to the load on the machine,
+// see loadGovernor.
+const parallelAuto = -1
+
+// Validates that 's' is a number of parallel builds, or "auto".
+func validateParallel(s string) (int, error) {
+	if s == "auto" {
+		return parallelAuto, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number, or auto", s)
+	}
+	if n < 1 {
+		return 0, fmt.Errorf("must be at least 1, got %d", n)
+	}
+	return n, nil
+}
+
+// Formats a number of parallel builds as it is written in a directive.
+func formatParallel(n int) string {
+	if n == parallelAuto {
+		return "auto"
+	}
+	return strconv.Itoa(n)
+}
+
+// Validates that 's' is a partial success policy.
+func validatePartial(s string) (partialPolicy, error) {
+	switch p := partialPolicy(s); p {
+	case partialKeep, partialDiscard, partialManifest:
+		return p, nil
+	}
+	return "", fmt.Errorf("%q is not one of %s, %s, %s", s, partialKeep, partialDiscard, partialManifest)
+}
+
+// Validates that 's' is a keep-going setting, a boolean, returning it as "true" or "false".
+func validateKeepGoing(s string) (string, error) {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return "", fmt.Errorf("%q is not true or false", s)
+	}
+	return strconv.FormatBool(b), nil
+}
+
+// Validates that 's' is a list of environment variable names.
+func validateEnvAllow(s string) ([]string, error) {
+	var names []string
+	for name := range strings.SplitSeq(s, ",") {
+		if name == "" {
+			return nil, fmt.Errorf("empty variable name")
+		}
+		for idx, c := range name {
+			if !(c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (idx > 0 && c >= '0' && c <= '9')) {
+				return nil, fmt.Errorf("%q is not a valid variable name", name)
+			}
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// Validates that 's' is a list of file name patterns, as for filepath.Match.
+func validateIgnoreFiles(s string) ([]string, error) {
+	var patterns []string
+	for pattern := range strings.SplitSeq(s, ",") {
+		if pattern == "" {
+			return nil, fmt.Errorf("empty pattern")
+		}
+		if strings.ContainsRune(pattern, '/') {
+			return nil, fmt.Errorf("%q is not a file name pattern: only the names of files are matched", pattern)
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%q: %w", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// The value of directive-file= naming the file containing func main.
+const directiveFileMain = "main"
+
+// Validates that 's' names a source file of a package, or is "main".
+func validateDirectiveFile(s string) (string, error) {
+	if s == directiveFileMain {
+		return s, nil
+	}
+	if !strings.HasSuffix(s, ".go") || strings.ContainsAny(s, `/\`) {
+		return "", fmt.Errorf("%q is not the name of a Go source file, or %q", s, directiveFileMain)
+	}
+	return s, nil
+}
+
+// Returns whether the source file at 'path' is the one named by 'directiveFile'.
+func isDirectiveFile(path, directiveFile string) bool {
+	if directiveFile != directiveFileMain {
+		return filepath.Base(path) == directiveFile
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.SkipObjectResolution)
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(file.Decls, func(decl ast.Decl) bool {
+		fn, ok := decl.(*ast.FuncDecl)
+		return ok && fn.Recv == nil && fn.Name.Name == "main"
+	})
+}
+
+// Returns whether 'lines' opt their file out of directives entirely, with //go:multibuild:ignore.
+func hasIgnoreDirective(lines []directiveLine) bool {
+	return slices.ContainsFunc(lines, func(d directiveLine) bool { return d.Text == "//go:multibuild:ignore" })
+}
+
+// Returns whether the directives in the source file at 'path' are ignored, by one of 'patterns'.
+func ignoredSource(path string, patterns []string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		matched, _ := filepath.Match(pattern, filepath.Base(path))
+		return matched
+	})
+}
+
 // Validates that the 's' is a list of formats.
 func validateFormatString(s string) ([]format, error) {
 	if s == "" {
@@ -190,9 +963,20 @@ func validateFormatString(s string) ([]format, error) {
 	}
 
 	var allowedFormats = map[format]struct{}{
-		formatRaw: {},
-		formatZip: {},
-		formatTgz: {},
+		formatRaw:          {},
+		formatZip:          {},
+		formatTgz:          {},
+		formatDmg:          {},
+		formatPkg:          {},
+		formatAppImage:     {},
+		formatFreeBSDPkg:   {},
+		formatSnap:         {},
+		formatTarZst:       {},
+		formatZst:          {},
+		formatGz:           {},
+		formatBundleZip:    {},
+		formatBundleTgz:    {},
+		formatBundleTarZst: {},
 	}
 
 	var formats []format
@@ -220,6 +1004,23 @@ func validateFilterString(s string) ([]filter, error) {
 	for i < len(s) {
 		start := i
 
+		// "host" on its own is shorthand for "host/host"
+		if strings.HasPrefix(s[i:], hostKeyword) {
+			end := i + len(hostKeyword)
+			if end == len(s) || s[end] == ',' {
+				out = append(out, filter(hostKeyword+"/"+hostKeyword))
+				i = end
+				if i == len(s) {
+					break
+				}
+				i++ // skip ','
+				if i == len(s) {
+					return nil, fmt.Errorf("at %d: trailing comma", i-1)
+				}
+				continue
+			}
+		}
+
 		// parse GOOS
 		osStart := i
 		if i < len(s) {
@@ -258,10 +1059,32 @@ func validateFilterString(s string) ([]filter, error) {
 			return nil, fmt.Errorf("at %d: expected GOARCH", i)
 		}
 		goarch := s[archStart:i]
+		f := filter(fmt.Sprintf("%s/%s", goos, goarch))
 
-		out = append(out, filter(fmt.Sprintf("%s/%s", goos, goarch)))
-
-		// end or comma
+		// parse an optional variant, e.g. linux/arm/v7
+		if i < len(s) && s[i] == '/' {
+			i++ // skip '/'
+			variantStart := i
+			for i < len(s) && (isAlphaNum(s[i]) || s[i] == '.') {
+				i++
+			}
+			if variantStart == i {
+				return nil, fmt.Errorf("at %d: expected a variant", i)
+			}
+			variant := s[variantStart:i]
+			if goarch == "*" {
+				return nil, fmt.Errorf("at %d: a variant needs an architecture, not *", variantStart)
+			}
+			// host is checked once it's known, see validateFilterPlatforms.
+			if set, ok := archVariants[goarch]; goarch != hostKeyword && (!ok || !slices.Contains(set.values, variant)) {
+				return nil, fmt.Errorf("at %d: %q is not a variant of %s", variantStart, variant, goarch)
+			}
+			f = filter(fmt.Sprintf("%s/%s/%s", goos, goarch, variant))
+		}
+
+		out = append(out, f)
+
+		// end or comma
 		if i == len(s) {
 			break
 		}
@@ -285,17 +1108,55 @@ func validateFilterString(s string) ([]filter, error) {
 	return out, nil
 }
 
-// Reads from 'io' on behalf of a path, and returns parsed options.
-func scanBuildPath(reader io.Reader, path string) (options, error) {
-	var opts options
+// A directive found in a source file.
+type directiveLine struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// Reads from 'reader', and returns the directives found in it.
+func findDirectives(reader io.Reader) ([]directiveLine, error) {
+	var lines []directiveLine
 	scanner := bufio.NewScanner(reader)
 	i := 0
 	for scanner.Scan() {
 		i += 1
-		line := scanner.Text()
-		line = strings.TrimSpace(line)
-		if !strings.HasPrefix(line, "//go:multibuild:") {
-			continue
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "//go:multibuild:") {
+			lines = append(lines, directiveLine{i, line})
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// Reads from 'io' on behalf of a path, and returns parsed options.
+func scanBuildPath(reader io.Reader, path string) (options, error) {
+	lines, err := findDirectives(reader)
+	if err != nil {
+		return options{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return parseDirectives(lines, path)
+}
+
+// Parses the directives found in 'path'.
+func parseDirectives(lines []directiveLine, path string) (options, error) {
+	var opts options
+	// A file can opt out entirely, e.g. generated code which happens to contain lines that
+	// look like directives.
+	if hasIgnoreDirective(lines) {
+		if dlog {
+			log.Printf("Ignoring directives in %s", path)
+		}
+		return opts, nil
+	}
+	for _, d := range lines {
+		i, line := d.Line, d.Text
+		here := origin{source: sourceDirective, location: fmt.Sprintf("%s:%d", path, i)}
+		if migrated, applied := migrateDirective(line); len(applied) > 0 {
+			for _, m := range applied {
+				warn(warnDeprecatedDirective, "%s: %s is deprecated since directive version %d, use %s (or run 'multibuild fix')", here.location, m.old, m.version, m.new)
+			}
+			line = migrated
 		}
 		if strings.HasPrefix(line, "//go:multibuild:output=") {
 			if dlog {
@@ -310,6 +1171,64 @@ func scanBuildPath(reader io.Reader, path string) (options, error) {
 				return options{}, fmt.Errorf("%s:%d: go:multibuild:output=%s is invalid: %s", path, i, rest, err)
 			}
 			opts.Output = parsed
+			opts.setOrigin(settingKey("output"), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:define=") {
+			if dlog {
+				log.Printf("Found define: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:define=")
+			d, err := validateDefine(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:define=%s is invalid: %s", path, i, rest, err)
+			}
+			if j := slices.IndexFunc(opts.Defines, func(o definedPlaceholder) bool { return o.name == d.name }); j >= 0 {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:define of %s was already set to %s, found: %q here", path, i, d.name, opts.Defines[j].value, rest)
+			}
+			opts.Defines = append(opts.Defines, d)
+			opts.setOrigin(d.key(), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:alias=") {
+			if dlog {
+				log.Printf("Found alias: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:alias=")
+			if len(opts.Aliases) > 0 {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:alias was already set to %s, found: %q here", path, i, opts.Aliases, rest)
+			}
+			parsed, err := validateAliases(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:alias=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.Aliases = parsed
+			opts.setOrigin(settingKey("alias"), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:archive-entry=") {
+			if dlog {
+				log.Printf("Found archive-entry: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:archive-entry=")
+			if opts.ArchiveEntry != "" {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:archive-entry was already set to %s, found: %q here", path, i, opts.ArchiveEntry, rest)
+			}
+			parsed, err := validateArchiveEntry(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:archive-entry=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.ArchiveEntry = parsed
+			opts.setOrigin(settingKey("archive-entry"), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:extension.") {
+			if dlog {
+				log.Printf("Found extension: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:extension.")
+			key, value, ok := strings.Cut(rest, "=")
+			if !ok {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:extension.%s is invalid: expected extension.FILTER=.EXT", path, i, rest)
+			}
+			te, err := validateTargetExtension(key, value)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:extension.%s is invalid: %s", path, i, rest, err)
+			}
+			opts.Extensions = append(opts.Extensions, te)
+			opts.setOrigin(settingKey("extension", string(te.filter)), here)
 		} else if strings.HasPrefix(line, "//go:multibuild:format=") {
 			if dlog {
 				log.Printf("Found format: %s:%d: %s", path, i, line)
@@ -323,6 +1242,7 @@ func scanBuildPath(reader io.Reader, path string) (options, error) {
 				return options{}, fmt.Errorf("%s:%d: go:multibuild:format=%s is invalid: %s", path, i, rest, err)
 			}
 			opts.Format = parsed
+			opts.setOrigin(settingKey("format"), here)
 		} else if strings.HasPrefix(line, "//go:multibuild:include=") {
 			if dlog {
 				log.Printf("Found include: %s:%d: %s", path, i, line)
@@ -333,6 +1253,9 @@ func scanBuildPath(reader io.Reader, path string) (options, error) {
 				return options{}, fmt.Errorf("%s:%d: go:multibuild:include=%s is invalid: %s", path, i, rest, err)
 			}
 			opts.Include = filters
+			for _, f := range filters {
+				opts.setOrigin(settingKey("include", string(f)), here)
+			}
 		} else if strings.HasPrefix(line, "//go:multibuild:exclude=") {
 			if dlog {
 				log.Printf("Found exclude: %s:%d: %s", path, i, line)
@@ -343,6 +1266,470 @@ func scanBuildPath(reader io.Reader, path string) (options, error) {
 				return options{}, fmt.Errorf("%s:%d: go:multibuild:exclude=%s is invalid: %s", path, i, rest, err)
 			}
 			opts.Exclude = filters
+			for _, f := range filters {
+				opts.setOrigin(settingKey("exclude", string(f)), here)
+			}
+		} else if strings.HasPrefix(line, "//go:multibuild:parallel=") {
+			if dlog {
+				log.Printf("Found parallel: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:parallel=")
+			if opts.Parallel != 0 {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:parallel was already set to %s, found: %q here", path, i, formatParallel(opts.Parallel), rest)
+			}
+			parsed, err := validateParallel(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:parallel=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.Parallel = parsed
+			opts.setOrigin(settingKey("parallel"), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:memory-limit=") {
+			if dlog {
+				log.Printf("Found memory-limit: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:memory-limit=")
+			if opts.Limits.memory != 0 {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:memory-limit was already set to %s, found: %q here", path, i, formatMemoryLimit(opts.Limits.memory), rest)
+			}
+			parsed, err := validateMemoryLimit(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:memory-limit=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.Limits.memory = parsed
+			opts.setOrigin(settingKey("memory-limit"), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:cpu-limit=") {
+			if dlog {
+				log.Printf("Found cpu-limit: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:cpu-limit=")
+			if opts.Limits.cpus != 0 {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:cpu-limit was already set to %s, found: %q here", path, i, formatCPULimit(opts.Limits.cpus), rest)
+			}
+			parsed, err := validateCPULimit(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:cpu-limit=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.Limits.cpus = parsed
+			opts.setOrigin(settingKey("cpu-limit"), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:partial=") {
+			if dlog {
+				log.Printf("Found partial: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:partial=")
+			if opts.Partial != "" {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:partial was already set to %s, found: %q here", path, i, opts.Partial, rest)
+			}
+			parsed, err := validatePartial(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:partial=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.Partial = parsed
+			opts.setOrigin(settingKey("partial"), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:compression=") {
+			if dlog {
+				log.Printf("Found compression: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:compression=")
+			if opts.Compression != "" {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:compression was already set to %s, found: %q here", path, i, opts.Compression, rest)
+			}
+			parsed, err := validateCompression(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:compression=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.Compression = parsed
+			opts.setOrigin(settingKey("compression"), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:keep-going=") {
+			if dlog {
+				log.Printf("Found keep-going: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:keep-going=")
+			if opts.KeepGoing != "" {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:keep-going was already set to %s, found: %q here", path, i, opts.KeepGoing, rest)
+			}
+			parsed, err := validateKeepGoing(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:keep-going=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.KeepGoing = parsed
+			opts.setOrigin(settingKey("keep-going"), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:checksums=") {
+			if dlog {
+				log.Printf("Found checksums: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:checksums=")
+			if opts.Checksums != "" {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:checksums was already set to %s, found: %q here", path, i, opts.Checksums, rest)
+			}
+			parsed, err := validateChecksums(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:checksums=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.Checksums = parsed
+			opts.setOrigin(settingKey("checksums"), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:checksum-algorithm=") {
+			if dlog {
+				log.Printf("Found checksum-algorithm: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:checksum-algorithm=")
+			if opts.ChecksumAlgorithm != "" {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:checksum-algorithm was already set to %s, found: %q here", path, i, opts.ChecksumAlgorithm, rest)
+			}
+			parsed, err := validateChecksumAlgorithm(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:checksum-algorithm=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.ChecksumAlgorithm = parsed
+			opts.setOrigin(settingKey("checksum-algorithm"), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:checksum-sidecars=") {
+			if dlog {
+				log.Printf("Found checksum-sidecars: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:checksum-sidecars=")
+			if opts.ChecksumSidecars != "" {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:checksum-sidecars was already set to %s, found: %q here", path, i, opts.ChecksumSidecars, rest)
+			}
+			parsed, err := validateChecksumSidecars(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:checksum-sidecars=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.ChecksumSidecars = parsed
+			opts.setOrigin(settingKey("checksum-sidecars"), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:wasm-exec=") {
+			if dlog {
+				log.Printf("Found wasm-exec: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:wasm-exec=")
+			if opts.WasmExec != "" {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:wasm-exec was already set to %s, found: %q here", path, i, opts.WasmExec, rest)
+			}
+			parsed, err := validateWasmExec(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:wasm-exec=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.WasmExec = parsed
+			opts.setOrigin(settingKey("wasm-exec"), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:stamp=") {
+			if dlog {
+				log.Printf("Found stamp: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:stamp=")
+			if opts.Stamp != "" {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:stamp was already set to %s, found: %q here", path, i, opts.Stamp, rest)
+			}
+			parsed, err := validateStamp(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:stamp=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.Stamp = parsed
+			opts.setOrigin(settingKey("stamp"), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:ldflags=") {
+			if dlog {
+				log.Printf("Found ldflags: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:ldflags=")
+			if opts.Ldflags != "" {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:ldflags was already set to %s, found: %q here", path, i, opts.Ldflags, rest)
+			}
+			parsed, err := validateLdflags(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:ldflags=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.Ldflags = parsed
+			opts.setOrigin(settingKey("ldflags"), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:ldflags.") {
+			if dlog {
+				log.Printf("Found ldflags: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:ldflags.")
+			key, value, ok := strings.Cut(rest, "=")
+			if !ok {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:ldflags.%s is invalid: expected ldflags.FILTER=FLAGS", path, i, rest)
+			}
+			tl, err := validateTargetLdflags(key, value)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:ldflags.%s is invalid: %s", path, i, rest, err)
+			}
+			opts.TargetLdflags = append(opts.TargetLdflags, tl)
+			opts.setOrigin(settingKey("ldflags", string(tl.filter)), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:gocache=") {
+			if dlog {
+				log.Printf("Found gocache: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:gocache=")
+			if opts.GoCache != "" {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:gocache was already set to %s, found: %q here", path, i, opts.GoCache, rest)
+			}
+			parsed, err := validateGoCache(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:gocache=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.GoCache = parsed
+			opts.setOrigin(settingKey("gocache"), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:gocacheprog=") {
+			if dlog {
+				log.Printf("Found gocacheprog: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:gocacheprog=")
+			if opts.GoCacheProg != "" {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:gocacheprog was already set to %s, found: %q here", path, i, opts.GoCacheProg, rest)
+			}
+			parsed, err := validateGoCache(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:gocacheprog=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.GoCacheProg = parsed
+			opts.setOrigin(settingKey("gocacheprog"), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:image=") {
+			if dlog {
+				log.Printf("Found image: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:image=")
+			if opts.Image != "" {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:image was already set to %s, found: %q here", path, i, opts.Image, rest)
+			}
+			parsed, err := validateImage(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:image=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.Image = parsed
+			opts.setOrigin(settingKey("image"), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:image-base=") {
+			if dlog {
+				log.Printf("Found image-base: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:image-base=")
+			if opts.ImageBase != "" {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:image-base was already set to %s, found: %q here", path, i, opts.ImageBase, rest)
+			}
+			parsed, err := validateImageBase(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:image-base=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.ImageBase = parsed
+			opts.setOrigin(settingKey("image-base"), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:publish=") {
+			if dlog {
+				log.Printf("Found publish: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:publish=")
+			hook, err := validateHook(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:publish=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.Publish = append(opts.Publish, hook)
+			opts.setOrigin(settingKey("publish", hook), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:upload=") {
+			if dlog {
+				log.Printf("Found upload: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:upload=")
+			upload, err := validateUpload(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:upload=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.Upload = append(opts.Upload, upload)
+			opts.setOrigin(settingKey("upload", upload), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:deploy=") {
+			if dlog {
+				log.Printf("Found deploy: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:deploy=")
+			deploy, err := validateDeploy(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:deploy=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.Deploy = append(opts.Deploy, deploy)
+			opts.setOrigin(settingKey("deploy", deploy), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:package.") {
+			if dlog {
+				log.Printf("Found package: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:package.")
+			key, value, ok := strings.Cut(rest, "=")
+			if !ok || !slices.Contains(packageKeys, key) {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:package.%s is invalid: expected one of %s", path, i, rest,
+					strings.Join(mapSlice(packageKeys, func(k string) string { return "package." + k + "=" }), ", "))
+			}
+			if err := validatePackageValue(key, value); err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:package.%s is invalid: %s", path, i, rest, err)
+			}
+			field := opts.Package.field(key)
+			if *field != "" {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:package.%s was already set to %s, found: %q here", path, i, key, *field, value)
+			}
+			*field = value
+			opts.setOrigin(settingKey("package."+key), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:registry-auth.") {
+			if dlog {
+				log.Printf("Found registry-auth: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:registry-auth.")
+			key, value, ok := strings.Cut(rest, "=")
+			if !ok {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:registry-auth.%s is invalid: expected registry-auth.REGISTRY=SOURCE", path, i, rest)
+			}
+			ra, err := validateRegistryAuth(key, value)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:registry-auth.%s is invalid: %s", path, i, rest, err)
+			}
+			opts.RegistryAuth = append(opts.RegistryAuth, ra)
+			opts.setOrigin(settingKey("registry-auth", ra.host), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:copy-to.") {
+			if dlog {
+				log.Printf("Found copy-to: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:copy-to.")
+			key, value, ok := strings.Cut(rest, "=")
+			if !ok {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:copy-to.%s is invalid: expected copy-to.FILTER=HOST:PATH[,HOST:PATH...]", path, i, rest)
+			}
+			cd, err := validateCopyTo(key, value)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:copy-to.%s is invalid: %s", path, i, rest, err)
+			}
+			opts.CopyTo = append(opts.CopyTo, cd)
+			opts.setOrigin(settingKey("copy-to", string(cd.filter)), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:class.") {
+			if dlog {
+				log.Printf("Found class: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:class.")
+			key, value, ok := strings.Cut(rest, "=")
+			name, setting, hasSetting := strings.Cut(key, ".")
+			class, err := validateClass(name)
+			if !ok || err != nil || (hasSetting && !slices.Contains(classKeys, setting)) {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:class.%s is invalid: expected class.CLASS=FILTERS, or one of %s, with CLASS heavy or light", path, i, rest,
+					strings.Join(mapSlice(classKeys, func(k string) string { return "class.CLASS." + k + "=" }), ", "))
+			}
+			if hasSetting {
+				settings := opts.ClassSettings.of(class)
+				if v := settings.get(setting); v != "" {
+					return options{}, fmt.Errorf("%s:%d: go:multibuild:class.%s was already set to %s, found: %q here", path, i, key, v, value)
+				}
+				if err := settings.set(setting, value); err != nil {
+					return options{}, fmt.Errorf("%s:%d: go:multibuild:class.%s is invalid: %s", path, i, rest, err)
+				}
+				opts.setOrigin(settingKey("class."+key), here)
+			} else {
+				filters, err := validateFilterString(value)
+				if err != nil {
+					return options{}, fmt.Errorf("%s:%d: go:multibuild:class.%s is invalid: %s", path, i, rest, err)
+				}
+				for _, f := range filters {
+					opts.Classes = append(opts.Classes, classTag{filter: f, class: class})
+					opts.setOrigin(settingKey("class."+name, string(f)), here)
+				}
+			}
+		} else if strings.HasPrefix(line, "//go:multibuild:remote.") {
+			if dlog {
+				log.Printf("Found remote: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:remote.")
+			key, value, ok := strings.Cut(rest, "=")
+			if !ok {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:remote.%s is invalid: expected remote.FILTER=HOST[:DIR]", path, i, rest)
+			}
+			rb, err := validateRemote(key, value)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:remote.%s is invalid: %s", path, i, rest, err)
+			}
+			opts.Remote = append(opts.Remote, rb)
+			opts.setOrigin(settingKey("remote", string(rb.filter)), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:variant.") {
+			if dlog {
+				log.Printf("Found variant: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:variant.")
+			key, value, ok := strings.Cut(rest, "=")
+			if !ok {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:variant.%s is invalid: expected variant.FILTER=VARIANT[,VARIANT...]", path, i, rest)
+			}
+			vs, err := validateVariant(key, value)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:variant.%s is invalid: %s", path, i, rest, err)
+			}
+			opts.Variants = append(opts.Variants, vs)
+			opts.setOrigin(settingKey("variant", string(vs.filter)), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:env.") {
+			if dlog {
+				log.Printf("Found env: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:env.")
+			key, value, ok := strings.Cut(rest, "=")
+			if !ok {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:env.%s is invalid: expected env.FILTER=NAME=VALUE", path, i, rest)
+			}
+			v, err := validateTargetEnvVar(key, value)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:env.%s is invalid: %s", path, i, rest, err)
+			}
+			opts.TargetEnv = append(opts.TargetEnv, v)
+			opts.setOrigin(v.key(), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:env-allow=") {
+			if dlog {
+				log.Printf("Found env-allow: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:env-allow=")
+			names, err := validateEnvAllow(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:env-allow=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.EnvAllow = append(opts.EnvAllow, names...)
+			for _, name := range names {
+				opts.setOrigin(settingKey("env-allow", name), here)
+			}
+		} else if strings.HasPrefix(line, "//go:multibuild:directive-file=") {
+			if dlog {
+				log.Printf("Found directive-file: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:directive-file=")
+			if opts.DirectiveFile != "" {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:directive-file was already set to %s, found: %q here", path, i, opts.DirectiveFile, rest)
+			}
+			parsed, err := validateDirectiveFile(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:directive-file=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.DirectiveFile = parsed
+			opts.setOrigin(settingKey("directive-file"), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:ignore-files=") {
+			if dlog {
+				log.Printf("Found ignore-files: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:ignore-files=")
+			patterns, err := validateIgnoreFiles(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:ignore-files=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.IgnoreFiles = append(opts.IgnoreFiles, patterns...)
+			for _, pattern := range patterns {
+				opts.setOrigin(settingKey("ignore-files", pattern), here)
+			}
+		} else if strings.HasPrefix(line, "//go:multibuild:secret=") {
+			if dlog {
+				log.Printf("Found secret: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:secret=")
+			secret, err := validateSecret(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:secret=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.Secrets = append(opts.Secrets, secret)
+			opts.setOrigin(settingKey("secret", secret), here)
+		} else if strings.HasPrefix(line, "//go:multibuild:priority=") {
+			if dlog {
+				log.Printf("Found priority: %s:%d: %s", path, i, line)
+			}
+			rest := strings.TrimPrefix(line, "//go:multibuild:priority=")
+			filters, err := validateFilterString(rest)
+			if err != nil {
+				return options{}, fmt.Errorf("%s:%d: go:multibuild:priority=%s is invalid: %s", path, i, rest, err)
+			}
+			opts.Priority = append(opts.Priority, filters...)
+			for _, f := range filters {
+				opts.setOrigin(settingKey("priority", string(f)), here)
+			}
 		} else {
 			return options{}, fmt.Errorf("%s:%d: bad go:multibuild instruction: %q", path, i, line)
 		}
@@ -351,48 +1738,213 @@ func scanBuildPath(reader io.Reader, path string) (options, error) {
 	return opts, nil
 }
 
-// Scan all provided sources, and build options from them.
-func scanBuildDir(sources []string) (options, error) {
+// Scan all provided sources, and merge the directives found into a single layer.
+// 'scope' is the configuration inherited from outside the sources (see inheritedConfig):
+// sources matching its ignore-files, or an ignore-files directive in one of the sources,
+// are skipped, and if a directive-file is set, directives in any other source are an error.
+func scanDirectives(sources []string, scope options) (options, error) {
 	var opts options
-	for _, path := range sources {
-		f, err := os.Open(path)
-		if err != nil {
-			return options{}, fmt.Errorf("open: %s: %w", path, err)
+	// A daemon keeps the directives of unchanged files in memory; otherwise they come from the cache.
+	found, daemonErr := daemonDirectives(daemonSocket(), sources)
+	all := make([][]directiveLine, len(sources))
+	for idx, path := range sources {
+		if daemonErr == nil {
+			all[idx] = found[idx]
+		} else {
+			var err error
+			if all[idx], err = cachedDirectives(path); err != nil {
+				return options{}, err
+			}
+		}
+	}
+	// These apply to every source, wherever they were found, so they are gathered first.
+	ignoreFiles, directiveFile, directiveFileOrigin := slices.Clone(scope.IgnoreFiles), scope.DirectiveFile, scope.originOf(settingKey("directive-file"))
+	for idx, lines := range all {
+		for _, d := range lines {
+			if rest, ok := strings.CutPrefix(d.Text, "//go:multibuild:ignore-files="); ok {
+				if patterns, err := validateIgnoreFiles(rest); err == nil {
+					ignoreFiles = append(ignoreFiles, patterns...)
+				}
+			} else if rest, ok := strings.CutPrefix(d.Text, "//go:multibuild:directive-file="); ok {
+				if name, err := validateDirectiveFile(rest); err == nil {
+					directiveFile = name
+					directiveFileOrigin = []origin{{source: sourceDirective, location: fmt.Sprintf("%s:%d", sources[idx], d.Line)}}
+				}
+			}
+		}
+	}
+	for idx, path := range sources {
+		lines := all[idx]
+		if ignoredSource(path, ignoreFiles) {
+			if dlog {
+				log.Printf("Ignoring directives in %s", path)
+			}
+			continue
+		}
+		if directiveFile != "" && len(lines) > 0 && filepath.Base(path) != inheritedConfigName && !hasIgnoreDirective(lines) && !isDirectiveFile(path, directiveFile) {
+			where := "the file containing func main"
+			if directiveFile != directiveFileMain {
+				where = directiveFile
+			}
+			return options{}, fmt.Errorf("%s:%d: directives are only allowed in %s (directive-file=%s from %s)", path, lines[0].Line, where, directiveFile, describeOrigins(directiveFileOrigin))
 		}
-		defer f.Close()
-		topts, err := scanBuildPath(f, path)
+		topts, err := parseDirectives(lines, path)
 		if err != nil {
 			return options{}, err
 		}
+		// Reports 'key' being set both in 'topts' and by an earlier file, naming both places.
+		conflict := func(key string) error {
+			return fmt.Errorf("conflicting %s= directives: %s, and %s", key, describeOrigins(topts.originOf(key)), describeOrigins(opts.originOf(key)))
+		}
 		// TODO: Test we cover this case properly
 		if len(opts.Output) > 0 && len(topts.Output) > 0 {
-			return options{}, fmt.Errorf("%s: output= already set elsewhere", path)
+			return options{}, conflict(settingKey("output"))
 		} else if len(topts.Output) > 0 {
 			opts.Output = topts.Output
 		}
+		for _, d := range topts.Defines {
+			if j := slices.IndexFunc(opts.Defines, func(o definedPlaceholder) bool { return o.name == d.name }); j >= 0 {
+				return options{}, fmt.Errorf("conflicting define=%s= directives: %s, and %s", d.name, describeOrigins(topts.originOf(d.key())), describeOrigins(opts.originOf(opts.Defines[j].key())))
+			}
+			opts.Defines = append(opts.Defines, d)
+		}
+		if len(opts.Aliases) > 0 && len(topts.Aliases) > 0 {
+			return options{}, conflict(settingKey("alias"))
+		} else if len(topts.Aliases) > 0 {
+			opts.Aliases = topts.Aliases
+		}
+		if opts.ArchiveEntry != "" && topts.ArchiveEntry != "" {
+			return options{}, conflict(settingKey("archive-entry"))
+		} else if topts.ArchiveEntry != "" {
+			opts.ArchiveEntry = topts.ArchiveEntry
+		}
 		if len(opts.Format) > 0 && len(topts.Format) > 0 {
-			return options{}, fmt.Errorf("%s: format= already set elsewhere", path)
+			return options{}, conflict(settingKey("format"))
 		} else if len(topts.Format) > 0 {
 			opts.Format = topts.Format
 		}
+		if opts.Parallel != 0 && topts.Parallel != 0 {
+			return options{}, conflict(settingKey("parallel"))
+		} else if topts.Parallel != 0 {
+			opts.Parallel = topts.Parallel
+		}
+		if opts.Limits.memory != 0 && topts.Limits.memory != 0 {
+			return options{}, conflict(settingKey("memory-limit"))
+		} else if topts.Limits.memory != 0 {
+			opts.Limits.memory = topts.Limits.memory
+		}
+		if opts.Limits.cpus != 0 && topts.Limits.cpus != 0 {
+			return options{}, conflict(settingKey("cpu-limit"))
+		} else if topts.Limits.cpus != 0 {
+			opts.Limits.cpus = topts.Limits.cpus
+		}
+		if opts.Partial != "" && topts.Partial != "" {
+			return options{}, conflict(settingKey("partial"))
+		} else if topts.Partial != "" {
+			opts.Partial = topts.Partial
+		}
+		if opts.Compression != "" && topts.Compression != "" {
+			return options{}, conflict(settingKey("compression"))
+		} else if topts.Compression != "" {
+			opts.Compression = topts.Compression
+		}
+		if opts.KeepGoing != "" && topts.KeepGoing != "" {
+			return options{}, conflict(settingKey("keep-going"))
+		} else if topts.KeepGoing != "" {
+			opts.KeepGoing = topts.KeepGoing
+		}
+		if opts.Checksums != "" && topts.Checksums != "" {
+			return options{}, conflict(settingKey("checksums"))
+		} else if topts.Checksums != "" {
+			opts.Checksums = topts.Checksums
+		}
+		if opts.ChecksumAlgorithm != "" && topts.ChecksumAlgorithm != "" {
+			return options{}, conflict(settingKey("checksum-algorithm"))
+		} else if topts.ChecksumAlgorithm != "" {
+			opts.ChecksumAlgorithm = topts.ChecksumAlgorithm
+		}
+		if opts.ChecksumSidecars != "" && topts.ChecksumSidecars != "" {
+			return options{}, conflict(settingKey("checksum-sidecars"))
+		} else if topts.ChecksumSidecars != "" {
+			opts.ChecksumSidecars = topts.ChecksumSidecars
+		}
+		if opts.WasmExec != "" && topts.WasmExec != "" {
+			return options{}, conflict(settingKey("wasm-exec"))
+		} else if topts.WasmExec != "" {
+			opts.WasmExec = topts.WasmExec
+		}
+		for _, key := range packageKeys {
+			if *opts.Package.field(key) != "" && *topts.Package.field(key) != "" {
+				return options{}, conflict(settingKey("package." + key))
+			} else if v := *topts.Package.field(key); v != "" {
+				*opts.Package.field(key) = v
+			}
+		}
+		if opts.DirectiveFile != "" && topts.DirectiveFile != "" {
+			return options{}, conflict(settingKey("directive-file"))
+		} else if topts.DirectiveFile != "" {
+			opts.DirectiveFile = topts.DirectiveFile
+		}
+		if opts.GoCache != "" && topts.GoCache != "" {
+			return options{}, conflict(settingKey("gocache"))
+		} else if topts.GoCache != "" {
+			opts.GoCache = topts.GoCache
+		}
+		if opts.GoCacheProg != "" && topts.GoCacheProg != "" {
+			return options{}, conflict(settingKey("gocacheprog"))
+		} else if topts.GoCacheProg != "" {
+			opts.GoCacheProg = topts.GoCacheProg
+		}
+		if opts.Stamp != "" && topts.Stamp != "" {
+			return options{}, conflict(settingKey("stamp"))
+		} else if topts.Stamp != "" {
+			opts.Stamp = topts.Stamp
+		}
+		if opts.Ldflags != "" && topts.Ldflags != "" {
+			return options{}, conflict(settingKey("ldflags"))
+		} else if topts.Ldflags != "" {
+			opts.Ldflags = topts.Ldflags
+		}
+		opts.TargetLdflags = append(opts.TargetLdflags, topts.TargetLdflags...)
+		opts.Extensions = append(opts.Extensions, topts.Extensions...)
+		if opts.Image != "" && topts.Image != "" {
+			return options{}, conflict(settingKey("image"))
+		} else if topts.Image != "" {
+			opts.Image = topts.Image
+		}
+		if opts.ImageBase != "" && topts.ImageBase != "" {
+			return options{}, conflict(settingKey("image-base"))
+		} else if topts.ImageBase != "" {
+			opts.ImageBase = topts.ImageBase
+		}
 		opts.Exclude = append(opts.Exclude, topts.Exclude...)
 		opts.Include = append(opts.Include, topts.Include...)
-	}
-
-	// By default, we include everything.
-	if len(opts.Include) == 0 {
-		opts.Include = []filter{"*/*"}
-	}
-	if len(opts.Format) == 0 {
-		opts.Format = []format{formatRaw}
-	}
-
-	// These require CGO_ENABLED=1, which I don't want to touch right now.
-	// As I don't have a use for it, let's just disable them.
-	opts.Exclude = append(opts.Exclude, "android/*", "ios/*")
-
-	if len(opts.Output) == 0 {
-		opts.Output = "${TARGET}-${GOOS}-${GOARCH}"
+		opts.Priority = append(opts.Priority, topts.Priority...)
+		opts.Remote = append(opts.Remote, topts.Remote...)
+		opts.TargetEnv = append(opts.TargetEnv, topts.TargetEnv...)
+		opts.Variants = append(opts.Variants, topts.Variants...)
+		opts.Classes = append(opts.Classes, topts.Classes...)
+		for _, class := range resourceClasses {
+			for _, key := range classKeys {
+				if opts.ClassSettings.of(class).get(key) != "" && topts.ClassSettings.of(class).get(key) != "" {
+					return options{}, conflict(settingKey("class." + string(class) + "." + key))
+				}
+				opts.ClassSettings.of(class).copy(*topts.ClassSettings.of(class), key)
+			}
+		}
+		opts.RegistryAuth = append(opts.RegistryAuth, topts.RegistryAuth...)
+		opts.EnvAllow = append(opts.EnvAllow, topts.EnvAllow...)
+		opts.Secrets = append(opts.Secrets, topts.Secrets...)
+		opts.Deploy = append(opts.Deploy, topts.Deploy...)
+		opts.Upload = append(opts.Upload, topts.Upload...)
+		opts.CopyTo = append(opts.CopyTo, topts.CopyTo...)
+		opts.Publish = append(opts.Publish, topts.Publish...)
+		opts.IgnoreFiles = append(opts.IgnoreFiles, topts.IgnoreFiles...)
+		for key, origins := range topts.origins {
+			for _, o := range origins {
+				opts.setOrigin(key, o)
+			}
+		}
 	}
 	return opts, nil
 }
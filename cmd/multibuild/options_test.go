@@ -6,6 +6,8 @@ package main
 
 import (
 	"os"
+	"path/filepath"
+	"runtime"
 	"slices"
 	"strings"
 	"testing"
@@ -38,6 +40,19 @@ func TestFilterMatches(t *testing.T) {
 		// Full wildcard
 		{"*/*", "windows/amd64", true},
 		{"*/*", "linux/arm64", true},
+
+		// Host
+		{"host/host", runtime.GOOS + "/" + runtime.GOARCH, true},
+		{"host/*", runtime.GOOS + "/wat", true},
+		{"*/host", "wat/" + runtime.GOARCH, true},
+		{"host/host", "wat/wat", false},
+
+		// Variants
+		{"linux/arm", "linux/arm/v7", true},
+		{"linux/*", "linux/arm/v7", true},
+		{"linux/arm/v7", "linux/arm/v7", true},
+		{"linux/arm/v7", "linux/arm/v6", false},
+		{"linux/arm/v7", "linux/arm", false},
 	}
 
 	for _, tt := range tests {
@@ -63,108 +78,835 @@ func TestBuildTargetList(t *testing.T) {
 		wantErr bool
 	}{
 		{
-			name:    "Include windows/arm64 only",
-			options: options{Include: []filter{"windows/arm64"}},
-			want:    []target{"windows/arm64"},
-			wantErr: false,
+			name:    "Include windows/arm64 only",
+			options: options{Include: []filter{"windows/arm64"}},
+			want:    []target{"windows/arm64"},
+			wantErr: false,
+		},
+		{
+			name:    "Include all arm64",
+			options: options{Include: []filter{"*/arm64"}},
+			want:    []target{"windows/arm64", "linux/arm64"},
+			wantErr: false,
+		},
+		{
+			name:    "Include windows/*, exclude windows/arm64",
+			options: options{Include: []filter{"windows/*"}, Exclude: []filter{"windows/arm64"}},
+			want:    []target{"windows/amd64"},
+			wantErr: false,
+		},
+		{
+			name:    "Exclude all windows",
+			options: options{Include: []filter{"*/*"}, Exclude: []filter{"windows/*"}},
+			want:    []target{"linux/amd64", "linux/arm64"},
+			wantErr: false,
+		},
+		{
+			name:    "Required include missing",
+			options: options{Include: []filter{"darwin/amd64"}}, // not in allTargets
+			want:    nil,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.options.buildTargetList(allTargets)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("want/got mismatch: %+v vs %+v", got, tt.want)
+			}
+			for idx := range got {
+				got := got[idx]
+				want := tt.want[idx]
+				if got != want {
+					t.Errorf("mismatch at %d; got %v, want %v", idx, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildTargetListConflict(t *testing.T) {
+	var opts options
+	opts.Include = []filter{"windows/arm64"}
+	opts.setOrigin(settingKey("include", "windows/arm64"), origin{source: sourceDirective, location: "a.go:1"})
+	opts.Exclude = []filter{"linux/*", "windows/*"}
+	opts.setOrigin(settingKey("exclude", "linux/*"), origin{source: sourceDirective, location: "b.go:2"})
+	opts.setOrigin(settingKey("exclude", "windows/*"), origin{source: sourceDirective, location: "b.go:3"})
+
+	_, err := opts.buildTargetList([]target{"windows/arm64", "linux/arm64"})
+	want := "multibuild: include=windows/arm64 (directive at a.go:1) is contradicted by exclude=windows/* (directive at b.go:3)"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+}
+
+func TestScanDirectivesConflict(t *testing.T) {
+	a := makeTempFile(t, "//go:multibuild:output=${TARGET}-${GOOS}-${GOARCH}\n")
+	defer os.Remove(a)
+	b := makeTempFile(t, "package main\n\n//go:multibuild:output=bin/${TARGET}-${GOOS}-${GOARCH}\n")
+	defer os.Remove(b)
+
+	_, err := scanDirectives([]string{a, b}, options{})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"conflicting output= directives", b + ":3", a + ":1"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("got %q, want it to mention %q", err, want)
+		}
+	}
+}
+
+func TestScanDirectivesIgnoreFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"main.go":     "//go:multibuild:include=linux/amd64\n//go:multibuild:ignore-files=*.pb.go\npackage main\n",
+		"api.pb.go":   "//go:multibuild:include=bogus\npackage main\n",
+		"zz_types.go": "//go:multibuild:output=${TARGET}-${GOOS}-${GOARCH}\npackage main\n",
+	})
+	sources := []string{filepath.Join(dir, "api.pb.go"), filepath.Join(dir, "main.go"), filepath.Join(dir, "zz_types.go")}
+
+	// api.pb.go is ignored by main.go, though it comes first, and zz_types.go by the caller.
+	got, err := scanDirectives(sources, options{IgnoreFiles: []string{"zz_*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got.Include, []filter{"linux/amd64"}) || got.Output != "" || !slices.Equal(got.IgnoreFiles, []string{"*.pb.go"}) {
+		t.Errorf("got include=%v output=%q ignore-files=%v", got.Include, got.Output, got.IgnoreFiles)
+	}
+}
+
+func TestScanDirectivesDirectiveFile(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"app.go":       "//go:multibuild:directive-file=main\npackage main\n\nfunc main() {}\n",
+		"generated.go": "// Code generated by foo. DO NOT EDIT.\n//go:multibuild:ignore\n//go:multibuild:include=bogus\npackage main\n",
+		"plain.go":     "package main\n\nfunc helper() {}\n",
+		"stray.go":     "package main\n\n//go:multibuild:include=linux/amd64\nfunc stray() {}\n",
+	})
+	app, generated, plain, stray := filepath.Join(dir, "app.go"), filepath.Join(dir, "generated.go"), filepath.Join(dir, "plain.go"), filepath.Join(dir, "stray.go")
+
+	// Files without directives, and those ignoring theirs, are fine.
+	got, err := scanDirectives([]string{app, generated, plain}, options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.DirectiveFile != "main" || len(got.Include) != 0 {
+		t.Errorf("got directive-file=%q include=%v", got.DirectiveFile, got.Include)
+	}
+
+	_, err = scanDirectives([]string{app, stray}, options{})
+	if err == nil || !strings.Contains(err.Error(), "stray.go:3: directives are only allowed in the file containing func main") || !strings.Contains(err.Error(), "app.go:1") {
+		t.Errorf("got error %v", err)
+	}
+
+	// Inherited from a .multibuild file, it applies to the sources too.
+	var scope options
+	scope.DirectiveFile = "multibuild.go"
+	scope.setOrigin(settingKey("directive-file"), origin{source: sourceDirective, location: ".multibuild:1"})
+	_, err = scanDirectives([]string{stray}, scope)
+	if err == nil || !strings.Contains(err.Error(), "directives are only allowed in multibuild.go (directive-file=multibuild.go from directive at .multibuild:1)") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestOrderTargets(t *testing.T) {
+	targets := []target{"windows/amd64", "linux/arm64", "darwin/arm64", "linux/amd64"}
+
+	tests := []struct {
+		name     string
+		priority []filter
+		want     []target
+	}{
+		{
+			name: "alphabetical by default",
+			want: []target{"darwin/arm64", "linux/amd64", "linux/arm64", "windows/amd64"},
+		},
+		{
+			name:     "single priority",
+			priority: []filter{"windows/amd64"},
+			want:     []target{"windows/amd64", "darwin/arm64", "linux/amd64", "linux/arm64"},
+		},
+		{
+			name:     "priorities in filter order",
+			priority: []filter{"*/arm64", "windows/*"},
+			want:     []target{"darwin/arm64", "linux/arm64", "windows/amd64", "linux/amd64"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := options{Priority: tt.priority}.orderTargets(targets)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestrictTargetList(t *testing.T) {
+	host := target(runtime.GOOS + "/" + runtime.GOARCH)
+	allTargets := []target{"windows/amd64", "linux/arm64", host}
+
+	got, err := restrictTargetList(allTargets, []filter{"host/host"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(got, []target{host}) {
+		t.Errorf("got %v, want %v", got, []target{host})
+	}
+
+	got, err = restrictTargetList(allTargets, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(got, allTargets) {
+		t.Errorf("got %v, want %v", got, allTargets)
+	}
+
+	_, err = restrictTargetList(allTargets, []filter{"plan9/*"})
+	if err == nil {
+		t.Errorf("expected error restricting to an unconfigured target")
+	}
+}
+
+func TestReadTargetList(t *testing.T) {
+	allTargets := []target{"darwin/arm64", "linux/amd64", "linux/arm64", "windows/amd64"}
+
+	got, err := readTargetList(strings.NewReader("# from CI\nwindows/amd64\n\n  linux/arm64  \n"), allTargets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The order is kept, and nothing is filtered.
+	if want := []target{"windows/amd64", "linux/arm64"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for input, wantErr := range map[string]string{
+		"linux/amd64\nplan9/sparc\n": "line 2: plan9/sparc is not a target go supports",
+		"linux/*\n":                  "line 1: linux/* is not a target go supports",
+		"linux/amd64\nlinux/amd64\n": "line 2: linux/amd64 is listed more than once",
+		"# nothing\n\n":              "no targets are listed",
+	} {
+		_, err := readTargetList(strings.NewReader(input), allTargets)
+		if err == nil || err.Error() != wantErr {
+			t.Errorf("readTargetList(%q) error = %v, want %q", input, err, wantErr)
+		}
+	}
+}
+
+func TestScanBuildPath(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		want      options
+		wantError bool
+	}{
+		{
+			name:      "empty input",
+			input:     "",
+			want:      options{},
+			wantError: false,
+		},
+		{
+			name:  "include only",
+			input: `//go:multibuild:include=windows/arm64,linux/*`,
+			want: options{
+				Include: []filter{"windows/arm64", "linux/*"},
+			},
+			wantError: false,
+		},
+		{
+			name:  "exclude only",
+			input: `//go:multibuild:exclude=darwin/amd64,*/arm64`,
+			want: options{
+				Exclude: []filter{"darwin/amd64", "*/arm64"},
+			},
+			wantError: false,
+		},
+		{
+			name: "both include and exclude",
+			input: `
+				//go:multibuild:include=windows/arm64
+				//go:multibuild:exclude=darwin/amd64
+				`,
+			want: options{
+				Include: []filter{"windows/arm64"},
+				Exclude: []filter{"darwin/amd64"},
+			},
+			wantError: false,
+		},
+		{
+			name: "ignores unrelated go: comments",
+			input: `//go:generate something
+//go:otherkey:value
+//go:multibuild:include=windows/amd64`,
+			want: options{
+				Include: []filter{"windows/amd64"},
+			},
+			wantError: false,
+		},
+		{
+			name: "priority accumulates",
+			input: `
+				//go:multibuild:priority=linux/amd64
+				//go:multibuild:priority=darwin/*
+				`,
+			want: options{
+				Priority: []filter{"linux/amd64", "darwin/*"},
+			},
+			wantError: false,
+		},
+		{
+			name:      "parallel twice",
+			input:     "//go:multibuild:parallel=2\n//go:multibuild:parallel=3",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:  "parallel auto",
+			input: `//go:multibuild:parallel=auto`,
+			want: options{
+				Parallel: parallelAuto,
+			},
+			wantError: false,
+		},
+		{
+			name:      "parallel auto twice",
+			input:     "//go:multibuild:parallel=auto\n//go:multibuild:parallel=auto",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:      "invalid parallel",
+			input:     "//go:multibuild:parallel=none",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:  "limits",
+			input: "//go:multibuild:memory-limit=2GiB\n//go:multibuild:cpu-limit=1.5",
+			want: options{
+				Limits: buildLimits{memory: 2 << 30, cpus: 1.5},
+			},
+			wantError: false,
+		},
+		{
+			name:      "memory-limit twice",
+			input:     "//go:multibuild:memory-limit=2GiB\n//go:multibuild:memory-limit=1GiB",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:      "invalid cpu-limit",
+			input:     "//go:multibuild:cpu-limit=0",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:  "classes",
+			input: "//go:multibuild:class.heavy=js/wasm,windows/*\n//go:multibuild:class.light=linux/*\n//go:multibuild:class.heavy.parallel=2\n//go:multibuild:class.heavy.memory-limit=4GiB",
+			want: options{
+				Classes: []classTag{{"js/wasm", classHeavy}, {"windows/*", classHeavy}, {"linux/*", classLight}},
+				ClassSettings: classesSettings{
+					Heavy: classSettings{parallel: 2, limits: buildLimits{memory: 4 << 30}},
+				},
+			},
+			wantError: false,
+		},
+		{
+			name:      "unknown class",
+			input:     "//go:multibuild:class.medium=linux/*",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:      "unknown class setting",
+			input:     "//go:multibuild:class.heavy.priority=1",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:      "class setting twice",
+			input:     "//go:multibuild:class.light.parallel=2\n//go:multibuild:class.light.parallel=4",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:  "partial",
+			input: `//go:multibuild:partial=discard`,
+			want: options{
+				Partial: partialDiscard,
+			},
+			wantError: false,
+		},
+		{
+			name:      "invalid partial",
+			input:     "//go:multibuild:partial=some",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:  "keep-going",
+			input: `//go:multibuild:keep-going=0`,
+			want: options{
+				KeepGoing: "false",
+			},
+			wantError: false,
+		},
+		{
+			name:  "compression",
+			input: `//go:multibuild:compression=best`,
+			want: options{
+				Compression: compressionBest,
+			},
+			wantError: false,
+		},
+		{
+			name:      "invalid compression",
+			input:     "//go:multibuild:compression=9",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:  "checksums",
+			input: `//go:multibuild:checksums=dist/SHA256SUMS`,
+			want: options{
+				Checksums: "dist/SHA256SUMS",
+			},
+			wantError: false,
+		},
+		{
+			name:      "checksums twice",
+			input:     "//go:multibuild:checksums=SHA256SUMS\n//go:multibuild:checksums=SUMS",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:  "checksum-algorithm",
+			input: `//go:multibuild:checksum-algorithm=blake2b`,
+			want: options{
+				ChecksumAlgorithm: checksumBLAKE2b,
+			},
+			wantError: false,
+		},
+		{
+			name:      "invalid checksum-algorithm",
+			input:     "//go:multibuild:checksum-algorithm=md5",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:  "checksum-sidecars",
+			input: `//go:multibuild:checksum-sidecars=1`,
+			want: options{
+				ChecksumSidecars: "true",
+			},
+			wantError: false,
+		},
+		{
+			name:      "checksum-sidecars twice",
+			input:     "//go:multibuild:checksum-sidecars=true\n//go:multibuild:checksum-sidecars=false",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:  "wasm-exec",
+			input: `//go:multibuild:wasm-exec=true`,
+			want: options{
+				WasmExec: "true",
+			},
+			wantError: false,
+		},
+		{
+			name:      "wasm-exec twice",
+			input:     "//go:multibuild:wasm-exec=true\n//go:multibuild:wasm-exec=false",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:      "invalid keep-going",
+			input:     "//go:multibuild:keep-going=sometimes",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:      "keep-going twice",
+			input:     "//go:multibuild:keep-going=true\n//go:multibuild:keep-going=false",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:  "remote",
+			input: `//go:multibuild:remote.darwin/*=ci@mac-mini:builds/app`,
+			want: options{
+				Remote: []remoteBuilder{{filter: "darwin/*", host: "ci@mac-mini", dir: "builds/app"}},
+			},
+			wantError: false,
+		},
+		{
+			name:      "invalid remote",
+			input:     `//go:multibuild:remote.darwin/*`,
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:  "env",
+			input: "//go:multibuild:env.linux/mipsle=GOMIPS=softfloat\n//go:multibuild:env.darwin/*=CGO_ENABLED=1",
+			want: options{
+				TargetEnv: []targetEnvVar{{filter: "linux/mipsle", name: "GOMIPS", value: "softfloat"}, {filter: "darwin/*", name: "CGO_ENABLED", value: "1"}},
+			},
+			wantError: false,
+		},
+		{
+			name:  "define",
+			input: "//go:multibuild:define=PRODUCT=Widget\n//go:multibuild:define=CHANNEL=beta-1",
+			want: options{
+				Defines: []definedPlaceholder{{name: "PRODUCT", value: "Widget"}, {name: "CHANNEL", value: "beta-1"}},
+			},
+			wantError: false,
+		},
+		{
+			name:  "alias",
+			input: "//go:multibuild:alias=amd64=x86_64,darwin=macOS",
+			want: options{
+				Aliases: nameAliases{{name: "amd64", alias: "x86_64"}, {name: "darwin", alias: "macOS"}},
+			},
+			wantError: false,
+		},
+		{
+			name:  "archive-entry",
+			input: "//go:multibuild:archive-entry=${NAME}/${TARGET}",
+			want: options{
+				ArchiveEntry: "${NAME}/${TARGET}",
+			},
+			wantError: false,
+		},
+		{
+			name:      "archive-entry twice",
+			input:     "//go:multibuild:archive-entry=a\n//go:multibuild:archive-entry=b",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:  "extension",
+			input: "//go:multibuild:extension.js/wasm=.wasm\n//go:multibuild:extension.windows/*=",
+			want: options{
+				Extensions: []targetExtension{{filter: "js/wasm", extension: ".wasm"}, {filter: "windows/*", extension: ""}},
+			},
+			wantError: false,
+		},
+		{
+			name:      "invalid extension",
+			input:     `//go:multibuild:extension.js/wasm=wasm`,
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:      "alias twice",
+			input:     "//go:multibuild:alias=amd64=x86_64\n//go:multibuild:alias=darwin=macOS",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:      "invalid alias",
+			input:     `//go:multibuild:alias=amd64`,
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:      "define twice",
+			input:     "//go:multibuild:define=PRODUCT=Widget\n//go:multibuild:define=PRODUCT=Gadget",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:      "define a builtin placeholder",
+			input:     `//go:multibuild:define=GOOS=plan9`,
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:      "define a lowercase placeholder",
+			input:     `//go:multibuild:define=product=Widget`,
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:      "define a directory",
+			input:     `//go:multibuild:define=PRODUCT=a/b`,
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:      "define without a value",
+			input:     `//go:multibuild:define=PRODUCT`,
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:      "invalid env",
+			input:     `//go:multibuild:env.linux/*=GOOS=darwin`,
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:  "ldflags",
+			input: "//go:multibuild:ldflags=-s -w\n//go:multibuild:ldflags.windows/*=-H windowsgui",
+			want: options{
+				Ldflags:       "-s -w",
+				TargetLdflags: []targetLdflags{{filter: "windows/*", flags: "-H windowsgui"}},
+			},
+			wantError: false,
+		},
+		{
+			name:      "ldflags twice",
+			input:     "//go:multibuild:ldflags=-s\n//go:multibuild:ldflags=-w",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:      "invalid ldflags",
+			input:     `//go:multibuild:ldflags.windows/*=windowsgui`,
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:  "variant",
+			input: "//go:multibuild:variant.linux/arm=v6,v7\n//go:multibuild:variant.*/amd64=v1,v3",
+			want: options{
+				Variants: []variantSetting{{filter: "linux/arm", variants: []string{"v6", "v7"}}, {filter: "*/amd64", variants: []string{"v1", "v3"}}},
+			},
+			wantError: false,
+		},
+		{
+			name:      "invalid variant",
+			input:     `//go:multibuild:variant.linux/arm=v9`,
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:  "env-allow",
+			input: "//go:multibuild:env-allow=GOFLAGS,CGO_ENABLED\n//go:multibuild:env-allow=MY_VAR1",
+			want: options{
+				EnvAllow: []string{"GOFLAGS", "CGO_ENABLED", "MY_VAR1"},
+			},
+			wantError: false,
+		},
+		{
+			name:      "invalid env-allow",
+			input:     "//go:multibuild:env-allow=1VAR",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:  "ignore-files",
+			input: "//go:multibuild:ignore-files=*.pb.go,zz_generated*",
+			want: options{
+				IgnoreFiles: []string{"*.pb.go", "zz_generated*"},
+			},
+			wantError: false,
+		},
+		{
+			name:  "gocache",
+			input: "//go:multibuild:gocache=/var/cache/go\n//go:multibuild:gocacheprog=cacheprog -dir /mnt/cache",
+			want: options{
+				GoCache:     "/var/cache/go",
+				GoCacheProg: "cacheprog -dir /mnt/cache",
+			},
+			wantError: false,
+		},
+		{
+			name:      "empty gocache",
+			input:     "//go:multibuild:gocache=",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:      "gocacheprog already set",
+			input:     "//go:multibuild:gocacheprog=a\n//go:multibuild:gocacheprog=b",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:  "directive-file",
+			input: "//go:multibuild:directive-file=multibuild.go",
+			want: options{
+				DirectiveFile: "multibuild.go",
+			},
+			wantError: false,
 		},
 		{
-			name:    "Include all arm64",
-			options: options{Include: []filter{"*/arm64"}},
-			want:    []target{"windows/arm64", "linux/arm64"},
-			wantErr: false,
+			name:  "directive-file main",
+			input: "//go:multibuild:directive-file=main",
+			want: options{
+				DirectiveFile: "main",
+			},
+			wantError: false,
 		},
 		{
-			name:    "Include windows/*, exclude windows/arm64",
-			options: options{Include: []filter{"windows/*"}, Exclude: []filter{"windows/arm64"}},
-			want:    []target{"windows/amd64"},
-			wantErr: false,
+			name:      "invalid directive-file",
+			input:     "//go:multibuild:directive-file=cmd/multibuild.go",
+			want:      options{},
+			wantError: true,
 		},
 		{
-			name:    "Exclude all windows",
-			options: options{Include: []filter{"*/*"}, Exclude: []filter{"windows/*"}},
-			want:    []target{"linux/amd64", "linux/arm64"},
-			wantErr: false,
+			name:      "directive-file already set",
+			input:     "//go:multibuild:directive-file=main\n//go:multibuild:directive-file=multibuild.go",
+			want:      options{},
+			wantError: true,
 		},
 		{
-			name:    "Required include missing",
-			options: options{Include: []filter{"darwin/amd64"}}, // not in allTargets
-			want:    nil,
-			wantErr: true,
+			name:      "invalid ignore-files",
+			input:     "//go:multibuild:ignore-files=gen/*.go",
+			want:      options{},
+			wantError: true,
 		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got, err := tt.options.buildTargetList(allTargets)
-			if (err != nil) != tt.wantErr {
-				t.Fatalf("got err %v, wantErr %v", err, tt.wantErr)
-			}
-			if len(got) != len(tt.want) {
-				t.Fatalf("want/got mismatch: %+v vs %+v", got, tt.want)
-			}
-			for idx := range got {
-				got := got[idx]
-				want := tt.want[idx]
-				if got != want {
-					t.Errorf("mismatch at %d; got %v, want %v", idx, got, tt.want)
-				}
-			}
-		})
-	}
-}
-
-func TestScanBuildPath(t *testing.T) {
-	tests := []struct {
-		name      string
-		input     string
-		want      options
-		wantError bool
-	}{
 		{
-			name:      "empty input",
-			input:     "",
+			name:      "ignore",
+			input:     "// Code generated by foo. DO NOT EDIT.\n//go:multibuild:ignore\n//go:multibuild:include=linux/amd64\n//go:multibuild:not-a-directive",
 			want:      options{},
 			wantError: false,
 		},
 		{
-			name:  "include only",
-			input: `//go:multibuild:include=windows/arm64,linux/*`,
+			name:  "secret",
+			input: "//go:multibuild:secret=builtin\n//go:multibuild:secret=corp\\.example\\.com",
 			want: options{
-				Include: []filter{"windows/arm64", "linux/*"},
+				Secrets: []string{"builtin", `corp\.example\.com`},
 			},
 			wantError: false,
 		},
 		{
-			name:  "exclude only",
-			input: `//go:multibuild:exclude=darwin/amd64,*/arm64`,
+			name:      "invalid secret",
+			input:     "//go:multibuild:secret=(unclosed",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:  "stamp",
+			input: "//go:multibuild:stamp=example.com/app/buildinfo",
 			want: options{
-				Exclude: []filter{"darwin/amd64", "*/arm64"},
+				Stamp: "example.com/app/buildinfo",
 			},
 			wantError: false,
 		},
 		{
-			name: "both include and exclude",
-			input: `
-				//go:multibuild:include=windows/arm64
-				//go:multibuild:exclude=darwin/amd64
-				`,
+			name:      "duplicate stamp",
+			input:     "//go:multibuild:stamp=main\n//go:multibuild:stamp=main",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:  "image",
+			input: "//go:multibuild:image=ghcr.io/example/app\n//go:multibuild:image-base=scratch",
 			want: options{
-				Include: []filter{"windows/arm64"},
-				Exclude: []filter{"darwin/amd64"},
+				Image:     "ghcr.io/example/app",
+				ImageBase: "scratch",
 			},
 			wantError: false,
 		},
 		{
-			name: "ignores unrelated go: comments",
-			input: `//go:generate something
-//go:otherkey:value
-//go:multibuild:include=windows/amd64`,
+			name:      "image by digest",
+			input:     "//go:multibuild:image=ghcr.io/example/app@sha256:0123",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:      "duplicate image-base",
+			input:     "//go:multibuild:image-base=scratch\n//go:multibuild:image-base=alpine",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:  "registry-auth",
+			input: "//go:multibuild:registry-auth.ghcr.io=env:GITHUB_ACTOR:GITHUB_TOKEN\n//go:multibuild:registry-auth.gcr.io=helper:gcloud",
 			want: options{
-				Include: []filter{"windows/amd64"},
+				RegistryAuth: []registryAuth{{"ghcr.io", "env:GITHUB_ACTOR:GITHUB_TOKEN"}, {"gcr.io", "helper:gcloud"}},
+			},
+			wantError: false,
+		},
+		{
+			name:      "invalid registry-auth",
+			input:     "//go:multibuild:registry-auth.ghcr.io=hunter2",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:  "upload",
+			input: "//go:multibuild:upload=https://artifacts.example.com/app/\n//go:multibuild:upload=azblob://account/releases",
+			want: options{
+				Upload: []string{"https://artifacts.example.com/app/", "azblob://account/releases"},
+			},
+			wantError: false,
+		},
+		{
+			name:      "invalid upload",
+			input:     "//go:multibuild:upload=ftp://example.com/",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:  "copy-to",
+			input: "//go:multibuild:copy-to.linux/amd64=deploy@web1:/opt/app/,deploy@web2:/opt/app/",
+			want: options{
+				CopyTo: []copyDest{{filter: "linux/amd64", hosts: []string{"deploy@web1:/opt/app/", "deploy@web2:/opt/app/"}}},
+			},
+			wantError: false,
+		},
+		{
+			name:  "publish",
+			input: "//go:multibuild:publish=./scripts/upload.sh ${ARCHIVE} ${GOOS} ${GOARCH} ${SHA256}",
+			want: options{
+				Publish: []string{"./scripts/upload.sh ${ARCHIVE} ${GOOS} ${GOARCH} ${SHA256}"},
+			},
+			wantError: false,
+		},
+		{
+			name:  "deploy",
+			input: "//go:multibuild:deploy=../../deploy/values.yaml\n//go:multibuild:deploy=../../deploy/kustomization.yaml",
+			want: options{
+				Deploy: []string{"../../deploy/values.yaml", "../../deploy/kustomization.yaml"},
+			},
+			wantError: false,
+		},
+		{
+			name:      "invalid deploy",
+			input:     "//go:multibuild:deploy=values.json",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:  "package",
+			input: "//go:multibuild:package.name=app\n//go:multibuild:package.identifier=com.example.app\n//go:multibuild:package.version=1.0",
+			want: options{
+				Package: packageInfo{Name: "app", Identifier: "com.example.app", Version: "1.0"},
+			},
+			wantError: false,
+		},
+		{
+			name:  "service",
+			input: "//go:multibuild:package.service=app\n//go:multibuild:package.bindir=/opt/app/bin",
+			want: options{
+				Package: packageInfo{Service: "app", BinDir: "/opt/app/bin"},
 			},
 			wantError: false,
 		},
+		{
+			name:      "relative bindir",
+			input:     "//go:multibuild:package.bindir=bin",
+			want:      options{},
+			wantError: true,
+		},
+		{
+			name:      "unknown package key",
+			input:     "//go:multibuild:package.colour=blue",
+			want:      options{},
+			wantError: true,
+		},
 		{
 			name:      "invalid instruction",
 			input:     `//go:multibuild:badtag=foobar`,
@@ -186,6 +928,42 @@ func TestScanBuildPath(t *testing.T) {
 		if !slices.Equal(a.Exclude, b.Exclude) {
 			return false
 		}
+		if !slices.Equal(a.Priority, b.Priority) {
+			return false
+		}
+		if a.Parallel != b.Parallel || a.Limits != b.Limits || a.Partial != b.Partial || a.KeepGoing != b.KeepGoing || a.WasmExec != b.WasmExec || a.Compression != b.Compression || a.Checksums != b.Checksums || a.ChecksumAlgorithm != b.ChecksumAlgorithm || a.ChecksumSidecars != b.ChecksumSidecars {
+			return false
+		}
+		if !slices.Equal(a.Classes, b.Classes) || a.ClassSettings != b.ClassSettings {
+			return false
+		}
+		if !slices.Equal(a.Remote, b.Remote) {
+			return false
+		}
+		if !slices.Equal(a.TargetEnv, b.TargetEnv) {
+			return false
+		}
+		if !slices.Equal(a.Defines, b.Defines) || !slices.Equal(a.Aliases, b.Aliases) || !slices.Equal(a.Extensions, b.Extensions) || a.ArchiveEntry != b.ArchiveEntry {
+			return false
+		}
+		if a.Ldflags != b.Ldflags || !slices.Equal(a.TargetLdflags, b.TargetLdflags) {
+			return false
+		}
+		if !slices.EqualFunc(a.Variants, b.Variants, func(x, y variantSetting) bool { return x.filter == y.filter && slices.Equal(x.variants, y.variants) }) {
+			return false
+		}
+		if !slices.Equal(a.EnvAllow, b.EnvAllow) || !slices.Equal(a.IgnoreFiles, b.IgnoreFiles) {
+			return false
+		}
+		if !slices.Equal(a.Secrets, b.Secrets) || !slices.Equal(a.Deploy, b.Deploy) || !slices.Equal(a.RegistryAuth, b.RegistryAuth) || !slices.Equal(a.Upload, b.Upload) || !slices.Equal(a.Publish, b.Publish) {
+			return false
+		}
+		if !slices.EqualFunc(a.CopyTo, b.CopyTo, func(x, y copyDest) bool { return x.filter == y.filter && slices.Equal(x.hosts, y.hosts) }) {
+			return false
+		}
+		if a.Stamp != b.Stamp || a.DirectiveFile != b.DirectiveFile || a.GoCache != b.GoCache || a.GoCacheProg != b.GoCacheProg || a.Package != b.Package || a.Image != b.Image || a.ImageBase != b.ImageBase {
+			return false
+		}
 		return true
 	}
 
@@ -220,7 +998,7 @@ func TestScanBuildDir_BasicInclude(t *testing.T) {
 	file := makeTempFile(t, `//go:multibuild:include=windows/amd64,linux/*`)
 	defer os.Remove(file)
 
-	opts, err := scanBuildDir([]string{file})
+	opts, err := loadConfig([]string{file}, options{}, testPlatforms)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -236,7 +1014,7 @@ func TestScanBuildDir_MergeMultipleFiles(t *testing.T) {
 	f2 := makeTempFile(t, `//go:multibuild:include=darwin/*`)
 	defer os.Remove(f2)
 
-	opts, err := scanBuildDir([]string{f1, f2})
+	opts, err := loadConfig([]string{f1, f2}, options{}, testPlatforms)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -252,7 +1030,7 @@ func TestScanBuildDir_ExcludeDefaultCGO(t *testing.T) {
 
 	// Unset CGO_ENABLED
 	os.Setenv("CGO_ENABLED", "0")
-	opts, _ := scanBuildDir([]string{file})
+	opts, _ := loadConfig([]string{file}, options{}, testPlatforms)
 	found := slices.Contains(opts.Exclude, "android/*")
 	if !found {
 		t.Errorf("expected android/* to be excluded when CGO_ENABLED=0, got excludes %v", opts.Exclude)
@@ -263,14 +1041,14 @@ func TestScanBuildDir_EmptyIncludeGetsAll(t *testing.T) {
 	file := makeTempFile(t, "")
 	defer os.Remove(file)
 
-	opts, _ := scanBuildDir([]string{file})
+	opts, _ := loadConfig([]string{file}, options{}, testPlatforms)
 	if len(opts.Include) != 1 || opts.Include[0] != "*/*" {
 		t.Errorf("expected default include of */*, got %v", opts.Include)
 	}
 }
 
 func TestScanBuildDir_FileOpenError(t *testing.T) {
-	_, err := scanBuildDir([]string{"/not/exist"})
+	_, err := loadConfig([]string{"/not/exist"}, options{}, testPlatforms)
 	if err == nil || !strings.Contains(err.Error(), "no such file or directory") {
 		t.Errorf("expected open failure, got %v", err)
 	}
@@ -308,6 +1086,46 @@ func TestValidateTemplate(t *testing.T) {
 			input:   "build/${GOOS}/${GOARCH}/v1/${TARGET}",
 			wantErr: false,
 		},
+		{
+			name:    "variant",
+			input:   "bin/${TARGET}-${GOOS}-${GOARCH}${GOVARIANT}",
+			wantErr: false,
+		},
+		{
+			name:    "version",
+			input:   "dist/${TARGET}-${VERSION}-${GOOS}-${GOARCH}",
+			wantErr: false,
+		},
+		{
+			name:    "user placeholder",
+			input:   "dist/${PRODUCT}/${TARGET}-${CHANNEL_2}-${GOOS}-${GOARCH}",
+			wantErr: false,
+		},
+		{
+			name:    "environment variables",
+			input:   "dist/${ENV:BUILD_NUMBER}/${TARGET}-${ENV:release_name}-${GOOS}-${GOARCH}",
+			wantErr: false,
+		},
+		{
+			name:    "environment variable without a name",
+			input:   "dist/${ENV:}/${TARGET}-${GOOS}-${GOARCH}",
+			wantErr: true,
+		},
+		{
+			name:    "environment variable with a bad name",
+			input:   "dist/${ENV:BUILD-NUMBER}/${TARGET}-${GOOS}-${GOARCH}",
+			wantErr: true,
+		},
+		{
+			name:    "lowercase prefix of an environment variable",
+			input:   "dist/${env:BUILD_NUMBER}/${TARGET}-${GOOS}-${GOARCH}",
+			wantErr: true,
+		},
+		{
+			name:    "commit and date",
+			input:   "nightly/${DATE}/${TARGET}-${COMMIT}-${GOOS}-${GOARCH}",
+			wantErr: false,
+		},
 
 		// --- missing placeholders ---
 		{
@@ -360,15 +1178,66 @@ func TestValidateTemplate(t *testing.T) {
 			wantErr: true,
 		},
 
+		{
+			name:    "spaces",
+			input:   "bin/My Tool ${GOOS}/${GOARCH}/${TARGET} debug",
+			wantErr: false,
+		},
+		{
+			name:    "punctuation",
+			input:   "bin/${GOOS}/${GOARCH}/${TARGET}!+(v1),@'#&%~",
+			wantErr: false,
+		},
+		{
+			name:    "non-ASCII",
+			input:   "bin/outil-${GOOS}-${GOARCH}/${TARGET}-été",
+			wantErr: false,
+		},
+		{
+			name:    "escaped dollar",
+			input:   "bin/$$HOME/${GOOS}-${GOARCH}/${TARGET}$$",
+			wantErr: false,
+		},
+
 		// --- invalid characters ---
 		{
-			name:    "space not allowed",
-			input:   "bin/${GOOS}/${GOARCH}/${TARGET} debug",
+			name:    "backslash",
+			input:   `bin\${GOOS}/${GOARCH}/${TARGET}`,
+			wantErr: true,
+		},
+		{
+			name:    "wildcard",
+			input:   "bin/${GOOS}/${GOARCH}/${TARGET}*",
+			wantErr: true,
+		},
+		{
+			name:    "brackets",
+			input:   "bin/[${GOOS}]/${GOARCH}/${TARGET}",
+			wantErr: true,
+		},
+		{
+			name:    "newline",
+			input:   "bin/${GOOS}/${GOARCH}/${TARGET}\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid UTF-8",
+			input:   "bin/${GOOS}/${GOARCH}/${TARGET}\xff",
+			wantErr: true,
+		},
+		{
+			name:    "trailing dollar",
+			input:   "bin/${GOOS}/${GOARCH}/${TARGET}$",
+			wantErr: true,
+		},
+		{
+			name:    "trailing space in a directory",
+			input:   "bin /${GOOS}/${GOARCH}/${TARGET}",
 			wantErr: true,
 		},
 		{
-			name:    "exclamation mark",
-			input:   "bin/${GOOS}/${GOARCH}/${TARGET}!",
+			name:    "leading space",
+			input:   " ${TARGET}-${GOOS}-${GOARCH}",
 			wantErr: true,
 		},
 
@@ -413,6 +1282,43 @@ func TestValidateTemplate(t *testing.T) {
 	}
 }
 
+func TestValidateTemplateWindows(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr bool
+	}{
+		{"bin/My Tool ${TARGET}-${GOOS}-${GOARCH}", false},
+		{"bin/${TARGET}: ${GOOS}-${GOARCH}", true},
+		{"bin/${TARGET}|${GOOS}-${GOARCH}", true},
+		{`bin/"${TARGET}"-${GOOS}-${GOARCH}`, true},
+		{"bin./${TARGET}-${GOOS}-${GOARCH}", true},
+		{"con/${TARGET}-${GOOS}-${GOARCH}", true},
+		{"nul.d/${TARGET}-${GOOS}-${GOARCH}", true},
+		{"console/${TARGET}-${GOOS}-${GOARCH}", false},
+	}
+	for _, tt := range tests {
+		_, err := validateTemplateOn(tt.input, "windows")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateTemplateOn(%q, windows) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+		}
+		// Only windows is this strict.
+		if _, err := validateTemplateOn(tt.input, "linux"); err != nil {
+			t.Errorf("validateTemplateOn(%q, linux) error = %v", tt.input, err)
+		}
+	}
+}
+
+func TestOutputTemplateExpand(t *testing.T) {
+	got := outputTemplate("bin/$$v/My ${TARGET}-${GOOS}-${GOARCH}").expand(map[string]string{"TARGET": "app", "GOOS": "linux", "GOARCH": "arm64"}, "$")
+	if want := "bin/$v/My app-linux-arm64"; got != want {
+		t.Errorf("expand() = %q, want %q", got, want)
+	}
+	out, outBin := options{Output: "dist/${TARGET} $$${GOOS}-${GOARCH}"}.outputPaths("My Tool", "windows/amd64")
+	if out != "dist/My Tool $windows-amd64" || outBin != "dist/My Tool $windows-amd64.exe" {
+		t.Errorf("outputPaths() = %q, %q", out, outBin)
+	}
+}
+
 func TestValidateFilters_Valid(t *testing.T) {
 	tests := []struct {
 		name string
@@ -455,6 +1361,34 @@ func TestValidateFilters_Valid(t *testing.T) {
 				filter("*/arm64"),
 			},
 		},
+		{
+			name: "host shorthand",
+			in:   "host",
+			want: []filter{filter("host/host")},
+		},
+		{
+			name: "host shorthand in list",
+			in:   "host,linux/*,hostx/amd64",
+			want: []filter{
+				filter("host/host"),
+				filter("linux/*"),
+				filter("hostx/amd64"),
+			},
+		},
+		{
+			name: "host arch",
+			in:   "linux/host",
+			want: []filter{filter("linux/host")},
+		},
+		{
+			name: "variants",
+			in:   "linux/arm/v7,*/amd64/v3,linux/arm64/v8.2",
+			want: []filter{
+				filter("linux/arm/v7"),
+				filter("*/amd64/v3"),
+				filter("linux/arm64/v8.2"),
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -493,6 +1427,11 @@ func TestValidateFilters_Invalid(t *testing.T) {
 		{"wildcard partial arch", "linux/amd*"},
 		{"wildcard mixed os", "l*/amd64"},
 		{"wildcard mixed arch", "linux/*64"},
+		{"host trailing comma", "host,"},
+		{"missing variant", "linux/arm/"},
+		{"unknown variant", "linux/arm/v9"},
+		{"variant of wildcard arch", "linux/*/v7"},
+		{"arch without variants", "linux/s390x/v1"},
 	}
 
 	for _, tt := range tests {
@@ -530,6 +1469,12 @@ func TestValidateFormatString(t *testing.T) {
 			wantErr: false,
 			outputs: []format{formatTgz},
 		},
+		{
+			name:    "packages",
+			input:   "dmg,pkg,appimage,freebsd-pkg,snap",
+			wantErr: false,
+			outputs: []format{formatDmg, formatPkg, formatAppImage, formatFreeBSDPkg, formatSnap},
+		},
 		{
 			name:    "all",
 			input:   "raw,zip,tar.gz",
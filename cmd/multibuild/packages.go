@@ -0,0 +1,171 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Reports whether 'p' is a package pattern, e.g. ./cmd/..., rather than a single package.
+func isPackagePattern(p string) bool {
+	return strings.Contains(p, "...")
+}
+
+// Reports whether 'args' names more than one package, or a pattern that may match several,
+// each of which is built separately, see buildPackages.
+func (this cliArgs) multiplePackages() bool {
+	if len(this.packagePaths) == 1 && this.displayConfig {
+		// Their configurations are shown together, see displayPackageConfigsAndExit.
+		return false
+	}
+	return len(this.packagePaths) > 1 || slices.ContainsFunc(this.packagePaths, isPackagePattern)
+}
+
+// Returns the pattern matching every package in the current module, see --multibuild-all.
+func modulePattern() (string, error) {
+	out, err := exec.Command("go", "env", "GOMOD").Output()
+	if err != nil {
+		return "", fmt.Errorf("go env: %w", err)
+	}
+	gomod := strings.TrimSpace(string(out))
+	if gomod == "" || gomod == os.DevNull {
+		return "", fmt.Errorf("not in a module")
+	}
+	return filepath.Join(filepath.Dir(gomod), "..."), nil
+}
+
+// Expands the patterns in 'paths' to the main packages they match, as go build would, as paths
+// relative to the current directory where possible. Other paths are left as they are.
+func expandPackages(paths []string) ([]string, error) {
+	var expanded []string
+	add := func(p string) {
+		if !slices.Contains(expanded, p) {
+			expanded = append(expanded, p)
+		}
+	}
+	for _, p := range paths {
+		if !isPackagePattern(p) {
+			add(p)
+			continue
+		}
+		pkgs, err := listPackages([]string{p})
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, pkg := range pkgs {
+			if pkg.Name == "main" {
+				add(relativePackageDir(pkg.Dir))
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s matches no main packages", p)
+		}
+	}
+	return expanded, nil
+}
+
+// Returns 'dir' as a path relative to the current directory, e.g. ./cmd/app, if it's inside it.
+func relativePackageDir(dir string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return dir
+	}
+	rel, err := filepath.Rel(wd, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return dir
+	}
+	if rel == "." {
+		return rel
+	}
+	return "./" + filepath.ToSlash(rel)
+}
+
+// Checks that 'args', naming more than one package, can be built one package at a time.
+func checkMultiplePackages(args cliArgs) error {
+	for _, p := range args.packagePaths {
+		if strings.HasSuffix(p, ".go") {
+			return fmt.Errorf("multibuild: cannot build %s alongside other packages or files, name its package instead", p)
+		}
+	}
+	if args.output != "" {
+		return fmt.Errorf("multibuild: -o must name a directory when building multiple packages")
+	}
+
+	// Each package is built by its own run, which would overwrite whatever the last one wrote.
+	var clash []string
+	if args.manifest != "" {
+		clash = append(clash, "--multibuild-manifest")
+	}
+	if args.trace != "" {
+		clash = append(clash, "--multibuild-trace")
+	}
+	if args.events != "" && args.events != "-" && !strings.HasPrefix(args.events, "fd:") {
+		clash = append(clash, "--multibuild-events")
+	}
+	if args.deployPatch != "" {
+		clash = append(clash, "--multibuild-deploy-patch")
+	}
+	for _, spec := range args.summaries {
+		if spec.path != "" && spec.format != summaryMarkdown {
+			clash = append(clash, "--multibuild-summary="+string(spec.format)+":"+spec.path)
+		}
+	}
+	if args.targetsFrom == "-" {
+		// Only the first would get to read it.
+		clash = append(clash, "--multibuild-targets-from=-")
+	}
+	if len(clash) > 0 {
+		return fmt.Errorf("multibuild: cannot build multiple packages with %s, as each package would overwrite the last", strings.Join(clash, ", "))
+	}
+	return nil
+}
+
+// Builds each of the packages in 'args' in turn, as if multibuild was run for each on its own,
+// so that each finds its own sources and configuration, and names its outputs after itself.
+// Exits with the exit code of the first that failed, once they're all done.
+func buildPackages(args cliArgs) {
+	exe, err := os.Executable()
+	if err != nil {
+		fatal("multibuild: %s", err)
+	}
+
+	paths, err := expandPackages(args.packagePaths)
+	if err != nil {
+		fatal("multibuild: %s", err)
+	}
+
+	code := 0
+	var failed []string
+	for _, p := range paths {
+		// go build wants its flags before the package, so it goes last.
+		cmd := exec.Command(exe, append(slices.Clone(args.commonArgs), p)...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err := cmd.Run()
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			fatal("multibuild: %s: %s", p, err)
+		}
+		if c := cmd.ProcessState.ExitCode(); c != 0 {
+			failed = append(failed, p)
+			if code == 0 {
+				code = c
+			}
+		}
+	}
+	if len(failed) > 0 {
+		fatalCode(code, "multibuild: %d of %d packages failed: %s", len(failed), len(paths), strings.Join(failed, ", "))
+	}
+	os.Exit(0)
+}
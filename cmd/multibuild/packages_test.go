@@ -0,0 +1,116 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseArgsMultiplePackages(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	args, err := parseArgs("multibuild", []string{"-trimpath", "-o", "dist/", "--multibuild-restrict=host", "./cmd/a", "./cmd/b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"./cmd/a", "./cmd/b"}; !slices.Equal(args.packagePaths, want) {
+		t.Errorf("packagePaths = %q, want %q", args.packagePaths, want)
+	}
+	if want := []string{"-trimpath", "-o", "dist/", "--multibuild-restrict=host"}; !slices.Equal(args.commonArgs, want) {
+		t.Errorf("commonArgs = %q, want %q", args.commonArgs, want)
+	}
+
+	args, err = parseArgs("multibuild", []string{"./cmd/a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.packagePath != "./cmd/a" || args.output != "a" {
+		t.Errorf("single package: packagePath = %q, output = %q", args.packagePath, args.output)
+	}
+
+	invalid := [][]string{
+		{"cmd/a/main.go", "cmd/b/main.go"},
+		{"cmd/a/main.go", "./cmd/b"},
+		{"-o", "app", "./cmd/a", "./cmd/b"},
+		{"--multibuild-manifest=manifest.json", "./cmd/a", "./cmd/b"},
+		{"--multibuild-trace=trace.json", "./cmd/a", "./cmd/b"},
+		{"--multibuild-events=events.json", "./cmd/a", "./cmd/b"},
+		{"--multibuild-summary=json:summary.json", "./cmd/a", "./cmd/b"},
+		{"--multibuild-targets-from=-", "./cmd/a", "./cmd/b"},
+	}
+	for _, argv := range invalid {
+		if _, err := parseArgs("multibuild", argv); err == nil {
+			t.Errorf("parseArgs(%q) succeeded, expected an error", argv)
+		}
+	}
+
+	for _, argv := range [][]string{
+		{"--multibuild-events=-", "./cmd/a", "./cmd/b"},
+		{"--multibuild-summary=markdown:summary.md", "./cmd/a", "./cmd/b"},
+	} {
+		if _, err := parseArgs("multibuild", argv); err != nil {
+			t.Errorf("parseArgs(%q): %s", argv, err)
+		}
+	}
+}
+
+func TestExpandPackages(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"go.mod":             "module example.com/m\n\ngo 1.24\n",
+		"cmd/client/main.go": "package main\n\nfunc main() {}\n",
+		"cmd/server/main.go": "package main\n\nfunc main() {}\n",
+		"internal/lib.go":    "package internal\n",
+	})
+	t.Chdir(dir)
+
+	got, err := expandPackages([]string{"./cmd/server", "./..."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"./cmd/server", "./cmd/client"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := expandPackages([]string{"./internal/..."}); err == nil {
+		t.Errorf("expected an error for a pattern matching no main packages")
+	}
+
+	args, err := parseArgs("multibuild", []string{"./..."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !args.multiplePackages() {
+		t.Errorf("./... should be built one package at a time")
+	}
+	args, err = parseArgs("multibuild", []string{"--multibuild-configuration", "./..."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.multiplePackages() {
+		t.Errorf("the configurations of ./... should be shown together")
+	}
+
+	t.Chdir(filepath.Join(dir, "cmd"))
+	args, err = parseArgs("multibuild", []string{"-trimpath", "--multibuild-all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !args.multiplePackages() || slices.Contains(args.commonArgs, "--multibuild-all") {
+		t.Errorf("--multibuild-all: multiplePackages = %v, commonArgs = %q", args.multiplePackages(), args.commonArgs)
+	}
+	got, err = expandPackages(args.packagePaths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"./client", "./server"}; !slices.Equal(got, want) {
+		t.Errorf("--multibuild-all: got %q, want %q", got, want)
+	}
+	if _, err := parseArgs("multibuild", []string{"--multibuild-all", "./client"}); err == nil {
+		t.Errorf("--multibuild-all accepted packages too")
+	}
+}
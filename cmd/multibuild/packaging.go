@@ -0,0 +1,88 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Copies 'outBin' into a new temporary directory, for packaging tools which take a directory.
+// The caller must remove the directory.
+func stageBinary(outBin string) (string, error) {
+	dir, err := os.MkdirTemp("", "multibuild-package")
+	if err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, filepath.Base(outBin)), os.O_CREATE|os.O_WRONLY, 0755)
+	if err == nil {
+		err = copyRaw(f, outBin)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// Runs a packaging tool for 't', which must be installed.
+func runPackager(t target, log io.Writer, cmd *exec.Cmd) error {
+	name := filepath.Base(cmd.Args[0])
+	if errors.Is(cmd.Err, exec.ErrNotFound) {
+		return fmt.Errorf("%s is required, but was not found", name)
+	}
+	if err := runPrefixed(cmd, t, log); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// Returns a reverse-DNS identifier for 'module', e.g. example.com/app -> com.example.app.
+func moduleIdentifier(module string) string {
+	host, rest, _ := strings.Cut(module, "/")
+	parts := strings.Split(host, ".")
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	if rest != "" {
+		parts = append(parts, strings.Split(rest, "/")...)
+	}
+	return strings.Join(parts, ".")
+}
+
+// Returns a version for packages from the description of a version control version, e.g. v1.2.3 -> 1.2.3.
+func packageVersion(vcs string) string {
+	if vcs == "unknown" || vcs == "" {
+		return "0"
+	}
+	return strings.TrimPrefix(vcs, "v")
+}
+
+// Fills in anything in 'info' which packages need, but isn't set, from the package at 'packagePath'.
+func (this packageInfo) withDefaults(packagePath string) packageInfo {
+	module := modulePath(packagePath)
+	if this.Name == "" {
+		this.Name = path.Base(module)
+	}
+	if this.Identifier == "" {
+		this.Identifier = moduleIdentifier(module)
+	}
+	if this.Version == "" {
+		this.Version = packageVersion(vcsVersion(packagePath))
+	}
+	if this.Homepage == "" && strings.Contains(module, ".") {
+		this.Homepage = "https://" + module
+	}
+	return this
+}
@@ -0,0 +1,62 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Work on a target passes through a series of stages once it has built: packaging, attesting,
+// and publishing. Each stage has its own slots, so that slow compression or uploads for one
+// target only wait on each other, and never hold up the builds of others.
+type stage struct {
+	name   string
+	slots  chan int
+	events *eventLog
+}
+
+// Returns a stage called 'name', which works on up to 'n' targets at once, recording when
+// each starts in 'events'.
+func newStage(name string, n int, events *eventLog) *stage {
+	s := &stage{name: name, slots: make(chan int, n), events: events}
+	for slot := range n {
+		s.slots <- slot
+	}
+	return s
+}
+
+// When a target was in a stage, for traces.
+type stageSpan struct {
+	name       string
+	slot       int
+	start, end time.Time
+}
+
+// Runs 'fn' for 'r' once a slot is free, recording when, and any artifacts it writes or
+// error it fails with. If the deadline passes while waiting for a slot, 'r' fails instead.
+func (this *stage) run(ctx context.Context, r *targetResult, fn func()) {
+	span := stageSpan{name: this.name}
+	select {
+	case span.slot = <-this.slots:
+	case <-ctx.Done():
+		r.err, r.code = cancelReason(ctx)
+		this.events.emitError(r, this.name)
+		return
+	}
+	span.start = time.Now()
+	this.events.emit(this.name, r)
+	artifacts, failed := len(r.artifacts), r.err != nil
+	fn()
+	for _, path := range r.artifacts[min(artifacts, len(r.artifacts)):] {
+		this.events.emitArtifact(r, path)
+	}
+	if !failed {
+		this.events.emitError(r, this.name)
+	}
+	span.end = time.Now()
+	this.slots <- span.slot
+	r.stages = append(r.stages, span)
+}
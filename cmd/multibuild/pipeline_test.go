@@ -0,0 +1,98 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestStageRun(t *testing.T) {
+	s := newStage("archive", 2, nil)
+	results := make([]targetResult, 6)
+	var running, most atomic.Int32
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.run(context.Background(), &results[i], func() {
+				n := running.Add(1)
+				for {
+					m := most.Load()
+					if n <= m || most.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				running.Add(-1)
+			})
+		}()
+	}
+	wg.Wait()
+	if most.Load() > 2 {
+		t.Errorf("%d ran at once, want at most 2", most.Load())
+	}
+	for i, r := range results {
+		if len(r.stages) != 1 || r.stages[0].name != "archive" || r.stages[0].slot > 1 || r.stages[0].end.Before(r.stages[0].start) {
+			t.Errorf("result %d: stages %+v", i, r.stages)
+		}
+	}
+}
+
+func TestStageRunDeadline(t *testing.T) {
+	s := newStage("publish", 1, nil)
+	<-s.slots // busy
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var r targetResult
+	s.run(ctx, &r, func() { t.Error("ran after the deadline") })
+	if r.err != errDeadline || r.code != exitDeadline || len(r.stages) != 0 {
+		t.Errorf("got %v, code %d, stages %+v", r.err, r.code, r.stages)
+	}
+}
+
+func TestStageRunEvents(t *testing.T) {
+	var buf bytes.Buffer
+	s := newStage("archive", 1, newEventLog(&buf))
+	r := targetResult{target: "linux/amd64", artifacts: []string{"app-linux-amd64"}}
+	s.run(context.Background(), &r, func() {
+		r.artifacts = append(r.artifacts, "app-linux-amd64.tar.gz")
+		r.err = errors.New("disk full")
+	})
+
+	var got []buildEvent
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var e buildEvent
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, e)
+	}
+	if len(got) != 3 {
+		t.Fatalf("got %d events, want 3: %+v", len(got), got)
+	}
+	if got[0].Event != "archive" {
+		t.Errorf("first event %+v, want archive", got[0])
+	}
+	if got[1].Event != "artifact" || got[1].Artifact != "app-linux-amd64.tar.gz" {
+		t.Errorf("second event %+v, want the new artifact", got[1])
+	}
+	if got[2].Event != "error" || got[2].Step != "archive" || got[2].Error != "disk full" {
+		t.Errorf("third event %+v, want the error", got[2])
+	}
+
+	// A target which had already failed isn't reported again.
+	buf.Reset()
+	s.run(context.Background(), &r, func() {})
+	if bytes.Contains(buf.Bytes(), []byte(`"error"`)) {
+		t.Errorf("error reported twice: %s", buf.String())
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Common names for platforms which Go spells differently.
+// These are checked before looking for a similar spelling.
+var platformAliases = map[string]string{
+	"macos":   "darwin",
+	"mac":     "darwin",
+	"osx":     "darwin",
+	"win":     "windows",
+	"win32":   "windows",
+	"win64":   "windows",
+	"sunos":   "solaris",
+	"aarch64": "arm64",
+	"armv8":   "arm64",
+	"x86_64":  "amd64",
+	"x64":     "amd64",
+	"amd":     "amd64",
+	"i386":    "386",
+	"i686":    "386",
+	"x86":     "386",
+	"armv7":   "arm",
+	"armhf":   "arm",
+	"ppc64el": "ppc64le",
+	"wasm32":  "wasm",
+}
+
+// Splits targets into their distinct GOOS and GOARCH values.
+func platformValues(targets []target) (oses []string, arches []string) {
+	for _, t := range targets {
+		goos, goarch := t.osArch()
+		if !slices.Contains(oses, goos) {
+			oses = append(oses, goos)
+		}
+		if !slices.Contains(arches, goarch) {
+			arches = append(arches, goarch)
+		}
+	}
+	slices.Sort(oses)
+	slices.Sort(arches)
+	return oses, arches
+}
+
+// Returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// Returns the closest value in 'valid' to 'value', or "" if nothing is close.
+func suggestPlatform(value string, valid []string) string {
+	if alias, ok := platformAliases[strings.ToLower(value)]; ok && slices.Contains(valid, alias) {
+		return alias
+	}
+
+	best, bestDist := "", len(value)/2+1
+	for _, v := range valid {
+		if d := levenshtein(strings.ToLower(value), v); d < bestDist {
+			best, bestDist = v, d
+		}
+	}
+	return best
+}
+
+// Checks that every GOOS and GOARCH named in 'filters' exists in 'targets',
+// and that filters without wildcards name a platform which exists.
+func validateFilterPlatforms(filters []filter, targets []target) error {
+	oses, arches := platformValues(targets)
+
+	check := func(f filter, kind string, value string, valid []string) error {
+		if value == "*" || value == hostKeyword || slices.Contains(valid, value) {
+			return nil
+		}
+		if s := suggestPlatform(value, valid); s != "" {
+			return fmt.Errorf("%s: unknown %s %q, did you mean %q?", f, kind, value, s)
+		}
+		return fmt.Errorf("%s: unknown %s %q (known: %s)", f, kind, value, strings.Join(valid, ", "))
+	}
+
+	for _, f := range filters {
+		goos, goarch := target(f).osArch()
+		if err := check(f, "GOOS", goos, oses); err != nil {
+			return err
+		}
+		if err := check(f, "GOARCH", goarch, arches); err != nil {
+			return err
+		}
+		if goos == "*" || goarch == "*" {
+			continue
+		}
+		resolved := target(f.resolve())
+		if v := resolved.variant(); v != "" {
+			if _, arch := resolved.osArch(); !slices.Contains(archVariants[arch].values, v) {
+				return fmt.Errorf("%s: %q is not a variant of %s", f, v, arch)
+			}
+		}
+		if slices.Contains(targets, resolved.platform()) {
+			continue
+		}
+		goos, _ = resolved.osArch()
+		var supported []string
+		for _, t := range targets {
+			if tos, tarch := t.osArch(); tos == goos {
+				supported = append(supported, tarch)
+			}
+		}
+		return fmt.Errorf("%s: %s is not a known platform (%s supports: %s)", f, resolved.platform(), goos, strings.Join(supported, ", "))
+	}
+	return nil
+}
+
+// Checks the platforms named by all filters in 'opts' exist in 'targets'.
+func (this options) validatePlatforms(targets []target) error {
+	for _, setting := range []struct {
+		name    string
+		filters []filter
+	}{
+		{"include", this.Include},
+		{"exclude", this.Exclude},
+		{"priority", this.Priority},
+		{"remote", mapSlice(this.Remote, func(rb remoteBuilder) filter { return rb.filter })},
+	} {
+		for _, f := range setting.filters {
+			origins := this.originOf(settingKey(setting.name, string(f)))
+			if len(origins) > 0 && !slices.ContainsFunc(origins, func(o origin) bool { return o.source != sourceImplicit }) {
+				continue // our own rules, which may name platforms this Go doesn't have
+			}
+			if err := validateFilterPlatforms([]filter{f}, targets); err != nil {
+				where := strings.Join(mapSlice(origins, func(o origin) string { return o.String() }), ", ")
+				return fmt.Errorf("%s=%s (%s)", setting.name, err, where)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var testPlatforms = []target{
+	"darwin/amd64", "darwin/arm64",
+	"freebsd/amd64",
+	"linux/386", "linux/amd64", "linux/arm", "linux/arm64", "linux/ppc64le",
+	"windows/amd64", "windows/arm64",
+}
+
+func TestSuggestPlatform(t *testing.T) {
+	oses, arches := platformValues(testPlatforms)
+
+	tests := []struct {
+		value string
+		valid []string
+		want  string
+	}{
+		{"macos", oses, "darwin"},
+		{"MacOS", oses, "darwin"},
+		{"linx", oses, "linux"},
+		{"windos", oses, "windows"},
+		{"freebds", oses, "freebsd"},
+		{"aarch64", arches, "arm64"},
+		{"x86_64", arches, "amd64"},
+		{"i686", arches, "386"},
+		{"amd46", arches, "amd64"},
+		{"ppc64el", arches, "ppc64le"},
+		{"zzzzzzzz", arches, ""},
+	}
+
+	for _, tt := range tests {
+		if got := suggestPlatform(tt.value, tt.valid); got != tt.want {
+			t.Errorf("suggestPlatform(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestValidateFilterPlatforms(t *testing.T) {
+	tests := []struct {
+		filter  filter
+		wantErr string
+	}{
+		{"linux/amd64", ""},
+		{"*/arm64", ""},
+		{"linux/*", ""},
+		{"host/host", ""},
+		{"macos/arm64", `did you mean "darwin"?`},
+		{"linux/aarch64", `did you mean "arm64"?`},
+		{"plan9/*", `unknown GOOS "plan9" (known: darwin, freebsd, linux, windows)`},
+		{"darwin/386", `darwin/386 is not a known platform (darwin supports: amd64, arm64)`},
+		{"freebsd/arm64", `freebsd/arm64 is not a known platform (freebsd supports: amd64)`},
+	}
+
+	for _, tt := range tests {
+		err := validateFilterPlatforms([]filter{tt.filter}, testPlatforms)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", tt.filter, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: got error %v, want %q", tt.filter, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidatePlatformsReportsOrigin(t *testing.T) {
+	var opts options
+	opts.Exclude = []filter{"macos/*"}
+	opts.setOrigin(settingKey("exclude", "macos/*"), origin{source: sourceDirective, location: "main.go:7"})
+
+	err := opts.validatePlatforms(testPlatforms)
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	want := `exclude=macos/*: unknown GOOS "macos", did you mean "darwin"? (directive at main.go:7)`
+	if err.Error() != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Shows how far along a run is on a terminal, and how long the rest of it should take,
+// going by earlier runs. A nil progress shows nothing.
+type progress struct {
+	mu       sync.Mutex
+	w        io.Writer
+	expected map[target]time.Duration
+	parallel int
+	pending  []target // not started yet, in the order they will be
+	running  map[target]time.Time
+	done     int
+	total    int
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Returns a progress display for building 'targets' on 'w', redrawn every second until close is
+// called, or nil if 'w' isn't a terminal.
+func newProgress(w *os.File, targets []target, parallel int, expected map[target]time.Duration) *progress {
+	if st, err := w.Stat(); err != nil || st.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	this := &progress{
+		w:        w,
+		expected: expected,
+		parallel: parallel,
+		pending:  slices.Clone(targets),
+		running:  make(map[target]time.Time),
+		total:    len(targets),
+		stop:     make(chan struct{}),
+	}
+	this.wg.Add(1)
+	go func() {
+		defer this.wg.Done()
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			this.draw()
+			select {
+			case <-ticker.C:
+			case <-this.stop:
+				return
+			}
+		}
+	}()
+	return this
+}
+
+// Records that 't' started building.
+func (this *progress) start(t target) {
+	if this == nil {
+		return
+	}
+	this.mu.Lock()
+	this.pending = slices.DeleteFunc(this.pending, func(p target) bool { return p == t })
+	this.running[t] = time.Now()
+	this.mu.Unlock()
+	this.draw()
+}
+
+// Records that 't' is done, or was never started.
+func (this *progress) finish(t target) {
+	if this == nil {
+		return
+	}
+	this.mu.Lock()
+	this.pending = slices.DeleteFunc(this.pending, func(p target) bool { return p == t })
+	delete(this.running, t)
+	this.done++
+	this.mu.Unlock()
+	this.draw()
+}
+
+// Stops redrawing, and clears the display.
+func (this *progress) close() {
+	if this == nil {
+		return
+	}
+	close(this.stop)
+	this.wg.Wait()
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	fmt.Fprint(this.w, "\r\033[K")
+}
+
+// Redraws the display. The cursor is left at the start of the line, so that anything else
+// written meanwhile (such as build errors) starts there, rather than after the display.
+func (this *progress) draw() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	fmt.Fprintf(this.w, "\r\033[K%s\r", this.line(time.Now()))
+}
+
+// Rounds 'd' for display, keeping some precision for quick builds.
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Second {
+		return d.Round(100 * time.Millisecond)
+	}
+	return d.Round(time.Second)
+}
+
+// How many of the running targets are described, so that the line doesn't wrap,
+// which would stop it being redrawn in place.
+const progressShown = 2
+
+// Describes the state of the run at 'now', e.g.
+// "multibuild: 3/10 done, about 40s left: linux/amd64 4s/~12s, darwin/arm64 2s, +1 more"
+func (this *progress) line(now time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "multibuild: %d/%d done", this.done, this.total)
+	if left, ok := this.remaining(now); ok {
+		fmt.Fprintf(&b, ", about %s left", roundDuration(left))
+	}
+
+	// The targets which have been running longest come first.
+	running := make([]target, 0, len(this.running))
+	for t := range this.running {
+		running = append(running, t)
+	}
+	slices.SortFunc(running, func(a, b target) int {
+		if c := this.running[a].Compare(this.running[b]); c != 0 {
+			return c
+		}
+		return strings.Compare(string(a), string(b))
+	})
+	for idx, t := range running {
+		if idx == 0 {
+			b.WriteString(": ")
+		} else {
+			b.WriteString(", ")
+		}
+		if idx == progressShown {
+			fmt.Fprintf(&b, "+%d more", len(running)-idx)
+			break
+		}
+		elapsed := now.Sub(this.running[t]).Round(time.Second)
+		if d, ok := this.expected[t]; ok {
+			fmt.Fprintf(&b, "%s %s/~%s", t, elapsed, roundDuration(d))
+		} else {
+			fmt.Fprintf(&b, "%s %s", t, elapsed)
+		}
+	}
+	return b.String()
+}
+
+// Estimates how long the rest of the run will take at 'now', by scheduling what is left onto
+// the workers as the run would, using how long each target took before. Targets which haven't
+// been built before are assumed to take as long as the average of those which have.
+// Returns false if there is nothing to go by.
+func (this *progress) remaining(now time.Time) (time.Duration, bool) {
+	if len(this.expected) == 0 {
+		return 0, false
+	}
+	var average time.Duration
+	for _, d := range this.expected {
+		average += d
+	}
+	average /= time.Duration(len(this.expected))
+	expect := func(t target) time.Duration {
+		if d, ok := this.expected[t]; ok {
+			return d
+		}
+		return average
+	}
+
+	// When each worker will be free.
+	free := make([]time.Duration, max(this.parallel, 1))
+	idx := 0
+	for t, started := range this.running {
+		if idx < len(free) {
+			free[idx] = max(expect(t)-now.Sub(started), 0)
+			idx++
+		}
+	}
+	for _, t := range this.pending {
+		next := slices.Index(free, slices.Min(free))
+		free[next] += expect(t)
+	}
+	return slices.Max(free), true
+}
@@ -0,0 +1,52 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgress(t *testing.T) {
+	now := time.Now()
+	p := &progress{
+		expected: map[target]time.Duration{
+			"linux/amd64":  10 * time.Second,
+			"darwin/arm64": 20 * time.Second,
+		},
+		parallel: 2,
+		pending:  []target{"windows/amd64", "freebsd/amd64"},
+		running: map[target]time.Time{
+			"linux/amd64":  now.Add(-4 * time.Second),
+			"darwin/arm64": now.Add(-25 * time.Second),
+		},
+		done:  1,
+		total: 5,
+	}
+
+	// linux/amd64 has 6s left, and darwin/arm64 is overdue, so its worker takes windows/amd64
+	// next (15s, the average), then linux/amd64's worker takes freebsd/amd64 (another 15s).
+	if left, ok := p.remaining(now); !ok || left != 21*time.Second {
+		t.Errorf("remaining: got %s, %v, want 21s", left, ok)
+	}
+	want := "multibuild: 1/5 done, about 21s left: darwin/arm64 25s/~20s, linux/amd64 4s/~10s"
+	if got := p.line(now); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Without any history, there's no estimate, and only a few targets are described.
+	p.expected = nil
+	p.running["windows/amd64"] = now
+	want = "multibuild: 1/5 done: darwin/arm64 25s, linux/amd64 4s, +1 more"
+	if got := p.line(now); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// A nil progress is fine to use.
+	var none *progress
+	none.start("linux/amd64")
+	none.finish("linux/amd64")
+	none.close()
+}
@@ -0,0 +1,81 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Returns the directory of the package at 'packagePath', which paths in publishing
+// directives (deploy= files, publish= commands) are relative to.
+func packageDir(packagePath string) string {
+	if st, err := os.Stat(packagePath); err != nil || !st.IsDir() {
+		return filepath.Dir(packagePath)
+	}
+	return packagePath
+}
+
+// Checks 'opts' has somewhere to publish to, and everything publishing needs.
+func validatePublish(opts options) error {
+	if opts.Image == "" && len(opts.Upload) == 0 && len(opts.CopyTo) == 0 && len(opts.Publish) == 0 {
+		return fmt.Errorf("--multibuild-publish needs an image=, upload=, copy-to= or publish= to publish to")
+	}
+	if (opts.Image != "" || len(opts.CopyTo) > 0) && !slices.Contains(opts.Format, formatRaw) {
+		return fmt.Errorf("--multibuild-publish needs the binaries for image= and copy-to=, but format= doesn't include raw")
+	}
+	return nil
+}
+
+// Publishes the results of a successful run: pushes the image, if image= is set, updating
+// any deploy= files, uploads the artifacts to each upload= destination, and copies the
+// binaries to each copy-to= host. Returns the image published, by digest.
+func publishResults(ctx context.Context, opts options, args cliArgs, results []targetResult) (string, error) {
+	binaries := make(map[target]string)
+	for _, r := range results {
+		_, binaries[r.target] = opts.outputPaths(args.output, r.target)
+	}
+
+	var image string
+	if opts.Image != "" {
+		rc := newRegistryClient(func(host string) (registryCredentials, error) {
+			return registryCredentialsFor(host, opts.RegistryAuth, os.LookupEnv)
+		})
+		var err error
+		image, err = publishImage(ctx, rc, opts.Image, opts.ImageBase, filepath.Base(args.output), binaries)
+		if err != nil {
+			return "", err
+		}
+		fmt.Println(image)
+
+		if len(opts.Deploy) > 0 {
+			if err := updateDeployFiles(packageDir(args.packagePath), opts.Deploy, opts.Image, image, args.deployPatch); err != nil {
+				return image, fmt.Errorf("updating deployment files: %w", err)
+			}
+		}
+	}
+
+	if len(opts.Upload) > 0 {
+		var artifacts []uploadArtifact
+		for _, r := range results {
+			for _, a := range r.artifacts {
+				artifacts = append(artifacts, uploadArtifact{path: a, target: r.target})
+			}
+		}
+		if err := uploadArtifacts(ctx, filepath.Base(args.output), opts.Upload, artifacts, os.LookupEnv); err != nil {
+			return image, err
+		}
+	}
+
+	if len(opts.CopyTo) > 0 {
+		if err := copyBinaries(ctx, opts.CopyTo, binaries); err != nil {
+			return image, err
+		}
+	}
+	return image, nil
+}
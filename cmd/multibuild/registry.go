@@ -0,0 +1,426 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Media types for images, see https://github.com/opencontainers/image-spec
+const (
+	mediaOCIIndex    = "application/vnd.oci.image.index.v1+json"
+	mediaOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+	mediaOCIConfig   = "application/vnd.oci.image.config.v1+json"
+	mediaOCILayer    = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+	mediaDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaDockerConfig   = "application/vnd.docker.container.image.v1+json"
+	mediaDockerLayer    = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+)
+
+// A reference to an image, e.g. ghcr.io/me/app:v1
+type imageRef struct {
+	// The registry's host (and port), e.g. ghcr.io
+	registry string
+
+	// e.g. me/app
+	repository string
+
+	// A tag or a digest.
+	reference string
+}
+
+func (this imageRef) String() string {
+	sep := ":"
+	if strings.HasPrefix(this.reference, "sha256:") {
+		sep = "@"
+	}
+	return this.registry + "/" + this.repository + sep + this.reference
+}
+
+// Parses an image reference, following docker's rules for where the registry ends.
+// References without a tag or digest get "latest".
+func parseImageRef(s string) (imageRef, error) {
+	if s == "" || strings.ContainsAny(s, " \t") {
+		return imageRef{}, fmt.Errorf("%q is not an image reference", s)
+	}
+	var ref imageRef
+	name := s
+	if before, digest, ok := strings.Cut(s, "@"); ok {
+		name, ref.reference = before, digest
+	} else if i := strings.LastIndex(s, ":"); i > strings.LastIndex(s, "/") {
+		name, ref.reference = s[:i], s[i+1:]
+	}
+	if ref.reference == "" {
+		ref.reference = "latest"
+	}
+
+	first, rest, ok := strings.Cut(name, "/")
+	if ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		ref.registry, ref.repository = first, rest
+	} else {
+		ref.registry, ref.repository = "docker.io", name
+	}
+	if ref.registry == "docker.io" && !strings.Contains(ref.repository, "/") {
+		ref.repository = "library/" + ref.repository
+	}
+	if ref.repository == "" || strings.ToLower(ref.repository) != ref.repository {
+		return imageRef{}, fmt.Errorf("%q is not an image reference: repositories are lower case", s)
+	}
+	return ref, nil
+}
+
+// Returns the base URL of the registry's API.
+func (this imageRef) baseURL() string {
+	host := this.registry
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	scheme := "https"
+	if h, _, _ := strings.Cut(host, ":"); h == "localhost" || h == "127.0.0.1" {
+		scheme = "http" // a local registry, for development
+	}
+	return scheme + "://" + host + "/v2/" + this.repository
+}
+
+// Talks to registries using the distribution API.
+// See https://github.com/opencontainers/distribution-spec
+type registryClient struct {
+	client *http.Client
+
+	// Returns the credentials for a registry, see registryCredentialsFor.
+	credentials func(host string) (registryCredentials, error)
+
+	mu    sync.Mutex
+	creds map[string]registryCredentials // by registry
+	auths map[string]string              // Authorization headers, by registry and scope
+}
+
+// Returns a client, using 'credentials' to authenticate. If it is nil, registries are accessed anonymously.
+func newRegistryClient(credentials func(host string) (registryCredentials, error)) *registryClient {
+	if credentials == nil {
+		credentials = func(string) (registryCredentials, error) { return registryCredentials{}, nil }
+	}
+	return &registryClient{client: http.DefaultClient, credentials: credentials, creds: make(map[string]registryCredentials), auths: make(map[string]string)}
+}
+
+// Returns the digest of 'data'.
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Parses a WWW-Authenticate challenge, e.g. Bearer realm="...",service="..."
+func parseChallenge(header string) (scheme string, params map[string]string) {
+	scheme, rest, _ := strings.Cut(header, " ")
+	params = make(map[string]string)
+	for rest != "" {
+		var kv string
+		// Values are quoted, and may contain commas.
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.TrimSpace(key)
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				break
+			}
+			kv, rest = value[1:end+1], value[end+2:]
+		} else {
+			kv, rest, _ = strings.Cut(value, ",")
+		}
+		params[strings.ToLower(key)] = kv
+		rest = strings.TrimPrefix(strings.TrimSpace(rest), ",")
+	}
+	return strings.ToLower(scheme), params
+}
+
+// Returns the Authorization header for 'scope' on 'host', as asked for by 'challenge'.
+func (this *registryClient) authorize(ctx context.Context, host, challenge, scope string) (string, error) {
+	this.mu.Lock()
+	creds, ok := this.creds[host]
+	this.mu.Unlock()
+	if !ok {
+		var err error
+		if creds, err = this.credentials(host); err != nil {
+			return "", err
+		}
+		this.mu.Lock()
+		this.creds[host] = creds
+		this.mu.Unlock()
+	}
+	scheme, params := parseChallenge(challenge)
+	switch {
+	case scheme == "basic":
+		if creds.username == "" {
+			return "", fmt.Errorf("credentials are required")
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(creds.username+":"+creds.password)), nil
+	case scheme == "bearer" && params["realm"] != "":
+		token, err := this.fetchToken(ctx, params, scope, creds)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	}
+	return "", fmt.Errorf("unsupported authentication challenge %q", challenge)
+}
+
+// Gets a token for 'scope' from the token server described by a challenge's 'params'.
+// See https://distribution.github.io/distribution/spec/auth/token/
+func (this *registryClient) fetchToken(ctx context.Context, params map[string]string, scope string, creds registryCredentials) (string, error) {
+	var req *http.Request
+	var err error
+	if creds.identityToken != "" {
+		// See https://distribution.github.io/distribution/spec/auth/oauth/
+		form := url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {creds.identityToken},
+			"service":       {params["service"]},
+			"scope":         {scope},
+			"client_id":     {"multibuild"},
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, params["realm"], strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		u, err := url.Parse(params["realm"])
+		if err != nil {
+			return "", err
+		}
+		q := u.Query()
+		if params["service"] != "" {
+			q.Set("service", params["service"])
+		}
+		q.Set("scope", scope)
+		u.RawQuery = q.Encode()
+		if req, err = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil); err != nil {
+			return "", err
+		}
+		if creds.username != "" {
+			req.SetBasicAuth(creds.username, creds.password)
+		}
+	}
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request: %s", resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("token request: %w", err)
+	}
+	if body.Token == "" {
+		body.Token = body.AccessToken
+	}
+	return body.Token, nil
+}
+
+// Does a request against 'ref's repository, authenticating if asked to.
+// 'push' says whether the request needs push access. The body is read from 'body', if not nil.
+func (this *registryClient) do(ctx context.Context, ref imageRef, push bool, method, rawURL string, header http.Header, body []byte) (*http.Response, error) {
+	scope := "repository:" + ref.repository + ":pull"
+	if push {
+		scope += ",push"
+	}
+	key := ref.registry + " " + scope
+
+	for attempt := 0; ; attempt++ {
+		var r io.Reader
+		if body != nil {
+			r = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, rawURL, r)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if body != nil {
+			req.ContentLength = int64(len(body))
+		}
+		this.mu.Lock()
+		auth := this.auths[key]
+		this.mu.Unlock()
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+
+		resp, err := this.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+		resp.Body.Close()
+		auth, err = this.authorize(ctx, ref.registry, resp.Header.Get("WWW-Authenticate"), scope)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ref.registry, err)
+		}
+		this.mu.Lock()
+		this.auths[key] = auth
+		this.mu.Unlock()
+	}
+}
+
+// Returns an error describing an unexpected response.
+func responseError(what string, resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if len(msg) > 0 {
+		return fmt.Errorf("%s: %s: %s", what, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return fmt.Errorf("%s: %s", what, resp.Status)
+}
+
+// Fetches the manifest (or index) 'ref' points at.
+func (this *registryClient) getManifest(ctx context.Context, ref imageRef) (data []byte, mediaType string, err error) {
+	header := http.Header{"Accept": {mediaOCIIndex, mediaOCIManifest, mediaDockerList, mediaDockerManifest}}
+	resp, err := this.do(ctx, ref, false, http.MethodGet, ref.baseURL()+"/manifests/"+ref.reference, header, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", responseError("get manifest "+ref.String(), resp)
+	}
+	data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	mediaType, _, _ = strings.Cut(resp.Header.Get("Content-Type"), ";")
+	if mediaType == "" {
+		var m struct {
+			MediaType string `json:"mediaType"`
+		}
+		json.Unmarshal(data, &m)
+		mediaType = m.MediaType
+	}
+	return data, mediaType, nil
+}
+
+// Fetches a blob from 'ref's repository.
+func (this *registryClient) getBlob(ctx context.Context, ref imageRef, digest string) ([]byte, error) {
+	resp, err := this.do(ctx, ref, false, http.MethodGet, ref.baseURL()+"/blobs/"+digest, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError("get blob "+digest, resp)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if digestOf(data) != digest {
+		return nil, fmt.Errorf("get blob %s: digest mismatch", digest)
+	}
+	return data, nil
+}
+
+// Returns whether 'ref's repository has a blob.
+func (this *registryClient) hasBlob(ctx context.Context, ref imageRef, digest string) (bool, error) {
+	resp, err := this.do(ctx, ref, true, http.MethodHead, ref.baseURL()+"/blobs/"+digest, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+// Resolves the Location of an upload, which may be relative.
+func uploadLocation(resp *http.Response) (*url.URL, error) {
+	loc, err := resp.Location()
+	if err != nil {
+		return nil, fmt.Errorf("upload: no location: %w", err)
+	}
+	return loc, nil
+}
+
+// Uploads 'data' as a blob to 'ref's repository, unless it is already there.
+// If 'from' is in the same registry, the blob is mounted from it instead, if possible.
+func (this *registryClient) putBlob(ctx context.Context, ref imageRef, digest string, data func() ([]byte, error), from *imageRef) error {
+	if ok, err := this.hasBlob(ctx, ref, digest); err != nil {
+		return err
+	} else if ok {
+		return nil
+	}
+
+	start := ref.baseURL() + "/blobs/uploads/"
+	if from != nil && from.registry == ref.registry {
+		start += "?" + url.Values{"mount": {digest}, "from": {from.repository}}.Encode()
+	}
+	resp, err := this.do(ctx, ref, true, http.MethodPost, start, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusCreated {
+		return nil // mounted
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		return responseError("start upload", resp)
+	}
+	loc, err := uploadLocation(resp)
+	if err != nil {
+		return err
+	}
+
+	body, err := data()
+	if err != nil {
+		return err
+	}
+	q := loc.Query()
+	q.Set("digest", digest)
+	loc.RawQuery = q.Encode()
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	resp, err = this.do(ctx, ref, true, http.MethodPut, loc.String(), header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return responseError("upload "+digest, resp)
+	}
+	return nil
+}
+
+// Pushes a manifest (or index) to 'ref', returning its digest.
+func (this *registryClient) putManifest(ctx context.Context, ref imageRef, mediaType string, data []byte) (string, error) {
+	header := http.Header{"Content-Type": {mediaType}}
+	resp, err := this.do(ctx, ref, true, http.MethodPut, ref.baseURL()+"/manifests/"+ref.reference, header, data)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", responseError("put manifest "+ref.String(), resp)
+	}
+	return digestOf(data), nil
+}
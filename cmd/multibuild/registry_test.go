@@ -0,0 +1,251 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// An in-memory registry, implementing enough of the distribution API to push and pull images.
+type fakeRegistry struct {
+	*httptest.Server
+
+	// If set, requests need this bearer token, which is handed out by /token.
+	token string
+
+	// If set, /token needs these credentials. Without a token, requests need them directly, using basic auth.
+	username, password string
+
+	mu        sync.Mutex
+	blobs     map[string][]byte // by repository@digest
+	manifests map[string][]byte // by repository:reference, and repository@digest
+	types     map[string]string // manifest media types, by digest
+	scopes    []string          // requested from /token
+	uploads   int
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	r := &fakeRegistry{blobs: map[string][]byte{}, manifests: map[string][]byte{}, types: map[string]string{}}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// The host, for use in image references.
+func (this *fakeRegistry) host() string {
+	return strings.TrimPrefix(this.URL, "http://")
+}
+
+// Stores a blob in 'repo', returning its digest.
+func (this *fakeRegistry) addBlob(repo string, data []byte) string {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.blobs[repo+"@"+digestOf(data)] = data
+	return digestOf(data)
+}
+
+// Stores a manifest in 'repo' as 'reference', returning its digest.
+func (this *fakeRegistry) addManifest(repo, reference, mediaType string, data []byte) string {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	digest := digestOf(data)
+	this.manifests[repo+":"+reference] = data
+	this.manifests[repo+"@"+digest] = data
+	this.types[digest] = mediaType
+	return digest
+}
+
+func (this *fakeRegistry) manifest(repo, reference string) []byte {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	sep := ":"
+	if strings.HasPrefix(reference, "sha256:") {
+		sep = "@"
+	}
+	return this.manifests[repo+sep+reference]
+}
+
+func (this *fakeRegistry) blob(repo, digest string) []byte {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.blobs[repo+"@"+digest]
+}
+
+func (this *fakeRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	if username, password, _ := req.BasicAuth(); req.URL.Path == "/token" && (username != this.username || password != this.password) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if req.URL.Path == "/token" {
+		this.mu.Lock()
+		this.scopes = append(this.scopes, req.URL.Query().Get("scope"))
+		this.mu.Unlock()
+		fmt.Fprintf(w, `{"token": %q}`, this.token)
+		return
+	}
+	if this.token != "" && req.Header.Get("Authorization") != "Bearer "+this.token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake"`, this.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if username, password, _ := req.BasicAuth(); this.token == "" && this.username != "" && (username != this.username || password != this.password) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="fake"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	body, _ := io.ReadAll(req.Body)
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if repo, id, ok := strings.Cut(path, "/blobs/uploads/"); ok {
+		q := req.URL.Query()
+		switch {
+		case req.Method == http.MethodPost && q.Get("mount") != "":
+			if data, ok := this.blobs[q.Get("from")+"@"+q.Get("mount")]; ok {
+				this.blobs[repo+"@"+q.Get("mount")] = data
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+			fallthrough
+		case req.Method == http.MethodPost:
+			this.uploads++
+			w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%d", repo, this.uploads))
+			w.WriteHeader(http.StatusAccepted)
+		case req.Method == http.MethodPut && id != "":
+			if digestOf(body) != q.Get("digest") {
+				http.Error(w, "digest mismatch", http.StatusBadRequest)
+				return
+			}
+			this.blobs[repo+"@"+q.Get("digest")] = body
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	} else if repo, digest, ok := strings.Cut(path, "/blobs/"); ok {
+		data, ok := this.blobs[repo+"@"+digest]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	} else if repo, reference, ok := strings.Cut(path, "/manifests/"); ok {
+		sep := ":"
+		if strings.HasPrefix(reference, "sha256:") {
+			sep = "@"
+		}
+		switch req.Method {
+		case http.MethodPut:
+			digest := digestOf(body)
+			this.manifests[repo+sep+reference] = body
+			this.manifests[repo+"@"+digest] = body
+			this.types[digest] = req.Header.Get("Content-Type")
+			w.WriteHeader(http.StatusCreated)
+		default:
+			data, ok := this.manifests[repo+sep+reference]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", this.types[digestOf(data)])
+			w.Write(data)
+		}
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestParseImageRef(t *testing.T) {
+	for _, tt := range []struct {
+		input string
+		want  imageRef
+		err   bool
+	}{
+		{"alpine", imageRef{"docker.io", "library/alpine", "latest"}, false},
+		{"me/app:v1", imageRef{"docker.io", "me/app", "v1"}, false},
+		{"ghcr.io/me/app", imageRef{"ghcr.io", "me/app", "latest"}, false},
+		{"localhost:5000/app:dev", imageRef{"localhost:5000", "app", "dev"}, false},
+		{"gcr.io/distroless/static@sha256:abcd", imageRef{"gcr.io", "distroless/static", "sha256:abcd"}, false},
+		{"ghcr.io/Me/App", imageRef{}, true},
+		{"", imageRef{}, true},
+	} {
+		got, err := parseImageRef(tt.input)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseImageRef(%q) = %+v, %v; want %+v, error %v", tt.input, got, err, tt.want, tt.err)
+		}
+	}
+
+	if got := (imageRef{"127.0.0.1:5000", "app", "latest"}).baseURL(); got != "http://127.0.0.1:5000/v2/app" {
+		t.Errorf("baseURL() = %s", got)
+	}
+	if got := (imageRef{"docker.io", "library/alpine", "latest"}).baseURL(); got != "https://registry-1.docker.io/v2/library/alpine" {
+		t.Errorf("baseURL() = %s", got)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry",scope="repository:a/b:pull,push"`)
+	if scheme != "bearer" || params["realm"] != "https://auth.example.com/token" || params["service"] != "registry" || params["scope"] != "repository:a/b:pull,push" {
+		t.Errorf("got %s %v", scheme, params)
+	}
+}
+
+func TestRegistryToken(t *testing.T) {
+	reg := newFakeRegistry(t)
+	reg.token = "secret"
+	reg.addBlob("app", []byte("blob"))
+	ref := imageRef{reg.host(), "app", "latest"}
+
+	rc := newRegistryClient(nil)
+	for range 2 {
+		if data, err := rc.getBlob(t.Context(), ref, digestOf([]byte("blob"))); err != nil || string(data) != "blob" {
+			t.Fatalf("getBlob() = %q, %v", data, err)
+		}
+	}
+	// The token is reused.
+	if len(reg.scopes) != 1 || reg.scopes[0] != "repository:app:pull" {
+		t.Errorf("scopes = %v", reg.scopes)
+	}
+}
+
+func TestRegistryCredentials(t *testing.T) {
+	for _, bearer := range []bool{true, false} {
+		reg := newFakeRegistry(t)
+		if bearer {
+			reg.token = "token"
+		}
+		reg.username, reg.password = "user", "pass"
+		reg.addBlob("app", []byte("blob"))
+		ref := imageRef{reg.host(), "app", "latest"}
+		digest := digestOf([]byte("blob"))
+
+		if _, err := newRegistryClient(nil).getBlob(t.Context(), ref, digest); err == nil {
+			t.Errorf("bearer=%v: anonymous access succeeded", bearer)
+		}
+		asked := 0
+		rc := newRegistryClient(func(host string) (registryCredentials, error) {
+			asked++
+			if host != reg.host() {
+				t.Errorf("asked for credentials for %s", host)
+			}
+			return registryCredentials{username: "user", password: "pass"}, nil
+		})
+		for range 2 {
+			if _, err := rc.getBlob(t.Context(), ref, digest); err != nil {
+				t.Errorf("bearer=%v: %s", bearer, err)
+			}
+		}
+		if asked != 1 {
+			t.Errorf("bearer=%v: asked for credentials %d times", bearer, asked)
+		}
+	}
+}
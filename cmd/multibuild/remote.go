@@ -0,0 +1,258 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// A machine to delegate the build of some targets to, over SSH.
+// e.g. //go:multibuild:remote.darwin/*=builder@mac-mini:src/app
+type remoteBuilder struct {
+	// The targets to build remotely.
+	filter filter
+
+	// The SSH destination, e.g. builder@mac-mini
+	host string
+
+	// The directory on the remote to build in, relative to the remote user's home.
+	dir string
+}
+
+func (this remoteBuilder) String() string {
+	return this.host + ":" + this.dir
+}
+
+// Validates a remote directive, 'key' being the filter and 'value' the destination, i.e. host[:dir].
+func validateRemote(key, value string) (remoteBuilder, error) {
+	filters, err := validateFilterString(key)
+	if err != nil {
+		return remoteBuilder{}, err
+	}
+	if len(filters) != 1 {
+		return remoteBuilder{}, fmt.Errorf("expected a single filter, got %q", key)
+	}
+	host, dir, _ := strings.Cut(value, ":")
+	if host == "" || strings.HasPrefix(host, "-") || strings.ContainsAny(host, " \t") {
+		return remoteBuilder{}, fmt.Errorf("%q is not a valid SSH destination", host)
+	}
+	if dir == "" {
+		dir = "multibuild"
+	}
+	if path.Clean(dir) == "." || path.Clean(dir) == "/" {
+		return remoteBuilder{}, fmt.Errorf("%q is not a valid remote directory", dir)
+	}
+	return remoteBuilder{filter: filters[0], host: host, dir: path.Clean(dir)}, nil
+}
+
+// Returns the remote builder for 't', if it should be built remotely.
+// If more than one matches, the first wins.
+func (this options) remoteFor(t target) (remoteBuilder, bool) {
+	for _, rb := range this.Remote {
+		if rb.filter.matches(t) {
+			return rb, true
+		}
+	}
+	return remoteBuilder{}, false
+}
+
+// Quotes 's' for use in a POSIX shell command line.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Writes the files under 'root' to 'w' as a tar stream, skipping version control metadata.
+func tarDir(w io.Writer, root string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && (d.Name() == ".git" || d.Name() == ".hg" || d.Name() == ".svn") {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		} else if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil // sockets and such have no business in a build
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// Returns the command to run over SSH to build 't' on 'rb'.
+// 'rel' is the local working directory, relative to the module root, and
+// 'name' is the name of the binary to produce in the remote output directory, and 'env'
+// holds variables set for 't', as NAME=VALUE.
+func remoteBuildScript(rb remoteBuilder, rel string, t target, name string, args []string, env []string) string {
+	goos, goarch := t.osArch()
+	out := path.Join("out", remoteOutDir(t), name)
+	var b strings.Builder
+	fmt.Fprintf(&b, "cd %s && base=$(pwd) && cd %s && ", shellQuote(rb.dir), shellQuote(path.Join("src", filepath.ToSlash(rel))))
+	fmt.Fprintf(&b, "GOOS=%s GOARCH=%s ", shellQuote(goos), shellQuote(goarch))
+	if v := variantEnv(t); v != "" {
+		name, value, _ := strings.Cut(v, "=")
+		fmt.Fprintf(&b, "%s=%s ", name, shellQuote(value))
+	}
+	for _, name := range []string{"CGO_ENABLED", "GOFLAGS", "GOPROXY", "GOTOOLCHAIN"} {
+		if v, ok := os.LookupEnv(name); ok {
+			fmt.Fprintf(&b, "%s=%s ", name, shellQuote(v))
+		}
+	}
+	for _, kv := range env {
+		name, value, _ := strings.Cut(kv, "=")
+		fmt.Fprintf(&b, "%s=%s ", name, shellQuote(value))
+	}
+	fmt.Fprintf(&b, "go build -o \"$base\"/%s", shellQuote(out))
+	for _, arg := range args {
+		b.WriteString(" " + shellQuote(arg))
+	}
+	return b.String()
+}
+
+// Returns the directory under out/ on a remote builder that 't' is built into, e.g. linux_arm_v7.
+func remoteOutDir(t target) string {
+	return strings.ReplaceAll(string(t), "/", "_")
+}
+
+// Runs ssh with 'script' as the remote command.
+func sshCommand(ctx context.Context, host, script string) *exec.Cmd {
+	return exec.CommandContext(ctx, "ssh", "-o", "BatchMode=yes", host, script)
+}
+
+var (
+	remoteSyncMu sync.Mutex
+	remoteSyncs  = make(map[string]*remoteSync)
+)
+
+// The sources are copied to each remote builder once per run, however many targets it builds.
+type remoteSync struct {
+	once sync.Once
+	err  error
+}
+
+// Copies the module at 'root' to 'rb', replacing whatever was synced there before.
+func syncRemote(ctx context.Context, rb remoteBuilder, root string) error {
+	remoteSyncMu.Lock()
+	rs, ok := remoteSyncs[rb.String()]
+	if !ok {
+		rs = &remoteSync{}
+		remoteSyncs[rb.String()] = rs
+	}
+	remoteSyncMu.Unlock()
+
+	rs.once.Do(func() {
+		src := shellQuote(path.Join(rb.dir, "src"))
+		cmd := sshCommand(ctx, rb.host, fmt.Sprintf("rm -rf %s && mkdir -p %s && tar -xf - -C %s", src, src, src))
+		pr, pw := io.Pipe()
+		cmd.Stdin = pr
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		go func() { pw.CloseWithError(tarDir(pw, root)) }()
+		if err := cmd.Run(); err != nil {
+			rs.err = fmt.Errorf("syncing sources to %s: %w: %s", rb, err, strings.TrimSpace(stderr.String()))
+		}
+		pr.Close()
+	})
+	return rs.err
+}
+
+// Returns the root directory of the main module.
+func moduleRoot() (string, error) {
+	out, err := exec.Command("go", "env", "GOMOD").Output()
+	if err != nil {
+		return "", err
+	}
+	gomod := strings.TrimSpace(string(out))
+	if gomod == "" || gomod == os.DevNull {
+		return "", fmt.Errorf("remote builds require a module")
+	}
+	return filepath.Dir(gomod), nil
+}
+
+// Builds 't' on 'rb', and copies the binary back to 'outBin'.
+// 'args' are the arguments for go build, without -o, and 'env' the variables set for 't'.
+func runRemoteBuild(ctx context.Context, rb remoteBuilder, outBin string, args []string, t target, env []string, log io.Writer) error {
+	root, err := moduleRoot()
+	if err != nil {
+		return err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, wd)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("working directory %s is not inside the module at %s", wd, root)
+	}
+	if err := syncRemote(ctx, rb, root); err != nil {
+		return err
+	}
+
+	name := filepath.Base(outBin)
+	if err := runPrefixed(sshCommand(ctx, rb.host, remoteBuildScript(rb, rel, t, name, args, env)), t, log); err != nil {
+		return fmt.Errorf("remote go build on %s: %w", rb.host, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outBin), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(outBin, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fetch := sshCommand(ctx, rb.host, "cat "+shellQuote(path.Join(rb.dir, "out", remoteOutDir(t), name)))
+	fetch.Stdout = f
+	var stderr bytes.Buffer
+	fetch.Stderr = &stderr
+	if err := fetch.Run(); err != nil {
+		return fmt.Errorf("fetching %s from %s: %w: %s", name, rb.host, err, strings.TrimSpace(stderr.String()))
+	}
+	return f.Close()
+}
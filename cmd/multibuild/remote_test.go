@@ -0,0 +1,95 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestValidateRemote(t *testing.T) {
+	tests := []struct {
+		key, value string
+		want       remoteBuilder
+		wantErr    bool
+	}{
+		{key: "darwin/*", value: "mac-mini", want: remoteBuilder{filter: "darwin/*", host: "mac-mini", dir: "multibuild"}},
+		{key: "darwin/arm64", value: "ci@mac-mini:builds/app/", want: remoteBuilder{filter: "darwin/arm64", host: "ci@mac-mini", dir: "builds/app"}},
+		{key: "darwin/*,linux/*", value: "mac-mini", wantErr: true},
+		{key: "darwin/*", value: "", wantErr: true},
+		{key: "darwin/*", value: "-oProxyCommand=evil", wantErr: true},
+		{key: "darwin/*", value: "mac-mini:/", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := validateRemote(tt.key, tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s=%s: got error %v, wantErr %v", tt.key, tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s=%s: got %+v, want %+v", tt.key, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	for in, want := range map[string]string{
+		"simple":     `'simple'`,
+		"with space": `'with space'`,
+		"it's":       `'it'\''s'`,
+		"$HOME":      `'$HOME'`,
+	} {
+		if got := shellQuote(in); got != want {
+			t.Errorf("%q: got %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestRemoteBuild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ssh is a shell script")
+	}
+
+	// A fake ssh, which runs the command locally, in a fake home directory.
+	home := t.TempDir()
+	bin := t.TempDir()
+	fake := "#!/bin/sh\nshift 3\ncd \"$FAKE_SSH_HOME\" && exec sh -c \"$1\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "ssh"), []byte(fake), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_SSH_HOME", home)
+
+	mod := t.TempDir()
+	gover := strings.TrimPrefix(runtime.Version(), "go")
+	if err := os.WriteFile(filepath.Join(mod, "go.mod"), []byte("module remote\n\ngo "+gover+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(mod, "cmd", "app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(mod, "cmd", "app", "main.go"), []byte("package main\nfunc main() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(filepath.Join(mod, "cmd"))
+
+	rb := remoteBuilder{filter: "*/*", host: "builder", dir: "builds/remote"}
+	out := filepath.Join("dist", "app")
+	if err := runRemoteBuild(context.Background(), rb, out, []string{"./app"}, target(runtime.GOOS+"/"+runtime.GOARCH), nil, nil); err != nil {
+		t.Fatalf("remote build failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(home, "builds", "remote", "src", "cmd", "app", "main.go")); err != nil {
+		t.Errorf("sources were not synced: %v", err)
+	}
+	if err := exec.Command(filepath.Join(mod, "cmd", out)).Run(); err != nil {
+		t.Errorf("fetched binary does not run: %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"html/template"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+// The HTML report is a single file, with no external resources, so that it
+// can be archived (e.g. as a CI artifact) and read later.
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>multibuild: {{.Built}} of {{len .Results}} targets built</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+td.ok { background: #dfd; }
+td.failed { background: #fdd; }
+td.discarded { background: #ffd; }
+code, pre { font-family: monospace; }
+pre { background: #f4f4f4; padding: 0.5em; overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1>multibuild: {{.Built}} of {{len .Results}} targets built</h1>
+
+<h2>Targets</h2>
+<table>
+<tr><th></th>{{range .Arches}}<th>{{.}}</th>{{end}}</tr>
+{{range .Matrix}}<tr><th>{{.OS}}</th>{{range .Cells}}{{if .}}<td class="{{.}}">{{.}}</td>{{else}}<td></td>{{end}}{{end}}</tr>
+{{end}}</table>
+
+<h2>Artifacts</h2>
+<table>
+<tr><th>Target</th><th>Status</th><th>Duration</th><th>Artifact</th><th>Size</th><th>SHA-256</th></tr>
+{{range .Rows}}<tr><td>{{.Target}}</td><td class="{{.Status}}">{{.Status}}</td><td>{{.Duration}}</td>{{if .Artifact}}<td><a href="{{.Link}}">{{.Artifact}}</a></td><td>{{.Size}}</td><td><code>{{.SHA256}}</code></td>{{else}}<td></td><td></td><td></td>{{end}}</tr>
+{{end}}</table>
+
+<h2>Logs</h2>
+{{range .Results}}<details{{if .Failed}} open{{end}}>
+<summary>{{.Target}} ({{.Status}})</summary>
+<pre>{{if .Log}}{{.Log}}{{else}}(no output){{end}}</pre>
+</details>
+{{end}}
+</body>
+</html>
+`))
+
+// Writes a self-contained HTML report describing 'results'.
+// Artifacts are linked relative to 'base', the directory the report is written to.
+func writeHTMLReport(w io.Writer, results []targetResult, rows []summaryRow, base string) error {
+	type reportRow struct {
+		Target, Status, Duration, Artifact, Link, Size, SHA256 string
+	}
+	type reportResult struct {
+		Target, Status, Log string
+		Failed              bool
+	}
+	type matrixRow struct {
+		OS    string
+		Cells []string
+	}
+	var data struct {
+		Built   int
+		Arches  []string
+		Matrix  []matrixRow
+		Rows    []reportRow
+		Results []reportResult
+	}
+
+	targets := make([]target, 0, len(results))
+	status := make(map[target]string)
+	for _, r := range results {
+		if r.err == nil {
+			data.Built++
+		}
+		targets = append(targets, r.target)
+		status[r.target] = r.status()
+		data.Results = append(data.Results, reportResult{
+			Target: string(r.target),
+			Status: r.status(),
+			Log:    r.log,
+			Failed: r.err != nil,
+		})
+	}
+
+	var oses []string
+	oses, data.Arches = platformValues(targets)
+	for _, goos := range oses {
+		row := matrixRow{OS: goos}
+		for _, goarch := range data.Arches {
+			row.Cells = append(row.Cells, status[target(goos+"/"+goarch)])
+		}
+		data.Matrix = append(data.Matrix, row)
+	}
+
+	for _, row := range rows {
+		rr := reportRow{
+			Target:   string(row.result.target),
+			Status:   row.result.status(),
+			Duration: row.result.duration().Round(100 * time.Millisecond).String(),
+		}
+		if row.artifact != "" {
+			rr.Artifact = row.artifact
+			rr.Link = filepath.ToSlash(row.artifact)
+			if abs, err := filepath.Abs(row.artifact); err == nil {
+				if rel, err := filepath.Rel(base, abs); err == nil {
+					rr.Link = filepath.ToSlash(rel)
+				}
+			}
+			rr.Size = formatSize(row.size)
+			rr.SHA256 = row.sha256
+		}
+		data.Rows = append(data.Rows, rr)
+	}
+
+	return reportTemplate.Execute(w, data)
+}
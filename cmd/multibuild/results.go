@@ -0,0 +1,265 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// The error for targets which didn't finish before the run deadline.
+var errDeadline = errors.New("deadline exceeded")
+
+// The error for targets which didn't finish because another failed, with keep-going=false.
+var errStopped = errors.New("stopped after another target failed")
+
+// Returns why work for a target was cancelled by 'ctx', and its exit code: the deadline passed,
+// or another target failed. The latter has no exit code of its own, so that the run exits with
+// the code of the target which did fail (see applyPartialPolicy).
+func cancelReason(ctx context.Context) (error, int) {
+	if context.Cause(ctx) == errStopped {
+		return errStopped, 0
+	}
+	return errDeadline, exitDeadline
+}
+
+// The outcome of building a single target.
+type targetResult struct {
+	target target
+
+	// The files produced for this target.
+	artifacts []string
+
+	// Set if the artifacts were removed because of the partial success policy.
+	discarded bool
+
+	// Why the target failed, if it did.
+	err error
+
+	// The exit code for the failure, e.g. exitBuild.
+	code int
+
+	// The output of go build, and any other errors, for reports.
+	log string
+
+	// When the target was queued, work on it started, go build finished, and
+	// the target was done. 'built' is zero if go build failed.
+	queued, started, built, finished time.Time
+
+	// Which worker slot built the target.
+	worker int
+
+	// The stages the target went through after building, in order.
+	stages []stageSpan
+
+	// The names of the environment variables go build was run with.
+	environment []string
+
+	// The SHA-256 of each artifact, by path, if they were needed, see checksumTarget.
+	checksums map[string]string
+
+	// The checksum of each artifact in the checksum-algorithm of the run, for the checksums file
+	// and sidecars. The same as 'checksums', for SHA-256.
+	sums map[string]string
+}
+
+// How long the target took to build, and go through each stage after.
+func (this targetResult) duration() time.Duration {
+	return this.finished.Sub(this.started)
+}
+
+// The size and SHA-256 of an artifact, as of its modification time.
+type artifactDigest struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// The digests of the artifacts of the run, by path, so that each is only read once, however
+// many things need it. See artifactInfo.
+var artifactDigests sync.Map
+
+// Records 'sum', the SHA-256 of the artifact just written to 'path', e.g. as it was written.
+func recordArtifactDigest(path string, sum []byte) {
+	if st, err := os.Stat(path); err == nil {
+		artifactDigests.Store(path, artifactDigest{size: st.Size(), modTime: st.ModTime(), sum: hex.EncodeToString(sum)})
+	}
+}
+
+// Returns the size and SHA-256 of the artifact at 'path'. Unless it has changed since, the
+// digest recorded when it was written, or last read, is used.
+func artifactInfo(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return 0, "", err
+	}
+	if v, ok := artifactDigests.Load(path); ok {
+		if d := v.(artifactDigest); d.size == st.Size() && d.modTime.Equal(st.ModTime()) {
+			return d.size, d.sum, nil
+		}
+	}
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	artifactDigests.Store(path, artifactDigest{size: n, modTime: st.ModTime(), sum: sum})
+	return n, sum, nil
+}
+
+// Works out the checksum of each of the artifacts of 'r'. This runs as a stage of its own, so
+// that hashing the artifacts of one target happens while others are still building, rather
+// than all at the end of the run. With a checksums file or sidecars in another algorithm of
+// 'opts', those checksums are worked out too, and sidecars are written next to each artifact.
+func checksumTarget(r *targetResult, opts options) {
+	r.checksums = make(map[string]string)
+	for _, a := range r.artifacts {
+		_, sum, err := artifactInfo(a)
+		if err != nil {
+			r.err, r.code = fmt.Errorf("failed to checksum %s: %w", a, err), exitArchive
+			return
+		}
+		r.checksums[a] = sum
+	}
+	r.sums = r.checksums
+	sidecars := opts.ChecksumSidecars == "true"
+	if !opts.ChecksumAlgorithm.isSHA256() && (opts.Checksums != "" || sidecars) {
+		r.sums = make(map[string]string)
+		for _, a := range r.artifacts {
+			sum, err := opts.ChecksumAlgorithm.sum(a)
+			if err != nil {
+				r.err, r.code = fmt.Errorf("failed to checksum %s: %w", a, err), exitArchive
+				return
+			}
+			r.sums[a] = sum
+		}
+	}
+	if !sidecars {
+		return
+	}
+	for _, a := range slices.Clone(r.artifacts) {
+		sidecar, err := writeSidecar(a, r.sums[a], opts.ChecksumAlgorithm)
+		if err != nil {
+			r.err, r.code = fmt.Errorf("failed to write checksum of %s: %w", a, err), exitArchive
+			return
+		}
+		r.artifacts = append(r.artifacts, sidecar)
+	}
+}
+
+// Applies 'policy' to the results of a run, removing artifacts as required.
+// Returns the number of failed targets, and the exit code of the first failure.
+func applyPartialPolicy(policy partialPolicy, results []targetResult) (int, int) {
+	failed, code := 0, 0
+	for idx := range results {
+		r := &results[idx]
+		if r.err == nil {
+			continue
+		}
+		failed++
+		if code == 0 {
+			code = r.code // targets stopped by another's failure have none, see cancelReason
+		}
+		// Whatever was produced before the failure is incomplete, so never keep it.
+		removeArtifacts(r)
+	}
+
+	if failed > 0 && policy == partialDiscard {
+		for idx := range results {
+			if results[idx].err == nil {
+				removeArtifacts(&results[idx])
+				results[idx].discarded = true
+			}
+		}
+	}
+	return failed, code
+}
+
+func removeArtifacts(r *targetResult) {
+	for _, a := range r.artifacts {
+		if err := os.Remove(a); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "%s: failed to remove %s: %s\n", r.target, a, err)
+		}
+	}
+	r.artifacts, r.checksums = nil, nil
+}
+
+// The manifest describes the outcome of a run, for tools consuming its artifacts.
+type manifest struct {
+	// See manifestVersion.
+	Version int `json:"version"`
+
+	// Whether every target was built.
+	Complete bool `json:"complete"`
+
+	// Identifies the configuration the run used, see configHash.
+	ConfigHash string `json:"config_hash"`
+
+	// The image published from the run, by digest, if any.
+	Image string `json:"image,omitempty"`
+
+	Targets []manifestTarget `json:"targets"`
+}
+
+type manifestTarget struct {
+	Target string `json:"target"`
+
+	// "ok", "missing" (the target failed), or "discarded" (see partial=discard).
+	Status string `json:"status"`
+
+	Artifacts []string `json:"artifacts,omitempty"`
+	Error     string   `json:"error,omitempty"`
+
+	// The SHA-256 of each artifact, by path.
+	Checksums map[string]string `json:"checksums,omitempty"`
+
+	// The names of the environment variables passed to go build.
+	Environment []string `json:"environment,omitempty"`
+}
+
+// Builds the manifest for 'results', from a configuration with 'hash'.
+func buildManifest(hash string, results []targetResult) manifest {
+	m := manifest{Version: manifestVersion, Complete: true, ConfigHash: hash, Targets: []manifestTarget{}}
+	for _, r := range results {
+		mt := manifestTarget{Target: string(r.target), Status: "ok", Artifacts: r.artifacts, Environment: r.environment, Checksums: r.checksums}
+		switch {
+		case r.err != nil:
+			mt.Status = "missing"
+			mt.Error = r.err.Error()
+			m.Complete = false
+		case r.discarded:
+			mt.Status = "discarded"
+			m.Complete = false
+		}
+		m.Targets = append(m.Targets, mt)
+	}
+	return m
+}
+
+// Writes the manifest for 'results' and the published 'image' to 'path'.
+func writeManifest(path string, hash string, image string, results []targetResult) error {
+	m := buildManifest(hash, results)
+	m.Image = image
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,121 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyPartialPolicy(t *testing.T) {
+	tests := []struct {
+		policy     partialPolicy
+		wantKept   bool
+		wantStatus string
+	}{
+		{policy: partialKeep, wantKept: true, wantStatus: "ok"},
+		{policy: partialManifest, wantKept: true, wantStatus: "ok"},
+		{policy: partialDiscard, wantKept: false, wantStatus: "discarded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			dir := t.TempDir()
+			good := filepath.Join(dir, "good")
+			bad := filepath.Join(dir, "bad")
+			for _, p := range []string{good, bad} {
+				if err := os.WriteFile(p, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			results := []targetResult{
+				{target: "linux/amd64", artifacts: []string{good}},
+				{target: "windows/amd64", artifacts: []string{bad}, err: errors.New("broken"), code: exitArchive},
+			}
+			failed, code := applyPartialPolicy(tt.policy, results)
+			if failed != 1 || code != exitArchive {
+				t.Errorf("got failed=%d code=%d, want 1, %d", failed, code, exitArchive)
+			}
+			if _, err := os.Stat(bad); !os.IsNotExist(err) {
+				t.Errorf("artifact of failed target was kept")
+			}
+			if _, err := os.Stat(good); (err == nil) != tt.wantKept {
+				t.Errorf("artifact of successful target: kept=%v, want %v", err == nil, tt.wantKept)
+			}
+
+			m := buildManifest("", results)
+			if m.Complete {
+				t.Errorf("manifest claims to be complete")
+			}
+			if m.Targets[0].Status != tt.wantStatus {
+				t.Errorf("successful target status: got %q, want %q", m.Targets[0].Status, tt.wantStatus)
+			}
+			if m.Targets[1].Status != "missing" || m.Targets[1].Error != "broken" {
+				t.Errorf("failed target: got %+v", m.Targets[1])
+			}
+		})
+	}
+}
+
+func TestApplyPartialPolicySuccess(t *testing.T) {
+	results := []targetResult{{target: "linux/amd64", artifacts: []string{"x"}}}
+	if failed, code := applyPartialPolicy(partialDiscard, results); failed != 0 || code != 0 {
+		t.Errorf("got failed=%d code=%d for a successful run", failed, code)
+	}
+	if m := buildManifest("", results); !m.Complete || len(m.Targets[0].Artifacts) != 1 {
+		t.Errorf("got %+v", m)
+	}
+}
+
+func TestChecksumTarget(t *testing.T) {
+	dir := t.TempDir()
+	bin, ar := filepath.Join(dir, "app"), filepath.Join(dir, "app.zip")
+	if err := os.WriteFile(bin, []byte("binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeZip(ar, "app", bin, 0755, compressionDefault, nil); err != nil {
+		t.Fatal(err)
+	}
+	// The archive was hashed as it was written, and that agrees with reading it back.
+	recorded, ok := artifactDigests.Load(ar)
+	if !ok {
+		t.Fatal("no digest was recorded for the archive")
+	}
+	data, err := os.ReadFile(ar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if got := recorded.(artifactDigest).sum; got != hex.EncodeToString(sum[:]) {
+		t.Errorf("recorded %s, want %x", got, sum)
+	}
+
+	r := targetResult{target: "linux/amd64", artifacts: []string{bin, ar}}
+	checksumTarget(&r, options{})
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.checksums[bin] != "9a3a45d01531a20e89ac6ae10b0b0beb0492acd7216a368aa062d1a5fecaf9cd" || r.checksums[ar] != hex.EncodeToString(sum[:]) {
+		t.Errorf("got checksums %v", r.checksums)
+	}
+
+	// A changed artifact is hashed again.
+	if err := os.WriteFile(bin, []byte("rebuilt binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, got, err := artifactInfo(bin); err != nil || got == r.checksums[bin] {
+		t.Errorf("got %s, %v after changing the artifact", got, err)
+	}
+
+	r.artifacts = append(r.artifacts, filepath.Join(dir, "missing"))
+	if checksumTarget(&r, options{}); r.err == nil || r.code != exitArchive {
+		t.Errorf("got %v, %d for a missing artifact", r.err, r.code)
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"sync"
+)
+
+// JSON-RPC 2.0 error codes, see https://www.jsonrpc.org/specification#error_object
+const (
+	rpcParseError     = -32700
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcFailed         = -32000 // multibuild failed, see the exit code in the data
+)
+
+// A JSON-RPC request, or a notification if it has no ID.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// A JSON-RPC response, or a notification from the server if it has no ID.
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  any             `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+// The parameters of every method: the command line multibuild would be run with,
+// e.g. ["-o", "bin/app", "--multibuild-restrict=linux/*", "./cmd/app"].
+type rpcParams struct {
+	Args []string `json:"args"`
+}
+
+// A target in the result of "plan".
+type plannedTarget struct {
+	Target    target   `json:"target"`
+	Artifacts []string `json:"artifacts"`
+}
+
+// An RPC server, writing messages to 'w'.
+type rpcServer struct {
+	self string
+	mu   sync.Mutex
+	w    io.Writer
+	wg   sync.WaitGroup
+}
+
+// Implements 'multibuild rpc'.
+func doRPC(self string, argv []string) {
+	fs := flag.NewFlagSet(self+" rpc", flag.ExitOnError)
+	fs.Parse(argv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := &rpcServer{self: self, w: os.Stdout}
+	if err := s.serve(ctx, os.Stdin); err != nil {
+		fatal("multibuild: rpc: %s", err)
+	}
+}
+
+// Answers requests read from 'r', one per line, until it ends.
+func (this *rpcServer) serve(ctx context.Context, r io.Reader) error {
+	defer this.wg.Wait()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var req rpcRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			this.send(rpcMessage{Error: &rpcError{Code: rpcParseError, Message: err.Error()}})
+			continue
+		}
+		var params rpcParams
+		if len(req.Params) > 0 {
+			if err := json.Unmarshal(req.Params, &params); err != nil {
+				this.reply(req, nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()})
+				continue
+			}
+		}
+		switch req.Method {
+		case "targets":
+			result, rerr := this.targets(params)
+			this.reply(req, result, rerr)
+		case "plan":
+			result, rerr := this.plan(params)
+			this.reply(req, result, rerr)
+		case "check":
+			this.wg.Add(1)
+			go func() {
+				defer this.wg.Done()
+				result, rerr := this.check(ctx, params)
+				this.reply(req, result, rerr)
+			}()
+		case "build":
+			// Builds take a while, so other requests are answered in the meantime.
+			this.wg.Add(1)
+			go func() {
+				defer this.wg.Done()
+				result, rerr := this.build(ctx, req, params)
+				this.reply(req, result, rerr)
+			}()
+		default:
+			this.reply(req, nil, &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("unknown method %q", req.Method)})
+		}
+	}
+	return scanner.Err()
+}
+
+// Writes 'msg' as a line.
+func (this *rpcServer) send(msg rpcMessage) {
+	msg.JSONRPC = "2.0"
+	data, err := json.Marshal(msg)
+	if err != nil {
+		data, _ = json.Marshal(rpcMessage{JSONRPC: "2.0", ID: msg.ID, Error: &rpcError{Code: rpcFailed, Message: err.Error()}})
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.w.Write(append(data, '\n'))
+}
+
+// Answers 'req' with 'result', or 'rerr'. Notifications aren't answered.
+func (this *rpcServer) reply(req rpcRequest, result any, rerr *rpcError) {
+	if len(req.ID) == 0 {
+		return
+	}
+	if rerr != nil {
+		this.send(rpcMessage{ID: req.ID, Error: rerr})
+	} else {
+		this.send(rpcMessage{ID: req.ID, Result: result})
+	}
+}
+
+// Parses the command line in 'params', and plans the build it describes.
+func (this *rpcServer) planFor(params rpcParams) (cliArgs, options, []target, *rpcError) {
+	args, err := parseArgs(this.self, params.Args)
+	if err != nil {
+		return cliArgs{}, options{}, nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	if args.multiplePackages() {
+		return cliArgs{}, options{}, nil, &rpcError{Code: rpcInvalidParams, Message: "multibuild: cannot plan multiple packages at once, ask for each in turn"}
+	}
+	opts, targets, _, code, err := planBuild(args)
+	if err != nil {
+		return cliArgs{}, options{}, nil, &rpcError{Code: rpcFailed, Message: err.Error(), Data: map[string]int{"exitCode": code}}
+	}
+	return args, opts, targets, nil
+}
+
+// Implements "targets": the targets that would be built, in order.
+func (this *rpcServer) targets(params rpcParams) (any, *rpcError) {
+	_, _, targets, rerr := this.planFor(params)
+	if rerr != nil {
+		return nil, rerr
+	}
+	return map[string][]target{"targets": targets}, nil
+}
+
+// Implements "plan": the targets that would be built, and the artifacts each would produce.
+func (this *rpcServer) plan(params rpcParams) (any, *rpcError) {
+	args, opts, targets, rerr := this.planFor(params)
+	if rerr != nil {
+		return nil, rerr
+	}
+	planned := make([]plannedTarget, 0, len(targets))
+	for _, t := range targets {
+		out, outBin := opts.outputPaths(args.output, t)
+		p := plannedTarget{Target: t, Artifacts: []string{}}
+		for _, f := range opts.Format {
+			if f == formatRaw {
+				p.Artifacts = append(p.Artifacts, outBin)
+			} else if f.appliesTo(t) {
+				p.Artifacts = append(p.Artifacts, f.path(out, outBin))
+			}
+		}
+		if args.attest {
+			p.Artifacts = append(p.Artifacts, out+".intoto.json")
+		}
+		planned = append(planned, p)
+	}
+	return map[string][]plannedTarget{"targets": planned}, nil
+}
+
+// Implements "check": type checks each target that would be built, see typeCheck.
+func (this *rpcServer) check(ctx context.Context, params rpcParams) (any, *rpcError) {
+	args, opts, targets, rerr := this.planFor(params)
+	if rerr != nil {
+		return nil, rerr
+	}
+	env, cleanupEnv, err := buildEnviron(args.sandbox, opts)
+	if err != nil {
+		return nil, &rpcError{Code: rpcFailed, Message: err.Error()}
+	}
+	defer cleanupEnv()
+	patterns := args.sources
+	if len(patterns) == 0 {
+		patterns = []string{args.packagePath}
+	}
+	results, err := typeCheckTargets(ctx, env, opts, patterns, targets)
+	if err != nil {
+		return nil, &rpcError{Code: rpcFailed, Message: err.Error()}
+	}
+	return map[string][]checkResult{"targets": results}, nil
+}
+
+// Implements "build": runs multibuild with the command line in 'params', sending each of its
+// events as an "event" notification, and its output as "log" notifications, as they happen.
+// Results in its exit code.
+func (this *rpcServer) build(ctx context.Context, req rpcRequest, params rpcParams) (any, *rpcError) {
+	if _, err := parseArgs(this.self, params.Args); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, &rpcError{Code: rpcFailed, Message: err.Error()}
+	}
+	// Running a separate process keeps builds from interfering with each other, or the server.
+	cmd := exec.CommandContext(ctx, exe, append(slices.Clone(params.Args), "--multibuild-events=-")...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, &rpcError{Code: rpcFailed, Message: err.Error()}
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, &rpcError{Code: rpcFailed, Message: err.Error()}
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, &rpcError{Code: rpcFailed, Message: err.Error()}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			var e buildEvent
+			if json.Unmarshal(scanner.Bytes(), &e) == nil {
+				this.send(rpcMessage{Method: "event", Params: map[string]any{"id": req.ID, "event": e}})
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			this.send(rpcMessage{Method: "log", Params: map[string]any{"id": req.ID, "line": scanner.Text()}})
+		}
+	}()
+	wg.Wait() // before Wait, which closes the pipes
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, &rpcError{Code: rpcFailed, Message: err.Error()}
+	}
+	return map[string]int{"exitCode": cmd.ProcessState.ExitCode()}, nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Writes a module to a new directory, and changes to it.
+func writeRPCModule(t *testing.T, directives string) string {
+	dir := t.TempDir()
+	src := directives + "package main\n\nfunc main() {}\n"
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/app\n\ngo 1.24\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+	return dir
+}
+
+// Returns the messages sent in answer to 'requests'.
+func rpcExchange(t *testing.T, s *rpcServer, requests string) []map[string]any {
+	var out bytes.Buffer
+	s.w = &out
+	if err := s.serve(t.Context(), strings.NewReader(requests)); err != nil {
+		t.Fatal(err)
+	}
+	var msgs []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var msg map[string]any
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("bad message %q: %s", line, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestRPC(t *testing.T) {
+	writeRPCModule(t, "//go:multibuild:include=linux/amd64,darwin/arm64\n//go:multibuild:format=raw,tar.gz,dmg\n//go:multibuild:output=dist/${TARGET}-${GOOS}-${GOARCH}\n")
+	msgs := rpcExchange(t, &rpcServer{self: "multibuild"}, strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"targets","params":{"args":["--multibuild-restrict=linux/*"]}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"plan","params":{"args":["-o","app"]}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"wat"}`,
+		`{"jsonrpc":"2.0","id":4,"method":"targets","params":{"args":["--multibuild-restrict=plan9/*"]}}`,
+		`not json`,
+		`{"jsonrpc":"2.0","method":"targets"}`,
+	}, "\n"))
+	if len(msgs) != 5 {
+		t.Fatalf("got %d messages, want 5 (notifications aren't answered): %v", len(msgs), msgs)
+	}
+
+	got, _ := json.Marshal(msgs[0]["result"])
+	if string(got) != `{"targets":["linux/amd64"]}` {
+		t.Errorf("targets: got %s", got)
+	}
+	got, _ = json.Marshal(msgs[1]["result"])
+	if want := `{"targets":[{"artifacts":["dist/app-darwin-arm64","dist/app-darwin-arm64.tar.gz","dist/app-darwin-arm64.dmg"],"target":"darwin/arm64"},` +
+		`{"artifacts":["dist/app-linux-amd64","dist/app-linux-amd64.tar.gz"],"target":"linux/amd64"}]}`; string(got) != want {
+		t.Errorf("plan: got %s\nwant %s", got, want)
+	}
+	for idx, code := range map[int]float64{2: rpcMethodNotFound, 3: rpcFailed, 4: rpcParseError} {
+		rerr, _ := msgs[idx]["error"].(map[string]any)
+		if rerr == nil || rerr["code"] != code {
+			t.Errorf("message %d: got %v, want error code %v", idx, msgs[idx], code)
+		}
+	}
+}
+
+func TestRPCBuild(t *testing.T) {
+	bin := filepath.Join(t.TempDir(), "multibuild")
+	if out, err := exec.Command("go", "build", "-o", bin).CombinedOutput(); err != nil {
+		t.Fatalf("build failed: %v\n%s", err, out)
+	}
+	writeRPCModule(t, "//go:multibuild:include="+runtime.GOOS+"/"+runtime.GOARCH+"\n")
+
+	cmd := exec.Command(bin, "rpc")
+	cmd.Stdin = strings.NewReader(`{"jsonrpc":"2.0","id":"b","method":"build","params":{"args":["-o","app"]}}` + "\n")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("rpc failed: %v", err)
+	}
+	var events []string
+	var result map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		var msg struct {
+			Method string
+			Params struct {
+				ID    string
+				Event buildEvent
+			}
+			Result map[string]any
+		}
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("bad message %q: %s", line, err)
+		}
+		if msg.Method == "event" && msg.Params.ID == "b" {
+			events = append(events, msg.Params.Event.Event+":"+msg.Params.Event.Status)
+		} else if msg.Result != nil {
+			result = msg.Result
+		}
+	}
+	if strings.Join(events, ",") != "queued:,build:,artifact:,archive:,done:ok" {
+		t.Errorf("got events %v", events)
+	}
+	if result["exitCode"] != 0.0 {
+		t.Errorf("got result %v", result)
+	}
+}
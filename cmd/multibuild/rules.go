@@ -0,0 +1,110 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// What 'multibuild scaffold bazel' and 'scaffold please' need to know about the package:
+// a rule is generated for each configured target, named as multibuild names its binary,
+// so that the directives stay the source of truth for what gets built.
+type ruleScaffold struct {
+	// The package's directory, relative to its module's root (which is assumed to be the
+	// root of the workspace or repository), in slash form.
+	pkgDir string
+
+	// The name of the package's go_binary rule.
+	binary string
+
+	// The configured targets, and what their binaries are called.
+	outputs []ruleOutput
+}
+
+type ruleOutput struct {
+	target target
+	name   string
+}
+
+// Returns the rules to generate for 'pkg'.
+func newRuleScaffold(pkg scaffoldPackage) ruleScaffold {
+	name := filepath.Base(pkg.dir)
+	sc := ruleScaffold{pkgDir: pkg.rel, binary: name}
+	for _, t := range pkg.targets {
+		_, outBin := pkg.opts.outputPaths(name, t)
+		sc.outputs = append(sc.outputs, ruleOutput{target: t, name: path.Base(filepath.ToSlash(outBin))})
+	}
+	return sc
+}
+
+// Returns the label of the package, e.g. //cmd/app
+func (this ruleScaffold) label() string {
+	if this.pkgDir == "." {
+		return "//"
+	}
+	return "//" + this.pkgDir
+}
+
+// Returns the label of the generated package, e.g. //cmd/app/multibuild
+func (this ruleScaffold) generated() string {
+	return strings.TrimSuffix(this.label(), "/") + "/multibuild"
+}
+
+// Returns a BUILD.bazel with a go_cross_binary (from rules_go) for each target.
+func (this ruleScaffold) bazel() string {
+	var b strings.Builder
+	fmt.Fprintln(&b, "# Generated by multibuild scaffold bazel from the //go:multibuild: directives. Regenerate it, rather than editing it.")
+	fmt.Fprintf(&b, "# bazel build %s:binaries\n", this.generated())
+	fmt.Fprintln(&b, `load("@rules_go//go:def.bzl", "go_cross_binary")`)
+	for _, o := range this.outputs {
+		goos, goarch := o.target.osArch()
+		fmt.Fprintln(&b)
+		fmt.Fprintln(&b, "go_cross_binary(")
+		fmt.Fprintf(&b, "    name = %q,\n", o.name)
+		fmt.Fprintf(&b, "    platform = \"@rules_go//go/toolchain:%s_%s\",\n", goos, goarch)
+		fmt.Fprintf(&b, "    target = \"%s:%s\",\n", this.label(), this.binary)
+		fmt.Fprintln(&b, ")")
+	}
+	this.filegroup(&b)
+	return b.String()
+}
+
+// Returns a BUILD for Please, copying the binary cross compiled for each target.
+func (this ruleScaffold) please() string {
+	var b strings.Builder
+	fmt.Fprintln(&b, "# Generated by multibuild scaffold please from the //go:multibuild: directives. Regenerate it, rather than editing it.")
+	fmt.Fprintf(&b, "# plz build %s:binaries\n", this.generated())
+	for idx, o := range this.outputs {
+		goos, goarch := o.target.osArch()
+		if idx > 0 {
+			fmt.Fprintln(&b)
+		}
+		fmt.Fprintln(&b, "genrule(")
+		fmt.Fprintf(&b, "    name = %q,\n", o.name)
+		fmt.Fprintf(&b, "    srcs = [\"///%s_%s%s:%s\"],\n", goos, goarch, this.label(), this.binary)
+		fmt.Fprintf(&b, "    outs = [%q],\n", o.name)
+		fmt.Fprintln(&b, `    cmd = "cp $SRC $OUT",`)
+		fmt.Fprintln(&b, "    binary = True,")
+		fmt.Fprintln(&b, ")")
+	}
+	this.filegroup(&b)
+	return b.String()
+}
+
+// Writes a filegroup of every target's binary, called binaries.
+func (this ruleScaffold) filegroup(b *strings.Builder) {
+	fmt.Fprintln(b)
+	fmt.Fprintln(b, "filegroup(")
+	fmt.Fprintln(b, `    name = "binaries",`)
+	fmt.Fprintln(b, "    srcs = [")
+	for _, o := range this.outputs {
+		fmt.Fprintf(b, "        \":%s\",\n", o.name)
+	}
+	fmt.Fprintln(b, "    ],")
+	fmt.Fprintln(b, ")")
+}
@@ -0,0 +1,172 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// The environment variables go needs to find itself and its caches.
+// These are always passed through, as they say where things are, rather than what gets built.
+var envEssential = []string{
+	"PATH", "HOME",
+	"GOROOT", "GOPATH", "GOCACHE", "GOCACHEPROG", "GOMODCACHE",
+
+	// Windows can't do much without these.
+	"SYSTEMROOT", "SYSTEMDRIVE", "USERPROFILE", "LOCALAPPDATA", "APPDATA", "PATHEXT", "COMSPEC",
+}
+
+// The environment variables which affect the build, and are passed through to
+// sandboxed builds unless the package has its own allowlist (see env-allow).
+// GOFLAGS isn't among them, as it can change the artifacts, e.g. with -ldflags or -tags.
+var envBuildDefault = []string{
+	// Modules.
+	"GOPROXY", "GOPRIVATE", "GONOPROXY", "GONOSUMDB", "GOSUMDB", "GOINSECURE", "GOTOOLCHAIN",
+
+	// cgo, for those who enable it.
+	"CGO_ENABLED", "CC", "CXX", "CGO_CFLAGS", "CGO_CPPFLAGS", "CGO_CXXFLAGS", "CGO_LDFLAGS", "PKG_CONFIG",
+}
+
+// Returns the environment variables which are allowed to affect the build:
+// 'envAllow' if the package has an allowlist, and envBuildDefault otherwise.
+func buildEnvAllow(envAllow []string) []string {
+	if len(envAllow) > 0 {
+		return envAllow
+	}
+	return envBuildDefault
+}
+
+// Normalizes an environment variable name for comparison.
+func envName(name string) string {
+	if runtime.GOOS == "windows" {
+		return strings.ToUpper(name) // Windows environment variables are case insensitive
+	}
+	return name
+}
+
+// Returns the names of the variables in 'environ', sorted.
+func envNames(environ []string) []string {
+	names := mapSlice(environ, func(kv string) string {
+		name, _, _ := strings.Cut(kv, "=")
+		return name
+	})
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// Returns the variables from 'environ' named in 'allow', plus settings which
+// isolate go from the machine: no go env file, and private temporary directories in 'tmp'.
+func sandboxEnv(environ []string, allow []string, tmp string) []string {
+	var out []string
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if slices.ContainsFunc(allow, func(a string) bool { return envName(a) == envName(name) }) {
+			out = append(out, kv)
+		}
+	}
+	out = append(out,
+		"GOENV=off", // settings from 'go env -w' are machine specific
+		"TMPDIR="+tmp,
+		"GOTMPDIR="+tmp,
+	)
+	if runtime.GOOS == "windows" {
+		out = append(out, "TEMP="+tmp, "TMP="+tmp)
+	}
+	return out
+}
+
+// Returns the base environment for child builds, and a function to clean up after them.
+// Unless 'sandbox' is set, or the package has an allowlist (env-allow), that's just
+// the environment multibuild was run with, pointed at the build cache configured in 'opts'.
+func buildEnviron(sandbox bool, opts options) ([]string, func(), error) {
+	cache, err := goCacheEnviron(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !sandbox && len(opts.EnvAllow) == 0 {
+		return append(os.Environ(), cache...), func() {}, nil
+	}
+	tmp, err := os.MkdirTemp("", "multibuild-sandbox")
+	if err != nil {
+		return nil, nil, err
+	}
+	allow := append(slices.Clone(envEssential), buildEnvAllow(opts.EnvAllow)...)
+	return append(sandboxEnv(os.Environ(), allow, tmp), cache...), func() { os.RemoveAll(tmp) }, nil
+}
+
+// Returns 'env' with the variables choosing the target from 'environ' (GOOS, GOARCH and the
+// variant variables, e.g. GOARM) added back, for building with plain go build when they are set.
+// Without them, a scrubbed environment would quietly build for the host instead.
+func passthroughEnv(env, environ []string) []string {
+	names := []string{"GOOS", "GOARCH"}
+	for _, set := range archVariants {
+		names = append(names, set.env)
+	}
+	names = mapSlice(names, envName)
+	present := mapSlice(envNames(env), envName) // they already are, unless the environment was scrubbed
+	out := slices.Clone(env)
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if slices.Contains(names, envName(name)) && !slices.Contains(present, envName(name)) {
+			out = append(out, kv)
+		}
+	}
+	return out
+}
+
+// An environment variable set for the builds of the targets matching a filter.
+// e.g. //go:multibuild:env.linux/mipsle=GOMIPS=softfloat
+type targetEnvVar struct {
+	filter filter
+	name   string
+	value  string
+}
+
+func (this targetEnvVar) String() string {
+	return this.name + "=" + this.value
+}
+
+// Returns the key the origins of the setting are recorded under.
+func (this targetEnvVar) key() string {
+	return settingKey("env", string(this.filter), this.String())
+}
+
+// Validates an env directive, 'key' being the filter and 'value' the variable, as NAME=VALUE.
+func validateTargetEnvVar(key, value string) (targetEnvVar, error) {
+	filters, err := validateFilterString(key)
+	if err != nil {
+		return targetEnvVar{}, err
+	}
+	if len(filters) != 1 {
+		return targetEnvVar{}, fmt.Errorf("expected a single filter, got %q", key)
+	}
+	name, val, ok := strings.Cut(value, "=")
+	if !ok {
+		return targetEnvVar{}, fmt.Errorf("expected NAME=VALUE, got %q", value)
+	}
+	if _, err := validateEnvAllow(name); err != nil {
+		return targetEnvVar{}, err
+	}
+	if envName(name) == "GOOS" || envName(name) == "GOARCH" {
+		return targetEnvVar{}, fmt.Errorf("%s is set by multibuild, for each target", name)
+	}
+	return targetEnvVar{filter: filters[0], name: name, value: val}, nil
+}
+
+// Returns the variables set for the builds of 't', as NAME=VALUE, in the order they were found,
+// so that if more than one sets a variable, the last wins.
+func (this options) environFor(t target) []string {
+	var out []string
+	for _, v := range this.TargetEnv {
+		if v.filter.matches(t) {
+			out = append(out, v.String())
+		}
+	}
+	return out
+}
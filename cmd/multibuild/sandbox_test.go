@@ -0,0 +1,131 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSandboxEnv(t *testing.T) {
+	environ := []string{
+		"PATH=/usr/bin",
+		"HOME=/home/me",
+		"GOPROXY=https://proxy.example.com",
+		"AWS_SECRET_ACCESS_KEY=hunter2",
+		"GOOS=plan9",
+		"GOENV=/home/me/.config/go/env",
+		"GOFLAGS=-ldflags=-X=main.Version=dirty",
+	}
+	got := sandboxEnv(environ, append(envEssential, envBuildDefault...), "/tmp/sandbox")
+	for _, want := range []string{
+		"PATH=/usr/bin",
+		"HOME=/home/me",
+		"GOPROXY=https://proxy.example.com",
+		"GOENV=off",
+		"TMPDIR=/tmp/sandbox",
+		"GOTMPDIR=/tmp/sandbox",
+	} {
+		if !slices.Contains(got, want) {
+			t.Errorf("missing %s in %v", want, got)
+		}
+	}
+	for _, unwanted := range []string{
+		"AWS_SECRET_ACCESS_KEY=hunter2",
+		"GOOS=plan9",
+		"GOENV=/home/me/.config/go/env",
+		"GOFLAGS=-ldflags=-X=main.Version=dirty",
+	} {
+		if slices.Contains(got, unwanted) {
+			t.Errorf("%s leaked into %v", unwanted, got)
+		}
+	}
+
+	// A package which allows GOFLAGS gets it.
+	got = sandboxEnv(environ, append(envEssential, buildEnvAllow([]string{"GOFLAGS"})...), "/tmp/sandbox")
+	if !slices.Contains(got, "GOFLAGS=-ldflags=-X=main.Version=dirty") || slices.Contains(got, "GOPROXY=https://proxy.example.com") {
+		t.Errorf("env-allow=GOFLAGS got %v", got)
+	}
+}
+
+func TestValidateTargetEnvVar(t *testing.T) {
+	tests := []struct {
+		key, value string
+		want       targetEnvVar
+		wantErr    bool
+	}{
+		{key: "linux/mipsle", value: "GOMIPS=softfloat", want: targetEnvVar{filter: "linux/mipsle", name: "GOMIPS", value: "softfloat"}},
+		{key: "darwin/*", value: "CC=o64-clang -arch arm64", want: targetEnvVar{filter: "darwin/*", name: "CC", value: "o64-clang -arch arm64"}},
+		{key: "linux/*", value: "CGO_CFLAGS=", want: targetEnvVar{filter: "linux/*", name: "CGO_CFLAGS", value: ""}},
+		{key: "linux/*", value: "CGO_ENABLED", wantErr: true},
+		{key: "linux/*", value: "1BAD=x", wantErr: true},
+		{key: "linux/*", value: "GOARCH=arm", wantErr: true},
+		{key: "linux/*,darwin/*", value: "CC=clang", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := validateTargetEnvVar(tt.key, tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s=%s: got error %v, wantErr %v", tt.key, tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s=%s: got %+v, want %+v", tt.key, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestEnvironFor(t *testing.T) {
+	opts := options{TargetEnv: []targetEnvVar{
+		{filter: "linux/*", name: "CGO_ENABLED", value: "1"},
+		{filter: "linux/mipsle", name: "GOMIPS", value: "softfloat"},
+		{filter: "darwin/*", name: "CC", value: "o64-clang"},
+	}}
+	if got, want := opts.environFor("linux/mipsle"), []string{"CGO_ENABLED=1", "GOMIPS=softfloat"}; !slices.Equal(got, want) {
+		t.Errorf("linux/mipsle: got %q, want %q", got, want)
+	}
+	if got := opts.environFor("windows/amd64"); len(got) != 0 {
+		t.Errorf("windows/amd64: got %q, want nothing", got)
+	}
+
+	// The variables reach go build, and CGO_ENABLED isn't turned off over them.
+	env := targetEnv(append([]string{"PATH=/bin"}, opts.environFor("linux/amd64")...), "linux/amd64")
+	if slices.Contains(env, "CGO_ENABLED=0") || !slices.Contains(env, "CGO_ENABLED=1") {
+		t.Errorf("got %q, want CGO_ENABLED=1 alone", env)
+	}
+}
+
+func TestPassthroughEnv(t *testing.T) {
+	t.Setenv("GOOS", "plan9")
+	t.Setenv("GOARCH", "arm")
+	t.Setenv("GOARM", "6")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "hunter2")
+	env, cleanup, err := buildEnviron(true, options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	got := passthroughEnv(env, os.Environ())
+	for _, want := range []string{"GOOS=plan9", "GOARCH=arm", "GOARM=6"} {
+		if !slices.Contains(got, want) {
+			t.Errorf("missing %s in %v", want, got)
+		}
+	}
+	if slices.Contains(got, "AWS_SECRET_ACCESS_KEY=hunter2") {
+		t.Errorf("AWS_SECRET_ACCESS_KEY leaked into %v", got)
+	}
+
+	// Without the sandbox, nothing is added twice.
+	env, cleanup, err = buildEnviron(false, options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	got = passthroughEnv(env, os.Environ())
+	if n := len(slices.DeleteFunc(slices.Clone(got), func(kv string) bool { return !strings.HasPrefix(kv, "GOOS=") })); n != 1 {
+		t.Errorf("got GOOS %d times in %v", n, got)
+	}
+}
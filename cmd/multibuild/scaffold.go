@@ -0,0 +1,330 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// What 'multibuild scaffold docker' needs to know about the package.
+type dockerScaffold struct {
+	// The binary's name, as ${TARGET}.
+	name string
+
+	// The package's directory, relative to its module's root, in slash form.
+	pkgDir string
+
+	// The Go version from go.mod, e.g. 1.24
+	goVersion string
+
+	// The configured options, which name the outputs (see outputPaths).
+	opts options
+
+	// The linux targets which are configured.
+	platforms []target
+}
+
+// Returns a multi-stage Dockerfile, which builds the binary, to be built from the module root.
+func (this dockerScaffold) multiStage() string {
+	var b strings.Builder
+	fmt.Fprintln(&b, "# Generated by multibuild scaffold docker. Build from the module root, e.g.:")
+	fmt.Fprintf(&b, "#   docker buildx build --platform %s -f %s .\n", this.platformList(), path.Join(this.pkgDir, "Dockerfile"))
+	fmt.Fprintf(&b, "FROM --platform=$BUILDPLATFORM golang:%s AS build\n", this.goVersion)
+	fmt.Fprintln(&b, "ARG TARGETOS TARGETARCH")
+	fmt.Fprintln(&b, "WORKDIR /src")
+	fmt.Fprintln(&b, "COPY go.mod go.sum* ./")
+	fmt.Fprintln(&b, "RUN go mod download")
+	fmt.Fprintln(&b, "COPY . .")
+	fmt.Fprintf(&b, "RUN CGO_ENABLED=0 GOOS=$TARGETOS GOARCH=$TARGETARCH go build -trimpath -o /out/%s ./%s\n", this.name, this.pkgDir)
+	fmt.Fprintln(&b)
+	fmt.Fprintf(&b, "FROM %s\n", defaultImageBase)
+	fmt.Fprintf(&b, "COPY --from=build /out/%s /%s\n", this.name, this.name)
+	fmt.Fprintf(&b, "ENTRYPOINT [\"/%s\"]\n", this.name)
+	return b.String()
+}
+
+// Returns whether docker's build arguments name the binary of each platform, as scratch uses
+// them. They don't if an alias or a text/template output template makes something else of GOOS
+// or GOARCH, e.g. with title.
+func (this dockerScaffold) scratchNamesBinaries() bool {
+	for _, t := range this.platforms {
+		goos, goarch := t.osArch()
+		_, want := this.opts.outputPaths(this.name, t)
+		got := this.opts.Output.expand(map[string]string{"TARGET": this.name, "GOOS": "${TARGETOS}", "GOARCH": "${TARGETARCH}", "GOVARIANT": "${TARGETVARIANT}"}, "$")
+		got = strings.NewReplacer("${TARGETOS}", goos, "${TARGETARCH}", goarch, "${TARGETVARIANT}", t.variant()).Replace(got)
+		if got != want {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the build context of the scratch Dockerfile, relative to the directory multibuild is
+// run in, and the path of the binaries in it. That's the directory the output template writes to,
+// so that only the binaries are sent to docker, or "." if the directory depends on the platform.
+func (this dockerScaffold) scratchSource() (string, string) {
+	// Docker's build arguments give the platform being built, in the same terms as Go.
+	// A literal $ is escaped from that substitution.
+	src := this.opts.Output.expand(map[string]string{"TARGET": this.name, "GOOS": "${TARGETOS}", "GOARCH": "${TARGETARCH}", "GOVARIANT": "${TARGETVARIANT}"}, `\$`)
+	dir, file := path.Split(src)
+	if dir == "" || strings.Contains(dir, "$") {
+		return ".", src
+	}
+	return path.Clean(dir), file
+}
+
+// Returns a Dockerfile which copies in the binaries built by multibuild, to be built from
+// the directory the output template writes to (see scratchSource). 'dockerfile' is its path,
+// relative to the directory multibuild is run in.
+func (this dockerScaffold) scratch(dockerfile string) string {
+	context, src := this.scratchSource()
+	args := "TARGETOS TARGETARCH"
+	if this.opts.Output.has("GOVARIANT") {
+		args += " TARGETVARIANT"
+	}
+
+	var b strings.Builder
+	fmt.Fprintln(&b, "# Generated by multibuild scaffold docker. Build with multibuild first, then from the same directory, e.g.:")
+	if context == "." && dockerfile == "Dockerfile" {
+		fmt.Fprintf(&b, "#   docker buildx build --platform %s .\n", this.platformList())
+	} else {
+		fmt.Fprintf(&b, "#   docker buildx build --platform %s -f %s %s\n", this.platformList(), dockerfile, context)
+	}
+	fmt.Fprintln(&b, "FROM scratch")
+	fmt.Fprintln(&b, "ARG "+args)
+	if strings.Contains(src, " ") {
+		// Otherwise, the path would be split in two.
+		fmt.Fprintf(&b, "COPY [%s, %s]\n", strconv.Quote(src), strconv.Quote("/"+this.name))
+	} else {
+		fmt.Fprintf(&b, "COPY %s /%s\n", src, this.name)
+	}
+	fmt.Fprintf(&b, "ENTRYPOINT [\"/%s\"]\n", this.name)
+	return b.String()
+}
+
+// Returns a compose file for the image, built from 'context' with 'dockerfile'.
+func (this dockerScaffold) compose(context, dockerfile string) string {
+	var b strings.Builder
+	fmt.Fprintln(&b, "services:")
+	fmt.Fprintf(&b, "  %s:\n", this.name)
+	fmt.Fprintf(&b, "    image: %s\n", this.name)
+	fmt.Fprintln(&b, "    build:")
+	fmt.Fprintf(&b, "      context: %s\n", context)
+	fmt.Fprintf(&b, "      dockerfile: %s\n", dockerfile)
+	return b.String()
+}
+
+// Returns a compose file for the scratch image, for a package in 'dir', when multibuild is run
+// in 'wd'. The compose file is next to the package, so the build context (where the binaries
+// are) is named from there, and the Dockerfile from the build context.
+func (this dockerScaffold) scratchCompose(dir, wd string) (string, error) {
+	context, _ := this.scratchSource()
+	contextDir := filepath.FromSlash(context)
+	if !filepath.IsAbs(contextDir) {
+		contextDir = filepath.Join(wd, contextDir)
+	}
+	fromDir, err := filepath.Rel(dir, contextDir)
+	if err != nil {
+		return "", err
+	}
+	toDir, err := filepath.Rel(contextDir, dir)
+	if err != nil {
+		return "", err
+	}
+	return this.compose(filepath.ToSlash(fromDir), path.Join(filepath.ToSlash(toDir), "Dockerfile")), nil
+}
+
+func (this dockerScaffold) platformList() string {
+	return strings.Join(mapSlice(this.platforms, func(t target) string { return string(t) }), ",")
+}
+
+// Writes 'content' to 'path', unless it exists and 'force' isn't set.
+func writeScaffold(path, content string, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !force {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if os.IsExist(err) {
+		return fmt.Errorf("%s already exists (use -force to overwrite it)", path)
+	} else if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "multibuild: wrote %s\n", path)
+	return f.Close()
+}
+
+// What scaffolds need to know about the package being scaffolded.
+type scaffoldPackage struct {
+	// The package's directory.
+	dir string
+
+	// The package's directory, relative to its module's root, in slash form.
+	rel string
+
+	// The Go version from go.mod, e.g. 1.24.3
+	goVersion string
+
+	// The package's configuration, and the targets it builds.
+	opts    options
+	targets []target
+}
+
+// Finds the package at 'packagePath', and loads its configuration.
+// On failure, also returns the exit code to use.
+func findScaffoldPackage(packagePath string) (scaffoldPackage, int, error) {
+	out, err := exec.Command("go", "list", "-f", "{{.Dir}}\n{{with .Module}}{{.Dir}}\n{{.GoVersion}}{{end}}", packagePath).Output()
+	if err != nil {
+		return scaffoldPackage{}, exitFailure, fmt.Errorf("failed to find package %s: %s", packagePath, err)
+	}
+	fields := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(fields) != 3 {
+		return scaffoldPackage{}, exitFailure, fmt.Errorf("%s is not in a module", packagePath)
+	}
+	pkgDir, moduleDir, goVersion := fields[0], fields[1], fields[2]
+	rel, err := filepath.Rel(moduleDir, pkgDir)
+	if err != nil {
+		return scaffoldPackage{}, exitFailure, err
+	}
+
+	sources, _, err := sourcesList(packagePath)
+	if err != nil {
+		return scaffoldPackage{}, exitFailure, fmt.Errorf("failed to discover sources: %s", err)
+	}
+	allTargets, err := targetList()
+	if err != nil {
+		return scaffoldPackage{}, exitTargets, fmt.Errorf("failed to list targets: %s", err)
+	}
+	opts, err := loadConfig(sources, options{}, allTargets)
+	if err != nil {
+		return scaffoldPackage{}, exitConfig, err
+	}
+	targets, err := opts.buildTargetList(allTargets)
+	if err != nil {
+		return scaffoldPackage{}, exitTargets, err
+	}
+	return scaffoldPackage{dir: pkgDir, rel: filepath.ToSlash(rel), goVersion: goVersion, opts: opts, targets: targets}, 0, nil
+}
+
+// Implements 'multibuild scaffold'.
+func doScaffold(self string, argv []string) {
+	usage := fmt.Sprintf("usage: %s scaffold docker [-scratch] [-compose] [-force] [package]\n"+
+		"       %s scaffold bazel|please [-force] [package]", self, self)
+	if len(argv) == 0 || !slices.Contains([]string{"docker", "bazel", "please"}, argv[0]) {
+		fatal("%s", usage)
+	}
+	kind := argv[0]
+	fs := flag.NewFlagSet(self+" scaffold "+kind, flag.ExitOnError)
+	var scratch, compose *bool
+	if kind == "docker" {
+		scratch = fs.Bool("scratch", false, "copy in the binaries built by multibuild, instead of building in the image")
+		compose = fs.Bool("compose", false, "also write a compose.yaml")
+	}
+	force := fs.Bool("force", false, "overwrite existing files")
+	fs.Parse(argv[1:])
+
+	packagePath := "."
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		packagePath = fs.Arg(0)
+	default:
+		fatal("multibuild: scaffold takes at most one package")
+	}
+	pkg, code, err := findScaffoldPackage(packagePath)
+	if err != nil {
+		fatalCode(code, "multibuild: %s", err)
+	}
+
+	switch kind {
+	case "docker":
+		scaffoldDocker(pkg, *scratch, *compose, *force)
+	case "bazel", "please":
+		sc := newRuleScaffold(pkg)
+		if len(sc.outputs) == 0 {
+			fatal("multibuild: no targets are configured")
+		}
+		dir := filepath.Join(pkg.dir, "multibuild")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			fatal("multibuild: %s", err)
+		}
+		name, content := "BUILD.bazel", sc.bazel()
+		if kind == "please" {
+			name, content = "BUILD", sc.please()
+		}
+		if err := writeScaffold(filepath.Join(dir, name), content, *force); err != nil {
+			fatal("multibuild: %s", err)
+		}
+	}
+}
+
+// Implements 'multibuild scaffold docker'.
+func scaffoldDocker(pkg scaffoldPackage, scratch, compose, force bool) {
+	goVersion := pkg.goVersion
+	if major, minor, ok := strings.Cut(goVersion, "."); ok {
+		minor, _, _ = strings.Cut(minor, ".")
+		goVersion = major + "." + minor // images are tagged by minor version
+	}
+
+	sc := dockerScaffold{name: filepath.Base(pkg.dir), pkgDir: pkg.rel, goVersion: goVersion, opts: pkg.opts}
+	for _, t := range pkg.targets {
+		if strings.HasPrefix(string(t), "linux/") {
+			sc.platforms = append(sc.platforms, t)
+		}
+	}
+	if len(sc.platforms) == 0 {
+		fatal("multibuild: no linux targets are configured, so there is nothing to put in an image")
+	}
+
+	// Compose files are next to the Dockerfile, and say where to build from.
+	dockerfile := filepath.Join(pkg.dir, "Dockerfile")
+	context, composeDockerfile := ".", "Dockerfile"
+	if sc.pkgDir != "." {
+		context = strings.TrimSuffix(strings.Repeat("../", strings.Count(sc.pkgDir, "/")+1), "/")
+		composeDockerfile = path.Join(sc.pkgDir, "Dockerfile")
+	}
+	content, composeContent := sc.multiStage(), sc.compose(context, composeDockerfile)
+	if scratch {
+		if !slices.Contains(pkg.opts.Format, formatRaw) {
+			fatal("multibuild: -scratch copies in the raw binaries, but format doesn't include raw")
+		}
+		if !sc.scratchNamesBinaries() {
+			fatal("multibuild: -scratch names the binaries with docker's build arguments, which can't express the output template %s", sc.opts.Output)
+		}
+		// The output template is relative to the directory multibuild is run in, not the package.
+		wd, err := os.Getwd()
+		if err != nil {
+			fatal("multibuild: %s", err)
+		}
+		rel, err := filepath.Rel(wd, dockerfile)
+		if err != nil {
+			fatal("multibuild: %s", err)
+		}
+		content = sc.scratch(filepath.ToSlash(rel))
+		if composeContent, err = sc.scratchCompose(pkg.dir, wd); err != nil {
+			fatal("multibuild: %s", err)
+		}
+	}
+	if err := writeScaffold(dockerfile, content, force); err != nil {
+		fatal("multibuild: %s", err)
+	}
+	if compose {
+		if err := writeScaffold(filepath.Join(pkg.dir, "compose.yaml"), composeContent, force); err != nil {
+			fatal("multibuild: %s", err)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDockerScaffold(t *testing.T) {
+	sc := dockerScaffold{
+		name:      "app",
+		pkgDir:    "cmd/app",
+		goVersion: "1.24",
+		opts:      options{Output: "bin/${TARGET}-${GOOS}-${GOARCH}"},
+		platforms: []target{"linux/amd64", "linux/arm64"},
+	}
+
+	tests := []struct {
+		name string
+		got  string
+		want []string
+	}{
+		{
+			name: "multi-stage",
+			got:  sc.multiStage(),
+			want: []string{
+				"--platform linux/amd64,linux/arm64 -f cmd/app/Dockerfile .\n",
+				"FROM --platform=$BUILDPLATFORM golang:1.24 AS build\n",
+				"go build -trimpath -o /out/app ./cmd/app\n",
+				"COPY --from=build /out/app /app\n",
+			},
+		},
+		{
+			name: "scratch",
+			got:  sc.scratch("Dockerfile"),
+			want: []string{
+				"--platform linux/amd64,linux/arm64 -f Dockerfile bin\n",
+				"FROM scratch\n",
+				"COPY app-${TARGETOS}-${TARGETARCH} /app\n",
+				`ENTRYPOINT ["/app"]` + "\n",
+			},
+		},
+		{
+			name: "scratch with spaces",
+			got:  dockerScaffold{name: "app", opts: options{Output: "bin/My $$${TARGET}-${GOOS}-${GOARCH}"}}.scratch("Dockerfile"),
+			want: []string{
+				`COPY ["My \\$app-${TARGETOS}-${TARGETARCH}", "/app"]` + "\n",
+			},
+		},
+		{
+			name: "scratch with a text/template",
+			got:  dockerScaffold{name: "app", opts: options{Output: "bin/$/{{.TARGET}}-{{.GOOS}}-{{.GOARCH}}"}}.scratch("Dockerfile"),
+			want: []string{
+				`COPY bin/\$/app-${TARGETOS}-${TARGETARCH} /app` + "\n",
+			},
+		},
+		{
+			name: "scratch without a directory",
+			got:  dockerScaffold{name: "app", opts: options{Output: "${TARGET}-${GOOS}-${GOARCH}"}}.scratch("Dockerfile"),
+			want: []string{
+				"COPY app-${TARGETOS}-${TARGETARCH} /app\n",
+			},
+		},
+		{
+			name: "scratch in another directory",
+			got:  sc.scratch("cmd/app/Dockerfile"),
+			want: []string{
+				"--platform linux/amd64,linux/arm64 -f cmd/app/Dockerfile bin\n",
+			},
+		},
+		{
+			name: "compose",
+			got:  sc.compose("../..", "cmd/app/Dockerfile"),
+			want: []string{"  app:\n", "      context: ../..\n", "      dockerfile: cmd/app/Dockerfile\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, want := range tt.want {
+				if !strings.Contains(tt.got, want) {
+					t.Errorf("missing %q in:\n%s", want, tt.got)
+				}
+			}
+		})
+	}
+}
+
+func TestScratchNamesBinaries(t *testing.T) {
+	tests := []struct {
+		output    outputTemplate
+		platforms []target
+		want      bool
+	}{
+		{"bin/${TARGET}-${GOOS}-${GOARCH}", []target{"linux/amd64", "linux/arm64"}, true},
+		{"bin/${TARGET}-${GOOS}-${GOARCH}${GOVARIANT}", []target{"linux/amd64", "linux/arm/v7"}, true},
+		{"bin/{{.TARGET}}-{{.GOOS}}-{{.GOARCH}}", []target{"linux/amd64", "linux/arm64"}, true},
+		{"bin/{{.TARGET}}-{{title .GOOS}}-{{.GOARCH}}", []target{"linux/amd64"}, false},
+		// Without ${GOVARIANT}, the variant is part of ${GOARCH}, but not of $TARGETARCH.
+		{"bin/${TARGET}-${GOOS}-${GOARCH}", []target{"linux/amd64", "linux/arm/v7"}, false},
+	}
+	for _, tt := range tests {
+		sc := dockerScaffold{name: "app", opts: options{Output: tt.output}, platforms: tt.platforms}
+		if got := sc.scratchNamesBinaries(); got != tt.want {
+			t.Errorf("scratchNamesBinaries() with %s on %s = %v, want %v", tt.output, tt.platforms, got, tt.want)
+		}
+	}
+}
+
+func TestWriteScaffold(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Dockerfile")
+	if err := writeScaffold(path, "one", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeScaffold(path, "two", false); err == nil {
+		t.Errorf("expected an error overwriting without force")
+	}
+	if err := writeScaffold(path, "three", true); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "three" {
+		t.Errorf("got %q, want %q", data, "three")
+	}
+}
+
+func TestRuleScaffold(t *testing.T) {
+	pkg := scaffoldPackage{
+		dir:     filepath.Join("src", "cmd", "app"),
+		rel:     "cmd/app",
+		opts:    options{Output: "bin/${TARGET}-${GOOS}-${GOARCH}"},
+		targets: []target{"linux/amd64", "windows/arm64"},
+	}
+	sc := newRuleScaffold(pkg)
+
+	tests := []struct {
+		name string
+		got  string
+		want []string
+	}{
+		{
+			name: "bazel",
+			got:  sc.bazel(),
+			want: []string{
+				"# bazel build //cmd/app/multibuild:binaries\n",
+				"    name = \"app-linux-amd64\",\n    platform = \"@rules_go//go/toolchain:linux_amd64\",\n    target = \"//cmd/app:app\",\n",
+				"    name = \"app-windows-arm64.exe\",\n    platform = \"@rules_go//go/toolchain:windows_arm64\",\n",
+				"    name = \"binaries\",\n    srcs = [\n        \":app-linux-amd64\",\n        \":app-windows-arm64.exe\",\n    ],\n",
+			},
+		},
+		{
+			name: "please",
+			got:  sc.please(),
+			want: []string{
+				"# plz build //cmd/app/multibuild:binaries\n",
+				"    name = \"app-linux-amd64\",\n    srcs = [\"///linux_amd64//cmd/app:app\"],\n    outs = [\"app-linux-amd64\"],\n",
+				"    srcs = [\"///windows_arm64//cmd/app:app\"],\n    outs = [\"app-windows-arm64.exe\"],\n",
+				"    name = \"binaries\",\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, want := range tt.want {
+				if !strings.Contains(tt.got, want) {
+					t.Errorf("missing %q in:\n%s", want, tt.got)
+				}
+			}
+		})
+	}
+
+	pkg.rel = "."
+	if got := newRuleScaffold(pkg).please(); !strings.Contains(got, "# plz build //multibuild:binaries\n") || !strings.Contains(got, "///linux_amd64//:app") {
+		t.Errorf("unexpected labels for a package at the root:\n%s", got)
+	}
+}
+
+func TestScratchCompose(t *testing.T) {
+	dir := filepath.FromSlash("/src/cmd/app")
+	tests := []struct {
+		wd      string
+		output  outputTemplate
+		context string
+		want    string
+	}{
+		// The context is where the binaries are, named from the package, and the Dockerfile
+		// is named from the context.
+		{"/src/cmd/app", "bin/${TARGET}-${GOOS}-${GOARCH}", "bin", "../Dockerfile"},
+		{"/src/cmd/app", "../../dist/${TARGET}-${GOOS}-${GOARCH}", "../../dist", "../cmd/app/Dockerfile"},
+		{"/src/cmd/app", "${TARGET}-${GOOS}-${GOARCH}", ".", "Dockerfile"},
+		{"/src/cmd/app", "bin/${GOOS}/${TARGET}-${GOARCH}", ".", "Dockerfile"},
+
+		// The output template is relative to where multibuild is run, not the package.
+		{"/src", "bin/${TARGET}-${GOOS}-${GOARCH}", "../../bin", "../cmd/app/Dockerfile"},
+		{"/src", "${TARGET}-${GOOS}-${GOARCH}", "../..", "cmd/app/Dockerfile"},
+	}
+	for _, tt := range tests {
+		sc := dockerScaffold{name: "app", opts: options{Output: tt.output}}
+		got, err := sc.scratchCompose(dir, filepath.FromSlash(tt.wd))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"      context: " + tt.context + "\n", "      dockerfile: " + tt.want + "\n"} {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: missing %q in:\n%s", tt.output, want, got)
+			}
+		}
+	}
+}
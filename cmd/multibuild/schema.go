@@ -0,0 +1,86 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// The version of the manifest format, bumped whenever it changes incompatibly: fields may be
+// added without a bump, but never removed, renamed, or given a different meaning.
+const manifestVersion = 1
+
+// The JSON schema (draft 2020-12) of the manifest, see --multibuild-manifest.
+// Keep this in step with manifest and manifestTarget.
+var manifestSchema = fmt.Sprintf(`{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "title": "multibuild manifest",
+  "description": "The outcome of a multibuild run, written with --multibuild-manifest.",
+  "type": "object",
+  "required": ["version", "complete", "config_hash", "targets"],
+  "properties": {
+    "version": {
+      "description": "The version of the manifest format, bumped whenever it changes incompatibly.",
+      "const": %d
+    },
+    "complete": {
+      "description": "Whether every target was built.",
+      "type": "boolean"
+    },
+    "config_hash": {
+      "description": "Identifies the configuration the run used.",
+      "type": "string"
+    },
+    "image": {
+      "description": "The image published from the run, by digest, if any.",
+      "type": "string"
+    },
+    "targets": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["target", "status"],
+        "properties": {
+          "target": {
+            "description": "The target, as os/arch, or os/arch/variant.",
+            "type": "string",
+            "pattern": "^[^/]+/[^/]+(/[^/]+)?$"
+          },
+          "status": {
+            "description": "ok, missing (the target failed), or discarded (see partial=discard).",
+            "enum": ["ok", "missing", "discarded"]
+          },
+          "artifacts": {
+            "description": "The paths of the files built for the target.",
+            "type": "array",
+            "items": {"type": "string"}
+          },
+          "error": {
+            "description": "Why the target failed, if it did.",
+            "type": "string"
+          },
+          "checksums": {
+            "description": "The SHA-256 of each artifact, in hex, by path.",
+            "type": "object",
+            "additionalProperties": {"type": "string", "pattern": "^[0-9a-f]{64}$"}
+          },
+          "environment": {
+            "description": "The names of the environment variables passed to go build.",
+            "type": "array",
+            "items": {"type": "string"}
+          }
+        }
+      }
+    }
+  }
+}
+`, manifestVersion)
+
+// Implements --multibuild-schema: prints the schema of the manifest, and exits.
+func displaySchemaAndExit() {
+	fmt.Print(manifestSchema)
+	os.Exit(0)
+}
@@ -0,0 +1,80 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type schemaObject struct {
+	Required   []string `json:"required"`
+	Properties map[string]struct {
+		Const *int          `json:"const"`
+		Enum  []string      `json:"enum"`
+		Items *schemaObject `json:"items"`
+	} `json:"properties"`
+}
+
+// Checks that the keys of 'value' are all properties of 'schema', and its required ones are there.
+func checkSchemaKeys(t *testing.T, name string, schema schemaObject, value map[string]json.RawMessage) {
+	t.Helper()
+	for key := range value {
+		if _, ok := schema.Properties[key]; !ok {
+			t.Errorf("%s: %q is not in the schema", name, key)
+		}
+	}
+	for _, key := range schema.Required {
+		if _, ok := value[key]; !ok {
+			t.Errorf("%s: required %q is missing", name, key)
+		}
+	}
+}
+
+func TestManifestSchema(t *testing.T) {
+	var schema schemaObject
+	if err := json.Unmarshal([]byte(manifestSchema), &schema); err != nil {
+		t.Fatalf("schema isn't valid JSON: %s", err)
+	}
+	if v := schema.Properties["version"].Const; v == nil || *v != manifestVersion {
+		t.Errorf("version const = %v, want %d", v, manifestVersion)
+	}
+	targetSchema := schema.Properties["targets"].Items
+	if targetSchema == nil {
+		t.Fatal("no schema for targets")
+	}
+	if got := targetSchema.Properties["status"].Enum; !slices.Equal(got, []string{"ok", "missing", "discarded"}) {
+		t.Errorf("status enum = %v", got)
+	}
+
+	// Every field is set, so that none is left out of the schema.
+	results := []targetResult{
+		{target: "linux/amd64", artifacts: []string{"app-linux-amd64"}, environment: []string{"HOME"}, checksums: map[string]string{"app-linux-amd64": strings.Repeat("0", 64)}},
+		{target: "windows/amd64", err: errors.New("boom")},
+		{target: "darwin/arm64", discarded: true},
+	}
+	m := buildManifest("abc", results)
+	m.Image = "example.com/app@sha256:0"
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]json.RawMessage
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	checkSchemaKeys(t, "manifest", schema, got)
+
+	var targets []map[string]json.RawMessage
+	if err := json.Unmarshal(got["targets"], &targets); err != nil {
+		t.Fatal(err)
+	}
+	for _, mt := range targets {
+		checkSchemaKeys(t, string(mt["target"]), *targetSchema, mt)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// The keyword used in secret= to refer to the builtin patterns.
+const builtinSecrets = "builtin"
+
+// A pattern for something which should never end up in a binary.
+type secretPattern struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// Well known formats of credentials.
+// These are deliberately specific, as binaries are full of random looking data.
+var builtinSecretPatterns = []secretPattern{
+	{"private key", regexp.MustCompile(`-----BEGIN (RSA |EC |DSA |OPENSSH |ENCRYPTED )?PRIVATE KEY-----`)},
+	{"AWS access key", regexp.MustCompile(`(AKIA|ASIA)[0-9A-Z]{16}`)},
+	{"GitHub token", regexp.MustCompile(`(gh[pousr]_[A-Za-z0-9]{36}|github_pat_[A-Za-z0-9_]{82})`)},
+	{"GitLab token", regexp.MustCompile(`glpat-[A-Za-z0-9_-]{20}`)},
+	{"Slack token", regexp.MustCompile(`xox[abprs]-[0-9A-Za-z-]{10,}`)},
+	{"Google API key", regexp.MustCompile(`AIza[0-9A-Za-z_-]{35}`)},
+	{"Stripe key", regexp.MustCompile(`[sr]k_live_[0-9A-Za-z]{24,}`)},
+}
+
+// Validates a secret= value: either "builtin", or a regular expression.
+func validateSecret(s string) (string, error) {
+	if s == "" {
+		return "", fmt.Errorf("empty pattern")
+	}
+	if s == builtinSecrets {
+		return s, nil
+	}
+	if _, err := regexp.Compile(s); err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
+// Returns the patterns for the secret= values in 'secrets'.
+func secretPatterns(secrets []string) []secretPattern {
+	var out []secretPattern
+	builtin := false
+	for _, s := range secrets {
+		if s == builtinSecrets {
+			if !builtin {
+				out = append(out, builtinSecretPatterns...)
+			}
+			builtin = true
+			continue
+		}
+		out = append(out, secretPattern{name: "pattern " + s, re: regexp.MustCompile(s)})
+	}
+	return out
+}
+
+// Something which looks like a secret, found in a file.
+type secretMatch struct {
+	pattern string
+	offset  int
+
+	// Enough of the match to find it, without repeating the whole secret.
+	redacted string
+}
+
+func (this secretMatch) String() string {
+	return fmt.Sprintf("%s at offset %d (%s)", this.pattern, this.offset, this.redacted)
+}
+
+// Redacts all but the start of a match.
+func redact(s string) string {
+	const keep = 6
+	if len(s) <= keep {
+		return "..."
+	}
+	return s[:keep] + "..."
+}
+
+// Scans the file at 'path' for anything matching 'patterns'.
+func scanSecrets(path string, patterns []secretPattern) ([]secretMatch, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var matches []secretMatch
+	for _, p := range patterns {
+		for _, loc := range p.re.FindAllIndex(data, -1) {
+			matches = append(matches, secretMatch{pattern: p.name, offset: loc[0], redacted: redact(string(data[loc[0]:loc[1]]))})
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Snapcraft's names for GOARCH values.
+var snapArches = map[string]string{
+	"amd64":   "amd64",
+	"arm64":   "arm64",
+	"arm":     "armhf",
+	"ppc64le": "ppc64el",
+	"riscv64": "riscv64",
+	"s390x":   "s390x",
+}
+
+// Snap names are lower case letters, digits and hyphens, and can't start or end with a hyphen.
+var snapNameRE = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,38}[a-z0-9])?$`)
+
+// Returns 'version' in a form snapcraft accepts.
+func snapVersion(version string) string {
+	version = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(".+~-", r) || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '.'
+	}, version)
+	return version[:min(len(version), 32)]
+}
+
+// Returns a snapcraft.yaml for a snap of 'binary', built for 't'.
+// The binary is expected to be next to the snapcraft.yaml.
+// 'info' should have had defaults filled in, see withDefaults.
+func snapcraftYAML(t target, binary string, info packageInfo) (string, error) {
+	_, goarch := t.osArch()
+	arch, ok := snapArches[goarch]
+	if !ok {
+		return "", fmt.Errorf("snap: %s is not supported", t)
+	}
+	name := strings.ToLower(info.Name)
+	if !snapNameRE.MatchString(name) {
+		return "", fmt.Errorf("snap: %q is not a valid snap name (see package.name)", name)
+	}
+	description := info.Description
+	if description == "" {
+		description = name
+	}
+	summary := description
+	if r := []rune(summary); len(r) > 78 {
+		summary = string(r[:78])
+	}
+	var buildOn []string
+	for _, a := range snapArches {
+		buildOn = append(buildOn, a)
+	}
+	slices.Sort(buildOn)
+
+	// Values are quoted as JSON strings, which are valid YAML.
+	q := func(s string) string { return fmt.Sprintf("%q", s) }
+	var b strings.Builder
+	fmt.Fprintf(&b, "name: %s\n", name)
+	fmt.Fprintln(&b, "base: core24")
+	fmt.Fprintf(&b, "version: %s\n", q(snapVersion(info.Version)))
+	fmt.Fprintf(&b, "summary: %s\n", q(summary))
+	fmt.Fprintf(&b, "description: %s\n", q(description))
+	if info.License != "" {
+		fmt.Fprintf(&b, "license: %s\n", q(info.License))
+	}
+	fmt.Fprintln(&b, "grade: stable")
+	fmt.Fprintln(&b, "confinement: strict")
+	fmt.Fprintln(&b, "platforms:")
+	fmt.Fprintf(&b, "  %s:\n", arch)
+	fmt.Fprintf(&b, "    build-on: [%s]\n", strings.Join(buildOn, ", "))
+	fmt.Fprintf(&b, "    build-for: [%s]\n", arch)
+	fmt.Fprintln(&b, "apps:")
+	fmt.Fprintf(&b, "  %s:\n", name)
+	fmt.Fprintf(&b, "    command: bin/%s\n", name)
+	fmt.Fprintln(&b, "    plugs: [home, network, network-bind]")
+	fmt.Fprintln(&b, "parts:")
+	fmt.Fprintf(&b, "  %s:\n", name)
+	fmt.Fprintln(&b, "    plugin: dump")
+	fmt.Fprintln(&b, "    source: .")
+	fmt.Fprintln(&b, "    organize:")
+	fmt.Fprintf(&b, "      %s: bin/%s\n", q(binary), name)
+	fmt.Fprintln(&b, "    stage:")
+	fmt.Fprintf(&b, "      - bin/%s\n", name)
+	return b.String(), nil
+}
+
+// Writes a snap at 'arPath' of 'outBin', using snapcraft.
+func writeSnap(ctx context.Context, t target, arPath, outBin string, info packageInfo, log io.Writer) error {
+	yaml, err := snapcraftYAML(t, filepath.Base(outBin), info)
+	if err != nil {
+		return err
+	}
+	dir, err := stageBinary(outBin)
+	if err != nil {
+		return fmt.Errorf("failed to stage %s: %s", outBin, err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, "snapcraft.yaml"), []byte(yaml), 0644); err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(arPath)
+	if err != nil {
+		return err
+	}
+	_, goarch := t.osArch()
+	cmd := exec.CommandContext(ctx, "snapcraft", "pack", "--destructive-mode", "--build-for="+snapArches[goarch], "--output", abs)
+	cmd.Dir = dir
+	return runPackager(t, log, cmd)
+}
@@ -0,0 +1,74 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSnapcraftYAML(t *testing.T) {
+	got, err := snapcraftYAML("linux/arm", "app-linux-arm", packageInfo{Name: "App", Version: "1.2.3-4-gabc/def", License: "MIT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"name: app\n",
+		`version: "1.2.3-4-gabc.def"` + "\n",
+		`license: "MIT"` + "\n",
+		"    build-for: [armhf]\n",
+		"    command: bin/app\n",
+		`      "app-linux-arm": bin/app` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+
+	if _, err := snapcraftYAML("linux/amd64", "app", packageInfo{Name: "my_app"}); err == nil {
+		t.Errorf("expected an error for an invalid snap name")
+	}
+	if _, err := snapcraftYAML("linux/386", "app", packageInfo{Name: "app"}); err == nil {
+		t.Errorf("expected an error for an unsupported architecture")
+	}
+}
+
+func TestWriteSnap(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake snapcraft is a shell script")
+	}
+
+	// A fake snapcraft, which records its arguments and the project it was run in as the snap.
+	bin := t.TempDir()
+	fake := "#!/bin/sh\nfor a; do out=\"$a\"; done\n{ echo \"$@\"; ls; } > \"$out\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "snapcraft"), []byte(fake), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+	outBin := filepath.Join(dir, "app-linux-amd64")
+	if err := os.WriteFile(outBin, []byte("binary"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var log bytes.Buffer
+	if err := writeSnap(context.Background(), "linux/amd64", outBin+".snap", outBin, packageInfo{Name: "app", Version: "1"}, &log); err != nil {
+		t.Fatalf("writeSnap: %s\n%s", err, log.String())
+	}
+	data, err := os.ReadFile(outBin + ".snap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"pack --destructive-mode --build-for=amd64", "app-linux-amd64\n", "snapcraft.yaml\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("missing %q in:\n%s", want, data)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Describes how a run of multibuild produced its binaries.
+// With stamp=PACKAGE, these are set in PACKAGE with -X, see stampArgs.
+type provenance struct {
+	// Who built it, as user@host.
+	builder string
+
+	// When it was built.
+	time time.Time
+
+	// The version of multibuild which built it.
+	version string
+}
+
+// Validates the package to stamp provenance into.
+func validateStamp(s string) (string, error) {
+	if s == "" {
+		return "", fmt.Errorf("empty package")
+	}
+	if strings.ContainsAny(s, " \t'\"=") {
+		return "", fmt.Errorf("%q is not a package path", s)
+	}
+	return s, nil
+}
+
+// Returns the time of a build at 'now', in UTC, unless SOURCE_DATE_EPOCH says otherwise.
+// 'lookup' is normally os.LookupEnv.
+func buildTime(lookup func(string) (string, bool), now time.Time) (time.Time, error) {
+	// See https://reproducible-builds.org/specs/source-date-epoch/
+	if v, ok := lookup("SOURCE_DATE_EPOCH"); ok && v != "" {
+		secs, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("SOURCE_DATE_EPOCH=%s is not a number of seconds", v)
+		}
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	return now.UTC(), nil
+}
+
+// Returns the provenance of this run.
+// 'lookup' is normally os.LookupEnv, for SOURCE_DATE_EPOCH.
+func currentProvenance(lookup func(string) (string, bool), now time.Time) (provenance, error) {
+	t, err := buildTime(lookup, now)
+	if err != nil {
+		return provenance{}, err
+	}
+	p := provenance{time: t, version: "unknown"}
+
+	name, host := "unknown", "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if h, err := os.Hostname(); err == nil {
+		host = h
+	}
+	p.builder = name + "@" + host
+
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		p.version = info.Main.Version
+	}
+	return p, nil
+}
+
+// Returns the variables set in the stamped package for target 't'.
+func (this provenance) variables(t target) [][2]string {
+	return [][2]string{
+		{"Builder", this.builder},
+		{"BuildTime", this.time.Format(time.RFC3339)},
+		{"Target", string(t)},
+		{"MultibuildVersion", this.version},
+	}
+}
+
+// Quotes 's' as an argument in -ldflags, as go build splits them: at spaces, unless quoted
+// with ' or ", which can't be escaped. So a value with spaces and both quotes can't be given.
+func quoteLdflag(s string) (string, error) {
+	if !strings.ContainsAny(s, " \t\r\n") {
+		return s, nil // quotes only mean anything at the start, and the package path comes first
+	}
+	if !strings.Contains(s, `"`) {
+		return `"` + s + `"`, nil
+	}
+	if !strings.Contains(s, "'") {
+		return "'" + s + "'", nil
+	}
+	return "", fmt.Errorf("%q has spaces and both kinds of quote, so it can't be passed in -ldflags", s)
+}
+
+// Returns 'goBuildArgs', with -X flags setting the variables in 'vars' in 'pkg'.
+// These are added to any -ldflags already given, as go build only uses the last one.
+func stampArgs(goBuildArgs []string, pkg string, vars [][2]string) ([]string, error) {
+	var xs []string
+	for _, v := range vars {
+		x, err := quoteLdflag(fmt.Sprintf("%s.%s=%s", pkg, v[0], v[1]))
+		if err != nil {
+			return nil, err
+		}
+		xs = append(xs, "-X", x)
+	}
+	stamp := strings.Join(xs, " ")
+	return editLdflags(goBuildArgs, func(ldflags string) string { return strings.TrimSpace(ldflags + " " + stamp) }), nil
+}
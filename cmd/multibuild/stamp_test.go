@@ -0,0 +1,102 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestStampArgs(t *testing.T) {
+	vars := [][2]string{{"Target", "linux/amd64"}, {"Builder", "me@host"}}
+	const x = "-X main.Target=linux/amd64 -X main.Builder=me@host"
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "no ldflags",
+			args: []string{"-o", "out", "."},
+			want: []string{"-ldflags=" + x, "-o", "out", "."},
+		},
+		{
+			name: "ldflags=",
+			args: []string{"-ldflags=-s -w", "."},
+			want: []string{"-ldflags=-s -w " + x, "."},
+		},
+		{
+			name: "separate ldflags",
+			args: []string{"--ldflags", "-s", "."},
+			want: []string{"--ldflags", "-s " + x, "."},
+		},
+		{
+			name: "last ldflags wins",
+			args: []string{"-ldflags=-s", "-ldflags=-w"},
+			want: []string{"-ldflags=-s", "-ldflags=-w " + x},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stampArgs(tt.args, "main", vars)
+			if err != nil || !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	for _, tt := range []struct {
+		value string
+		want  string
+	}{
+		{"Jo Bloggs@host", `-ldflags=-X "example.com/app/buildinfo.Builder=Jo Bloggs@host"`},
+		{`Jo "JB" Bloggs@host`, `-ldflags=-X 'example.com/app/buildinfo.Builder=Jo "JB" Bloggs@host'`},
+		{`jo"b'@host`, `-ldflags=-X example.com/app/buildinfo.Builder=jo"b'@host`},
+	} {
+		got, err := stampArgs(nil, "example.com/app/buildinfo", [][2]string{{"Builder", tt.value}})
+		if want := []string{tt.want}; err != nil || !slices.Equal(got, want) {
+			t.Errorf("%s: got %q (%v), want %q", tt.value, got, err, want)
+		}
+	}
+	if got, err := stampArgs(nil, "main", [][2]string{{"Builder", `Jo "JB" O'Bloggs`}}); err == nil {
+		t.Errorf("got %q, expected an error for a value with spaces and both quotes", got)
+	}
+}
+
+func TestCurrentProvenance(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	env := map[string]string{}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	p, err := currentProvenance(lookup, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.time.Equal(now) {
+		t.Errorf("got time %s, want %s", p.time, now)
+	}
+
+	env["SOURCE_DATE_EPOCH"] = "1700000000"
+	if p, err = currentProvenance(lookup, now); err != nil {
+		t.Fatal(err)
+	}
+	vars := p.variables("linux/arm64")
+	if want := [2]string{"BuildTime", "2023-11-14T22:13:20Z"}; !slices.Contains(vars, want) {
+		t.Errorf("got %v, want %v", vars, want)
+	}
+	if want := [2]string{"Target", "linux/arm64"}; !slices.Contains(vars, want) {
+		t.Errorf("got %v, want %v", vars, want)
+	}
+
+	env["SOURCE_DATE_EPOCH"] = "yesterday"
+	if _, err = currentProvenance(lookup, now); err == nil {
+		t.Errorf("expected an error for a bad SOURCE_DATE_EPOCH")
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// The formats a summary of the run can be written in.
+type summaryFormat string
+
+const (
+	// A table, suitable for a PR comment or $GITHUB_STEP_SUMMARY.
+	summaryMarkdown summaryFormat = "markdown"
+
+	// A self-contained HTML report, including the logs of each target.
+	summaryHTML summaryFormat = "html"
+)
+
+// A requested summary, e.g. --multibuild-summary=markdown:summary.md
+type summarySpec struct {
+	format summaryFormat
+
+	// Where to write the summary. Empty means stdout.
+	path string
+}
+
+// Validates that 's' is a summary specification, i.e. format[:path].
+func validateSummarySpec(s string) (summarySpec, error) {
+	name, path, _ := strings.Cut(s, ":")
+	switch f := summaryFormat(name); f {
+	case summaryMarkdown, summaryHTML:
+		return summarySpec{format: f, path: path}, nil
+	}
+	return summarySpec{}, fmt.Errorf("summary format %q is not valid", name)
+}
+
+// A single artifact (or failed target) in a summary.
+type summaryRow struct {
+	result   targetResult
+	artifact string
+	size     int64
+	sha256   string
+}
+
+// Collects the rows for a summary of 'results', one per artifact.
+func summaryRows(results []targetResult) ([]summaryRow, error) {
+	var rows []summaryRow
+	for _, r := range results {
+		if len(r.artifacts) == 0 {
+			rows = append(rows, summaryRow{result: r})
+			continue
+		}
+		for _, a := range r.artifacts {
+			size, sum, err := artifactInfo(a)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, summaryRow{result: r, artifact: a, size: size, sha256: sum})
+		}
+	}
+	return rows, nil
+}
+
+// Describes the outcome of a target in a word.
+func (this targetResult) status() string {
+	switch {
+	case this.err != nil:
+		return "failed"
+	case this.discarded:
+		return "discarded"
+	}
+	return "ok"
+}
+
+// Formats a size in bytes for humans, e.g. 1.5 MiB.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Writes a markdown table describing 'results'.
+func writeMarkdownSummary(w io.Writer, results []targetResult, rows []summaryRow) {
+	built := 0
+	for _, r := range results {
+		if r.err == nil {
+			built++
+		}
+	}
+	fmt.Fprintf(w, "### multibuild: %d of %d targets built\n\n", built, len(results))
+	fmt.Fprintln(w, "| Target | Status | Duration | Artifact | Size | SHA-256 |")
+	fmt.Fprintln(w, "|--------|--------|----------|----------|------|---------|")
+	for _, row := range rows {
+		duration := row.result.duration().Round(100 * time.Millisecond).String()
+		if row.artifact == "" {
+			fmt.Fprintf(w, "| %s | %s | %s | | | |\n", row.result.target, row.result.status(), duration)
+			continue
+		}
+		fmt.Fprintf(w, "| %s | %s | %s | `%s` | %s | `%s` |\n", row.result.target, row.result.status(), duration, row.artifact, formatSize(row.size), row.sha256)
+	}
+}
+
+// Writes an aligned table of the artifacts of each target in 'results', with a row of totals,
+// for the end of a run. Sizes are taken from the filesystem, so nothing is hashed for it.
+func writeResultsTable(w io.Writer, results []targetResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tSTATUS\tDURATION\tOUTPUT\tSIZE")
+	var first, last time.Time
+	var files int
+	var total int64
+	built := 0
+	for _, r := range results {
+		if r.err == nil {
+			built++
+		}
+		if first.IsZero() || r.queued.Before(first) {
+			first = r.queued
+		}
+		if r.finished.After(last) {
+			last = r.finished
+		}
+		duration := r.duration().Round(100 * time.Millisecond).String()
+		if len(r.artifacts) == 0 {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t-\t-\n", r.target, r.status(), duration)
+			continue
+		}
+		for idx, a := range r.artifacts {
+			size := "-"
+			if st, err := os.Stat(a); err == nil {
+				size = formatSize(st.Size())
+				total += st.Size()
+			}
+			files++
+			if idx == 0 {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.target, r.status(), duration, a, size)
+			} else {
+				// The target's other artifacts are listed under it.
+				fmt.Fprintf(tw, "\t\t\t%s\t%s\n", a, size)
+			}
+		}
+	}
+	fmt.Fprintf(tw, "total\t%d/%d ok\t%s\t%d files\t%s\n", built, len(results), last.Sub(first).Round(100*time.Millisecond), files, formatSize(total))
+	tw.Flush()
+}
+
+// Writes the summary described by 'spec'.
+// Markdown files are appended to, so that the path can be e.g. $GITHUB_STEP_SUMMARY.
+func writeSummary(spec summarySpec, results []targetResult) error {
+	rows, err := summaryRows(results)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	base := "."
+	if spec.path != "" {
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if spec.format == summaryMarkdown {
+			flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		}
+		if err := os.MkdirAll(filepath.Dir(spec.path), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(spec.path, flags, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+		base = filepath.Dir(spec.path)
+	}
+	if base, err = filepath.Abs(base); err != nil {
+		return err
+	}
+
+	switch spec.format {
+	case summaryMarkdown:
+		writeMarkdownSummary(w, results, rows)
+	case summaryHTML:
+		return writeHTMLReport(w, results, rows, base)
+	}
+	return nil
+}
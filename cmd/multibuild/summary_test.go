@@ -0,0 +1,146 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateSummarySpec(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    summarySpec
+		wantErr bool
+	}{
+		{input: "markdown", want: summarySpec{format: summaryMarkdown}},
+		{input: "markdown:out/summary.md", want: summarySpec{format: summaryMarkdown, path: "out/summary.md"}},
+		{input: "html:report.html", want: summarySpec{format: summaryHTML, path: "report.html"}},
+		{input: "rtf", wantErr: true},
+		{input: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := validateSummarySpec(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: got error %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %+v, want %+v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	for n, want := range map[int64]string{
+		0:                      "0 B",
+		1023:                   "1023 B",
+		1024:                   "1.0 KiB",
+		1536 * 1024:            "1.5 MiB",
+		3 * 1024 * 1024 * 1024: "3.0 GiB",
+	} {
+		if got := formatSize(n); got != want {
+			t.Errorf("%d: got %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestMarkdownSummary(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "app-linux-amd64")
+	if err := os.WriteFile(bin, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	results := []targetResult{
+		{target: "linux/amd64", artifacts: []string{bin}, started: start, finished: start.Add(1500 * time.Millisecond)},
+		{target: "windows/amd64", err: errors.New("broken"), started: start, finished: start.Add(time.Second)},
+	}
+	rows, err := summaryRows(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	writeMarkdownSummary(&buf, results, rows)
+	want := "### multibuild: 1 of 2 targets built\n\n" +
+		"| Target | Status | Duration | Artifact | Size | SHA-256 |\n" +
+		"|--------|--------|----------|----------|------|---------|\n" +
+		"| linux/amd64 | ok | 1.5s | `" + bin + "` | 5 B | `2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824` |\n" +
+		"| windows/amd64 | failed | 1s | | | |\n"
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestHTMLReport(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "dist", "app-linux-amd64")
+	if err := os.MkdirAll(filepath.Dir(bin), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bin, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	results := []targetResult{
+		{target: "linux/amd64", artifacts: []string{bin}},
+		{target: "windows/arm64", err: errors.New("broken"), log: "./main.go:3:1: <oops>\n"},
+	}
+	rows, err := summaryRows(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeHTMLReport(&buf, results, rows, dir); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		"<title>multibuild: 1 of 2 targets built</title>",
+		`<tr><th>linux</th><td class="ok">ok</td><td></td></tr>`,
+		`<tr><th>windows</th><td></td><td class="failed">failed</td></tr>`,
+		`<a href="dist/app-linux-amd64">`,
+		"5 B",
+		"<details open>\n<summary>windows/arm64 (failed)</summary>",
+		"./main.go:3:1: &lt;oops&gt;",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("report does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestResultsTable(t *testing.T) {
+	dir := t.TempDir()
+	bin, ar := filepath.Join(dir, "app-linux-amd64"), filepath.Join(dir, "app-linux-amd64.zip")
+	os.WriteFile(bin, make([]byte, 2048), 0755)
+	os.WriteFile(ar, make([]byte, 1024), 0644)
+
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	results := []targetResult{
+		{target: "linux/amd64", artifacts: []string{bin, ar}, queued: start, started: start, finished: start.Add(1500 * time.Millisecond)},
+		{target: "windows/amd64", err: errors.New("boom"), queued: start, started: start, finished: start.Add(2 * time.Second)},
+	}
+	var b strings.Builder
+	writeResultsTable(&b, results)
+	want := strings.Join([]string{
+		"TARGET         STATUS  DURATION  OUTPUT" + strings.Repeat(" ", len(ar)-len("OUTPUT")+2) + "SIZE",
+		"linux/amd64    ok      1.5s      " + bin + strings.Repeat(" ", len(ar)-len(bin)+2) + "2.0 KiB",
+		"                                 " + ar + "  1.0 KiB",
+		"windows/amd64  failed  2s        -" + strings.Repeat(" ", len(ar)+1) + "-",
+		"total          1/2 ok  2s        2 files" + strings.Repeat(" ", len(ar)-len("2 files")+2) + "3.0 KiB",
+	}, "\n") + "\n"
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/build/constraint"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The GOOS and GOARCH values which may appear in file names, as in go/build.
+var (
+	knownOS = []string{"aix", "android", "darwin", "dragonfly", "freebsd", "hurd", "illumos", "ios", "js",
+		"linux", "nacl", "netbsd", "openbsd", "plan9", "solaris", "wasip1", "windows", "zos"}
+	knownArch = []string{"386", "amd64", "amd64p32", "arm", "armbe", "arm64", "arm64be", "loong64",
+		"mips", "mipsle", "mips64", "mips64le", "mips64p32", "mips64p32le", "ppc", "ppc64", "ppc64le",
+		"riscv", "riscv64", "s390", "s390x", "sparc", "sparc64", "wasm"}
+	unixOS = []string{"aix", "android", "darwin", "dragonfly", "freebsd", "hurd", "illumos", "ios",
+		"linux", "netbsd", "openbsd", "solaris"}
+)
+
+// A Go source file, as far as deciding which platforms it is built for.
+type supportFile struct {
+	name       string
+	constraint constraint.Expr // nil if there is no //go:build line
+	imports    []string
+	cgo        bool
+}
+
+// A package in the dependencies of the package being built.
+type supportPackage struct {
+	importPath string
+	files      []supportFile
+}
+
+// The dependencies of the package being built, with enough of each source file to tell
+// which platforms they can be built for.
+type supportGraph struct {
+	root     string
+	packages map[string]supportPackage
+}
+
+// Lists the dependencies of 'patterns', including the standard library, where some packages
+// are only for some platforms (e.g. syscall/js). Packages go build can't build on this machine are
+// still listed, as they may be fine for other targets.
+func loadSupportGraph(patterns []string) (supportGraph, error) {
+	cmd := exec.Command("go", append([]string{"list", "-e", "-deps", "-json=ImportPath,Dir,Standard,DepOnly,GoFiles,CgoFiles,IgnoredGoFiles"}, patterns...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return supportGraph{}, fmt.Errorf("go list: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	graph := supportGraph{packages: make(map[string]supportPackage)}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for dec.More() {
+		var p struct {
+			listedPackage
+			DepOnly        bool
+			IgnoredGoFiles []string
+		}
+		if err := dec.Decode(&p); err != nil {
+			return supportGraph{}, fmt.Errorf("go list: %w", err)
+		}
+		if !p.DepOnly {
+			graph.root = p.ImportPath
+		}
+		if p.Dir == "" {
+			continue // not found at all, which go build will explain
+		}
+		pkg := supportPackage{importPath: p.ImportPath}
+		for _, name := range slices.Concat(p.GoFiles, p.CgoFiles, p.IgnoredGoFiles) {
+			if strings.HasSuffix(name, "_test.go") {
+				continue
+			}
+			f, err := readSupportFile(filepath.Join(p.Dir, name))
+			if err != nil {
+				return supportGraph{}, err
+			}
+			pkg.files = append(pkg.files, f)
+		}
+		graph.packages[p.ImportPath] = pkg
+	}
+	return graph, nil
+}
+
+// Reads the build constraint and imports of the Go source file at 'path'.
+func readSupportFile(path string) (supportFile, error) {
+	f := supportFile{name: filepath.Base(path)}
+	file, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		return supportFile{}, err
+	}
+	for _, group := range file.Comments {
+		if group.Pos() > file.Package {
+			break
+		}
+		for _, c := range group.List {
+			if constraint.IsGoBuild(c.Text) {
+				if f.constraint, err = constraint.Parse(c.Text); err != nil {
+					return supportFile{}, fmt.Errorf("%s: %w", path, err)
+				}
+			}
+		}
+	}
+	for _, imp := range file.Imports {
+		p, _ := strconv.Unquote(imp.Path.Value)
+		if p == "C" {
+			f.cgo = true
+		} else {
+			f.imports = append(f.imports, p)
+		}
+	}
+	return f, nil
+}
+
+// What a file is built with: the target, and whether cgo and other build tags are on.
+type supportContext struct {
+	goos, goarch string
+	cgo          bool
+	tags         []string
+}
+
+// Returns whether 'tag' is satisfied, as go build would decide.
+func (this supportContext) matchTag(tag string) bool {
+	switch {
+	case tag == this.goos || tag == this.goarch:
+		return true
+	case tag == "linux" && this.goos == "android", tag == "solaris" && this.goos == "illumos", tag == "darwin" && this.goos == "ios":
+		return true
+	case tag == "unix":
+		return slices.Contains(unixOS, this.goos)
+	case tag == "cgo":
+		return this.cgo
+	case tag == "gc", strings.HasPrefix(tag, "go1."):
+		return true
+	}
+	return slices.Contains(this.tags, tag)
+}
+
+// Returns whether 'f' is built, going by its name and its //go:build line.
+func (this supportContext) matchFile(f supportFile) bool {
+	if f.cgo && !this.cgo {
+		return false
+	}
+	if f.constraint != nil && !f.constraint.Eval(this.matchTag) {
+		return false
+	}
+	// e.g. name_GOOS_GOARCH.go, name_GOOS.go or name_GOARCH.go, where anything before the first _ is
+	// ignored, so that e.g. linux.go is for every platform.
+	name, _, _ := strings.Cut(f.name, ".")
+	_, suffix, ok := strings.Cut(name, "_")
+	if !ok {
+		return true
+	}
+	l := strings.Split(suffix, "_")
+	if n := len(l); n >= 2 && slices.Contains(knownOS, l[n-2]) && slices.Contains(knownArch, l[n-1]) {
+		return this.matchTag(l[n-2]) && this.matchTag(l[n-1])
+	} else if last := l[n-1]; slices.Contains(knownOS, last) || slices.Contains(knownArch, last) {
+		return this.matchTag(last)
+	}
+	return true
+}
+
+// Returns why the package can't be built for the target of 'ctx', or "" if nothing stands in its
+// way: a package it needs having no Go files for the target. Only packages which were found in
+// the dependencies on this machine are checked.
+func (this supportGraph) unsupported(ctx supportContext) string {
+	_, reason := this.walk(ctx)
+	return reason
+}
+
+// Returns the packages needed to build for the target of 'ctx', as far as they are known, stopping
+// at the first with no Go files for the target, which is described by the returned reason.
+func (this supportGraph) walk(ctx supportContext) (map[string]bool, string) {
+	seen := make(map[string]bool)
+	var visit func(path, from string) string
+	visit = func(path, from string) string {
+		pkg, ok := this.packages[path]
+		if !ok || seen[path] {
+			return ""
+		}
+		seen[path] = true
+		var imports []string
+		found := false
+		for _, f := range pkg.files {
+			if ctx.matchFile(f) {
+				found = true
+				imports = append(imports, f.imports...)
+			}
+		}
+		if !found {
+			if from == "" {
+				return fmt.Sprintf("%s has no Go files for %s/%s", path, ctx.goos, ctx.goarch)
+			}
+			return fmt.Sprintf("%s (imported by %s) has no Go files for %s/%s", path, from, ctx.goos, ctx.goarch)
+		}
+		for _, imp := range imports {
+			if reason := visit(imp, path); reason != "" {
+				return reason
+			}
+		}
+		return ""
+	}
+	reason := visit(this.root, "")
+	return seen, reason
+}
+
+// Returns the build tags set by -tags in 'goBuildArgs'.
+func buildTags(goBuildArgs []string) []string {
+	var tags []string
+	for i, arg := range goBuildArgs {
+		arg = "-" + strings.TrimLeft(arg, "-") // flags may start with - or --
+		if v, ok := strings.CutPrefix(arg, "-tags="); ok {
+			tags = strings.Split(v, ",")
+		} else if arg == "-tags" && i+1 < len(goBuildArgs) {
+			tags = strings.Split(goBuildArgs[i+1], ",")
+		}
+	}
+	return tags
+}
+
+// Removes the targets which can't be built from 'targets', excluding them in 'opts' with a
+// warning: those a package they need has no Go files for, and those which don't support the
+// -buildmode given to go build. Features which build but can't work on a target, such as
+// loading plugins, are warned about. If the dependencies can't be listed, only the build mode
+// is checked, and go build is left to explain the rest.
+func excludeUnsupported(opts *options, args cliArgs, targets []target) []target {
+	goBuildArgs := slices.Concat(strings.Fields(os.Getenv("GOFLAGS")), args.goBuildArgs)
+	cgo, tags, mode := os.Getenv("CGO_ENABLED") == "1", buildTags(goBuildArgs), buildMode(goBuildArgs)
+	if slices.Contains(cgoBuildModes, mode) && !cgo {
+		warn(warnUnsupportedFeature, "-buildmode=%s needs cgo, which is off unless CGO_ENABLED=1 is set", mode)
+	}
+
+	patterns := args.sources
+	if len(patterns) == 0 {
+		patterns = []string{args.packagePath}
+	}
+	graph, err := loadSupportGraph(patterns)
+	var noPlugins []string
+	targets = slices.DeleteFunc(targets, func(t target) bool {
+		goos, goarch := t.osArch()
+		ctx := supportContext{goos: goos, goarch: goarch, cgo: cgo, tags: tags}
+		reason := unsupportedBuildMode(mode, t)
+		if reason == "" && err == nil {
+			var needed map[string]bool
+			if needed, reason = graph.walk(ctx); reason == "" && needed["plugin"] && !pluginsSupported(ctx) {
+				noPlugins = append(noPlugins, string(t))
+			}
+		}
+		if reason == "" {
+			return false
+		}
+		warn(warnUnsupportedTarget, "excluding %s, as %s", t, reason)
+		// Support doesn't depend on the variant, so exclude the platform once.
+		if f := filter(t.platform()); !slices.Contains(opts.Exclude, f) {
+			opts.Exclude = append(opts.Exclude, f)
+			opts.setOrigin(settingKey("exclude", string(f)), origin{source: sourceImplicit, location: reason})
+		}
+		return true
+	})
+	if len(noPlugins) > 0 {
+		warn(warnUnsupportedFeature, "the plugin package is imported, but plugins can't be loaded on %s: they need cgo, on one of %s",
+			strings.Join(noPlugins, ", "), strings.Join(pluginOS, ", "))
+	}
+	return targets
+}
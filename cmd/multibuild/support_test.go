@@ -0,0 +1,114 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"go/build/constraint"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSupportMatchFile(t *testing.T) {
+	expr := func(s string) constraint.Expr {
+		e, err := constraint.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	linux := supportContext{goos: "linux", goarch: "amd64"}
+	android := supportContext{goos: "android", goarch: "arm64"}
+	plan9 := supportContext{goos: "plan9", goarch: "386"}
+	tagged := supportContext{goos: "plan9", goarch: "386", tags: []string{"purego"}}
+
+	tcs := []struct {
+		file supportFile
+		ctx  supportContext
+		want bool
+	}{
+		{supportFile{name: "a.go"}, plan9, true},
+		{supportFile{name: "linux.go"}, plan9, true},
+		{supportFile{name: "a_linux.go"}, linux, true},
+		{supportFile{name: "a_linux.go"}, android, true},
+		{supportFile{name: "a_linux.go"}, plan9, false},
+		{supportFile{name: "a_amd64.go"}, linux, true},
+		{supportFile{name: "a_amd64.go"}, plan9, false},
+		{supportFile{name: "a_linux_386.go"}, linux, false},
+		{supportFile{name: "a_plan9_386.go"}, plan9, true},
+		{supportFile{name: "a_other.go"}, plan9, true},
+		{supportFile{name: "a.go", constraint: expr("//go:build unix")}, linux, true},
+		{supportFile{name: "a.go", constraint: expr("//go:build unix")}, plan9, false},
+		{supportFile{name: "a.go", constraint: expr("//go:build !windows && go1.21")}, plan9, true},
+		{supportFile{name: "a.go", constraint: expr("//go:build purego")}, plan9, false},
+		{supportFile{name: "a.go", constraint: expr("//go:build purego")}, tagged, true},
+		{supportFile{name: "a.go", cgo: true}, linux, false},
+		{supportFile{name: "a.go", cgo: true}, supportContext{goos: "linux", goarch: "amd64", cgo: true}, true},
+	}
+	for _, tc := range tcs {
+		if got := tc.ctx.matchFile(tc.file); got != tc.want {
+			t.Errorf("%s (%v) for %s/%s: got %v, want %v", tc.file.name, tc.file.constraint, tc.ctx.goos, tc.ctx.goarch, got, tc.want)
+		}
+	}
+}
+
+func TestSupportGraph(t *testing.T) {
+	graph := supportGraph{root: "app", packages: map[string]supportPackage{
+		"app": {importPath: "app", files: []supportFile{
+			{name: "main.go", imports: []string{"fmt", "example.com/term"}},
+			{name: "main_windows.go", imports: []string{"example.com/console"}},
+		}},
+		"example.com/term": {importPath: "example.com/term", files: []supportFile{
+			{name: "term_linux.go"}, {name: "term_windows.go"}, {name: "term_darwin.go"},
+		}},
+		// Only imported on linux, so it doesn't matter that it's empty elsewhere.
+		"example.com/console": {importPath: "example.com/console", files: []supportFile{
+			{name: "console_windows.go"},
+		}},
+	}}
+	for tgt, want := range map[target]string{
+		"linux/amd64":   "",
+		"windows/amd64": "",
+		"android/arm64": "",
+		"plan9/amd64":   "example.com/term (imported by app) has no Go files for plan9/amd64",
+	} {
+		goos, goarch, _ := strings.Cut(string(tgt), "/")
+		if got := graph.unsupported(supportContext{goos: goos, goarch: goarch}); got != want {
+			t.Errorf("%s: got %q, want %q", tgt, got, want)
+		}
+	}
+}
+
+func TestReadSupportFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a_unix.go")
+	src := "// Copyright\n\n//go:build linux && !cgo\n\n// Package a does things.\npackage a\n\nimport (\n\t\"C\"\n\t\"fmt\"\n)\n"
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := readSupportFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.name != "a_unix.go" || f.constraint == nil || f.constraint.String() != "linux && !cgo" || !f.cgo || !slices.Equal(f.imports, []string{"fmt"}) {
+		t.Errorf("got %+v", f)
+	}
+}
+
+func TestBuildTags(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want []string
+	}{
+		{nil, nil},
+		{[]string{"-tags=a,b", "-trimpath"}, []string{"a", "b"}},
+		{[]string{"--tags", "purego"}, []string{"purego"}},
+	} {
+		if got := buildTags(tc.args); !slices.Equal(got, tc.want) {
+			t.Errorf("%q: got %q, want %q", tc.args, got, tc.want)
+		}
+	}
+}
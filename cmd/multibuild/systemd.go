@@ -0,0 +1,45 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Returns where the binary is installed by packages.
+func (this packageInfo) installDir() string {
+	if this.BinDir != "" {
+		return this.BinDir
+	}
+	return "/usr/local/bin"
+}
+
+// Validates the user a service runs as.
+func validateServiceUser(s string) error {
+	if s == "" || strings.ContainsAny(s, " \t\n/:") {
+		return fmt.Errorf("%q is not a user name", s)
+	}
+	return nil
+}
+
+// Returns a systemd unit running the binary 'name', installed as described by 'info'.
+func systemdUnit(name string, info packageInfo) string {
+	var b strings.Builder
+	fmt.Fprintln(&b, "[Unit]")
+	fmt.Fprintf(&b, "Description=%s\n", name)
+	fmt.Fprintln(&b, "After=network-online.target")
+	fmt.Fprintln(&b, "Wants=network-online.target")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "[Service]")
+	fmt.Fprintf(&b, "ExecStart=%s\n", path.Join(info.installDir(), name))
+	fmt.Fprintf(&b, "User=%s\n", info.Service)
+	fmt.Fprintln(&b, "Restart=on-failure")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "[Install]")
+	fmt.Fprintln(&b, "WantedBy=multi-user.target")
+	return b.String()
+}
@@ -0,0 +1,86 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSystemdUnit(t *testing.T) {
+	got := systemdUnit("app-linux-amd64", packageInfo{Service: "app"})
+	for _, want := range []string{"ExecStart=/usr/local/bin/app-linux-amd64\n", "User=app\n", "WantedBy=multi-user.target\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+	got = systemdUnit("app", packageInfo{Service: "nobody", BinDir: "/opt/app/bin"})
+	if !strings.Contains(got, "ExecStart=/opt/app/bin/app\n") {
+		t.Errorf("expected bindir to be used in:\n%s", got)
+	}
+}
+
+func TestArchiveExtraFiles(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("app", []byte("binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	extra := []archiveFile{{name: "app.service", mode: 0644, data: []byte("[Unit]\n")}}
+
+	if err := writeTgz("app.tar.gz", "app", "app", 0755, compressionDefault, extra); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open("app.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+		if hdr.Name == "app.service" {
+			data, _ := io.ReadAll(tr)
+			if string(data) != "[Unit]\n" || hdr.Mode != 0644 {
+				t.Errorf("tar.gz: unexpected %s: %q, mode %o", hdr.Name, data, hdr.Mode)
+			}
+		}
+	}
+	if strings.Join(names, ",") != "app,app.service" {
+		t.Errorf("tar.gz: got entries %v", names)
+	}
+
+	if err := writeZip("app.zip", "app", "app", 0755, compressionDefault, extra); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.OpenReader(filepath.Join(".", "app.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	names = nil
+	for _, zf := range zr.File {
+		names = append(names, zf.Name)
+	}
+	if strings.Join(names, ",") != "app,app.service" {
+		t.Errorf("zip: got entries %v", names)
+	}
+}
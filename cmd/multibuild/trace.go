@@ -0,0 +1,101 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// An event in the Chrome trace event format.
+// See https://docs.google.com/document/d/1CvAClvFfyA5R-PhYUmn5OOQtYMH4h6I0nSsKchNAySU
+type traceEvent struct {
+	Name  string         `json:"name"`
+	Cat   string         `json:"cat,omitempty"`
+	Phase string         `json:"ph"`
+	TS    int64          `json:"ts"`            // microseconds
+	Dur   int64          `json:"dur,omitempty"` // microseconds
+	PID   int            `json:"pid"`
+	TID   int            `json:"tid"`
+	Args  map[string]any `json:"args,omitempty"`
+}
+
+// Processes in the trace: one lane per worker, and one lane per target while it is queued.
+// Each stage after building gets a process of its own, with a lane per slot.
+const (
+	tracePIDWorkers = 1
+	tracePIDQueue   = 2
+	tracePIDStages  = 3
+)
+
+// Builds a trace of 'results', showing when each target was queued, built, and went through each later stage.
+func buildTrace(results []targetResult) []traceEvent {
+	var epoch time.Time
+	for _, r := range results {
+		if epoch.IsZero() || r.queued.Before(epoch) {
+			epoch = r.queued
+		}
+	}
+	ts := func(t time.Time) int64 { return t.Sub(epoch).Microseconds() }
+	span := func(name, cat string, pid, tid int, from, to time.Time, args map[string]any) traceEvent {
+		return traceEvent{Name: name, Cat: cat, Phase: "X", TS: ts(from), Dur: max(ts(to)-ts(from), 1), PID: pid, TID: tid, Args: args}
+	}
+	meta := func(name string, pid, tid int, value string) traceEvent {
+		return traceEvent{Name: name, Phase: "M", PID: pid, TID: tid, Args: map[string]any{"name": value}}
+	}
+
+	events := []traceEvent{
+		meta("process_name", tracePIDWorkers, 0, "workers"),
+		meta("process_name", tracePIDQueue, 0, "queue"),
+	}
+	seenWorkers := make(map[int]bool)
+	stagePIDs := make(map[string]int)
+	seenSlots := make(map[[2]int]bool)
+	for idx, r := range results {
+		if !seenWorkers[r.worker] {
+			seenWorkers[r.worker] = true
+			events = append(events, meta("thread_name", tracePIDWorkers, r.worker, fmt.Sprintf("worker %d", r.worker)))
+		}
+		events = append(events, meta("thread_name", tracePIDQueue, idx, string(r.target)))
+
+		args := map[string]any{"target": string(r.target), "status": r.status()}
+		if r.err != nil {
+			args["error"] = r.err.Error()
+		}
+		events = append(events, span(string(r.target), "queued", tracePIDQueue, idx, r.queued, r.started, nil))
+		if r.built.IsZero() {
+			events = append(events, span(string(r.target)+": build", "build", tracePIDWorkers, r.worker, r.started, r.finished, args))
+			continue
+		}
+		events = append(events, span(string(r.target)+": build", "build", tracePIDWorkers, r.worker, r.started, r.built, args))
+		for _, s := range r.stages {
+			pid, ok := stagePIDs[s.name]
+			if !ok {
+				pid = tracePIDStages + len(stagePIDs)
+				stagePIDs[s.name] = pid
+				events = append(events, meta("process_name", pid, 0, s.name))
+			}
+			if !seenSlots[[2]int{pid, s.slot}] {
+				seenSlots[[2]int{pid, s.slot}] = true
+				events = append(events, meta("thread_name", pid, s.slot, fmt.Sprintf("%s %d", s.name, s.slot)))
+			}
+			events = append(events, span(string(r.target)+": "+s.name, s.name, pid, s.slot, s.start, s.end, args))
+		}
+	}
+	return events
+}
+
+// Writes a trace of 'results' to 'path'.
+func writeTrace(path string, results []targetResult) error {
+	data, err := json.Marshal(struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}{buildTrace(results)})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,49 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBuildTrace(t *testing.T) {
+	start := time.Unix(1000, 0)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	results := []targetResult{
+		{target: "linux/amd64", worker: 0, queued: at(0), started: at(0), built: at(100), finished: at(150), stages: []stageSpan{
+			{name: "archive", slot: 1, start: at(110), end: at(150)},
+		}},
+		{target: "windows/amd64", worker: 0, queued: at(0), started: at(150), finished: at(200), err: errors.New("broken")},
+	}
+
+	type span struct {
+		name     string
+		pid, tid int
+		ts, dur  int64
+	}
+	var got []span
+	for _, e := range buildTrace(results) {
+		if e.Phase == "X" {
+			got = append(got, span{e.Name, e.PID, e.TID, e.TS, e.Dur})
+		}
+	}
+	want := []span{
+		{"linux/amd64", tracePIDQueue, 0, 0, 1},
+		{"linux/amd64: build", tracePIDWorkers, 0, 0, 100000},
+		{"linux/amd64: archive", tracePIDStages, 1, 110000, 40000},
+		{"windows/amd64", tracePIDQueue, 1, 0, 150000},
+		{"windows/amd64: build", tracePIDWorkers, 0, 150000, 50000},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d spans, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("span %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,361 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Validates an upload= value: a URL to upload artifacts under.
+//
+//   - http(s)://host/path/: each artifact is PUT to the URL, followed by its name
+//   - dav(s)://host/path/: the same, on a WebDAV server, creating the collections first
+//   - azblob://account/container/path/: as a block blob, in Azure Blob Storage
+//   - artifactory://host/path/ and nexus://host/path/: over HTTPS, with checksums for the
+//     repository to verify. Artifactory is first asked to deploy by checksum alone.
+//
+// The path may use ${TARGET}, ${GOOS} and ${GOARCH}, as output templates do.
+func validateUpload(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http", "https", "dav", "davs", "azblob", "artifactory", "nexus":
+	default:
+		return "", fmt.Errorf("%q is not supported, expected http(s)://, dav(s)://, azblob://, artifactory:// or nexus://", u.Scheme+"://")
+	}
+	for rest := s; strings.Contains(rest, "${"); {
+		_, rest, _ = strings.Cut(rest, "${")
+		var placeholder string
+		placeholder, rest, _ = strings.Cut(rest, "}")
+		if placeholder != "TARGET" && placeholder != "GOOS" && placeholder != "GOARCH" {
+			return "", fmt.Errorf("unknown placeholder ${%s}, expected ${TARGET}, ${GOOS} or ${GOARCH}", placeholder)
+		}
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("%q has no host", s)
+	}
+	if u.User != nil {
+		return "", fmt.Errorf("credentials don't belong in directives, use a netrc file instead (see README)")
+	}
+	if u.Scheme == "azblob" && strings.Trim(u.Path, "/") == "" {
+		return "", fmt.Errorf("%q has no container, expected azblob://account/container/path", s)
+	}
+	return s, nil
+}
+
+// Credentials for a host, from a netrc file.
+type netrcEntry struct {
+	login, password string
+}
+
+// Parses a netrc file, returning the entries by machine. The default entry is under "".
+// See https://everything.curl.dev/usingcurl/netrc.html
+func parseNetrc(data string) map[string]netrcEntry {
+	// Macros run until a blank line, and aren't something we have any use for.
+	var fields []string
+	inMacro := false
+	for line := range strings.Lines(data) {
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		for _, f := range strings.Fields(line) {
+			if f == "macdef" {
+				inMacro = true
+				break
+			}
+			fields = append(fields, f)
+		}
+	}
+
+	entries := make(map[string]netrcEntry)
+	machine := ""
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "machine":
+			if i+1 < len(fields) {
+				machine = fields[i+1]
+				i++
+			}
+		case "default":
+			machine = ""
+		case "login", "password":
+			if i+1 < len(fields) {
+				e := entries[machine]
+				if fields[i] == "login" {
+					e.login = fields[i+1]
+				} else {
+					e.password = fields[i+1]
+				}
+				entries[machine] = e
+				i++
+			}
+		}
+	}
+	return entries
+}
+
+// Returns the netrc credentials for 'host', from $NETRC or ~/.netrc.
+func netrcCredentials(host string, lookup func(string) (string, bool)) (netrcEntry, bool) {
+	file, ok := lookup("NETRC")
+	if !ok || file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return netrcEntry{}, false
+		}
+		file = filepath.Join(home, ".netrc")
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return netrcEntry{}, false
+	}
+	entries := parseNetrc(string(data))
+	if e, ok := entries[host]; ok {
+		return e, true
+	}
+	e, ok := entries[""]
+	return e, ok
+}
+
+// An artifact to upload, and the target it belongs to.
+type uploadArtifact struct {
+	path   string
+	target target
+}
+
+// Uploads artifacts to a single upload= destination.
+type uploader struct {
+	client *http.Client
+
+	// The upload= value, possibly with placeholders.
+	dest string
+
+	// 'lookup' is normally os.LookupEnv.
+	lookup func(string) (string, bool)
+
+	// The WebDAV collections created so far, by URL.
+	created map[string]bool
+}
+
+func newUploader(dest string, lookup func(string) (string, bool)) *uploader {
+	return &uploader{client: http.DefaultClient, dest: dest, lookup: lookup, created: make(map[string]bool)}
+}
+
+// Returns the destination for 't', with placeholders expanded. 'name' is the value of ${TARGET}.
+func (this *uploader) destFor(name string, t target) (*url.URL, error) {
+	goos, goarch := t.osArch()
+	s := strings.ReplaceAll(this.dest, "${TARGET}", name)
+	s = strings.ReplaceAll(s, "${GOOS}", goos)
+	s = strings.ReplaceAll(s, "${GOARCH}", goarch)
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return u, nil
+}
+
+// Returns the URL to upload 'name' to, under 'dest'.
+func (this *uploader) requestURL(dest *url.URL, name string) (string, error) {
+	u := *dest
+	u.Path = path.Join(u.Path, name)
+	switch u.Scheme {
+	case "dav":
+		u.Scheme = "http"
+	case "davs", "artifactory", "nexus":
+		u.Scheme = "https"
+	case "azblob":
+		// The account is the host. $AZURE_STORAGE_ENDPOINT allows for other clouds, or Azurite.
+		endpoint, ok := this.lookup("AZURE_STORAGE_ENDPOINT")
+		if !ok || endpoint == "" {
+			endpoint = "https://" + u.Host + ".blob.core.windows.net"
+		}
+		e, err := url.Parse(endpoint)
+		if err != nil {
+			return "", fmt.Errorf("AZURE_STORAGE_ENDPOINT=%s is invalid: %w", endpoint, err)
+		}
+		sas, ok := this.lookup("AZURE_STORAGE_SAS_TOKEN")
+		if !ok || sas == "" {
+			return "", fmt.Errorf("AZURE_STORAGE_SAS_TOKEN is not set, for %s", dest.Redacted())
+		}
+		e.Path = path.Join(e.Path, u.Path)
+		e.RawQuery = strings.TrimPrefix(sas, "?")
+		return e.String(), nil
+	}
+	return u.String(), nil
+}
+
+// Does a request against 'dest', with credentials from netrc, or for Artifactory, $ARTIFACTORY_ACCESS_TOKEN.
+func (this *uploader) do(ctx context.Context, dest *url.URL, method, rawURL string, header http.Header, body *os.File, size int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Body, req.ContentLength = body, size
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if token, ok := this.lookup("ARTIFACTORY_ACCESS_TOKEN"); ok && token != "" && dest.Scheme == "artifactory" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if dest.Scheme != "azblob" {
+		if e, ok := netrcCredentials(req.URL.Hostname(), this.lookup); ok {
+			req.SetBasicAuth(e.login, e.password)
+		}
+	}
+	return this.client.Do(req)
+}
+
+// Creates the WebDAV collections leading to 'dest', if they don't exist already.
+func (this *uploader) createCollections(ctx context.Context, dest *url.URL) error {
+	u := *dest
+	u.Scheme = map[string]string{"dav": "http", "davs": "https"}[u.Scheme]
+	dir := "/"
+	for part := range strings.SplitSeq(strings.Trim(dest.Path, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		dir = path.Join(dir, part) + "/"
+		u.Path = dir
+		if this.created[u.String()] {
+			continue
+		}
+		resp, err := this.do(ctx, dest, "MKCOL", u.String(), nil, nil, 0)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		// 405 means it already exists.
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+			return fmt.Errorf("MKCOL %s: %s", dir, resp.Status)
+		}
+		this.created[u.String()] = true
+	}
+	return nil
+}
+
+// Returns the checksum headers Artifactory and Nexus use to verify uploads, for the file at 'local'.
+func checksumHeaders(local string) (http.Header, error) {
+	f, err := os.Open(local)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h1, h256, h5 := sha1.New(), sha256.New(), md5.New()
+	if _, err := io.Copy(io.MultiWriter(h1, h256, h5), f); err != nil {
+		return nil, err
+	}
+	return http.Header{
+		"X-Checksum-Sha1":   {hex.EncodeToString(h1.Sum(nil))},
+		"X-Checksum-Sha256": {hex.EncodeToString(h256.Sum(nil))},
+		"X-Checksum-Md5":    {hex.EncodeToString(h5.Sum(nil))},
+	}, nil
+}
+
+// Returns whether 'resp' is a successful upload.
+func uploaded(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated || resp.StatusCode == http.StatusNoContent
+}
+
+// Uploads 'a' under 'dest'.
+func (this *uploader) upload(ctx context.Context, dest *url.URL, a uploadArtifact) error {
+	if dest.Scheme == "dav" || dest.Scheme == "davs" {
+		if err := this.createCollections(ctx, dest); err != nil {
+			return err
+		}
+	}
+	name := filepath.Base(a.path)
+	target, err := this.requestURL(dest, name)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	switch dest.Scheme {
+	case "azblob":
+		// See https://learn.microsoft.com/rest/api/storageservices/put-blob
+		header.Set("x-ms-blob-type", "BlockBlob")
+		header.Set("x-ms-version", "2023-11-03")
+	case "artifactory", "nexus":
+		checksums, err := checksumHeaders(a.path)
+		if err != nil {
+			return err
+		}
+		for k, v := range checksums {
+			header[k] = v
+		}
+	}
+
+	if dest.Scheme == "artifactory" {
+		// If Artifactory already has the content (e.g. from another repository), it can be
+		// deployed by checksum alone, without uploading it again.
+		deploy := header.Clone()
+		deploy.Set("X-Checksum-Deploy", "true")
+		resp, err := this.do(ctx, dest, http.MethodPut, target, deploy, nil, 0)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if uploaded(resp) {
+			return nil
+		} else if resp.StatusCode != http.StatusNotFound {
+			return responseError("deploy "+name+" by checksum", resp)
+		}
+	}
+
+	f, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	resp, err := this.do(ctx, dest, http.MethodPut, target, header, f, st.Size())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if !uploaded(resp) {
+		return responseError("upload "+name, resp)
+	}
+	return nil
+}
+
+// Uploads 'artifacts' under each of 'dests'. 'name' is the value of ${TARGET}.
+func uploadArtifacts(ctx context.Context, name string, dests []string, artifacts []uploadArtifact, lookup func(string) (string, bool)) error {
+	for _, d := range dests {
+		u := newUploader(d, lookup)
+		for _, a := range artifacts {
+			dest, err := u.destFor(name, a.target)
+			if err != nil {
+				return err
+			}
+			if err := u.upload(ctx, dest, a); err != nil {
+				return fmt.Errorf("%s: %w", dest.Redacted(), err)
+			}
+			fmt.Fprintf(os.Stderr, "multibuild: uploaded %s to %s\n", filepath.Base(a.path), dest.Redacted())
+		}
+	}
+	return nil
+}
@@ -9,10 +9,42 @@ import (
 	"os"
 )
 
+// Exit codes, so that scripts wrapping multibuild can tell failures apart.
+const (
+	// Anything not covered below.
+	exitFailure = 1
+
+	// Bad arguments, directives, or other configuration.
+	exitConfig = 2
+
+	// The list of targets could not be determined.
+	exitTargets = 3
+
+	// go build failed for a target.
+	exitBuild = 4
+
+	// Producing an archive or package failed.
+	exitArchive = 5
+
+	// Publishing artifacts failed.
+	exitPublish = 6
+
+	// The run deadline expired before everything was done.
+	exitDeadline = 7
+
+	// Something which looks like a secret was found in a binary.
+	exitSecret = 8
+)
+
 func fatal(format string, args ...any) {
+	fatalCode(exitFailure, format, args...)
+}
+
+// Like fatal, but exits with a specific code.
+func fatalCode(code int, format string, args ...any) {
 	format += "\n"
 	fmt.Fprintf(os.Stderr, format, args...)
-	os.Exit(1)
+	os.Exit(code)
 }
 
 func mapSlice[T any, R any](in []T, fn func(T) R) []R {
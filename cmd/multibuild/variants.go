@@ -0,0 +1,130 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// An architecture's variants, and the environment variable go build chooses one with.
+type archVariantSet struct {
+	env    string
+	values []string
+}
+
+// The variants of each architecture which has them, as they are written in targets, e.g. linux/arm/v7.
+// Values are those of the environment variable, except for arm, where v7 is GOARM=7.
+var archVariants = map[string]archVariantSet{
+	"386":      {"GO386", []string{"sse2", "softfloat"}},
+	"amd64":    {"GOAMD64", []string{"v1", "v2", "v3", "v4"}},
+	"arm":      {"GOARM", []string{"v5", "v6", "v7"}},
+	"arm64":    {"GOARM64", []string{"v8.0", "v8.1", "v8.2", "v8.3", "v8.4", "v8.5", "v8.6", "v8.7", "v8.8", "v8.9", "v9.0", "v9.1", "v9.2", "v9.3", "v9.4", "v9.5"}},
+	"mips":     {"GOMIPS", []string{"hardfloat", "softfloat"}},
+	"mipsle":   {"GOMIPS", []string{"hardfloat", "softfloat"}},
+	"mips64":   {"GOMIPS64", []string{"hardfloat", "softfloat"}},
+	"mips64le": {"GOMIPS64", []string{"hardfloat", "softfloat"}},
+	"ppc64":    {"GOPPC64", []string{"power8", "power9", "power10"}},
+	"ppc64le":  {"GOPPC64", []string{"power8", "power9", "power10"}},
+	"riscv64":  {"GORISCV64", []string{"rva20u64", "rva22u64"}},
+}
+
+// The variants to build of the platforms matching a filter.
+// e.g. //go:multibuild:variant.linux/arm=v6,v7
+type variantSetting struct {
+	filter   filter
+	variants []string
+}
+
+// Validates a variant directive, 'key' being the filter and 'value' the variants.
+// The filter must name an architecture, so the variants can be checked against it.
+func validateVariant(key, value string) (variantSetting, error) {
+	filters, err := validateFilterString(key)
+	if err != nil {
+		return variantSetting{}, err
+	}
+	if len(filters) != 1 {
+		return variantSetting{}, fmt.Errorf("expected a single filter, got %q", key)
+	}
+	_, goarch, _ := strings.Cut(string(filters[0].resolve()), "/")
+	set, ok := archVariants[goarch]
+	if !ok {
+		if goarch == "*" {
+			return variantSetting{}, fmt.Errorf("%q must name an architecture, as each has its own variants", key)
+		}
+		return variantSetting{}, fmt.Errorf("%s has no variants", goarch)
+	}
+	var variants []string
+	for _, v := range strings.Split(value, ",") {
+		if !slices.Contains(set.values, v) {
+			return variantSetting{}, fmt.Errorf("%q is not a variant of %s, expected one of %s", v, goarch, strings.Join(set.values, ", "))
+		}
+		if slices.Contains(variants, v) {
+			return variantSetting{}, fmt.Errorf("%s is listed more than once", v)
+		}
+		variants = append(variants, v)
+	}
+	return variantSetting{filter: filters[0], variants: variants}, nil
+}
+
+// Returns the variants to build of 't': those set for it, if any (if more than one setting
+// matches, the first wins), and those named by include filters, e.g. include=linux/arm/v7.
+func (this options) variantsFor(t target) []string {
+	var variants []string
+	for _, vs := range this.Variants {
+		if vs.filter.matches(t) {
+			variants = slices.Clone(vs.variants)
+			break
+		}
+	}
+	for _, f := range this.Include {
+		ft := target(f.resolve())
+		if v := ft.variant(); v != "" && filter(ft.platform()).matches(t) && !slices.Contains(variants, v) {
+			variants = append(variants, v)
+		}
+	}
+	return variants
+}
+
+// Replaces each of 'targets' with its variants, where there are any (see variantsFor).
+func (this options) expandVariants(targets []target) []target {
+	var out []target
+	for _, t := range targets {
+		variants := this.variantsFor(t)
+		if len(variants) == 0 || t.variant() != "" {
+			out = append(out, t)
+			continue
+		}
+		for _, v := range variants {
+			out = append(out, target(string(t)+"/"+v))
+		}
+	}
+	return out
+}
+
+// Returns whether 't' is one of 'allTargets', or a variant of one.
+func knownTarget(allTargets []target, t target) bool {
+	if !slices.Contains(allTargets, t.platform()) {
+		return false
+	}
+	_, goarch := t.osArch()
+	return t.variant() == "" || slices.Contains(archVariants[goarch].values, t.variant())
+}
+
+// Returns the environment variable which selects the variant of 't', e.g. GOARM=7, or "" if
+// it has no variant.
+func variantEnv(t target) string {
+	v := t.variant()
+	if v == "" {
+		return ""
+	}
+	_, goarch := t.osArch()
+	set := archVariants[goarch]
+	if set.env == "GOARM" {
+		v = strings.TrimPrefix(v, "v")
+	}
+	return set.env + "=" + v
+}
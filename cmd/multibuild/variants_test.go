@@ -0,0 +1,110 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestValidateVariant(t *testing.T) {
+	tests := []struct {
+		key, value string
+		want       []string
+		wantErr    bool
+	}{
+		{key: "linux/arm", value: "v6,v7", want: []string{"v6", "v7"}},
+		{key: "*/amd64", value: "v3", want: []string{"v3"}},
+		{key: "linux/mipsle", value: "softfloat", want: []string{"softfloat"}},
+		{key: "linux/arm", value: "7", wantErr: true},
+		{key: "linux/arm", value: "v6,v6", wantErr: true},
+		{key: "linux/*", value: "v7", wantErr: true},
+		{key: "linux/s390x", value: "v1", wantErr: true},
+		{key: "linux/arm,linux/amd64", value: "v7", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := validateVariant(tt.key, tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s=%s: got error %v, wantErr %v", tt.key, tt.value, err, tt.wantErr)
+			continue
+		}
+		if !slices.Equal(got.variants, tt.want) {
+			t.Errorf("%s=%s: got %v, want %v", tt.key, tt.value, got.variants, tt.want)
+		}
+	}
+}
+
+func TestExpandVariants(t *testing.T) {
+	opts := options{Variants: []variantSetting{
+		{filter: "linux/arm", variants: []string{"v6", "v7"}},
+		{filter: "*/amd64", variants: []string{"v3"}},
+	}}
+	got := opts.expandVariants([]target{"linux/arm", "linux/amd64", "linux/arm64", "windows/arm"})
+	want := []target{"linux/arm/v6", "linux/arm/v7", "linux/amd64/v3", "linux/arm64", "windows/arm"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestExpandIncludedVariants(t *testing.T) {
+	opts := options{
+		Include:  []filter{"linux/*", "linux/arm/v5"},
+		Variants: []variantSetting{{filter: "linux/arm", variants: []string{"v7"}}},
+	}
+	got := opts.expandVariants([]target{"linux/arm", "linux/arm64"})
+	want := []target{"linux/arm/v7", "linux/arm/v5", "linux/arm64"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestVariantEnv(t *testing.T) {
+	for tgt, want := range map[target]string{
+		"linux/arm":              "",
+		"linux/arm/v6":           "GOARM=6",
+		"linux/amd64/v3":         "GOAMD64=v3",
+		"linux/mipsle/softfloat": "GOMIPS=softfloat",
+		"linux/arm64/v8.2":       "GOARM64=v8.2",
+	} {
+		if got := variantEnv(tgt); got != want {
+			t.Errorf("%s: got %q, want %q", tgt, got, want)
+		}
+	}
+}
+
+func TestVariantOutputPaths(t *testing.T) {
+	opts := options{Output: "${TARGET}-${GOOS}-${GOARCH}"}
+	v6, _ := opts.outputPaths("app", "linux/arm/v6")
+	v7, _ := opts.outputPaths("app", "linux/arm/v7")
+	if v6 != "app-linux-armv6" || v7 != "app-linux-armv7" {
+		t.Errorf("got %s and %s, want app-linux-armv6 and app-linux-armv7", v6, v7)
+	}
+
+	// With ${GOVARIANT}, ${GOARCH} is just the architecture.
+	opts.Output = "${TARGET}-${GOOS}-${GOARCH}-${GOVARIANT}"
+	for tgt, want := range map[target]string{
+		"linux/arm/v7": "app-linux-arm-v7",
+		"linux/arm64":  "app-linux-arm64-",
+	} {
+		if got, _ := opts.outputPaths("app", tgt); got != want {
+			t.Errorf("%s: got %s, want %s", tgt, got, want)
+		}
+	}
+}
+
+func TestKnownTarget(t *testing.T) {
+	all := []target{"linux/arm", "linux/amd64"}
+	for tgt, want := range map[target]bool{
+		"linux/arm":    true,
+		"linux/arm/v7": true,
+		"linux/arm/v9": false,
+		"linux/386":    false,
+		"linux/386/v1": false,
+	} {
+		if got := knownTarget(all, tgt); got != want {
+			t.Errorf("%s: got %v, want %v", tgt, got, want)
+		}
+	}
+}
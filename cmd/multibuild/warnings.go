@@ -0,0 +1,168 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Identifies a kind of warning, so they can be reported (and grepped for) uniformly.
+type warningKind string
+
+const (
+	// A filter which doesn't match any target it could apply to.
+	warnUnmatchedFilter warningKind = "unmatched-filter"
+
+	// The same setting was given more than once.
+	warnDuplicateDirective warningKind = "duplicate-directive"
+
+	// The package uses cgo, but multibuild turned it off.
+	warnImplicitCgo warningKind = "implicit-cgo"
+
+	// Configuration from the environment is in effect.
+	warnEnvOverride warningKind = "env-override"
+
+	// A directive uses an old spelling, see migrations.
+	warnDeprecatedDirective warningKind = "deprecated-directive"
+
+	// Metrics could not be pushed. This doesn't fail the build by itself.
+	warnMetricsPush warningKind = "metrics-push"
+
+	// There might not be enough disk space for the outputs.
+	warnDiskSpace warningKind = "disk-space"
+
+	// Builds couldn't be given a lower priority, see --multibuild-nice.
+	warnNice warningKind = "nice"
+
+	// Builds couldn't be limited, see memory-limit and cpu-limit.
+	warnLimits warningKind = "limits"
+
+	// A target was excluded, as a package it needs has no Go files for it, or it doesn't
+	// support the build mode.
+	warnUnsupportedTarget warningKind = "unsupported-target"
+
+	// Something the package uses builds for a target, but won't work there, e.g. plugins.
+	warnUnsupportedFeature warningKind = "unsupported-feature"
+
+	// Directives contradict each other, e.g. putting a target in two resource classes.
+	warnConflictingDirective warningKind = "conflicting-directive"
+)
+
+type warning struct {
+	kind warningKind
+	msg  string
+}
+
+var (
+	warningsMu sync.Mutex
+	warnings   []warning
+)
+
+// Reports a warning. With --multibuild-strict, warnings are turned into errors by checkWarnings.
+func warn(kind warningKind, format string, args ...any) {
+	w := warning{kind: kind, msg: fmt.Sprintf(format, args...)}
+	warningsMu.Lock()
+	defer warningsMu.Unlock()
+	warnings = append(warnings, w)
+	fmt.Fprintf(os.Stderr, "multibuild: warning: %s [%s]\n", w.msg, w.kind)
+}
+
+// If 'strict' is set, exits if any warnings have been reported.
+func checkWarnings(strict bool) {
+	warningsMu.Lock()
+	n := len(warnings)
+	warningsMu.Unlock()
+	if strict && n > 0 {
+		fatalCode(exitConfig, "multibuild: %d warning(s) treated as errors (--multibuild-strict)", n)
+	}
+}
+
+// Warns about any settings which were given more than once.
+func warnDuplicateSettings(opts options) {
+	keys := make([]string, 0, len(opts.origins))
+	for key := range opts.origins {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		origins := opts.origins[key]
+		if len(origins) < 2 {
+			continue
+		}
+		warn(warnDuplicateDirective, "%s is set more than once: %s", key,
+			strings.Join(mapSlice(origins, func(o origin) string { return o.String() }), ", "))
+	}
+}
+
+// Warns about any configuration coming from the environment. The build cache settings
+// are left out, as they don't change what is built, and are meant to be set by CI.
+func warnEnvironmentSettings(opts options) {
+	seen := make(map[string]bool)
+	var names []string
+	for key, origins := range opts.origins {
+		if key == settingKey("gocache") || key == settingKey("gocacheprog") {
+			continue
+		}
+		for _, o := range origins {
+			if o.source == sourceEnvironment && !seen[o.location] {
+				seen[o.location] = true
+				names = append(names, o.location)
+			}
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		warn(warnEnvOverride, "configuration from %s is in effect", name)
+	}
+}
+
+// Warns about exclude, priority, class and remote filters which don't match anything in 'targets'.
+// 'targets' should be the targets selected by the include filters.
+func warnUnmatchedFilters(opts options, targets []target) {
+	check := func(name string, filters []filter) {
+		for _, f := range filters {
+			if slices.ContainsFunc(targets, f.matches) {
+				continue
+			}
+			origins := opts.originOf(settingKey(name, string(f)))
+			if slices.ContainsFunc(origins, func(o origin) bool { return o.source == sourceImplicit }) {
+				continue
+			}
+			where := strings.Join(mapSlice(origins, func(o origin) string { return o.String() }), ", ")
+			warn(warnUnmatchedFilter, "%s=%s (%s) does not match any included target", name, f, where)
+		}
+	}
+	check("exclude", opts.Exclude)
+	check("priority", opts.Priority)
+	for _, class := range resourceClasses {
+		check("class."+string(class), opts.classFilters(class))
+	}
+	check("remote", mapSlice(opts.Remote, func(rb remoteBuilder) filter { return rb.filter }))
+}
+
+// Warns about class filters putting targets in 'targets' in more than one resource class,
+// naming where both came from, and the class which wins.
+func warnConflictingClasses(opts options, targets []target) {
+	for i, a := range opts.Classes {
+		for _, b := range opts.Classes[i+1:] {
+			if a.class == b.class {
+				continue
+			}
+			both := filterSlice(targets, func(t target) bool { return a.filter.matches(t) && b.filter.matches(t) })
+			if len(both) == 0 {
+				continue
+			}
+			aKey, bKey := settingKey("class."+string(a.class), string(a.filter)), settingKey("class."+string(b.class), string(b.filter))
+			warn(warnConflictingDirective, "%s (%s) and %s (%s) both match %s; %s takes precedence",
+				aKey, describeOrigins(opts.originOf(aKey)), bKey, describeOrigins(opts.originOf(bKey)),
+				strings.Join(mapSlice(both, func(t target) string { return string(t) }), ", "), opts.classOf(both[0]))
+		}
+	}
+}
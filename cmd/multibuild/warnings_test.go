@@ -0,0 +1,92 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+// Returns the kinds of warnings reported by 'fn'.
+func collectWarnings(fn func()) []warningKind {
+	warningsMu.Lock()
+	warnings = nil
+	warningsMu.Unlock()
+
+	fn()
+
+	warningsMu.Lock()
+	defer warningsMu.Unlock()
+	kinds := mapSlice(warnings, func(w warning) warningKind { return w.kind })
+	warnings = nil
+	return kinds
+}
+
+func TestWarnUnmatchedFilters(t *testing.T) {
+	directive := origin{source: sourceDirective, location: "main.go:1"}
+
+	var opts options
+	opts.Exclude = []filter{"linux/386", "plan9/*"}
+	opts.setOrigin(settingKey("exclude", "linux/386"), directive)
+	opts.setOrigin(settingKey("exclude", "plan9/*"), directive)
+	opts.Priority = []filter{"darwin/*"}
+	opts.setOrigin(settingKey("priority", "darwin/*"), directive)
+	opts = resolveConfig(opts) // adds implicit excludes, which must not warn
+
+	got := collectWarnings(func() {
+		warnUnmatchedFilters(opts, []target{"linux/386", "linux/amd64"})
+	})
+	want := []warningKind{warnUnmatchedFilter, warnUnmatchedFilter}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestWarnDuplicateSettings(t *testing.T) {
+	file := makeTempFile(t, "//go:multibuild:include=linux/*\n//go:multibuild:include=linux/*,darwin/*\n")
+	defer os.Remove(file)
+
+	opts, err := loadConfig([]string{file}, options{}, testPlatforms)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := collectWarnings(func() { warnDuplicateSettings(opts) })
+	if !slices.Equal(got, []warningKind{warnDuplicateDirective}) {
+		t.Errorf("got %v, want a single duplicate warning", got)
+	}
+}
+
+func TestWarnEnvironmentSettings(t *testing.T) {
+	var env options
+	env.Parallel = 2
+	env.setOrigin(settingKey("parallel"), origin{source: sourceEnvironment, location: "MULTIBUILD_PARALLEL"})
+	env.Include = []filter{"linux/*", "darwin/*"}
+	env.setOrigin(settingKey("include", "linux/*"), origin{source: sourceEnvironment, location: "MULTIBUILD_INCLUDE"})
+	env.setOrigin(settingKey("include", "darwin/*"), origin{source: sourceEnvironment, location: "MULTIBUILD_INCLUDE"})
+	env.GoCache = "/var/cache/go"
+	env.setOrigin(settingKey("gocache"), origin{source: sourceEnvironment, location: "MULTIBUILD_GOCACHE"})
+	opts := resolveConfig(defaultOptions(), env)
+
+	got := collectWarnings(func() { warnEnvironmentSettings(opts) })
+	if !slices.Equal(got, []warningKind{warnEnvOverride, warnEnvOverride}) {
+		t.Errorf("got %v, want one warning per variable, except MULTIBUILD_GOCACHE", got)
+	}
+}
+
+func TestWarnConflictingClasses(t *testing.T) {
+	var opts options
+	opts.Classes = []classTag{{filter: "windows/*", class: classLight}, {filter: "*/arm64", class: classHeavy}, {filter: "linux/*", class: classLight}}
+	for _, tag := range opts.Classes {
+		opts.setOrigin(settingKey("class."+string(tag.class), string(tag.filter)), origin{source: sourceDirective, location: "main.go:1"})
+	}
+
+	got := collectWarnings(func() {
+		warnConflictingClasses(opts, []target{"darwin/arm64", "linux/amd64", "windows/amd64", "windows/arm64"})
+	})
+	if !slices.Equal(got, []warningKind{warnConflictingDirective}) {
+		t.Errorf("got %v, want a single conflict warning", got)
+	}
+}
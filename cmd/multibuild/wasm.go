@@ -0,0 +1,58 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Returns whether 't' builds WebAssembly (js/wasm or wasip1/wasm), which is run by something
+// else, rather than executed.
+func isWasm(t target) bool {
+	_, goarch := t.osArch()
+	return goarch == "wasm"
+}
+
+// Returns the mode of the binary of 't' in archives.
+func binaryMode(t target) int64 {
+	if isWasm(t) {
+		return 0644
+	}
+	return 0755
+}
+
+// Validates that 's' is a wasm-exec setting, a boolean, returning it as "true" or "false".
+func validateWasmExec(s string) (string, error) {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return "", fmt.Errorf("%q is not true or false", s)
+	}
+	return strconv.FormatBool(b), nil
+}
+
+// Returns wasm_exec.js from the Go installation, to be put next to js/wasm binaries in archives
+// with //go:multibuild:wasm-exec=true. It moved from misc/wasm to lib/wasm in go 1.24.
+func wasmExecFile() (archiveFile, error) {
+	out, err := exec.Command("go", "env", "GOROOT").Output()
+	if err != nil {
+		return archiveFile{}, fmt.Errorf("failed to find GOROOT for wasm_exec.js: %s", err)
+	}
+	goroot := strings.TrimSpace(string(out))
+	for _, dir := range []string{"lib/wasm", "misc/wasm"} {
+		data, err := os.ReadFile(filepath.Join(goroot, dir, "wasm_exec.js"))
+		if err == nil {
+			return archiveFile{name: "wasm_exec.js", mode: 0644, data: data}, nil
+		}
+		if !os.IsNotExist(err) {
+			return archiveFile{}, fmt.Errorf("failed to read wasm_exec.js: %s", err)
+		}
+	}
+	return archiveFile{}, fmt.Errorf("wasm_exec.js was not found in %s", goroot)
+}
@@ -0,0 +1,65 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWasmTargets(t *testing.T) {
+	tests := []struct {
+		target   target
+		wantExt  string
+		wantMode int64
+	}{
+		{"js/wasm", ".wasm", 0644},
+		{"wasip1/wasm", ".wasm", 0644},
+		{"windows/amd64", ".exe", 0755},
+		{"linux/amd64", "", 0755},
+	}
+	for _, tt := range tests {
+		if got := (options{}).extensionFor(tt.target); got != tt.wantExt {
+			t.Errorf("extensionFor(%s) = %q, want %q", tt.target, got, tt.wantExt)
+		}
+		if got := binaryMode(tt.target); got != tt.wantMode {
+			t.Errorf("binaryMode(%s) = %o, want %o", tt.target, got, tt.wantMode)
+		}
+	}
+
+	// An extension directive still wins.
+	opts := options{Extensions: []targetExtension{{filter: "js/wasm", extension: ""}}}
+	if got := opts.extensionFor("js/wasm"); got != "" {
+		t.Errorf("extensionFor(js/wasm) with extension.js/wasm= gave %q", got)
+	}
+}
+
+func TestWasmExecFile(t *testing.T) {
+	f, err := wasmExecFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.name != "wasm_exec.js" || f.mode != 0644 || !strings.Contains(string(f.data), "Go") {
+		t.Errorf("unexpected wasm_exec.js: %s, mode %o, %d bytes", f.name, f.mode, len(f.data))
+	}
+
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("app.wasm", []byte("\x00asm"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeZip("app.zip", "app.wasm", "app.wasm", binaryMode("js/wasm"), compressionDefault, []archiveFile{f}); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.OpenReader("app.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	if len(zr.File) != 2 || zr.File[0].Mode() != 0644 || zr.File[1].Name != "wasm_exec.js" {
+		t.Errorf("unexpected entries: %+v", zr.File)
+	}
+}
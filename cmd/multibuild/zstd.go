@@ -0,0 +1,85 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// There is no zstd encoder in the standard library, so zstd compression is done by the zstd
+// command.
+
+// A writer compressing into 'w' with a zstd process.
+type zstdWriter struct {
+	cmd    *exec.Cmd
+	in     io.WriteCloser
+	stderr bytes.Buffer
+}
+
+// Returns a zstd writer to 'w', compressing at 'level'. The process takes one of the shared
+// compression workers until it is closed.
+func newZstdWriter(ctx context.Context, w io.Writer, level compressionLevel) (*zstdWriter, error) {
+	this := &zstdWriter{cmd: exec.CommandContext(ctx, "zstd", "-q", "-c", level.zstd())}
+	this.cmd.Stdout = w
+	this.cmd.Stderr = &this.stderr
+	in, err := this.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	this.in = in
+	compressors <- struct{}{}
+	if err := this.cmd.Start(); err != nil {
+		<-compressors
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, fmt.Errorf("zstd is required, but was not found")
+		}
+		return nil, fmt.Errorf("zstd: %s", err)
+	}
+	return this, nil
+}
+
+func (this *zstdWriter) Write(p []byte) (int, error) {
+	return this.in.Write(p)
+}
+
+// Finishes the stream, and waits for zstd to write it out.
+func (this *zstdWriter) Close() error {
+	defer func() { <-compressors }()
+	this.in.Close()
+	if err := this.cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(this.stderr.String()); msg != "" {
+			return fmt.Errorf("zstd: %s: %s", err, msg)
+		}
+		return fmt.Errorf("zstd: %s", err)
+	}
+	return nil
+}
+
+// Returns a function creating archivers writing tar.zst archives, compressed at 'level'.
+func newTarZstArchiver(ctx context.Context, level compressionLevel) func(io.Writer) (archiver, error) {
+	return func(w io.Writer) (archiver, error) {
+		zw, err := newZstdWriter(ctx, w, level)
+		if err != nil {
+			return nil, err
+		}
+		return newTarArchiver(zw), nil
+	}
+}
+
+// Writes a tar.zst archive at 'arPath' containing the binary at 'binPath' as 'outBin' with 'mode', and 'extra'.
+func writeTarZst(ctx context.Context, arPath, outBin, binPath string, mode int64, level compressionLevel, extra []archiveFile) error {
+	return writeArchive(arPath, newTarZstArchiver(ctx, level), outBin, binPath, mode, extra)
+}
+
+// Writes the binary at 'binPath', compressed with zstd at 'level', at 'path'.
+func writeZst(ctx context.Context, path, binPath string, level compressionLevel) error {
+	return writeCompressed(path, func(w io.Writer) (io.WriteCloser, error) { return newZstdWriter(ctx, w, level) }, binPath)
+}
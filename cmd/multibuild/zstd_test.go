@@ -0,0 +1,92 @@
+// Copyright 2025 Robin Burchell. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestFormatPath(t *testing.T) {
+	tests := []struct {
+		format format
+		want   string
+	}{
+		{formatTgz, "bin/app-windows-amd64.tar.gz"},
+		{formatTarZst, "bin/app-windows-amd64.tar.zst"},
+		{formatZst, "bin/app-windows-amd64.exe.zst"},
+		{formatGz, "bin/app-windows-amd64.exe.gz"},
+	}
+	for _, tt := range tests {
+		if got := tt.format.path("bin/app-windows-amd64", "bin/app-windows-amd64.exe"); got != tt.want {
+			t.Errorf("%s: path() = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestWriteZstd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake zstd is a shell script")
+	}
+
+	// A fake zstd, which doesn't compress, so the output can be read back as it is.
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "zstd"), []byte("#!/bin/sh\ncat\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("app", []byte("binary"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	extra := []archiveFile{{name: "app.service", mode: 0644, data: []byte("[Unit]\n")}}
+	if err := writeTarZst(context.Background(), "app.tar.zst", "app", "app", 0755, compressionDefault, extra); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open("app.tar.zst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+		if hdr.Name == "app" && hdr.Mode != 0755 {
+			t.Errorf("unexpected mode of app: %o", hdr.Mode)
+		}
+	}
+	if strings.Join(names, ",") != "app,app.service" {
+		t.Errorf("got entries %v", names)
+	}
+
+	if err := writeZst(context.Background(), "app.zst", "app", compressionDefault); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile("app.zst"); err != nil || string(data) != "binary" {
+		t.Errorf("got %q (%v)", data, err)
+	}
+
+	// Without zstd, the error says so, and the worker is given back.
+	t.Setenv("PATH", t.TempDir())
+	for range cap(compressors) + 1 {
+		if err := writeZst(context.Background(), "app.zst", "app", compressionDefault); err == nil || !strings.Contains(err.Error(), "zstd is required") {
+			t.Fatalf("expected zstd to be required, got %v", err)
+		}
+	}
+}